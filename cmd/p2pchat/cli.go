@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"p2pchat/pkg/chat"
+	"p2pchat/pkg/daemon"
+	"p2pchat/pkg/logger"
+)
+
+// Scriptable commands: send, tail, peers and history print to stdout and
+// exit, so chat works from CI hooks and shell scripts. They go through the
+// daemon when one is running as this user. Otherwise they start a chat
+// service of their own for as long as the command needs it, which means
+// waiting for discovery to find the other peers first.
+const (
+	// DefaultSendWait is how long send waits for someone to deliver to
+	DefaultSendWait = 10 * time.Second
+
+	// DefaultPeersWait is how long peers listens for announcements (discovery beacons every 5s)
+	DefaultPeersWait = 6 * time.Second
+)
+
+// CommandOptions holds the flags of the scriptable commands
+type CommandOptions struct {
+	Room  string
+	To    string
+	Wait  time.Duration
+	Since string
+	Limit int
+	JSON  bool
+	Args  []string // Positional arguments, the message text for send
+}
+
+// isCommand returns true for the scriptable commands
+func isCommand(mode string) bool {
+	switch mode {
+	case ModeSend, ModeTail, ModePeers, ModeHistory:
+		return true
+	default:
+		return false
+	}
+}
+
+// commandFlags registers the flags of one command next to the common ones
+func commandFlags(mode string) *CommandOptions {
+	options := &CommandOptions{}
+
+	switch mode {
+	case ModeSend:
+		flag.StringVar(&options.Room, "room", chat.DefaultRoom, "Room to send to (joined if needed)")
+		flag.StringVar(&options.To, "to", "", "Send a direct message to this user or peer ID instead")
+		flag.DurationVar(&options.Wait, "wait", DefaultSendWait, "How long to wait for peers, and for one to confirm the message, without a daemon")
+	case ModeTail:
+		flag.StringVar(&options.Room, "room", "", "Only print messages of this room")
+	case ModePeers:
+		flag.BoolVar(&options.JSON, "json", false, "Print JSON instead of a table")
+		flag.DurationVar(&options.Wait, "wait", DefaultPeersWait, "How long to listen for peers without a daemon")
+	case ModeHistory:
+		flag.StringVar(&options.Room, "room", chat.DefaultRoom, "Room to print")
+		flag.StringVar(&options.To, "to", "", "Print the direct messages with this user or peer ID instead")
+		flag.StringVar(&options.Since, "since", "", "Only messages newer than a duration (1h, 30m) or an RFC 3339 time")
+		flag.IntVar(&options.Limit, "limit", 0, "Only the newest N messages (0 = all)")
+		flag.BoolVar(&options.JSON, "json", false, "Print JSON lines instead of text")
+	}
+	return options
+}
+
+// commandBackend is what the commands need, from a daemon or from a chat service of their own
+type commandBackend interface {
+	GetMessages() <-chan *chat.Message
+	GetConnectedPeers() []chat.PeerInfo
+	ResolvePeer(name string) (chat.PeerInfo, error)
+	GetDirectConversations() map[string]string
+	SendRoomMessage(roomID, content string) error
+	SendDirect(peerID, content string) error
+	JoinRoom(name string) (string, error)
+	GetRoomMembers(roomID string) []string
+	GetRoomHistory(roomID string) []*chat.Message
+	GetDirectHistory(peerID string) []*chat.Message
+}
+
+// runCommand runs a scriptable command and returns the process exit code
+func runCommand(mode string, config *Config) int {
+	// Stdout is for the command's output, logs only go to stderr when asked for
+	if !config.Debug {
+		logger.Silent()
+	}
+
+	var err error
+	switch mode {
+	case ModeSend:
+		err = runSend(config)
+	case ModeTail:
+		err = runTail(config)
+	case ModePeers:
+		err = runPeers(config)
+	case ModeHistory:
+		err = runHistory(config)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %s: %v\n", mode, err)
+		return 1
+	}
+	return 0
+}
+
+// openBackend connects to the daemon, or creates a chat service (started only if online is set)
+// Whatever it returns must be released with the returned function
+func openBackend(config *Config, online bool) (commandBackend, bool, func(), error) {
+	if client, err := daemon.Dial(config.SocketPath); err == nil {
+		return client, false, func() { client.Close() }, nil
+	}
+
+	// A node of our own would be a second one with our peer ID next to the TUI
+	unlock := func() {}
+	if online {
+		var err error
+		if unlock, err = lockInstance(config.Username); err != nil {
+			return nil, false, nil, fmt.Errorf("%w, run it as a daemon to use commands alongside it", err)
+		}
+	}
+
+	chatService, _, err := newChatService(config)
+	if err != nil {
+		unlock()
+		return nil, false, nil, err
+	}
+	if online {
+		if err := chatService.Start(); err != nil {
+			chatService.Stop()
+			unlock()
+			return nil, false, nil, fmt.Errorf("failed to start chat service: %w", err)
+		}
+	}
+	return chatService, true, func() {
+		chatService.Stop()
+		unlock()
+	}, nil
+}
+
+// waitUntil polls condition until it holds or timeout passes
+func waitUntil(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if condition() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// runSend delivers one message, read from the arguments or else from stdin
+func runSend(config *Config) error {
+	options := config.Command
+
+	content := strings.Join(options.Args, " ")
+	if content == "" {
+		data, err := io.ReadAll(bufio.NewReader(os.Stdin))
+		if err != nil {
+			return fmt.Errorf("failed to read message from stdin: %w", err)
+		}
+		content = strings.TrimRight(string(data), "\n")
+	}
+	if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("nothing to send")
+	}
+
+	backend, local, release, err := openBackend(config, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Direct messages need the peer connected, daemon or not
+	if options.To != "" {
+		var target chat.PeerInfo
+		waitUntil(options.Wait, func() bool {
+			target, err = backend.ResolvePeer(options.To)
+			return err == nil && target.Connected
+		})
+		if err != nil {
+			return err
+		}
+		if !target.Connected {
+			return fmt.Errorf("%s is not connected", options.To)
+		}
+		if err := backend.SendDirect(target.PeerID, content); err != nil {
+			return err
+		}
+		if local {
+			return awaitDelivery(backend.(*chat.ChatService), backend.GetDirectHistory(target.PeerID), content, options.Wait)
+		}
+		return nil
+	}
+
+	roomID, err := backend.JoinRoom(options.Room)
+	if err != nil {
+		return err
+	}
+
+	// A daemon keeps the message and syncs it to peers later, we won't be around to
+	if local && !waitUntil(options.Wait, func() bool { return len(backend.GetRoomMembers(roomID)) > 0 }) {
+		return fmt.Errorf("no peers in #%s after %s", roomID, options.Wait)
+	}
+	if err := backend.SendRoomMessage(roomID, content); err != nil {
+		return err
+	}
+	if local {
+		return awaitDelivery(backend.(*chat.ChatService), backend.GetRoomHistory(roomID), content, options.Wait)
+	}
+	return nil
+}
+
+// awaitDelivery waits for a peer to confirm the message we just sent, our chat service stops with us
+// Without a receipt in time, it's enough that the message was written to every connected peer
+func awaitDelivery(chatService *chat.ChatService, history []*chat.Message, content string, timeout time.Duration) error {
+	var sent *chat.Message
+	for i := len(history) - 1; i >= 0 && sent == nil; i-- {
+		if history[i].SenderID == chatService.GetPeerID() && history[i].Content == content {
+			sent = history[i]
+		}
+	}
+	if sent == nil {
+		return fmt.Errorf("sent message is missing from history")
+	}
+
+	if waitUntil(timeout, func() bool { return len(chatService.GetDeliveryState(sent.ID).Delivered) > 0 }) {
+		return nil
+	}
+	if chatService.GetUnsentCount() > 0 {
+		return fmt.Errorf("message not delivered after %s", timeout)
+	}
+	return nil
+}
+
+// runTail prints chat messages as JSON lines until interrupted
+func runTail(config *Config) error {
+	options := config.Command
+
+	roomID := ""
+	if options.Room != "" {
+		normalized, err := chat.NormalizeRoomName(options.Room)
+		if err != nil {
+			return err
+		}
+		roomID = normalized
+	}
+
+	backend, _, release, err := openBackend(config, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var done <-chan struct{}
+	if client, ok := backend.(*daemon.Client); ok {
+		if err := client.Subscribe(); err != nil {
+			return err
+		}
+		done = client.Done()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	encoder := json.NewEncoder(os.Stdout)
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-done:
+			return fmt.Errorf("lost the daemon")
+		case msg, ok := <-backend.GetMessages():
+			if !ok {
+				return nil
+			}
+			if !isTailed(msg, roomID) {
+				continue
+			}
+			if err := encoder.Encode(daemon.NewMessageView(msg)); err != nil {
+				return err // Stdout went away, e.g. the end of a pipe
+			}
+		}
+	}
+}
+
+// isTailed returns true for messages tail prints: conversation, not protocol chatter
+func isTailed(msg *chat.Message, roomID string) bool {
+	switch msg.Type {
+	case chat.MessageTypeChat, chat.MessageTypeDirect, chat.MessageTypeReaction, chat.MessageTypeEdit, chat.MessageTypeDelete:
+	default:
+		return false
+	}
+	return roomID == "" || msg.ConversationID() == roomID
+}
+
+// runPeers prints the peers we can see
+func runPeers(config *Config) error {
+	options := config.Command
+
+	backend, local, release, err := openBackend(config, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Nobody tells us when discovery is complete, so give every peer a chance to announce
+	if local {
+		time.Sleep(options.Wait)
+	}
+	peers := backend.GetConnectedPeers()
+
+	if options.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(peers)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "USERNAME\tPEER ID\tADDRESS\tSOURCE\tINTERFACE\tCONNECTION\tPENDING\tPRESENCE\tLAST SEEN")
+	for _, p := range peers {
+		presence := p.Presence
+		if p.PresenceReason != "" {
+			presence += " (" + p.PresenceReason + ")"
+		}
+		source := p.Source
+		if source == "" {
+			source = "-"
+		}
+		iface := p.Interface
+		if iface == "" {
+			iface = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			p.Username, p.PeerID, p.Address, source, iface, p.ConnectionState, p.Pending, presence, p.LastSeen.Format(time.TimeOnly))
+	}
+	return table.Flush()
+}
+
+// runHistory prints stored messages of a room or DM, oldest first
+func runHistory(config *Config) error {
+	options := config.Command
+
+	since, err := parseSince(options.Since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// History is on disk, no need to go online for it
+	backend, _, release, err := openBackend(config, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var history []*chat.Message
+	if options.To != "" {
+		history = backend.GetDirectHistory(directPeerID(backend, options.To))
+	} else {
+		roomID, err := chat.NormalizeRoomName(options.Room)
+		if err != nil {
+			return err
+		}
+		history = backend.GetRoomHistory(roomID)
+	}
+
+	history = filterHistory(history, since, options.Limit)
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, msg := range history {
+		if options.JSON {
+			if err := encoder.Encode(daemon.NewMessageView(msg)); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Println(formatHistoryLine(msg))
+	}
+	return nil
+}
+
+// directPeerID finds the peer ID of someone we may only know from history
+func directPeerID(backend commandBackend, name string) string {
+	if p, err := backend.ResolvePeer(name); err == nil {
+		return p.PeerID
+	}
+	for peerID, username := range backend.GetDirectConversations() {
+		if username == name {
+			return peerID
+		}
+	}
+	return name
+}
+
+// parseSince turns "1h" or an RFC 3339 time into a cutoff, empty means no cutoff
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(since); err == nil {
+		return now.Add(-duration), nil
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -since %q, use a duration like 1h or an RFC 3339 time", since)
+}
+
+// filterHistory keeps chat messages newer than since, at most the newest limit of them
+func filterHistory(history []*chat.Message, since time.Time, limit int) []*chat.Message {
+	var kept []*chat.Message
+	for _, msg := range history {
+		if msg.Type != chat.MessageTypeChat && msg.Type != chat.MessageTypeDirect {
+			continue // Room joins and name changes are noise in a transcript
+		}
+		if msg.Timestamp.Before(since) {
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	if limit > 0 && len(kept) > limit {
+		kept = kept[len(kept)-limit:]
+	}
+	return kept
+}
+
+// formatHistoryLine renders a message as one line of a transcript
+func formatHistoryLine(msg *chat.Message) string {
+	text := msg.Text()
+	switch {
+	case msg.Deleted:
+		text = "(deleted)"
+	case msg.IsEdited():
+		text += " (edited)"
+	}
+	return fmt.Sprintf("%s <%s> %s", msg.Timestamp.Local().Format(time.DateTime), msg.Username, strings.ReplaceAll(text, "\n", " "))
+}
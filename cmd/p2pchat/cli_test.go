@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"p2pchat/pkg/chat"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	since, err := parseSince("90m", now)
+	if err != nil || !since.Equal(now.Add(-90*time.Minute)) {
+		t.Errorf("Expected 90 minutes ago, got %v (%v)", since, err)
+	}
+
+	since, err = parseSince("2026-03-14T08:00:00Z", now)
+	if err != nil || since.Hour() != 8 {
+		t.Errorf("Expected 08:00, got %v (%v)", since, err)
+	}
+
+	if since, err := parseSince("", now); err != nil || !since.IsZero() {
+		t.Errorf("Empty -since should mean everything, got %v (%v)", since, err)
+	}
+	if _, err := parseSince("yesterday", now); err == nil {
+		t.Error("Expected an error for an unparseable -since")
+	}
+}
+
+func TestFilterHistory(t *testing.T) {
+	now := time.Now()
+	var history []*chat.Message
+	for i, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, 10 * time.Minute} {
+		msg := chat.NewChatMessage("peer", "alice", strings.Repeat("x", i+1), uint64(i))
+		msg.Timestamp = now.Add(-age)
+		history = append(history, msg)
+	}
+	join := chat.NewRoomJoinMessage("peer", "alice", chat.DefaultRoom, 9)
+	history = append(history, join)
+
+	if kept := filterHistory(history, now.Add(-time.Hour), 0); len(kept) != 2 {
+		t.Errorf("Expected 2 messages in the last hour, got %d", len(kept))
+	}
+	if kept := filterHistory(history, time.Time{}, 3); len(kept) != 3 || kept[2].Content != "xxxx" {
+		t.Errorf("Expected the newest 3 chat messages, got %d", len(kept))
+	}
+}
+
+func TestFormatHistoryLine(t *testing.T) {
+	msg := chat.NewChatMessage("peer", "alice", "first line\nsecond line", 1)
+	if line := formatHistoryLine(msg); !strings.HasSuffix(line, "<alice> first line second line") {
+		t.Errorf("Expected one line per message, got %q", line)
+	}
+
+	msg.Deleted = true
+	if line := formatHistoryLine(msg); !strings.HasSuffix(line, "<alice> (deleted)") {
+		t.Errorf("Expected a deleted marker, got %q", line)
+	}
+}
+
+func TestSplitMode(t *testing.T) {
+	mode, args := splitMode([]string{"send", "-room", "ci", "deploy done"})
+	if mode != ModeSend || len(args) != 3 {
+		t.Errorf("Expected send with 3 args, got %q %v", mode, args)
+	}
+
+	mode, args = splitMode([]string{"-username", "alice"})
+	if mode != "" || len(args) != 2 {
+		t.Errorf("Options alone should start the TUI, got %q %v", mode, args)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// lockInstance would take the lock of the instance that runs as username
+// There is no flock here, so nothing stops a second instance, use the daemon for commands
+func lockInstance(username string) (func(), error) {
+	return func() {}, nil
+}
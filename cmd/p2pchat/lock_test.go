@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOnlyOneInstancePerUser(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	release, err := lockInstance("alice")
+	if err != nil {
+		t.Fatalf("Failed to take the lock: %v", err)
+	}
+	if _, err := lockInstance("alice"); !errors.Is(err, errAlreadyRunning) {
+		t.Errorf("Expected a second instance to be refused, got %v", err)
+	}
+	other, err := lockInstance("bob")
+	if err != nil {
+		t.Errorf("Expected another user to run alongside, got %v", err)
+	} else {
+		other()
+	}
+
+	release()
+	again, err := lockInstance("alice")
+	if err != nil {
+		t.Fatalf("Expected the lock to be free once released, got %v", err)
+	}
+	again()
+}
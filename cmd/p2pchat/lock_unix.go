@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockInstance takes the lock of the instance that runs as username, so only one node has our peer ID
+// It returns errAlreadyRunning while another process holds it, the lock goes away with the process
+func lockInstance(username string) (func(), error) {
+	path := instanceLockPath(username)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open instance lock: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errAlreadyRunning
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return func() { file.Close() }, nil
+}
@@ -2,15 +2,26 @@ package main
 
 import (
 	"bufio" // Need this to read user inputs(all chat lines I think)
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"p2pchat/internal/peer"
 	"p2pchat/pkg/chat"
+	"p2pchat/pkg/daemon"
+	"p2pchat/pkg/discovery"
+	"p2pchat/pkg/identity"
+	"p2pchat/pkg/plugins"
+	"p2pchat/pkg/secure"
+	"p2pchat/pkg/settings"
 	"p2pchat/pkg/ui"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"p2pchat/pkg/logger"
@@ -18,23 +29,101 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 )
 
+// errAlreadyRunning means another p2pchat holds the instance lock (see lockInstance)
+var errAlreadyRunning = errors.New("p2pchat is already running as this user")
+
 const (
-	DefaultUsername      = "" // Empty to trigger interactive prompt
-	DefaultPort          = 0  // 0 to trigger automatic assignment
-	DefaultMulticastAddr = "224.0.0.1:9999"
-	PortRangeStart       = 8080 // Start of automatic port range
-	PortRangeEnd         = 8999 // End of automatic port range
+	DefaultUsername       = "" // Empty to trigger interactive prompt
+	DefaultPort           = 0  // 0 to trigger automatic assignment
+	DefaultMulticastAddr  = "224.0.0.1:9999"
+	DefaultMulticastAddr6 = discovery.DefaultMulticastAddr6
+	PortRangeStart        = 8080 // Start of automatic port range
+	PortRangeEnd          = 8999 // End of automatic port range
+	DefaultHistoryDays    = 30   // How long persisted history is kept
 )
 
 type Config struct {
-	Username      string
-	Port          int
-	MulticastAddr string
-	Debug         bool
+	Username       string
+	Port           int
+	MulticastAddr  string
+	MulticastAddr6 string   // IPv6 group, "" for IPv4 only
+	Backends       []string // Discovery backends, see discovery.ParseBackends
+	Interfaces     []string // Interfaces discovery uses, nil for every one that can multicast
+	Debug          bool
+	NoHistory      bool // Keep history in memory only
+	HistoryDays    int  // Retention for persisted history (0 = forever)
+	NoReadReceipts bool // Don't tell authors when their messages were on our screen
+	DownloadDir    string
+	SocketPath     string // Control socket of the daemon
+	Plugins        string // Comma separated built-in plugins (see pkg/plugins)
+	PortRange      PortRange
+	Theme          ui.Theme
+	Peers          PeerList // Dialed by address, for networks that drop multicast
+
+	// Network tunables, defaults in the discovery and chat packages
+	Discovery  discovery.Timing
+	Connection chat.ConnectionTiming
+	Outbox     chat.OutboxLimits // Messages held for peers that are away
+
+	Command *CommandOptions // Set for send, tail, peers and history (see cli.go)
+}
+
+// PortRange is where automatic port assignment looks first
+type PortRange struct {
+	First, Last int
 }
 
+// String writes a range the way -port-range takes it
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// PeerList collects host:port addresses from repeated -peer flags or comma separated lists
+type PeerList []string
+
+// String writes the list the way -peers takes it
+func (l *PeerList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set adds one or more comma separated addresses
+func (l *PeerList) Set(value string) error {
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if _, err := peer.NewStaticPeer(address); err != nil {
+			return err
+		}
+		*l = append(*l, address)
+	}
+	return nil
+}
+
+// Commands that replace the TUI (see splitMode)
+const (
+	ModeDaemon = "daemon" // Headless, serves the control socket
+	ModeAttach = "attach" // TUI on a running daemon
+
+	ModeSend    = "send"    // Send one message and exit
+	ModeTail    = "tail"    // Stream messages as JSON lines
+	ModePeers   = "peers"   // List peers
+	ModeHistory = "history" // Print stored messages
+)
+
 func main() {
-	config := parseArgs()
+	mode, args := splitMode(os.Args[1:])
+	config := parseArgs(mode, args)
+
+	if config.Command != nil {
+		os.Exit(runCommand(mode, config))
+	}
+
+	if mode == ModeDaemon {
+		runDaemon(config)
+		return
+	}
 
 	// Set up logging
 	if config.Debug {
@@ -48,57 +137,310 @@ func main() {
 		logger.Silent()
 	}
 
+	// A daemon running as this user already owns our identity, share it instead of starting another
+	client, err := daemon.Dial(config.SocketPath)
+	if err == nil {
+		runAttached(client, config)
+		return
+	}
+	if mode == ModeAttach {
+		log.Fatalf("No daemon to attach to at %s: %v", config.SocketPath, err)
+	}
+
+	chatService, release := startChatService(config)
+	defer release()
+	defer chatService.Stop()
+
+	fmt.Printf("✅ Ready! Starting chat interface...\n\n")
+
+	if _, err := newProgram(chatService, config).Run(); err != nil {
+		log.Fatalf("TUI error: %v", err)
+	}
+}
+
+// splitMode separates a leading command like "daemon" from the options that follow it
+func splitMode(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", args
+	}
+
+	switch args[0] {
+	case ModeDaemon, ModeAttach, ModeSend, ModeTail, ModePeers, ModeHistory:
+		return args[0], args[1:]
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		flag.Usage()
+		os.Exit(2)
+		return "", nil
+	}
+}
+
+// describeDiscovery lists the backends, with the group multicast uses
+func describeDiscovery(config *Config) string {
+	var parts []string
+	for _, name := range config.Backends {
+		if name == discovery.BackendMulticast {
+			name += " " + config.MulticastAddr
+			if config.MulticastAddr6 != "" {
+				name += " " + config.MulticastAddr6
+			}
+		}
+		parts = append(parts, name)
+	}
+	if len(config.Interfaces) > 0 {
+		return strings.Join(parts, ", ") + " on " + strings.Join(config.Interfaces, ", ")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// startChatService loads our identity and history and brings the chat service up
+// It holds the instance lock until the returned function is called, call it after stopping the service
+func startChatService(config *Config) (*chat.ChatService, func()) {
 	fmt.Printf("🚀 Starting P2P Chat...\n")
 	fmt.Printf("   👤 Username: %s\n", config.Username)
 	fmt.Printf("   🔌 Port: %d\n", config.Port)
-	fmt.Printf("   📡 Discovery: %s\n", config.MulticastAddr)
+	fmt.Printf("   📡 Discovery: %s\n", describeDiscovery(config))
 	if config.Debug {
 		fmt.Printf("   🔍 Debug: Enabled (logging to p2pchat-debug.log)\n")
 	}
 	fmt.Printf("\n🔄 Initializing services...\n")
 
-	// Create and start services...
-	peerID := fmt.Sprintf("%s_%d", config.Username, time.Now().Unix()%1000)
-	chatService, err := chat.NewChatService(peerID, config.Username, config.Port, config.MulticastAddr)
+	// Only one of us may own the history and the peer ID
+	release, err := lockInstance(config.Username)
 	if err != nil {
-		log.Fatalf("Failed to create chat service: %v", err)
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	chatService, id, err := newChatService(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("   🪪 Peer ID: %s\n", id.PeerID())
+	fmt.Printf("   🔐 Encryption key: %s\n", secure.Fingerprint(id.StaticKey.PublicKey().Bytes()))
+	if !config.NoHistory {
+		fmt.Printf("   💾 History: %s (%d messages)\n", historyPath(config.Username), chatService.GetMessageCount())
 	}
 
 	if err := chatService.Start(); err != nil {
 		log.Fatalf("Failed to start chat service: %v", err)
 	}
-	defer chatService.Stop()
+	return chatService, release
+}
 
-	// Start TUI
-	model := ui.NewChatModel(chatService)
-	program := tea.NewProgram(
+// newChatService loads our identity and history into a chat service that isn't started yet
+func newChatService(config *Config) (*chat.ChatService, *identity.Identity, error) {
+	enabled, err := plugins.Load(config.Plugins)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Load (or create) this user's keys - the peer ID is derived from them
+	id, err := identity.LoadOrCreate(keyDir(config.Username))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load identity: %w", err)
+	}
+
+	chatService, err := chat.NewChatService(id, config.Username, config.Port, config.MulticastAddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create chat service: %w", err)
+	}
+
+	chatService.SetDownloadDir(config.DownloadDir)
+	chatService.SetReadReceipts(!config.NoReadReceipts)
+	for _, address := range config.Peers {
+		if err := chatService.AddStaticPeer(address); err != nil {
+			return nil, nil, err
+		}
+	}
+	// Peers added with /connect are dialed again next time
+	if err := chatService.SetPeerFile(peerFilePath(config.Username)); err != nil {
+		return nil, nil, err
+	}
+	if err := chatService.SetTiming(config.Discovery, config.Connection); err != nil {
+		return nil, nil, err
+	}
+	// Hold messages for peers that are away, on disk like the history
+	// Commands exit right away, the instance that stays up owns the file
+	outbox := outboxPath(config.Username)
+	if config.NoHistory || config.Command != nil {
+		outbox = ""
+	}
+	if err := chatService.SetOutbox(outbox, config.Outbox); err != nil {
+		return nil, nil, err
+	}
+	if err := chatService.SetDiscoveryInterfaces(config.Interfaces); err != nil {
+		return nil, nil, err
+	}
+	if err := chatService.SetMulticastAddr6(config.MulticastAddr6); err != nil {
+		return nil, nil, err
+	}
+	if err := chatService.SetDiscoveryBackends(config.Backends); err != nil {
+		return nil, nil, err
+	}
+
+	// Persist history so yesterday's discussion is still there after a restart
+	// Commands only read it, a TUI or daemon may be appending to it right now
+	if !config.NoHistory {
+		retention := chat.DefaultRetention
+		retention.MaxAge = time.Duration(config.HistoryDays) * 24 * time.Hour
+		var store *chat.FileStore
+		if config.Command != nil {
+			store = chat.NewReadOnlyFileStore(historyPath(config.Username), retention)
+		} else {
+			store, err = chat.NewFileStore(historyPath(config.Username), retention)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to open message history: %w", err)
+			}
+		}
+		if err := chatService.SetMessageStore(store); err != nil {
+			return nil, nil, fmt.Errorf("failed to load message history: %w", err)
+		}
+	}
+
+	// Built-in bots, they need to be in place before messages start arriving
+	for _, plugin := range enabled {
+		if err := chatService.RegisterPlugin(plugin); err != nil {
+			return nil, nil, err
+		}
+	}
+	return chatService, id, nil
+}
+
+// newProgram creates the TUI on top of a local chat service or an attached daemon
+func newProgram(backend ui.Backend, config *Config) *tea.Program {
+	model := ui.NewChatModel(backend)
+	model.SetTheme(config.Theme)
+	return tea.NewProgram(
 		model,
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
 	)
+}
 
-	fmt.Printf("✅ Ready! Starting chat interface...\n\n")
+// runDaemon keeps the chat service running without a TUI until we get a signal
+func runDaemon(config *Config) {
+	// Daemons log to stderr for whoever supervises them, debug chatter only on request
+	if !config.Debug {
+		logger.Quiet()
+	}
+
+	chatService, release := startChatService(config)
+	defer release()
+
+	server := daemon.NewServer(chatService, config.SocketPath)
+	if err := server.Start(); err != nil {
+		chatService.Stop()
+		log.Fatalf("Failed to start control socket: %v", err)
+	}
+	fmt.Printf("✅ Daemon ready! Control socket: %s\n", server.Path())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	fmt.Printf("\n🛑 Shutting down...\n")
+	if err := server.Stop(); err != nil {
+		logger.Error("Error closing control socket: %v", err)
+	}
+	if err := chatService.Stop(); err != nil {
+		logger.Error("Error stopping chat service: %v", err)
+	}
+}
+
+// runAttached runs the TUI against a daemon instead of a chat service of our own
+func runAttached(client *daemon.Client, config *Config) {
+	defer client.Close()
+
+	if err := client.Subscribe(); err != nil {
+		log.Fatalf("Failed to subscribe to daemon messages: %v", err)
+	}
+	fmt.Printf("🔌 Attaching to the daemon at %s...\n", config.SocketPath)
+
+	program := newProgram(client, config)
+
+	// Don't leave a frozen TUI on screen if the daemon goes away
+	go func() {
+		<-client.Done()
+		program.Quit()
+	}()
 
 	if _, err := program.Run(); err != nil {
 		log.Fatalf("TUI error: %v", err)
 	}
+
+	select {
+	case <-client.Done():
+		fmt.Fprintf(os.Stderr, "❌ Lost the daemon: %v\n", client.Err())
+		os.Exit(1)
+	default:
+	}
 }
 
-func parseArgs() *Config {
+func parseArgs(mode string, args []string) *Config {
 	var (
-		username  = flag.String("username", DefaultUsername, "Username for chat (interactive prompt if not provided)")
-		port      = flag.Int("port", DefaultPort, "TCP port for peer connections (auto-assigned if not provided)")
-		multicast = flag.String("multicast", DefaultMulticastAddr, "Multicast address for peer discovery")
-		debug     = flag.Bool("debug", false, "Enable debug logging")
-		help      = flag.Bool("help", false, "Show help message")
-		h         = flag.Bool("h", false, "Show help message (shorthand)")
+		username   = flag.String("username", DefaultUsername, "Username for chat (interactive prompt if not provided)")
+		port       = flag.Int("port", DefaultPort, "TCP port for peer connections (auto-assigned if not provided)")
+		multicast  = flag.String("multicast", DefaultMulticastAddr, "Multicast address for peer discovery")
+		multicast6 = flag.String("multicast6", DefaultMulticastAddr6, "IPv6 multicast group joined alongside -multicast, [ff02::1%eth0]:9999 for one interface (empty for IPv4 only)")
+		ifaces     = flag.String("interface", "", "Network interfaces to discover peers on, comma separated (default: every one that can multicast)")
+		finders    = flag.String("discovery", discovery.DefaultBackends, "How to find peers on the LAN, comma separated (available: "+strings.Join(discovery.BackendNames(), ", ")+")")
+		debug      = flag.Bool("debug", false, "Enable debug logging")
+		noHistory  = flag.Bool("no-history", false, "Don't save message history to disk")
+		history    = flag.Int("history-days", DefaultHistoryDays, "Days of message history to keep on disk (0 = forever)")
+		noReceipts = flag.Bool("no-read-receipts", false, "Don't tell others when their messages were on your screen (delivery is still acknowledged)")
+		downloads  = flag.String("downloads", "", "Directory for received files (default: ~/Downloads/p2pchat)")
+		socket     = flag.String("socket", "", "Control socket of the daemon (default: $XDG_RUNTIME_DIR/p2pchat/<username>.sock)")
+		bots       = flag.String("plugins", plugins.DefaultPlugins, "Built-in plugins to enable, comma separated (available: "+strings.Join(plugins.Names(), ", ")+")")
+		ports      = flag.String("port-range", PortRange{PortRangeStart, PortRangeEnd}.String(), "Ports to try first when auto-assigning")
+		theme      = flag.String("theme", ui.DefaultTheme, "Colors of the TUI (available: "+strings.Join(ui.ThemeNames(), ", ")+")")
+		file       = flag.String("config", "", "Config file (default: $XDG_CONFIG_HOME/p2pchat/config)")
+		profile    = flag.String("profile", "", "Profile of the config file to use")
+		help       = flag.Bool("help", false, "Show help message")
+		h          = flag.Bool("h", false, "Show help message (shorthand)")
+
+		// Tunables, mostly for unusual networks
+		discoveryTiming  = discovery.DefaultTiming
+		connectionTiming = chat.DefaultConnectionTiming
+		outboxLimits     = chat.DefaultOutboxLimits
+		staticPeers      PeerList
 	)
+	flag.Var(&staticPeers, "peer", "Peer to dial by host:port when multicast discovery can't find it (repeatable)")
+	flag.Var(&staticPeers, "peers", "Same as -peer, comma separated, like peers = [...] in the config file")
+	flag.DurationVar(&discoveryTiming.BeaconInterval, "beacon-interval", discoveryTiming.BeaconInterval, "How often to announce ourselves on the network")
+	flag.DurationVar(&discoveryTiming.CleanupInterval, "cleanup-interval", discoveryTiming.CleanupInterval, "How often to check for peers that went quiet")
+	flag.DurationVar(&discoveryTiming.StaleTimeout, "stale-timeout", discoveryTiming.StaleTimeout, "Silence before a peer counts as stale")
+	flag.DurationVar(&discoveryTiming.OfflineTimeout, "offline-timeout", discoveryTiming.OfflineTimeout, "Silence before a peer is dropped")
+	flag.DurationVar(&connectionTiming.RetryInterval, "retry-interval", connectionTiming.RetryInterval, "How often to retry failed peer connections")
+	flag.DurationVar(&connectionTiming.DialTimeout, "dial-timeout", connectionTiming.DialTimeout, "How long connecting to a peer may take")
+	flag.DurationVar(&connectionTiming.ReadTimeout, "read-timeout", connectionTiming.ReadTimeout, "Silence before a peer connection counts as dead")
+	flag.DurationVar(&connectionTiming.WriteTimeout, "write-timeout", connectionTiming.WriteTimeout, "How long sending a message to a peer may take")
+	flag.IntVar(&outboxLimits.MaxMessages, "outbox-size", outboxLimits.MaxMessages, "Messages held per peer while it is away, the oldest go first")
+	flag.DurationVar(&outboxLimits.MaxAge, "outbox-age", outboxLimits.MaxAge, "How long messages are held for a peer that is away")
+
+	// Everything above except these can also come from the environment and the config file
+	settable := make(map[string]bool)
+	flag.VisitAll(func(f *flag.Flag) {
+		settable[f.Name] = true
+	})
+	for _, name := range []string{"config", "profile", "help", "h"} {
+		delete(settable, name)
+	}
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "P2P Chat - IRC-style peer-to-peer chat system\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [command] [options]\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Simple usage (interactive prompts):\n")
 		fmt.Fprintf(os.Stderr, "  %s\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  daemon    Stay on the chat without a TUI, controlled through a Unix socket\n")
+		fmt.Fprintf(os.Stderr, "  attach    Open the TUI on a running daemon (plain %s does this too when one is running)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  send      Send one message and exit: send [-room R | -to user] text...\n")
+		fmt.Fprintf(os.Stderr, "  tail      Print incoming messages as JSON lines until interrupted\n")
+		fmt.Fprintf(os.Stderr, "  peers     List peers as a table, or JSON with -json\n")
+		fmt.Fprintf(os.Stderr, "  history   Print stored messages: history [-room R | -to user] [-since 1h] [-json]\n")
+		fmt.Fprintf(os.Stderr, "  (these use the daemon when one is running, otherwise join the network just long enough)\n\n")
+		fmt.Fprintf(os.Stderr, "Every option can also be set in the config file (history_days = 7) or the environment\n")
+		fmt.Fprintf(os.Stderr, "(P2PCHAT_HISTORY_DAYS=7). Options on the command line win over the environment, which wins over the file.\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -106,26 +448,108 @@ func parseArgs() *Config {
 		fmt.Fprintf(os.Stderr, "  %s -username alice                    # Specify username, auto-assign port\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -username alice -port 8080         # Full manual configuration\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -debug                             # Interactive mode with debug logging\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -username alice -no-history        # Don't keep history between runs\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -plugins echo,dice                 # Pick the built-in bots\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -profile work                      # Settings of [profiles.work] in the config file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -peer 10.8.0.12:8080               # Dial a peer when multicast is blocked\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -discovery multicast,mdns          # Also show up in avahi-browse\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -interface wlan0                   # Not on docker0 or the VPN\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s daemon -username buildbot          # Headless, for servers and scripts\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s attach -username buildbot          # Look over the bot's shoulder\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s send -room ci \"deploy done\"        # From a CI hook\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s history -since 1h -json | jq .text # Catch up from a script\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nStatus: Production Ready (Day 8) ✅\n")
 	}
 
-	flag.Parse()
+	var command *CommandOptions
+	if isCommand(mode) {
+		command = commandFlags(mode)
+	}
+
+	flag.CommandLine.Parse(args)
 
 	if *help || *h {
 		flag.Usage()
 		os.Exit(0)
 	}
 
+	if err := applySettings(*file, *profile, settable); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	portRange, err := parsePortRange(*ports)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	backends, err := discovery.ParseBackends(*finders)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	colors, err := ui.LookupTheme(*theme)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := errors.Join(discoveryTiming.Validate(), connectionTiming.Validate(), outboxLimits.Validate()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
 	config := &Config{
-		Username:      *username,
-		Port:          *port,
-		MulticastAddr: *multicast,
-		Debug:         *debug,
+		Username:       *username,
+		Port:           *port,
+		MulticastAddr:  *multicast,
+		MulticastAddr6: *multicast6,
+		Backends:       backends,
+		Interfaces:     discovery.ParseInterfaces(*ifaces),
+		Debug:          *debug,
+		NoHistory:      *noHistory,
+		HistoryDays:    *history,
+		NoReadReceipts: *noReceipts,
+		DownloadDir:    *downloads,
+		SocketPath:     *socket,
+		Plugins:        *bots,
+		PortRange:      portRange,
+		Theme:          colors,
+		Peers:          staticPeers,
+		Discovery:      discoveryTiming,
+		Connection:     connectionTiming,
+		Outbox:         outboxLimits,
+		Command:        command,
+	}
+
+	// Daemons and scripts run unattended and attach has to find the daemon's user, none of them prompt
+	if mode != "" && config.Username == "" {
+		config.Username = getDefaultUsername()
+	}
+
+	if command != nil {
+		command.Args = flag.Args()
+		config.Plugins = "" // Nobody is around to type their commands
+		if config.Port == 0 {
+			config.Port = findAvailablePortIn(config.PortRange) // Quietly, stdout belongs to the command's output
+		}
+	}
+
+	if config.SocketPath == "" {
+		config.SocketPath = socketPath(config.Username)
+	}
+
+	// The daemon picks the port and download directory when attaching
+	if mode == ModeAttach {
+		return config
 	}
 
 	// Interactive configuration if needed
 	config = enhanceConfigInteractively(config)
 
+	if config.DownloadDir == "" {
+		config.DownloadDir = downloadDir()
+	}
+
 	// Validate final port
 	if config.Port < 1024 || config.Port > 65535 {
 		fmt.Fprintf(os.Stderr, "Error: Port must be between 1024 and 65535\n")
@@ -135,6 +559,112 @@ func parseArgs() *Config {
 	return config
 }
 
+// applySettings fills in the options the command line left out from the environment and the config file
+func applySettings(path, profile string, settable map[string]bool) error {
+	// Only a file somebody asked for has to exist
+	required := true
+	if path == "" {
+		path = os.Getenv(settings.EnvConfig)
+	}
+	if path == "" {
+		path, required = settings.Path(), false
+	}
+	if profile == "" {
+		profile = os.Getenv(settings.EnvProfile)
+	}
+
+	file, err := settings.Load(path, required)
+	if err != nil {
+		return err
+	}
+	layer, err := file.Profile(profile)
+	if err != nil {
+		return err
+	}
+	return settings.Apply(flag.CommandLine, settable, settings.FromEnv(os.Environ()), layer)
+}
+
+// parsePortRange reads a port range like 8080-8999
+func parsePortRange(value string) (PortRange, error) {
+	first, last, ok := strings.Cut(value, "-")
+	if !ok {
+		return PortRange{}, fmt.Errorf("invalid port range %q, use first-last like 8080-8999", value)
+	}
+
+	var r PortRange
+	var err1, err2 error
+	r.First, err1 = strconv.Atoi(strings.TrimSpace(first))
+	r.Last, err2 = strconv.Atoi(strings.TrimSpace(last))
+	if err1 != nil || err2 != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q, use first-last like 8080-8999", value)
+	}
+	if r.First < 1024 || r.Last > 65535 || r.First > r.Last {
+		return PortRange{}, fmt.Errorf("port range %s must be within 1024-65535", r)
+	}
+	return r, nil
+}
+
+// keyDir returns where a user's key files live: $XDG_CONFIG_HOME/p2pchat/keys/<username>
+func keyDir(username string) string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = "." // No home directory, keep keys next to the binary
+	}
+	return filepath.Join(configDir, "p2pchat", "keys", username)
+}
+
+// historyPath returns where a user's message log lives: $XDG_DATA_HOME/p2pchat/<username>/history.jsonl
+func historyPath(username string) string {
+	return filepath.Join(dataDir(), "p2pchat", username, "history.jsonl")
+}
+
+// peerFilePath returns where /connect remembers addresses: $XDG_DATA_HOME/p2pchat/<username>/peers
+func peerFilePath(username string) string {
+	return filepath.Join(dataDir(), "p2pchat", username, "peers")
+}
+
+// instanceLockPath returns the lock of whoever runs as a user: $XDG_DATA_HOME/p2pchat/<username>/instance.lock
+func instanceLockPath(username string) string {
+	return filepath.Join(dataDir(), "p2pchat", username, "instance.lock")
+}
+
+// outboxPath returns where messages for peers that are away wait: $XDG_DATA_HOME/p2pchat/<username>/outbox.jsonl
+func outboxPath(username string) string {
+	return filepath.Join(dataDir(), "p2pchat", username, "outbox.jsonl")
+}
+
+// dataDir follows the XDG base directory spec, defaulting to ~/.local/share
+func dataDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "." // No home directory, keep history next to the binary
+	}
+	return filepath.Join(home, ".local", "share")
+}
+
+// socketPath returns where a user's daemon listens: $XDG_RUNTIME_DIR/p2pchat/<username>.sock
+func socketPath(username string) string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "p2pchat", username+".sock")
+	}
+	return filepath.Join(dataDir(), "p2pchat", username, "daemon.sock")
+}
+
+// downloadDir returns where received files go: $XDG_DOWNLOAD_DIR/p2pchat or ~/Downloads/p2pchat
+func downloadDir() string {
+	if dir := os.Getenv("XDG_DOWNLOAD_DIR"); dir != "" {
+		return filepath.Join(dir, "p2pchat")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "downloads"
+	}
+	return filepath.Join(home, "Downloads", "p2pchat")
+}
+
 func getDefaultUsername() string {
 	if username := os.Getenv("USER"); username != "" {
 		return username
@@ -162,7 +692,7 @@ func enhanceConfigInteractively(config *Config) *Config {
 
 	// Auto-assign port if not provided
 	if config.Port == 0 {
-		config.Port = findAvailablePort()
+		config.Port = findAvailablePortIn(config.PortRange)
 		fmt.Printf("🔌 Auto-assigned port: %d\n", config.Port)
 	}
 
@@ -205,16 +735,21 @@ func promptForUsername() string {
 	}
 }
 
-// findAvailablePort automatically finds an available port in the range
+// findAvailablePort automatically finds an available port in the default range
 func findAvailablePort() int {
-	for port := PortRangeStart; port <= PortRangeEnd; port++ {
+	return findAvailablePortIn(PortRange{PortRangeStart, PortRangeEnd})
+}
+
+// findAvailablePortIn finds an available port in a range, or lets the system pick one
+func findAvailablePortIn(portRange PortRange) int {
+	for port := portRange.First; port <= portRange.Last; port++ {
 		if isPortAvailable(port) {
 			return port
 		}
 	}
 
 	// Fallback to system-assigned port if range is exhausted
-	fmt.Printf("⚠️  Preferred port range (8080-8999) full, using system-assigned port...\n")
+	fmt.Fprintf(os.Stderr, "⚠️  Preferred port range (%s) full, using system-assigned port...\n", portRange)
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "❌ Failed to find available port: %v\n", err)
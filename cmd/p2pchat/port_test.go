@@ -113,3 +113,16 @@ func TestUsernameValidation(t *testing.T) {
 		t.Errorf("Default username '%s' is too long (%d chars, max 20)", username, len(username))
 	}
 }
+
+func TestParsePortRange(t *testing.T) {
+	r, err := parsePortRange("9000 - 9010")
+	if err != nil || r.First != 9000 || r.Last != 9010 {
+		t.Errorf("Expected 9000-9010, got %v (%v)", r, err)
+	}
+
+	for _, value := range []string{"9000", "a-b", "80-90", "9010-9000", "60000-70000"} {
+		if _, err := parsePortRange(value); err == nil {
+			t.Errorf("Expected parsePortRange(%q) to fail", value)
+		}
+	}
+}
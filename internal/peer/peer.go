@@ -1,7 +1,10 @@
 package peer
 
 import (
+	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,6 +15,14 @@ type Peer struct {
 	Address  *net.TCPAddr // IP and port for TCP connections
 	LastSeen time.Time    // When we last heard from this peer
 	Status   PeerStatus   // Current status
+	Source   Source       // How we learned about this peer
+
+	// Interface its beacons come in on, like "wlan0", "" if it wasn't heard on the LAN
+	Interface string
+
+	// Availability the user set for themselves (/away, /busy)
+	Presence       Presence
+	PresenceReason string
 }
 
 // PeerStatus represents the current state of a peer
@@ -38,6 +49,56 @@ func (s PeerStatus) String() string {
 	}
 }
 
+// Source says how we learned about a peer
+type Source string
+
+const (
+	SourceMulticast Source = "multicast" // Heard its beacon on the LAN
+	SourceMDNS      Source = "mdns"      // Saw its DNS-SD records on the LAN
+	SourceBroadcast Source = "broadcast" // Heard its beacon as a subnet broadcast
+	SourceExchange  Source = "pex"       // A connected peer told us about it (peer exchange)
+	SourceStatic    Source = "static"    // We were given its address (-peer, /connect)
+	SourceIncoming  Source = "incoming"  // It dialed us, nobody told us about it
+)
+
+// Presence is the availability a user sets for themselves
+// Unlike PeerStatus it says nothing about the network, only whether they want to talk
+type Presence string
+
+const (
+	PresenceAvailable Presence = ""     // Nothing set, older peers never send presence
+	PresenceAway      Presence = "away" // Stepped away, replies will be slow
+	PresenceBusy      Presence = "busy" // Around but doesn't want to be disturbed
+)
+
+// MaxPresenceReason limits away messages so they fit in discovery packets
+const MaxPresenceReason = 64
+
+// IsValid returns true for the presence values we know how to show
+func (p Presence) IsValid() bool {
+	return p == PresenceAvailable || p == PresenceAway || p == PresenceBusy
+}
+
+// String returns human-readable presence
+func (p Presence) String() string {
+	if p == PresenceAvailable {
+		return "available"
+	}
+	return string(p)
+}
+
+// CleanPresence turns untrusted presence from the network into something safe to show
+func CleanPresence(presence Presence, reason string) (Presence, string) {
+	if !presence.IsValid() || presence == PresenceAvailable {
+		return PresenceAvailable, ""
+	}
+	reason = strings.Join(strings.Fields(reason), " ") // No newlines or control spacing in the sidebar
+	if runes := []rune(reason); len(runes) > MaxPresenceReason {
+		reason = string(runes[:MaxPresenceReason])
+	}
+	return presence, reason
+}
+
 // IsAlive returns true if peer should be considered active
 func (p *Peer) IsAlive() bool {
 	return p.Status == PeerStatusOnline || p.Status == PeerStatusStale
@@ -62,3 +123,45 @@ func (p *Peer) CheckTimeout(staleThreshold, offlineThreshold time.Duration) {
 		p.Status = PeerStatusOnline
 	}
 }
+
+// StaticPrefix starts the placeholder ID of a peer we were given an address for
+// The real ID is only known once the peer identifies itself on connect
+const StaticPrefix = "static:"
+
+// NewStaticPeer creates a placeholder for a peer at host:port, for networks where discovery can't find it
+func NewStaticPeer(address string) (*Peer, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer address %q, use host:port", address)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 || host == "" {
+		return nil, fmt.Errorf("invalid peer address %q, use host:port", address)
+	}
+
+	// Names may not resolve until the VPN is up, dialing resolves them again
+	addr, _ := net.ResolveTCPAddr("tcp", address)
+
+	return &Peer{
+		ID:       StaticPrefix + address,
+		Username: address,
+		Address:  addr,
+		LastSeen: time.Now(),
+		Status:   PeerStatusUnknown,
+		Source:   SourceStatic,
+	}, nil
+}
+
+// IsStatic returns true for placeholders made by NewStaticPeer
+func (p *Peer) IsStatic() bool {
+	return IsStaticID(p.ID)
+}
+
+// StaticAddress returns the host:port of a placeholder made by NewStaticPeer
+func (p *Peer) StaticAddress() string {
+	return strings.TrimPrefix(p.ID, StaticPrefix)
+}
+
+// IsStaticID returns true for the placeholder IDs of NewStaticPeer
+func IsStaticID(id string) bool {
+	return strings.HasPrefix(id, StaticPrefix)
+}
@@ -0,0 +1,320 @@
+package chat
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"p2pchat/pkg/logger"
+)
+
+// Annotations: reactions, edits and deletes are messages of their own that
+// point at another message through TargetID. They are stored, relayed and
+// synced like chat messages, and MessageHistory applies them to their target
+// when both are present - in whatever order they arrive. Only the author of
+// a message may edit or delete it. Removing a reaction is a delete of the
+// reaction message, so each reaction belongs to exactly one author as well.
+const (
+	// MaxReactionLength limits a reaction to an emoji or a short word
+	MaxReactionLength = 16
+
+	// maxPendingAnnotations caps annotations waiting for a target we never saw
+	maxPendingAnnotations = 1000
+)
+
+// IsAnnotation returns true for reactions, edits and deletes
+func (m *Message) IsAnnotation() bool {
+	switch m.Type {
+	case MessageTypeReaction, MessageTypeEdit, MessageTypeDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// Text returns what to show for a message: the latest edit, or nothing once deleted
+func (m *Message) Text() string {
+	if m.Deleted {
+		return ""
+	}
+	if edits := m.Edits; len(edits) > 0 {
+		return edits[len(edits)-1].Content
+	}
+	return m.Content
+}
+
+// IsEdited returns true if the author changed the message after sending it
+func (m *Message) IsEdited() bool {
+	return len(m.Edits) > 0
+}
+
+// isAnnotatable returns true for messages people can react to, edit or delete
+func isAnnotatable(msg *Message) bool {
+	return msg.Type == MessageTypeChat || msg.Type == MessageTypeDirect
+}
+
+// newAnnotation creates an annotation that lands in the same room or DM as its target
+func newAnnotation(msgType MessageType, senderID, username string, target *Message, content string, sequence uint64) *Message {
+	msg := NewRoomMessage(senderID, username, "", content, sequence)
+	msg.Type = msgType
+	msg.TargetID = target.ID
+
+	if conversationID := target.ConversationID(); IsDirectConversation(conversationID) {
+		msg.RecipientID = DirectPeer(conversationID, senderID)
+	} else {
+		msg.RoomID = conversationID
+	}
+	return msg
+}
+
+// NewReactionMessage reacts to target with an emoji
+func NewReactionMessage(senderID, username string, target *Message, emoji string, sequence uint64) *Message {
+	return newAnnotation(MessageTypeReaction, senderID, username, target, emoji, sequence)
+}
+
+// NewEditMessage replaces the text of target (which must be ours)
+func NewEditMessage(senderID, username string, target *Message, content string, sequence uint64) *Message {
+	return newAnnotation(MessageTypeEdit, senderID, username, target, content, sequence)
+}
+
+// NewDeleteMessage tombstones target (which must be ours)
+func NewDeleteMessage(senderID, username string, target *Message, sequence uint64) *Message {
+	return newAnnotation(MessageTypeDelete, senderID, username, target, "", sequence)
+}
+
+// checkAnnotation returns why an annotation may not be applied to target, if it may not
+func checkAnnotation(annotation, target *Message) error {
+	if annotation.ConversationID() != target.ConversationID() {
+		return fmt.Errorf("message %s is in another conversation", target.ID)
+	}
+	if target.Deleted {
+		return fmt.Errorf("message %s was deleted", target.ID)
+	}
+
+	switch annotation.Type {
+	case MessageTypeReaction:
+		if !isAnnotatable(target) {
+			return fmt.Errorf("cannot react to a %s message", target.Type)
+		}
+		emoji := annotation.Content
+		if emoji == "" || len(emoji) > MaxReactionLength || strings.ContainsAny(emoji, " \t\r\n") {
+			return fmt.Errorf("invalid reaction %q", emoji)
+		}
+	case MessageTypeEdit:
+		if !isAnnotatable(target) {
+			return fmt.Errorf("cannot edit a %s message", target.Type)
+		}
+		if annotation.Content == "" {
+			return fmt.Errorf("cannot edit a message to be empty, delete it instead")
+		}
+	case MessageTypeDelete:
+		if !isAnnotatable(target) && target.Type != MessageTypeReaction {
+			return fmt.Errorf("cannot delete a %s message", target.Type)
+		}
+	}
+
+	// Anyone may react, only the author may change or remove what they wrote
+	if annotation.Type != MessageTypeReaction && annotation.SenderID != target.SenderID {
+		return fmt.Errorf("only %s can change message %s", target.Username, target.ID)
+	}
+	return nil
+}
+
+// CheckAnnotation returns an error if an annotation would be rejected right now
+func (h *MessageHistory) CheckAnnotation(annotation *Message) error {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	target := h.messageIDs[annotation.TargetID]
+	if target == nil {
+		return fmt.Errorf("message %s not found", annotation.TargetID)
+	}
+	return checkAnnotation(annotation, target)
+}
+
+// GetMessage returns a stored message by ID, or nil
+func (h *MessageHistory) GetMessage(messageID string) *Message {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.messageIDs[messageID]
+}
+
+// FindReaction returns the live reaction a peer left on a message with an emoji, or nil
+func (h *MessageHistory) FindReaction(targetID, senderID, emoji string) *Message {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	target := h.messageIDs[targetID]
+	if target == nil {
+		return nil
+	}
+	for _, reaction := range target.reactions {
+		if reaction.SenderID == senderID && reaction.Content == emoji && !reaction.Deleted {
+			return reaction
+		}
+	}
+	return nil
+}
+
+// annotate applies an annotation to its target, or parks it until the target arrives
+// Returns false if the annotation must be rejected
+// This must be called with mutex already locked!
+func (h *MessageHistory) annotate(annotation *Message) bool {
+	target := h.messageIDs[annotation.TargetID]
+	if target == nil {
+		if len(h.pending) >= maxPendingAnnotations {
+			logger.Debug("⏩ Too many annotations waiting, dropping %s", annotation.ID)
+			return true // Still kept, it just won't apply
+		}
+		h.pending[annotation.TargetID] = append(h.pending[annotation.TargetID], annotation)
+		return true
+	}
+
+	if err := checkAnnotation(annotation, target); err != nil {
+		logger.Error("🚫 Ignoring %s from %s: %v", annotation.Type, annotation.Username, err)
+		return false
+	}
+	h.apply(annotation, target)
+	return true
+}
+
+// applyPending applies annotations that arrived before msg did
+// This must be called with mutex already locked!
+func (h *MessageHistory) applyPending(msg *Message) {
+	waiting := h.pending[msg.ID]
+	if len(waiting) == 0 {
+		return
+	}
+	delete(h.pending, msg.ID)
+
+	// Edits must apply in the order they were made
+	sort.SliceStable(waiting, func(i, j int) bool {
+		return causallyBefore(waiting[i], waiting[j])
+	})
+	for _, annotation := range waiting {
+		target := h.messageIDs[msg.ID] // Each annotation swaps in a new copy
+		if err := checkAnnotation(annotation, target); err != nil {
+			logger.Error("🚫 Ignoring %s from %s: %v", annotation.Type, annotation.Username, err)
+			continue
+		}
+		h.apply(annotation, target)
+	}
+}
+
+// apply changes target according to a checked annotation
+// Messages handed out by history are never modified, the UI and the daemon
+// read them without the lock. Every change swaps in a changed copy instead.
+// This must be called with mutex already locked!
+func (h *MessageHistory) apply(annotation, target *Message) {
+	switch annotation.Type {
+	case MessageTypeReaction:
+		h.update(target, func(m *Message) {
+			m.reactions = append(slices.Clone(m.reactions), annotation)
+			m.Reactions = aggregateReactions(m.reactions)
+		})
+
+	case MessageTypeEdit:
+		h.update(target, func(m *Message) {
+			edits := append(slices.Clone(m.Edits), annotation)
+			sort.SliceStable(edits, func(i, j int) bool {
+				return causallyBefore(edits[i], edits[j])
+			})
+			m.Edits = edits
+		})
+
+	case MessageTypeDelete:
+		// Wipe every version of the text, not just the original
+		for _, edit := range target.Edits {
+			h.update(edit, func(m *Message) {
+				m.Content = ""
+				m.Deleted = true
+			})
+		}
+		h.update(target, func(m *Message) {
+			m.Content = ""
+			m.Edits = nil
+			m.Deleted = true
+		})
+	}
+}
+
+// update replaces msg with a changed copy everywhere history holds it
+// This must be called with mutex already locked!
+func (h *MessageHistory) update(msg *Message, change func(*Message)) {
+	updated := *msg
+	change(&updated)
+
+	if h.messageIDs[msg.ID] == msg {
+		h.messageIDs[msg.ID] = &updated
+	}
+	swap(h.rooms[msg.ConversationID()], msg, &updated)
+	if msg.ReplyTo != "" {
+		swap(h.replies[msg.ReplyTo], msg, &updated)
+	}
+	if msg.IsAnnotation() {
+		swap(h.pending[msg.TargetID], msg, &updated)
+	}
+
+	// A reaction also lives in the reactions of the message it is on
+	if msg.Type == MessageTypeReaction {
+		if parent := h.messageIDs[msg.TargetID]; parent != nil && slices.Contains(parent.reactions, msg) {
+			h.update(parent, func(m *Message) {
+				m.reactions = slices.Clone(m.reactions)
+				swap(m.reactions, msg, &updated)
+				m.Reactions = aggregateReactions(m.reactions)
+			})
+		}
+	}
+}
+
+// swap replaces old with updated in messages, the slice itself is only ever copied under the lock
+func swap(messages []*Message, old, updated *Message) {
+	if i := slices.Index(messages, old); i >= 0 {
+		messages[i] = updated
+	}
+}
+
+// aggregateReactions groups live reactions as emoji -> usernames
+func aggregateReactions(reactions []*Message) map[string][]string {
+	aggregated := make(map[string][]string)
+	for _, reaction := range reactions {
+		if reaction.Deleted {
+			continue
+		}
+		aggregated[reaction.Content] = append(aggregated[reaction.Content], reaction.Username)
+	}
+	if len(aggregated) == 0 {
+		return nil
+	}
+	return aggregated
+}
+
+// redactDeleted blanks the text of deleted messages (and their edits) in a loaded log
+// Returns how many entries changed so the caller knows to rewrite the file
+func redactDeleted(messages []*Message) int {
+	byID := make(map[string]*Message, len(messages))
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+	}
+
+	deleted := make(map[string]bool)
+	for _, msg := range messages {
+		if msg.Type != MessageTypeDelete {
+			continue
+		}
+		if target := byID[msg.TargetID]; target != nil && target.SenderID == msg.SenderID {
+			deleted[target.ID] = true
+		}
+	}
+
+	redacted := 0
+	for _, msg := range messages {
+		gone := deleted[msg.ID] || (msg.Type == MessageTypeEdit && deleted[msg.TargetID])
+		if gone && msg.Content != "" {
+			msg.Content = ""
+			redacted++
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotationsApplyToTarget(t *testing.T) {
+	history := NewMessageHistory(100)
+	original := NewChatMessage("alice-id", "alice", "lunch at 12?", 1)
+	history.AddMessage(original)
+	current := func() *Message { return history.GetMessage(original.ID) }
+
+	// Anyone can react
+	thumbs := NewReactionMessage("bob-id", "bob", original, "👍", 1)
+	history.AddMessage(thumbs)
+	history.AddMessage(NewReactionMessage("carol-id", "carol", original, "👍", 1))
+	party := NewReactionMessage("carol-id", "carol", original, "🎉", 2)
+	history.AddMessage(party)
+	if got := current().Reactions["👍"]; len(got) != 2 {
+		t.Errorf("Expected two 👍 reactions, got %v", got)
+	}
+
+	// Taking a reaction back is a delete of the reaction
+	history.AddMessage(NewDeleteMessage("bob-id", "bob", thumbs, 2))
+	if got := current().Reactions["👍"]; len(got) != 1 || got[0] != "carol" {
+		t.Errorf("Expected only carol's 👍 after bob took his back, got %v", got)
+	}
+
+	// Only the author may edit or delete
+	if history.AddMessage(NewEditMessage("bob-id", "bob", original, "lunch at 1", 3)) {
+		t.Error("Bob must not be able to edit Alice's message")
+	}
+	if history.AddMessage(NewDeleteMessage("bob-id", "bob", original, 4)) {
+		t.Error("Bob must not be able to delete Alice's message")
+	}
+	if history.AddMessage(NewDeleteMessage("alice-id", "alice", party, 2)) {
+		t.Error("Alice must not be able to remove Carol's reaction")
+	}
+
+	history.AddMessage(NewEditMessage("alice-id", "alice", original, "lunch at 12:30?", 2))
+	history.AddMessage(NewEditMessage("alice-id", "alice", original, "lunch at 1?", 3))
+	if current().Text() != "lunch at 1?" || len(current().Edits) != 2 {
+		t.Errorf("Expected the latest edit with history kept, got %q after %d edits", current().Text(), len(current().Edits))
+	}
+	if current().Content != "lunch at 12?" {
+		t.Error("The signed original content must stay as sent")
+	}
+
+	history.AddMessage(NewDeleteMessage("alice-id", "alice", original, 4))
+	if !current().Deleted || current().Text() != "" || current().Content != "" {
+		t.Error("Deleted message should be a tombstone without text")
+	}
+	if history.AddMessage(NewReactionMessage("bob-id", "bob", original, "😮", 5)) {
+		t.Error("Reacting to a deleted message should be rejected")
+	}
+
+	// What was handed out stays as it was, readers don't hold the lock
+	if original.Deleted || original.Content != "lunch at 12?" || len(original.Reactions) != 0 {
+		t.Error("Annotations must swap in a copy, not change a message others may be reading")
+	}
+}
+
+func TestAnnotationArrivingBeforeTarget(t *testing.T) {
+	history := NewMessageHistory(100)
+	original := NewChatMessage("alice-id", "alice", "helo", 1)
+	edit := NewEditMessage("alice-id", "alice", original, "hello", 2)
+	edit.Timestamp = original.Timestamp.Add(time.Second)
+	forged := NewEditMessage("mallory-id", "mallory", original, "pwned", 1)
+
+	// Sync and relays give no ordering guarantee
+	history.AddMessage(forged)
+	history.AddMessage(edit)
+	history.AddMessage(original)
+	current := func() *Message { return history.GetMessage(original.ID) }
+
+	if current().Text() != "hello" {
+		t.Errorf("Expected the early edit to apply once the target arrived, got %q", current().Text())
+	}
+	if len(current().Edits) != 1 {
+		t.Errorf("Forged edit should not apply, got %d edits", len(current().Edits))
+	}
+}
+
+func TestDeletedMessageRedactedOnDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, _ := NewFileStore(path, DefaultRetention)
+	history := NewMessageHistory(100)
+	history.SetStore(store)
+
+	original := NewChatMessage("alice-id", "alice", "my password is hunter2", 1)
+	history.AddMessage(original)
+	history.AddMessage(NewEditMessage("alice-id", "alice", original, "my password is hunter3", 2))
+	history.AddMessage(NewDeleteMessage("alice-id", "alice", original, 3))
+	history.Close()
+
+	store, _ = NewFileStore(path, DefaultRetention)
+	restored := NewMessageHistory(100)
+	if err := restored.SetStore(store); err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	defer restored.Close()
+
+	if msg := restored.GetMessage(original.ID); msg == nil || !msg.Deleted {
+		t.Error("Deleted message should come back as a tombstone")
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "hunter") {
+		t.Error("Deleted text should be gone from the history file after reload")
+	}
+}
+
+func TestAnnotationsReachPeers(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	alice.SendMessage("ship it?")
+	waitFor(t, "message", func() bool { return bob.GetMessageCount() == 1 })
+	id := bob.GetRoomHistory(DefaultRoom)[0].ID
+
+	if err := bob.React(id, "🚀"); err != nil {
+		t.Fatalf("React failed: %v", err)
+	}
+	waitFor(t, "reaction", func() bool { return len(alice.GetMessage(id).Reactions["🚀"]) == 1 })
+
+	// Reacting again toggles it off
+	bob.React(id, "🚀")
+	waitFor(t, "reaction removed", func() bool { return len(alice.GetMessage(id).Reactions) == 0 })
+
+	if err := bob.EditMessage(id, "ship it!"); err == nil {
+		t.Error("Bob should not be able to edit Alice's message")
+	}
+
+	alice.EditMessage(id, "ship it on friday?")
+	waitFor(t, "edit", func() bool { return bob.GetMessage(id).Text() == "ship it on friday?" })
+
+	alice.DeleteMessage(id)
+	waitFor(t, "delete", func() bool { return bob.GetMessage(id).Deleted })
+
+	if len(bob.GetRoomHistory(DefaultRoom)) != 1 {
+		t.Error("Annotations should not show up as messages of their own")
+	}
+}
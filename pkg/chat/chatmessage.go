@@ -6,6 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/identity"
 )
 
 // Message represents a chat message that flows between peers via TCP
@@ -18,13 +21,52 @@ type Message struct {
 	Username string      `json:"username"`  // Display name of sender
 
 	// Message content
-	Content   string    `json:"content"`   // The actual message text (for chat messages)
-	Timestamp time.Time `json:"timestamp"` // When this message was created
-	Sequence  uint64    `json:"sequence"`  // Message ordering within sender's stream
+	Content   string    `json:"content"`         // The actual message text (for chat messages)
+	Timestamp time.Time `json:"timestamp"`       // When this message was created
+	Sequence  uint64    `json:"sequence"`        // Message ordering within sender's stream
+	Clock     int64     `json:"clock,omitempty"` // Hybrid logical time history is ordered by (see clock.go)
+	TTL       int       `json:"ttl,omitempty"`   // Hops left before relays stop forwarding (see gossip.go)
 
 	// Optional metadata
-	RoomID   string         `json:"room_id,omitempty"`  // Future: support multiple rooms
-	Metadata map[string]any `json:"metadata,omitempty"` // Future: extensibility
+	RoomID      string         `json:"room_id,omitempty"`      // Room this message belongs to (see rooms.go)
+	RecipientID string         `json:"recipient_id,omitempty"` // Peer ID of the recipient (direct messages only)
+	ReplyTo     string         `json:"reply_to,omitempty"`     // ID of the message this one answers (see threads.go)
+	Metadata    map[string]any `json:"metadata,omitempty"`     // Future: extensibility
+
+	// History sync (see sync.go)
+	Sync     *SyncSummary `json:"sync,omitempty"`     // What the requester already holds (sync requests)
+	Messages []*Message   `json:"messages,omitempty"` // Backfilled messages (sync batches)
+
+	// Peer exchange (see pex.go)
+	Exchange *PeerExchange `json:"exchange,omitempty"` // The sender's port and connected peers
+
+	// File transfer (see transfer.go)
+	File *FileTransfer `json:"file,omitempty"` // Offer, chunk or control data for file_* messages
+
+	// Presence (see presence.go) - heartbeats carry the away reason as Content
+	Presence peer.Presence `json:"presence,omitempty"`
+
+	// Reactions, edits and deletes point at the message they change (see annotations.go)
+	TargetID string `json:"target_id,omitempty"`
+
+	// Set when one of the sender's plugins wrote this rather than the sender (see plugins.go)
+	Bot string `json:"bot,omitempty"`
+
+	// Ident only: what the sender speaks on this link (see wire.go)
+	// Not covered by the ident signature, peers without versions couldn't verify it then;
+	// the encrypted stream already keeps anyone from changing them
+	Protocol     int      `json:"protocol,omitempty"`     // Highest protocol version
+	Capabilities []string `json:"capabilities,omitempty"` // Frame encodings understood
+
+	// Applied by MessageHistory, never sent - peers rebuild these from the annotations
+	Reactions map[string][]string `json:"-"` // emoji -> usernames that reacted
+	Edits     []*Message          `json:"-"` // Edit messages in the order they were made, latest wins
+	Deleted   bool                `json:"-"` // Tombstoned by its author
+	reactions []*Message          // Reaction messages behind Reactions
+
+	// Authentication - SenderID must be the fingerprint of PublicKey
+	PublicKey string `json:"public_key,omitempty"` // Sender's Ed25519 public key (base64)
+	Signature string `json:"signature,omitempty"`  // Signature over identSigningBytes or signingBytes (base64)
 }
 
 // MessageType defines the different kinds of messages in the chat protocol
@@ -33,18 +75,51 @@ type MessageType string
 const (
 	// Core chat messages
 	MessageTypeChat      MessageType = "chat"      // Regular text message: "Hello everyone!"
+	MessageTypeDirect    MessageType = "direct"    // Private message to a single peer: "psst, Bob"
 	MessageTypeJoin      MessageType = "join"      // User joined: "Alice joined the chat"
 	MessageTypeLeave     MessageType = "leave"     // User left: "Alice left the chat"
-	MessageTypeHeartbeat MessageType = "heartbeat" // Keep-alive: used for connection health
+	MessageTypeHeartbeat MessageType = "heartbeat" // Keep-alive: connection health and presence
+	MessageTypeTyping    MessageType = "typing"    // "Alice is typing..." (never stored)
+
+	// Room membership
+	MessageTypeRoomJoin MessageType = "room_join" // User joined a room: "Alice joined #standup"
+	MessageTypeRoomPart MessageType = "room_part" // User left a room: "Alice left #standup"
+
+	// History sync
+	MessageTypeSyncRequest MessageType = "sync_request" // "Here is what I have, send me the rest"
+	MessageTypeSyncBatch   MessageType = "sync_batch"   // A chunk of messages the requester was missing
+
+	// Peer exchange (see pex.go)
+	MessageTypePeerExchange MessageType = "peer_exchange" // "I listen on 8080, and here is who else I'm connected to"
+
+	// File transfer (direct to one peer, never stored or relayed)
+	MessageTypeFileOffer  MessageType = "file_offer"  // "Want report.log (12 KB)?"
+	MessageTypeFileAccept MessageType = "file_accept" // "Yes, start at chunk N"
+	MessageTypeFileReject MessageType = "file_reject" // "No thanks"
+	MessageTypeFileChunk  MessageType = "file_chunk"  // A piece of the file
+	MessageTypeFileAck    MessageType = "file_ack"    // "Verified up to chunk N"
+	MessageTypeFileCancel MessageType = "file_cancel" // Either side gave up
+
+	// Annotations of an earlier message, referenced by TargetID
+	MessageTypeReaction MessageType = "reaction" // "👍 on that"
+	MessageTypeEdit     MessageType = "edit"     // "I meant to say..."
+	MessageTypeDelete   MessageType = "delete"   // "Forget I said that" (or un-react)
 
-	// Future message types I might add:
-	// MessageTypeTyping   MessageType = "typing"    // "Alice is typing..."
-	// MessageTypeFile     MessageType = "file"      // File transfer
-	// MessageTypeReaction MessageType = "reaction"  // Message reactions
+	// Receipts for the author of a message, referenced by TargetID (see receipts.go)
+	MessageTypeDelivered MessageType = "delivered" // "Got it"
+	MessageTypeRead      MessageType = "read"      // "Saw it"
+
+	// Local only, never sent or stored
+	MessageTypeNotice MessageType = "notice" // A plugin talking to its own user: "⏰ stand up"
 )
 
-// NewChatMessage creates a regular chat message
+// NewChatMessage creates a regular chat message in the default room
 func NewChatMessage(senderID, username, content string, sequence uint64) *Message {
+	return NewRoomMessage(senderID, username, DefaultRoom, content, sequence)
+}
+
+// NewRoomMessage creates a regular chat message in a specific room
+func NewRoomMessage(senderID, username, roomID, content string, sequence uint64) *Message {
 	return &Message{
 		ID:        generateMessageID(),
 		Type:      MessageTypeChat,
@@ -53,8 +128,41 @@ func NewChatMessage(senderID, username, content string, sequence uint64) *Messag
 		Content:   content,
 		Timestamp: time.Now(),
 		Sequence:  sequence,
-		RoomID:    "general", // Default room for now
+		RoomID:    roomID,
+	}
+}
+
+// NewDirectMessage creates a private message for a single peer
+func NewDirectMessage(senderID, username, recipientID, content string, sequence uint64) *Message {
+	return &Message{
+		ID:          generateMessageID(),
+		Type:        MessageTypeDirect,
+		SenderID:    senderID,
+		Username:    username,
+		Content:     content,
+		Timestamp:   time.Now(),
+		Sequence:    sequence,
+		RecipientID: recipientID,
+	}
+}
+
+// NewNoticeMessage creates a local notice from a plugin, shown in a room or DM but never sent
+func NewNoticeMessage(senderID, botName, conversationID, text string) *Message {
+	msg := &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeNotice,
+		SenderID:  senderID,
+		Username:  botName,
+		Content:   text,
+		Timestamp: time.Now(),
+		Bot:       botName,
+	}
+	if IsDirectConversation(conversationID) {
+		msg.RecipientID = DirectPeer(conversationID, senderID)
+	} else {
+		msg.RoomID = conversationID
 	}
+	return msg
 }
 
 // NewJoinMessage creates a user join notification
@@ -67,7 +175,7 @@ func NewJoinMessage(senderID, username string, sequence uint64) *Message {
 		Content:   fmt.Sprintf("%s joined the chat", username),
 		Timestamp: time.Now(),
 		Sequence:  sequence,
-		RoomID:    "general",
+		RoomID:    DefaultRoom,
 	}
 }
 
@@ -81,7 +189,35 @@ func NewLeaveMessage(senderID, username string, sequence uint64) *Message {
 		Content:   fmt.Sprintf("%s left the chat", username),
 		Timestamp: time.Now(),
 		Sequence:  sequence,
-		RoomID:    "general",
+		RoomID:    DefaultRoom,
+	}
+}
+
+// NewRoomJoinMessage announces that a user joined a room
+func NewRoomJoinMessage(senderID, username, roomID string, sequence uint64) *Message {
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeRoomJoin,
+		SenderID:  senderID,
+		Username:  username,
+		Content:   fmt.Sprintf("%s joined #%s", username, roomID),
+		Timestamp: time.Now(),
+		Sequence:  sequence,
+		RoomID:    roomID,
+	}
+}
+
+// NewRoomPartMessage announces that a user left a room
+func NewRoomPartMessage(senderID, username, roomID string, sequence uint64) *Message {
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeRoomPart,
+		SenderID:  senderID,
+		Username:  username,
+		Content:   fmt.Sprintf("%s left #%s", username, roomID),
+		Timestamp: time.Now(),
+		Sequence:  sequence,
+		RoomID:    roomID,
 	}
 }
 
@@ -95,10 +231,149 @@ func NewHeartbeatMessage(senderID, username string, sequence uint64) *Message {
 		Content:   "", // Heartbeats don't need content
 		Timestamp: time.Now(),
 		Sequence:  sequence,
-		RoomID:    "general",
+		RoomID:    DefaultRoom,
+	}
+}
+
+// NewTypingMessage tells a room (or one peer if recipientID is set) that we are typing
+func NewTypingMessage(senderID, username, roomID, recipientID string, sequence uint64) *Message {
+	return &Message{
+		ID:          generateMessageID(),
+		Type:        MessageTypeTyping,
+		SenderID:    senderID,
+		Username:    username,
+		Timestamp:   time.Now(),
+		Sequence:    sequence,
+		RoomID:      roomID,
+		RecipientID: recipientID,
 	}
 }
 
+// NewSyncRequestMessage asks a peer for the messages missing from summary
+func NewSyncRequestMessage(senderID, username string, summary *SyncSummary, sequence uint64) *Message {
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeSyncRequest,
+		SenderID:  senderID,
+		Username:  username,
+		Timestamp: time.Now(),
+		Sequence:  sequence,
+		Sync:      summary,
+	}
+}
+
+// NewSyncBatchMessage carries backfilled messages in reply to a sync request
+func NewSyncBatchMessage(senderID, username string, messages []*Message, sequence uint64) *Message {
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypeSyncBatch,
+		SenderID:  senderID,
+		Username:  username,
+		Timestamp: time.Now(),
+		Sequence:  sequence,
+		Messages:  messages,
+	}
+}
+
+// NewPeerExchangeMessage shares the peers we are connected to with one neighbour
+func NewPeerExchangeMessage(senderID, username string, exchange *PeerExchange, sequence uint64) *Message {
+	return &Message{
+		ID:        generateMessageID(),
+		Type:      MessageTypePeerExchange,
+		SenderID:  senderID,
+		Username:  username,
+		Timestamp: time.Now(),
+		Sequence:  sequence,
+		Exchange:  exchange,
+	}
+}
+
+// NewFileMessage creates one step of a file transfer with a peer
+func NewFileMessage(senderID, username, recipientID string, msgType MessageType, file *FileTransfer, sequence uint64) *Message {
+	return &Message{
+		ID:          generateMessageID(),
+		Type:        msgType,
+		SenderID:    senderID,
+		Username:    username,
+		Timestamp:   time.Now(),
+		Sequence:    sequence,
+		RecipientID: recipientID,
+		File:        file,
+	}
+}
+
+// IsFileTransfer returns true for the file_* message types
+func (m *Message) IsFileTransfer() bool {
+	switch m.Type {
+	case MessageTypeFileOffer, MessageTypeFileAccept, MessageTypeFileReject,
+		MessageTypeFileChunk, MessageTypeFileAck, MessageTypeFileCancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewIdentMessage creates the signed identification sent first on every connection
+// The signature covers the connection's handshake hash, so it can't be replayed on another link
+func NewIdentMessage(id *identity.Identity, username string, handshakeHash []byte) *Message {
+	msg := NewJoinMessage(id.PeerID(), username, 0)
+	msg.PublicKey = id.PublicKeyString()
+	msg.Signature = id.Sign(msg.identSigningBytes(handshakeHash))
+	return msg
+}
+
+// VerifyIdent checks that an identification message was signed by the owner of SenderID
+// for the connection with the given handshake hash
+func (m *Message) VerifyIdent(handshakeHash []byte) error {
+	return identity.Verify(m.SenderID, m.PublicKey, m.Signature, m.identSigningBytes(handshakeHash))
+}
+
+// identSigningBytes returns the canonical encoding of every field covered by the ident signature
+func (m *Message) identSigningBytes(handshakeHash []byte) []byte {
+	data, _ := json.Marshal(struct {
+		Context       string `json:"context"`
+		SenderID      string `json:"sender_id"`
+		Username      string `json:"username"`
+		Timestamp     int64  `json:"timestamp"`
+		HandshakeHash string `json:"handshake_hash"`
+	}{"p2pchat-ident", m.SenderID, m.Username, m.Timestamp.UnixNano(), hex.EncodeToString(handshakeHash)})
+	return data
+}
+
+// Sign attaches the author's signature so the message can be verified after it was
+// relayed or backfilled by someone else
+func (m *Message) Sign(id *identity.Identity) {
+	m.PublicKey = id.PublicKeyString()
+	m.Signature = id.Sign(m.signingBytes())
+}
+
+// VerifySignature checks that the message was signed by the owner of SenderID
+func (m *Message) VerifySignature() error {
+	return identity.Verify(m.SenderID, m.PublicKey, m.Signature, m.signingBytes())
+}
+
+// signingBytes returns the canonical encoding of every field covered by a message signature
+func (m *Message) signingBytes() []byte {
+	data, _ := json.Marshal(struct {
+		Context     string      `json:"context"`
+		ID          string      `json:"id"`
+		Type        MessageType `json:"type"`
+		SenderID    string      `json:"sender_id"`
+		Username    string      `json:"username"`
+		Content     string      `json:"content"`
+		Timestamp   int64       `json:"timestamp"`
+		Sequence    uint64      `json:"sequence"`
+		Clock       int64       `json:"clock,omitempty"` // Left out when unset, so messages from before clocks still verify
+		RoomID      string      `json:"room_id"`
+		RecipientID string      `json:"recipient_id"`
+		TargetID    string      `json:"target_id"`
+		ReplyTo     string      `json:"reply_to"`
+		Bot         string      `json:"bot"`
+	}{"p2pchat-message", m.ID, m.Type, m.SenderID, m.Username, m.Content,
+		m.Timestamp.UnixNano(), m.Sequence, m.Clock, m.RoomID, m.RecipientID, m.TargetID, m.ReplyTo, m.Bot})
+	return data
+}
+
 // Serialization methods
 
 // ToJSON serializes the message for network transmission
@@ -131,9 +406,28 @@ func FromJSON(data []byte) (*Message, error) {
 // Utility methods
 
 // IsUserVisible returns true if this message should be shown to users
-// (heartbeats are hidden from the UI)
+// (heartbeats and typing notifications are hidden from the UI)
 func (m *Message) IsUserVisible() bool {
-	return m.Type != MessageTypeHeartbeat
+	return m.Type != MessageTypeHeartbeat && m.Type != MessageTypeTyping
+}
+
+// IsRoomScoped returns true if only members of the message's room should receive it
+// Membership announcements go to everyone so all peers can track who is where
+func (m *Message) IsRoomScoped() bool {
+	return (m.Type == MessageTypeChat || m.Type == MessageTypeTyping || m.IsAnnotation()) && m.RoomID != ""
+}
+
+// ConversationID returns the history partition this message belongs to
+// Direct messages (and anything else addressed to one peer) get a key shared by both
+// participants, everything else uses its room
+func (m *Message) ConversationID() string {
+	if m.Type == MessageTypeDirect || m.RecipientID != "" {
+		return DirectConversationID(m.SenderID, m.RecipientID)
+	}
+	if m.RoomID == "" {
+		return DefaultRoom // Older peers don't set a room
+	}
+	return m.RoomID
 }
 
 // IsRecent checks if message is within acceptable time window
@@ -148,15 +442,45 @@ func (m *Message) String() string {
 	case MessageTypeChat:
 		return fmt.Sprintf("[%s] %s: %s",
 			m.Timestamp.Format("15:04:05"), m.Username, m.Content)
+	case MessageTypeDirect:
+		return fmt.Sprintf("[%s] %s -> %s: %s",
+			m.Timestamp.Format("15:04:05"), m.Username, m.RecipientID, m.Content)
 	case MessageTypeJoin:
 		return fmt.Sprintf("[%s] *** %s joined",
 			m.Timestamp.Format("15:04:05"), m.Username)
 	case MessageTypeLeave:
 		return fmt.Sprintf("[%s] *** %s left",
 			m.Timestamp.Format("15:04:05"), m.Username)
+	case MessageTypeRoomJoin:
+		return fmt.Sprintf("[%s] *** %s joined #%s",
+			m.Timestamp.Format("15:04:05"), m.Username, m.RoomID)
+	case MessageTypeRoomPart:
+		return fmt.Sprintf("[%s] *** %s left #%s",
+			m.Timestamp.Format("15:04:05"), m.Username, m.RoomID)
 	case MessageTypeHeartbeat:
 		return fmt.Sprintf("[%s] <heartbeat from %s>",
 			m.Timestamp.Format("15:04:05"), m.Username)
+	case MessageTypeTyping:
+		return fmt.Sprintf("[%s] <%s is typing>",
+			m.Timestamp.Format("15:04:05"), m.Username)
+	case MessageTypeReaction:
+		return fmt.Sprintf("[%s] *** %s reacted %s to %s",
+			m.Timestamp.Format("15:04:05"), m.Username, m.Content, m.TargetID)
+	case MessageTypeEdit:
+		return fmt.Sprintf("[%s] *** %s edited %s: %s",
+			m.Timestamp.Format("15:04:05"), m.Username, m.TargetID, m.Content)
+	case MessageTypeDelete:
+		return fmt.Sprintf("[%s] *** %s deleted %s",
+			m.Timestamp.Format("15:04:05"), m.Username, m.TargetID)
+	case MessageTypeDelivered, MessageTypeRead:
+		return fmt.Sprintf("[%s] <%s %s %s>",
+			m.Timestamp.Format("15:04:05"), m.Username, m.Type, m.TargetID)
+	case MessageTypeFileOffer:
+		return fmt.Sprintf("[%s] *** %s offers %s (%d bytes)",
+			m.Timestamp.Format("15:04:05"), m.Username, m.File.Name, m.File.Size)
+	case MessageTypeSyncBatch:
+		return fmt.Sprintf("[%s] <%d synced messages from %s>",
+			m.Timestamp.Format("15:04:05"), len(m.Messages), m.Username)
 	default:
 		return fmt.Sprintf("[%s] <%s from %s>",
 			m.Timestamp.Format("15:04:05"), m.Type, m.Username)
@@ -181,7 +505,18 @@ func generateMessageID() string {
 // IsValidMessageType checks if a message type is supported
 func IsValidMessageType(msgType MessageType) bool {
 	switch msgType {
-	case MessageTypeChat, MessageTypeJoin, MessageTypeLeave, MessageTypeHeartbeat:
+	case MessageTypeChat, MessageTypeJoin, MessageTypeLeave, MessageTypeHeartbeat, MessageTypeTyping:
+		return true
+	case MessageTypeRoomJoin, MessageTypeRoomPart, MessageTypeDirect:
+		return true
+	case MessageTypeSyncRequest, MessageTypeSyncBatch:
+		return true
+	case MessageTypeReaction, MessageTypeEdit, MessageTypeDelete:
+		return true
+	case MessageTypeDelivered, MessageTypeRead:
+		return true
+	case MessageTypeFileOffer, MessageTypeFileAccept, MessageTypeFileReject,
+		MessageTypeFileChunk, MessageTypeFileAck, MessageTypeFileCancel:
 		return true
 	default:
 		return false
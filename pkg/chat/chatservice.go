@@ -3,12 +3,14 @@ package chat
 import (
 	"context"
 	"fmt"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"p2pchat/internal/peer"
 	"p2pchat/pkg/discovery"
+	"p2pchat/pkg/identity"
 	"p2pchat/pkg/logger"
 )
 
@@ -16,6 +18,7 @@ import (
 // This is where UDP discovery meets TCP chat - the magic integration layer!
 type ChatService struct {
 	// Identity
+	identity *identity.Identity // Signs the messages we author
 	peerID   string
 	username string
 	port     int
@@ -26,11 +29,42 @@ type ChatService struct {
 
 	// Message handling
 	messageSequence  uint64        // Counter for message ordering
+	clock            *hybridClock  // Logical time of the messages we author (see clock.go)
 	incomingMessages chan *Message // Channel for UI to receive messages
 
 	// Enhanced Message History System
 	messageHistory *MessageHistory // In-memory message storage with duplicate detection
 
+	// Rooms
+	rooms *RoomRegistry // Local and remote room membership
+
+	// Gossip
+	relayed *relayCache // Message IDs we already forwarded
+
+	// File transfers
+	transfers *TransferManager
+
+	// Plugins and their slash commands (see plugins.go)
+	plugins *pluginRegistry
+
+	// Peers dialed by address (see static.go)
+	staticPeers []string
+	peerFile    string // Where /connect remembers addresses, empty to forget them
+	staticMutex sync.Mutex
+
+	// Peer exchange (see pex.go)
+	listenAddrs   map[string]*net.TCPAddr // peerID -> where it accepts connections, from its exchanges
+	exchangeMutex sync.Mutex
+
+	// Presence and typing (see presence.go)
+	presence      presenceState            // Our own, sent in heartbeats
+	peerPresence  map[string]presenceState // peerID -> last heartbeat
+	presenceMutex sync.RWMutex
+	typing        *typingTracker
+
+	// Delivery and read receipts (see receipts.go)
+	receipts *receiptTracker
+
 	// Lifecycle
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -38,34 +72,48 @@ type ChatService struct {
 }
 
 // NewChatService creates a new integrated chat service with message history
-func NewChatService(peerID, username string, port int, multicastAddr string) (*ChatService, error) {
+// The peer ID is derived from id, which also signs and encrypts everything we send
+func NewChatService(id *identity.Identity, username string, port int, multicastAddr string) (*ChatService, error) {
 	ctx, cancel := context.WithCancel(context.Background())
+	peerID := id.PeerID()
 
 	// Create discovery service
-	discoveryService, err := discovery.NewDiscoveryService(peerID, username, port, multicastAddr)
+	discoveryService, err := discovery.NewDiscoveryService(id, username, port, multicastAddr)
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("failed to create discovery service: %w", err)
 	}
 
 	// Create connection manager
-	connectionManager := NewConnectionManager(peerID, username, port)
+	connectionManager := NewConnectionManager(id, username, port)
 
 	// Create message history with reasonable limits
 	messageHistory := NewMessageHistory(1000) // Keep last 1000 messages
 
 	service := &ChatService{
+		identity:         id,
 		peerID:           peerID,
 		username:         username,
 		port:             port,
 		discovery:        discoveryService,
 		connections:      connectionManager,
+		clock:            newHybridClock(),
 		incomingMessages: make(chan *Message, 100), // Buffer incoming messages for UI
 		messageHistory:   messageHistory,           // Message history storage
+		rooms:            NewRoomRegistry(),
+		relayed:          newRelayCache(),
+		plugins:          newPluginRegistry(),
+		peerPresence:     make(map[string]presenceState),
+		listenAddrs:      make(map[string]*net.TCPAddr),
+		typing:           newTypingTracker(),
+		receipts:         newReceiptTracker(),
 		ctx:              ctx,
 		cancel:           cancel,
 	}
 
+	// Files land in the working directory until SetDownloadDir says otherwise
+	service.transfers = NewTransferManager(".", service.sendFileMessage)
+
 	// Set up the integration between discovery and connections
 	service.setupIntegration()
 
@@ -91,19 +139,125 @@ func (cs *ChatService) setupIntegration() {
 				joinMsg := NewJoinMessage(cs.peerID, cs.username, cs.nextSequence())
 				cs.connections.SendToPeer(p.ID, joinMsg)
 			}
+			cs.plugins.peerJoined(PeerInfo{PeerID: p.ID, Username: p.Username, Address: p.Address.String(), Connected: err == nil})
 		},
 
 		// On peer leave - handle disconnections gracefully
 		func(p *peer.Peer) {
 			logger.Debug("👋 Peer left discovery: %s (%s)", p.Username, p.ID)
 			// TCP connection will timeout naturally, but I could force disconnect here
+			cs.rooms.RemovePeer(p.ID)
+			cs.forgetPeer(p.ID)
+			cs.plugins.peerLeft(PeerInfo{PeerID: p.ID, Username: p.Username, Address: p.Address.String()})
 		},
 	)
 
+	// Only send room messages to peers that joined the room
+	cs.connections.SetRoomFilter(cs.rooms.IsMember)
+
+	// Tell every newly connected peer which rooms we are in
+	cs.connections.SetConnectHandler(func(peerID string) {
+		for _, roomID := range cs.rooms.JoinedRooms() {
+			if roomID == DefaultRoom {
+				continue // Everyone is in the default room
+			}
+			roomMsg := NewRoomJoinMessage(cs.peerID, cs.username, roomID, cs.nextSequence())
+			cs.originate(roomMsg)
+			if err := cs.connections.SendToPeer(peerID, roomMsg); err != nil {
+				logger.Error("⚠️ Failed to announce #%s to %s: %v", roomID, peerID, err)
+			}
+		}
+
+		// Let them know if we're away before the next heartbeat
+		cs.connections.SendToPeer(peerID, cs.newHeartbeat())
+
+		// Ask for anything we missed while we weren't connected
+		cs.requestSync(peerID)
+
+		// Pick up file transfers the disconnect interrupted
+		cs.transfers.Resume(peerID)
+
+		// Tell them who else is around, they may not be able to hear the beacons
+		cs.sendPeerExchange(peerID)
+	})
+
 	// Handle incoming TCP messages
 	cs.connections.SetMessageHandler(func(msg *Message, fromPeerID string) {
 		logger.Debug("📨 Received message from %s: %s", msg.Username, msg.Content)
 
+		// Messages from other authors must be signed relays
+		if !cs.acceptRelayed(msg, fromPeerID) {
+			return
+		}
+		cs.clock.witness(msg.Clock)
+
+		// Pass it on before filtering locally, we may be the only path to some peers
+		cs.relay(msg, fromPeerID)
+		if msg.SenderID == cs.peerID {
+			return // Our own message came back around the mesh
+		}
+
+		// Track room membership before deciding whether to keep the message
+		switch msg.Type {
+		case MessageTypeRoomJoin:
+			if !cs.rooms.AddMember(msg.RoomID, msg.SenderID) {
+				return // Already knew, this is a reconnect announcement
+			}
+		case MessageTypeRoomPart:
+			if !cs.rooms.RemoveMember(msg.RoomID, msg.SenderID) {
+				return
+			}
+		case MessageTypeLeave:
+			cs.rooms.RemovePeer(msg.SenderID)
+			cs.forgetPeer(msg.SenderID)
+		case MessageTypeHeartbeat:
+			cs.handleHeartbeat(msg)
+			return
+		case MessageTypeTyping:
+			if cs.handleTyping(msg) {
+				cs.forwardToUI(msg) // So the indicator shows without waiting for a poll
+			}
+			return
+		case MessageTypeSyncRequest:
+			cs.handleSyncRequest(msg, fromPeerID)
+			return
+		case MessageTypeSyncBatch:
+			cs.handleSyncBatch(msg, fromPeerID)
+			return
+		case MessageTypePeerExchange:
+			cs.handlePeerExchange(msg, fromPeerID)
+			return
+		case MessageTypeDelivered, MessageTypeRead:
+			if cs.handleReceipt(msg) {
+				cs.forwardToUI(msg) // So the delivery state on screen updates
+			}
+			return
+		case MessageTypeNotice:
+			return // Local only, a peer has no business sending these
+		}
+
+		// File transfers stay out of history, only new offers reach the UI
+		if msg.IsFileTransfer() {
+			if msg.RecipientID == cs.peerID && cs.transfers.HandleMessage(msg, fromPeerID) {
+				cs.forwardToUI(msg)
+			}
+			return
+		}
+
+		// Direct messages are only for their recipient, room traffic only for members
+		if msg.RecipientID != "" {
+			if msg.RecipientID != cs.peerID {
+				logger.Debug("⏩ Skipping direct message for %s: %s", msg.RecipientID, msg.ID)
+				return
+			}
+		} else if !cs.rooms.IsJoined(msg.ConversationID()) {
+			logger.Debug("⏩ Skipping message for #%s (not joined): %s", msg.RoomID, msg.ID)
+			return
+		}
+
+		// Their message is here, they're done typing it
+		cs.typing.clear(msg.ConversationID(), msg.SenderID)
+
 		// Add to message history with duplicate detection
 		added := cs.messageHistory.AddMessage(msg)
 		if !added {
@@ -112,19 +266,28 @@ func (cs *ChatService) setupIntegration() {
 			return
 		}
 
+		// Let the author know it arrived
+		cs.acknowledge(msg)
+
 		// Forward message to UI (this is how messages reach the human!)
-		select {
-		case cs.incomingMessages <- msg:
-			// Message delivered to UI
-			logger.Debug("✅ Message forwarded to UI: %s", msg.Content)
-		default:
-			// UI message buffer full - this shouldn't happen in normal use
-			logger.Error("⚠️ UI message buffer full, dropping message from %s", msg.Username)
-		}
+		cs.forwardToUI(msg)
+		cs.plugins.messageReceived(msg)
 	})
 
 }
 
+// forwardToUI hands a received message to the UI without blocking the network
+func (cs *ChatService) forwardToUI(msg *Message) {
+	select {
+	case cs.incomingMessages <- msg:
+		// Message delivered to UI
+		logger.Debug("✅ Message forwarded to UI: %s", msg.Content)
+	default:
+		// UI message buffer full - this shouldn't happen in normal use
+		logger.Error("⚠️ UI message buffer full, dropping message from %s", msg.Username)
+	}
+}
+
 // Start begins the chat service - this starts both UDP discovery and TCP listening
 func (cs *ChatService) Start() error {
 	logger.Debug("🚀 Starting chat service for %s on port %d", cs.username, cs.port)
@@ -142,24 +305,60 @@ func (cs *ChatService) Start() error {
 	}
 	logger.Debug("🔌 TCP listener started - ready for peer connections...")
 
+	// Don't wait for discovery to find peers we know the address of
+	cs.dialStaticPeers()
+
+	// Keep peers posted on our presence
+	cs.wg.Add(1)
+	go cs.heartbeatLoop()
+
+	// And on who else is out there
+	cs.wg.Add(1)
+	go cs.exchangeLoop()
+
 	logger.Debug("✅ Chat service fully started! Ready for human conversations! 💬")
 	return nil
 }
 
-// SendMessage sends a chat message to all connected peers
+// SendMessage sends a chat message to all connected peers in the default room
 // This is the function that makes human-to-human communication happen!
 func (cs *ChatService) SendMessage(content string) error {
+	return cs.SendRoomMessage(DefaultRoom, content)
+}
+
+// SendRoomMessage sends a chat message to the connected peers that are in a room
+func (cs *ChatService) SendRoomMessage(roomID, content string) error {
+	return cs.sendRoomMessage(roomID, content, "", "")
+}
+
+// sendTo sends a chat message to a room or DM, optionally as a reply or labelled as a plugin's bot
+func (cs *ChatService) sendTo(conversationID, content, replyTo, bot string) error {
+	if IsDirectConversation(conversationID) {
+		return cs.sendDirect(DirectPeer(conversationID, cs.peerID), content, replyTo, bot)
+	}
+	return cs.sendRoomMessage(conversationID, content, replyTo, bot)
+}
+
+// sendRoomMessage sends a chat message to a room, optionally as a reply or as a bot
+func (cs *ChatService) sendRoomMessage(roomID, content, replyTo, bot string) error {
 	if content == "" {
 		return fmt.Errorf("cannot send empty message")
 	}
+	if !cs.rooms.IsJoined(roomID) {
+		return fmt.Errorf("not in room #%s", roomID)
+	}
 
 	// Create the message
-	msg := NewChatMessage(cs.peerID, cs.username, content, cs.nextSequence())
+	msg := NewRoomMessage(cs.peerID, cs.username, roomID, content, cs.nextSequence())
+	msg.ReplyTo = replyTo
+	msg.Bot = bot
+	cs.originate(msg)
 
-	logger.Debug("📤 Sending message to all peers: %s", content)
+	logger.Debug("📤 Sending message to #%s: %s", roomID, content)
 
 	// Broadcast to all connected peers - this is the magic moment!
 	cs.connections.Broadcast(msg)
+	cs.typing.resetSent(roomID)
 
 	// NEW: Add our own message to history
 	cs.messageHistory.AddMessage(msg)
@@ -173,10 +372,72 @@ func (cs *ChatService) SendMessage(content string) error {
 		// Buffer full - very unlikely
 		logger.Error("⚠️ Failed to add own message to UI buffer")
 	}
+	cs.plugins.messageSent(msg)
+
+	return nil
+}
+
+// SendDirect sends a private message to a single connected peer
+func (cs *ChatService) SendDirect(peerID, content string) error {
+	return cs.sendDirect(peerID, content, "", "")
+}
+
+// sendDirect sends a private message, optionally as a reply or as a bot
+func (cs *ChatService) sendDirect(peerID, content, replyTo, bot string) error {
+	if content == "" {
+		return fmt.Errorf("cannot send empty message")
+	}
+	if peerID == cs.peerID {
+		return fmt.Errorf("cannot send a direct message to yourself")
+	}
+
+	msg := NewDirectMessage(cs.peerID, cs.username, peerID, content, cs.nextSequence())
+	msg.ReplyTo = replyTo
+	msg.Bot = bot
+	cs.originate(msg)
+
+	logger.Debug("📤 Sending direct message to %s: %s", peerID, content)
+
+	if err := cs.connections.SendToPeer(peerID, msg); err != nil {
+		return err
+	}
+	cs.typing.resetSent(msg.ConversationID())
+
+	cs.messageHistory.AddMessage(msg)
+
+	select {
+	case cs.incomingMessages <- msg:
+		logger.Debug("✅ Own direct message forwarded to UI: %s", content)
+	default:
+		logger.Error("⚠️ Failed to add own direct message to UI buffer")
+	}
+	cs.plugins.messageSent(msg)
 
 	return nil
 }
 
+// ResolvePeer finds a connected peer by username or peer ID
+func (cs *ChatService) ResolvePeer(name string) (PeerInfo, error) {
+	var matches []PeerInfo
+	for _, p := range cs.GetConnectedPeers() {
+		if p.PeerID == name {
+			return p, nil
+		}
+		if p.Username == name {
+			matches = append(matches, p)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return PeerInfo{}, fmt.Errorf("no peer named %s", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return PeerInfo{}, fmt.Errorf("%d peers are named %s, use the peer ID", len(matches), name)
+	}
+}
+
 // GetMessages returns a channel for receiving incoming messages
 // The UI reads from this channel to show messages to the human
 func (cs *ChatService) GetMessages() <-chan *Message {
@@ -189,10 +450,11 @@ func (cs *ChatService) GetConnectedPeers() []PeerInfo {
 	discoveredPeers := cs.discovery.GetOnlinePeers()
 
 	// Get connection details from connection manager
-	connectionDetails := make(map[string]*PeerConnection)
+	// Copies, the dial and session goroutines change these fields under connMutex
+	connectionDetails := make(map[string]PeerConnection)
 	cs.connections.connMutex.RLock()
 	for id, conn := range cs.connections.connections {
-		connectionDetails[id] = conn
+		connectionDetails[id] = *conn
 	}
 	cs.connections.connMutex.RUnlock()
 
@@ -200,16 +462,22 @@ func (cs *ChatService) GetConnectedPeers() []PeerInfo {
 	peerInfos := make([]PeerInfo, 0, len(discoveredPeers))
 
 	for _, p := range discoveredPeers {
+		presence := cs.peerPresenceOf(p)
 		info := PeerInfo{
 			PeerID:          p.ID,
 			Username:        p.Username,
 			Address:         p.Address.String(),
 			Status:          p.Status.String(),
 			LastSeen:        p.LastSeen,
-			Discovered:      true,  // Found via UDP discovery
+			Discovered:      true, // Found via UDP discovery or peer exchange
+			Source:          string(p.Source),
+			Interface:       p.Interface,
 			Connected:       false, // Default to false
 			ConnectionState: "disconnected",
 			RetryCount:      0,
+			Pending:         cs.connections.PendingMessages(p.ID),
+			Presence:        presence.presence.String(),
+			PresenceReason:  presence.reason,
 		}
 
 		// Check if we have TCP connection info
@@ -217,9 +485,47 @@ func (cs *ChatService) GetConnectedPeers() []PeerInfo {
 			info.Connected = (connDetail.State == StateConnected)
 			info.ConnectionState = connDetail.State.String()
 			info.RetryCount = connDetail.RetryCount
+			if connDetail.Static != "" {
+				info.Source = string(peer.SourceStatic) // We asked for this one, that says more
+			}
 		}
 
 		peerInfos = append(peerInfos, info)
+		delete(connectionDetails, p.ID)
+	}
+
+	// Peers discovery can't see, because we dialed them or they dialed us by address
+	for id, connDetail := range connectionDetails {
+		pending := cs.connections.PendingMessages(id)
+		if connDetail.State != StateConnected && connDetail.Static == "" && pending == 0 {
+			continue
+		}
+		presence := cs.peerPresenceOf(&peer.Peer{ID: id})
+		status := peer.PeerStatusOffline
+		if connDetail.State == StateConnected {
+			status = peer.PeerStatusOnline // Heartbeats keep the link up like beacons would
+		}
+		var source peer.Source
+		switch {
+		case connDetail.Static != "":
+			source = peer.SourceStatic
+		case !connDetail.Outbound:
+			source = peer.SourceIncoming
+		}
+		peerInfos = append(peerInfos, PeerInfo{
+			PeerID:          id,
+			Username:        connDetail.Username,
+			Address:         connDetail.Address.String(),
+			Status:          status.String(),
+			Source:          string(source),
+			LastSeen:        connDetail.LastSeen,
+			Connected:       connDetail.State == StateConnected,
+			ConnectionState: connDetail.State.String(),
+			RetryCount:      connDetail.RetryCount,
+			Pending:         pending,
+			Presence:        presence.presence.String(),
+			PresenceReason:  presence.reason,
+		})
 	}
 
 	return peerInfos
@@ -232,10 +538,15 @@ type PeerInfo struct {
 	Address         string
 	Status          string // From discovery service
 	LastSeen        time.Time
-	Discovered      bool   // Found via UDP discovery
+	Discovered      bool   // Found via UDP discovery or peer exchange
+	Source          string // How we learned about the peer: "multicast", "pex", "static" or "incoming"
+	Interface       string // Where its beacons come in, "" if we never heard one
 	Connected       bool   // Has active TCP connection
 	ConnectionState string // TCP connection state
 	RetryCount      int    // Number of connection retries
+	Pending         int    // Messages waiting in the outbox until the peer is reachable
+	Presence        string // "available", "away" or "busy" as set by the user
+	PresenceReason  string // Optional away message
 }
 
 // nextSequence returns the next message sequence number
@@ -255,10 +566,16 @@ func (cs *ChatService) NotifyPeerLeave() {
 	cs.connections.Broadcast(leaveMsg)
 }
 
-// SendHeartbeat sends a heartbeat to all connected peers
+// SendHeartbeat sends a heartbeat carrying our presence to all connected peers
 func (cs *ChatService) SendHeartbeat() {
+	cs.connections.Broadcast(cs.newHeartbeat())
+}
+
+// newHeartbeat creates a heartbeat with our current presence
+func (cs *ChatService) newHeartbeat() *Message {
 	heartbeat := NewHeartbeatMessage(cs.peerID, cs.username, cs.nextSequence())
-	cs.connections.Broadcast(heartbeat)
+	heartbeat.Presence, heartbeat.Content = cs.GetPresence()
+	return heartbeat
 }
 
 // GetStatus returns current service status
@@ -300,6 +617,8 @@ func (cs *ChatService) Stop() error {
 	cs.cancel()
 
 	// Stop services in reverse order
+	cs.transfers.Stop()
+
 	var err error
 	if stopErr := cs.connections.Stop(); stopErr != nil {
 		logger.Error("Error stopping connections: %v", stopErr)
@@ -313,17 +632,26 @@ func (cs *ChatService) Stop() error {
 		}
 	}
 
-	// Close message channel
-	close(cs.incomingMessages)
+	// Flush persisted history
+	if closeErr := cs.messageHistory.Close(); closeErr != nil {
+		logger.Error("Error closing message history: %v", closeErr)
+		if err == nil {
+			err = closeErr
+		}
+	}
 
-	// Wait for all goroutines
+	// Wait for all goroutines, including those of plugins
 	cs.wg.Wait()
+	cs.plugins.wait()
+
+	// Close message channel, nothing is left to send on it
+	close(cs.incomingMessages)
 
 	logger.Debug("✅ Chat service stopped")
 	return err
 }
 
-// GetMessageHistory returns all stored messages in chronological order
+// GetMessageHistory returns all stored messages in causal order
 func (cs *ChatService) GetMessageHistory() []*Message {
 	return cs.messageHistory.GetMessages()
 }
@@ -333,6 +661,210 @@ func (cs *ChatService) GetRecentMessages(limit int) []*Message {
 	return cs.messageHistory.GetRecentMessages(limit)
 }
 
+// GetRoomHistory returns the stored messages of one room in causal order
+// Reactions, edits and deletes are already applied to the messages they annotate
+func (cs *ChatService) GetRoomHistory(roomID string) []*Message {
+	return withoutAnnotations(cs.messageHistory.GetRoomMessages(roomID))
+}
+
+// GetDirectHistory returns the stored direct messages exchanged with a peer
+func (cs *ChatService) GetDirectHistory(peerID string) []*Message {
+	return withoutAnnotations(cs.messageHistory.GetRoomMessages(DirectConversationID(cs.peerID, peerID)))
+}
+
+// withoutAnnotations drops reactions, edits and deletes from a message list
+func withoutAnnotations(messages []*Message) []*Message {
+	visible := messages[:0]
+	for _, msg := range messages {
+		if !msg.IsAnnotation() {
+			visible = append(visible, msg)
+		}
+	}
+	return visible
+}
+
+// GetDirectConversations returns the peers we have stored DMs with, mapped to their last known username
+func (cs *ChatService) GetDirectConversations() map[string]string {
+	peers := make(map[string]string)
+	for _, conversationID := range cs.messageHistory.Conversations() {
+		if !IsDirectConversation(conversationID) {
+			continue
+		}
+
+		peerID := DirectPeer(conversationID, cs.peerID)
+		peers[peerID] = peerID // Fall back to the ID if they never wrote back
+		for _, msg := range cs.messageHistory.GetRoomMessages(conversationID) {
+			if msg.SenderID == peerID {
+				peers[peerID] = msg.Username
+			}
+		}
+	}
+	return peers
+}
+
+// SetMessageStore persists history to store and loads what it already holds
+// Call this before Start so reloaded messages are in place when the UI asks for them
+func (cs *ChatService) SetMessageStore(store MessageStore) error {
+	if err := cs.messageHistory.SetStore(store); err != nil {
+		return fmt.Errorf("failed to load message history: %w", err)
+	}
+
+	// Pick up where we left off, even if the wall clock went back since
+	for _, msg := range cs.messageHistory.GetMessages() {
+		cs.clock.witness(msg.Clock)
+	}
+	return nil
+}
+
+// SetTiming changes the intervals and timeouts of discovery and peer connections
+// Call this before Start, the defaults are discovery.DefaultTiming and DefaultConnectionTiming
+func (cs *ChatService) SetTiming(discoveryTiming discovery.Timing, connectionTiming ConnectionTiming) error {
+	if err := discoveryTiming.Validate(); err != nil {
+		return err
+	}
+	if err := connectionTiming.Validate(); err != nil {
+		return err
+	}
+	cs.discovery.SetTiming(discoveryTiming)
+	cs.connections.SetTiming(connectionTiming)
+	return nil
+}
+
+// SetOutbox keeps messages for unreachable peers in path (empty for memory only) within limits
+// Call this before Start, like SetMessageStore
+func (cs *ChatService) SetOutbox(path string, limits OutboxLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	cs.connections.outbox.SetLimits(limits)
+	if path == "" {
+		return nil
+	}
+	if err := cs.connections.outbox.SetFile(path); err != nil {
+		return fmt.Errorf("failed to load outbox: %w", err)
+	}
+	return nil
+}
+
+// SetDiscoveryBackends picks how peers are found on the LAN, e.g. "multicast" and "mdns"
+// Call this before Start, the default is multicast only
+func (cs *ChatService) SetDiscoveryBackends(names []string) error {
+	return cs.discovery.SetBackends(names)
+}
+
+// SetDiscoveryInterfaces limits LAN discovery to the named interfaces, nil means every one that can multicast
+// Call this before Start
+func (cs *ChatService) SetDiscoveryInterfaces(names []string) error {
+	return cs.discovery.SetInterfaces(names)
+}
+
+// SetMulticastAddr6 changes the IPv6 group discovery joins next to the IPv4 one, "" turns it off
+// Call this before Start, the default is discovery.DefaultMulticastAddr6
+func (cs *ChatService) SetMulticastAddr6(addr string) error {
+	return cs.discovery.SetMulticastAddr6(addr)
+}
+
+// SendFile offers a file to a connected peer (by username or ID) and returns the transfer ID
+func (cs *ChatService) SendFile(name, path string) (string, error) {
+	target, err := cs.ResolvePeer(name)
+	if err != nil {
+		return "", err
+	}
+	return cs.transfers.Offer(target.PeerID, target.Username, path)
+}
+
+// AcceptFile starts receiving an offered file (id may be a unique prefix)
+func (cs *ChatService) AcceptFile(id string) error {
+	return cs.transfers.Accept(id)
+}
+
+// RejectFile declines an offered file
+func (cs *ChatService) RejectFile(id string) error {
+	return cs.transfers.Reject(id)
+}
+
+// CancelFile stops a transfer in either direction
+func (cs *ChatService) CancelFile(id string) error {
+	return cs.transfers.Cancel(id)
+}
+
+// GetTransfers returns all file transfers of this session, newest first
+func (cs *ChatService) GetTransfers() []Transfer {
+	return cs.transfers.Transfers()
+}
+
+// SetDownloadDir sets where received files are saved
+func (cs *ChatService) SetDownloadDir(dir string) {
+	cs.transfers.SetDownloadDir(dir)
+}
+
+// sendFileMessage delivers one step of a file transfer to a peer
+func (cs *ChatService) sendFileMessage(peerID string, msgType MessageType, file *FileTransfer) error {
+	msg := NewFileMessage(cs.peerID, cs.username, peerID, msgType, file, cs.nextSequence())
+	return cs.connections.SendToPeer(peerID, msg)
+}
+
+// React toggles an emoji reaction on a message
+func (cs *ChatService) React(targetID, emoji string) error {
+	target := cs.messageHistory.GetMessage(targetID)
+	if target == nil {
+		return fmt.Errorf("message %s not found", targetID)
+	}
+
+	// Reacting twice with the same emoji takes it back
+	if existing := cs.messageHistory.FindReaction(targetID, cs.peerID, emoji); existing != nil {
+		return cs.sendAnnotation(NewDeleteMessage(cs.peerID, cs.username, existing, cs.nextSequence()))
+	}
+	return cs.sendAnnotation(NewReactionMessage(cs.peerID, cs.username, target, emoji, cs.nextSequence()))
+}
+
+// EditMessage replaces the text of one of our messages
+func (cs *ChatService) EditMessage(targetID, content string) error {
+	target := cs.messageHistory.GetMessage(targetID)
+	if target == nil {
+		return fmt.Errorf("message %s not found", targetID)
+	}
+	if content == target.Text() {
+		return nil // Nothing changed
+	}
+	return cs.sendAnnotation(NewEditMessage(cs.peerID, cs.username, target, content, cs.nextSequence()))
+}
+
+// DeleteMessage tombstones one of our messages for everyone
+func (cs *ChatService) DeleteMessage(targetID string) error {
+	target := cs.messageHistory.GetMessage(targetID)
+	if target == nil {
+		return fmt.Errorf("message %s not found", targetID)
+	}
+	return cs.sendAnnotation(NewDeleteMessage(cs.peerID, cs.username, target, cs.nextSequence()))
+}
+
+// GetMessage returns a stored message by ID, or nil
+func (cs *ChatService) GetMessage(messageID string) *Message {
+	return cs.messageHistory.GetMessage(messageID)
+}
+
+// sendAnnotation applies a reaction, edit or delete locally and sends it to the conversation
+func (cs *ChatService) sendAnnotation(msg *Message) error {
+	if err := cs.messageHistory.CheckAnnotation(msg); err != nil {
+		return err
+	}
+	cs.originate(msg)
+
+	if msg.RecipientID != "" {
+		if err := cs.connections.SendToPeer(msg.RecipientID, msg); err != nil {
+			return err
+		}
+	} else {
+		cs.connections.Broadcast(msg)
+	}
+
+	cs.messageHistory.AddMessage(msg)
+	cs.forwardToUI(msg) // So our own view updates like everyone else's
+	cs.plugins.messageSent(msg)
+	return nil
+}
+
 // GetChatMessages returns only chat messages (excluding join/leave notifications)
 func (cs *ChatService) GetChatMessages() []*Message {
 	return cs.messageHistory.GetMessages(MessageTypeChat)
@@ -379,6 +911,69 @@ func (cs *ChatService) ChangeUsername(newUsername string) error {
 	return nil
 }
 
+// GetUnsentCount returns how many messages still wait to go out to connected peers
+func (cs *ChatService) GetUnsentCount() int {
+	return cs.connections.unsent()
+}
+
+// GetPeerID returns our own peer ID
+func (cs *ChatService) GetPeerID() string {
+	return cs.peerID
+}
+
+// JoinRoom joins a room and announces it to every connected peer
+func (cs *ChatService) JoinRoom(name string) (string, error) {
+	roomID, err := NormalizeRoomName(name)
+	if err != nil {
+		return "", err
+	}
+	if !cs.rooms.Join(roomID) {
+		return roomID, nil // Already there, nothing to announce
+	}
+
+	joinMsg := NewRoomJoinMessage(cs.peerID, cs.username, roomID, cs.nextSequence())
+	cs.originate(joinMsg)
+	cs.connections.Broadcast(joinMsg)
+	cs.messageHistory.AddMessage(joinMsg)
+
+	logger.Debug("🚪 Joined #%s", roomID)
+	return roomID, nil
+}
+
+// PartRoom leaves a room and announces it to every connected peer
+func (cs *ChatService) PartRoom(name string) (string, error) {
+	roomID, err := NormalizeRoomName(name)
+	if err != nil {
+		return "", err
+	}
+	if roomID == DefaultRoom {
+		return "", fmt.Errorf("cannot leave #%s", DefaultRoom)
+	}
+	if !cs.rooms.Part(roomID) {
+		return "", fmt.Errorf("not in room #%s", roomID)
+	}
+
+	partMsg := NewRoomPartMessage(cs.peerID, cs.username, roomID, cs.nextSequence())
+	cs.originate(partMsg)
+	cs.connections.Broadcast(partMsg)
+
+	logger.Debug("🚪 Left #%s", roomID)
+	return roomID, nil
+}
+
+// GetJoinedRooms returns the rooms we are in, default room first
+func (cs *ChatService) GetJoinedRooms() []string {
+	return cs.rooms.JoinedRooms()
+}
+
+// GetRoomMembers returns the IDs of connected peers known to be in a room
+func (cs *ChatService) GetRoomMembers(roomID string) []string {
+	if roomID == DefaultRoom {
+		return cs.connections.GetConnectedPeers()
+	}
+	return cs.rooms.Members(roomID)
+}
+
 // GetUsername returns the current username
 func (cs *ChatService) GetUsername() string {
 	return cs.username
@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// Causal ordering: every message we author carries a hybrid logical clock in
+// Clock - nanoseconds like a Unix timestamp, but never behind anything we had
+// seen when we wrote it. Receiving a message moves our clock up to its Clock,
+// so a reply always sorts after the message it answers, however far the two
+// machines' wall clocks disagree. With clocks in sync it's just the time the
+// message was written.
+//
+// History is ordered by Clock, ties broken by sender ID and then message ID so
+// every peer shows the same order. Messages from peers without clocks (and
+// history stored before them) fall back to their Timestamp, which is in the
+// same unit.
+const (
+	// maxClockLead caps how far ahead of our wall clock a peer can push our clock
+	// A peer whose clock is a year ahead shouldn't drag everyone else's with it
+	maxClockLead = time.Hour
+)
+
+// hybridClock hands out the logical time for the messages we author
+type hybridClock struct {
+	last  int64            // Highest time handed out or witnessed
+	now   func() time.Time // Wall clock, replaceable in tests
+	mutex sync.Mutex
+}
+
+// newHybridClock creates a clock that starts at the wall clock
+func newHybridClock() *hybridClock {
+	return &hybridClock{now: time.Now}
+}
+
+// tick returns the time for a message we are about to send
+// It's the wall clock unless something we saw is ahead of it, then just after that
+func (c *hybridClock) tick() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if wall := c.now().UnixNano(); wall > c.last {
+		c.last = wall
+	} else {
+		c.last++
+	}
+	return c.last
+}
+
+// witness moves the clock up to a time seen on a message, so what we send next sorts after it
+func (c *hybridClock) witness(remote int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if limit := c.now().Add(maxClockLead).UnixNano(); remote > limit {
+		remote = limit
+	}
+	if remote > c.last {
+		c.last = remote
+	}
+}
+
+// logicalTime returns what a message is ordered by, its Timestamp if it has no clock
+func (m *Message) logicalTime() int64 {
+	if m.Clock != 0 {
+		return m.Clock
+	}
+	return m.Timestamp.UnixNano()
+}
+
+// causallyBefore returns true if a sorts before b in history
+// The sender and message ID tiebreaks make the order the same on every peer
+func causallyBefore(a, b *Message) bool {
+	if ta, tb := a.logicalTime(), b.logicalTime(); ta != tb {
+		return ta < tb
+	}
+	if a.SenderID != b.SenderID {
+		return a.SenderID < b.SenderID
+	}
+	return a.ID < b.ID
+}
@@ -0,0 +1,168 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"p2pchat/pkg/identity"
+)
+
+// skewedClock returns a hybrid clock whose wall clock is off by skew
+func skewedClock(skew time.Duration) *hybridClock {
+	c := newHybridClock()
+	c.now = func() time.Time { return time.Now().Add(skew) }
+	return c
+}
+
+// contents returns the content of each message, in order
+func contents(messages []*Message) []string {
+	var result []string
+	for _, msg := range messages {
+		result = append(result, msg.Content)
+	}
+	return result
+}
+
+func TestHybridClock(t *testing.T) {
+	c := newHybridClock()
+	wall := time.Now()
+	c.now = func() time.Time { return wall }
+
+	first := c.tick()
+	if first != wall.UnixNano() {
+		t.Errorf("Expected the wall clock, got %d off", first-wall.UnixNano())
+	}
+
+	// The wall clock going back doesn't take the clock with it
+	wall = wall.Add(-time.Minute)
+	if second := c.tick(); second <= first {
+		t.Errorf("Expected the clock to keep going up, got %d after %d", second, first)
+	}
+
+	// A message from a peer that is ahead pulls us along
+	ahead := wall.Add(10 * time.Minute).UnixNano()
+	c.witness(ahead)
+	if next := c.tick(); next != ahead+1 {
+		t.Errorf("Expected just after the witnessed time, got %d off", next-ahead)
+	}
+
+	// But not by more than maxClockLead
+	c.witness(wall.Add(365 * 24 * time.Hour).UnixNano())
+	if next := c.tick(); next > wall.Add(maxClockLead).UnixNano()+1 {
+		t.Errorf("Expected the clock to stay within %v of the wall clock, got %v ahead", maxClockLead, time.Duration(next-wall.UnixNano()))
+	}
+}
+
+func TestHistoryOrdersCausallyWithSkewedClocks(t *testing.T) {
+	// Bob's machine is an hour behind, he answers Alice a minute after she asked
+	alice, bob := newHybridClock(), skewedClock(-time.Hour)
+
+	question := NewChatMessage("alice-id", "alice", "anyone seen the deploy fail?", 1)
+	question.Clock = alice.tick()
+
+	bob.witness(question.Clock)
+	answer := NewChatMessage("bob-id", "bob", "yes, rolling back", 1)
+	answer.Timestamp = time.Now().Add(time.Minute - time.Hour)
+	answer.Clock = bob.tick()
+	answer.ReplyTo = question.ID
+
+	// Timestamps alone would put the answer first, whichever order they arrive in
+	for _, arrival := range [][]*Message{{question, answer}, {answer, question}} {
+		h := NewMessageHistory(100)
+		for _, msg := range arrival {
+			h.AddMessage(msg)
+		}
+		got := contents(h.GetRoomMessages(DefaultRoom))
+		if len(got) != 2 || got[0] != question.Content || got[1] != answer.Content {
+			t.Errorf("Expected the question before the answer, got %v", got)
+		}
+		if thread := contents(h.GetThread(answer.ID)); len(thread) != 2 || thread[1] != answer.Content {
+			t.Errorf("Expected the answer last in the thread, got %v", thread)
+		}
+	}
+}
+
+func TestHistoryOrderIsTheSameEverywhere(t *testing.T) {
+	clock := time.Now().UnixNano()
+	base := time.Now()
+
+	// Written at the same logical time by different peers, plus two from before clocks
+	carol := NewChatMessage("carol-id", "carol", "carol", 1)
+	carol.Clock = clock
+	bob := NewChatMessage("bob-id", "bob", "bob", 1)
+	bob.Clock = clock
+	early := NewChatMessage("dave-id", "dave", "early", 1)
+	early.Timestamp = base.Add(-time.Second)
+	late := NewChatMessage("dave-id", "dave", "late", 2)
+	late.Timestamp = time.Unix(0, clock).Add(time.Second)
+
+	want := []string{"early", "bob", "carol", "late"}
+	for _, arrival := range [][]*Message{{carol, bob, early, late}, {late, bob, carol, early}, {early, late, carol, bob}} {
+		h := NewMessageHistory(100)
+		for _, msg := range arrival {
+			h.AddMessage(msg)
+		}
+		got := contents(h.GetRoomMessages(DefaultRoom))
+		if len(got) != len(want) {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Expected %v, got %v", want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestClockIsSigned(t *testing.T) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	// Messages from before clocks still verify
+	legacy := NewChatMessage(id.PeerID(), "alice", "hi", 1)
+	legacy.Sign(id)
+	if err := legacy.VerifySignature(); err != nil {
+		t.Errorf("Expected a message without a clock to verify, got %v", err)
+	}
+
+	// A relay can't move a message around in everyone's history
+	msg := NewChatMessage(id.PeerID(), "alice", "hi", 2)
+	msg.Clock = time.Now().UnixNano()
+	msg.Sign(id)
+	msg.Clock += int64(time.Hour)
+	if msg.VerifySignature() == nil {
+		t.Error("Expected a changed clock to break the signature")
+	}
+}
+
+func TestRepliesFollowTheirParentAcrossPeers(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	// Alice's clock runs half an hour fast, Bob's is right
+	alice.clock = skewedClock(30 * time.Minute)
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	alice.SendMessage("who has the pager?")
+	waitFor(t, "question", func() bool { return bob.GetMessageCount() == 1 })
+	question := bob.GetRoomHistory(DefaultRoom)[0]
+
+	if err := bob.SendReply(question.ID, "me"); err != nil {
+		t.Fatalf("Reply failed: %v", err)
+	}
+	waitFor(t, "answer", func() bool { return alice.GetMessageCount() == 2 })
+
+	for _, cs := range []*ChatService{alice, bob} {
+		history := cs.GetRoomHistory(DefaultRoom)
+		if history[0].ID != question.ID || history[1].ReplyTo != question.ID {
+			t.Errorf("%s: expected the question before the answer, got %v", cs.username, contents(history))
+		}
+		if history[1].Clock <= history[0].Clock {
+			t.Errorf("%s: expected the answer's clock after the question's", cs.username)
+		}
+	}
+}
@@ -3,15 +3,18 @@ package chat
 import (
 	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
 	"p2pchat/internal/peer"
+	"p2pchat/pkg/identity"
 	"p2pchat/pkg/logger"
+	"p2pchat/pkg/secure"
 )
 
 // ConnectionManager handles TCP connections to all discovered peers
@@ -20,20 +23,29 @@ type ConnectionManager struct {
 	localPeerID   string
 	localUsername string
 	localPort     int
+	identity      *identity.Identity // Signs our identification, holds the handshake key
 
 	// Connection management
 	connections map[string]*PeerConnection // peerID -> connection
 	connMutex   sync.RWMutex               // Protects connections map
 
 	// Networking
-	listener net.Listener // TCP listener for incoming connections
+	listeners []net.Listener // TCP listeners for incoming connections, one dual-stack socket or one per family
 
 	// Message handling
-	messageHandler func(*Message, string) // Callback for incoming messages
+	messageHandler func(*Message, string)    // Callback for incoming messages
+	connectHandler func(string)              // Callback when a peer link comes up
+	roomFilter     func(string, string) bool // Decides if a peer is in a room (roomID, peerID)
+
+	// Messages waiting for peers we can't reach right now (see outbox.go)
+	outbox *Outbox
 
 	// Connection retry
 	retryTicker *time.Ticker
 
+	// Timeouts, see ConnectionTiming
+	timing ConnectionTiming
+
 	// Lifecycle
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -70,45 +82,89 @@ type PeerConnection struct {
 	PeerID      string
 	Username    string
 	Address     *net.TCPAddr
-	Conn        net.Conn
+	Conn        net.Conn // Encrypted stream (*secure.Conn) once connected
+	RemoteKey   []byte   // Peer's static public key from the handshake
 	State       ConnectionState
 	LastSeen    time.Time
 	LastAttempt time.Time
 	RetryCount  int
 	SendChan    chan *Message // Channel for outgoing messages
+	wake        chan struct{} // Tells the sending loop the outbox has something
+	Static      string        // host:port we were given for this peer, always redialed (see ConnectToPeer)
+	Outbound    bool          // We dialed the current session
+	Protocol    int           // Wire protocol of the current session (see wire.go)
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
 
+// ConnectionTiming holds the intervals and timeouts of peer connections
+type ConnectionTiming struct {
+	RetryInterval time.Duration // How often failed connections are retried (with backoff on top)
+	DialTimeout   time.Duration // How long connecting to a peer may take
+	ReadTimeout   time.Duration // Silence before a link counts as dead
+	WriteTimeout  time.Duration // How long sending one message may take
+}
+
+// DefaultConnectionTiming works for a LAN, heartbeats keep quiet links well inside the read timeout
+var DefaultConnectionTiming = ConnectionTiming{
+	RetryInterval: 10 * time.Second,
+	DialTimeout:   5 * time.Second,
+	ReadTimeout:   2 * time.Minute,
+	WriteTimeout:  30 * time.Second,
+}
+
+// Validate checks the timeouts make sense, quiet links must survive between heartbeats
+func (t ConnectionTiming) Validate() error {
+	if t.RetryInterval <= 0 || t.DialTimeout <= 0 || t.ReadTimeout <= 0 || t.WriteTimeout <= 0 {
+		return fmt.Errorf("connection intervals and timeouts must be positive")
+	}
+	if t.ReadTimeout <= HeartbeatInterval {
+		return fmt.Errorf("read timeout (%s) must be longer than the heartbeat interval (%s)", t.ReadTimeout, HeartbeatInterval)
+	}
+	return nil
+}
+
 // NewConnectionManager creates a new TCP connection manager
-func NewConnectionManager(peerID, username string, port int) *ConnectionManager {
+// Every connection is encrypted with a Noise handshake and authenticated with id
+func NewConnectionManager(id *identity.Identity, username string, port int) *ConnectionManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &ConnectionManager{
-		localPeerID:   peerID,
+		localPeerID:   id.PeerID(),
 		localUsername: username,
 		localPort:     port,
+		identity:      id,
 		connections:   make(map[string]*PeerConnection),
-		retryTicker:   time.NewTicker(10 * time.Second),
+		outbox:        NewOutbox(DefaultOutboxLimits),
+		retryTicker:   time.NewTicker(DefaultConnectionTiming.RetryInterval),
+		timing:        DefaultConnectionTiming,
 		ctx:           ctx,
 		cancel:        cancel,
 	}
 }
 
+// SetTiming changes the intervals and timeouts, call it before Start
+func (cm *ConnectionManager) SetTiming(timing ConnectionTiming) {
+	cm.timing = timing
+	cm.retryTicker.Reset(timing.RetryInterval)
+}
+
 // Start begins listening for incoming TCP connections
 func (cm *ConnectionManager) Start() error {
-	// Start TCP listener
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cm.localPort))
+	// Start TCP listeners
+	listeners, err := listenDualStack(cm.localPort)
 	if err != nil {
 		return fmt.Errorf("failed to start TCP listener: %w", err)
 	}
 
-	cm.listener = listener
-	logger.Debug("🔌 TCP listener started on port %d", cm.localPort)
+	cm.listeners = listeners
+	for _, listener := range listeners {
+		logger.Debug("🔌 TCP listener started on %s", listener.Addr())
 
-	// Accept incoming connections
-	cm.wg.Add(1)
-	go cm.acceptConnections()
+		// Accept incoming connections
+		cm.wg.Add(1)
+		go cm.acceptConnections(listener)
+	}
 
 	// Start connection retry loop
 	cm.wg.Add(1)
@@ -117,8 +173,28 @@ func (cm *ConnectionManager) Start() error {
 	return nil
 }
 
-// acceptConnections handles incoming TCP connections from other peers
-func (cm *ConnectionManager) acceptConnections() {
+// listenDualStack accepts IPv4 and IPv6 peers on port
+// Usually [::] takes both, IPv4 arriving as mapped addresses. Where the system
+// has no dual-stack sockets Go gives us 0.0.0.0, so IPv6 gets its own listener
+func listenDualStack(port int) ([]net.Listener, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	listeners := []net.Listener{listener}
+
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok && addr.IP.To4() != nil {
+		if listener6, err := net.Listen("tcp6", net.JoinHostPort("::", strconv.Itoa(port))); err == nil {
+			listeners = append(listeners, listener6)
+		} else {
+			logger.Debug("🔌 No IPv6 listener on port %d: %v", port, err) // Fine on hosts without IPv6
+		}
+	}
+	return listeners, nil
+}
+
+// acceptConnections handles incoming TCP connections from other peers on one listener
+func (cm *ConnectionManager) acceptConnections(listener net.Listener) {
 	defer cm.wg.Done()
 
 	for {
@@ -127,11 +203,11 @@ func (cm *ConnectionManager) acceptConnections() {
 			return
 		default:
 			// Set a timeout so I can check for context cancellation
-			if tcpListener, ok := cm.listener.(*net.TCPListener); ok {
+			if tcpListener, ok := listener.(*net.TCPListener); ok {
 				tcpListener.SetDeadline(time.Now().Add(5 * time.Second))
 			}
 
-			conn, err := cm.listener.Accept()
+			conn, err := listener.Accept()
 			if err != nil {
 				// Check if it's a timeout (expected) vs real error
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
@@ -155,23 +231,30 @@ func (cm *ConnectionManager) handleIncomingConnection(conn net.Conn) {
 	defer cm.wg.Done()
 	// Note: Do NOT defer conn.Close() here - ownership transfers to peer connection
 
-	// Read the first message to identify the peer
-	reader := bufio.NewReader(conn)
-	line, err := reader.ReadString('\n')
+	// Encrypt the connection before anything else is exchanged
+	secureConn, err := secure.Server(conn, cm.identity.StaticKey)
 	if err != nil {
-		logger.Error("❌ Failed to read peer identification: %v", err)
+		logger.Error("❌ Encrypted handshake failed with %s: %v", conn.RemoteAddr(), err)
 		conn.Close() // Close on error only
 		return
 	}
 
-	// Parse the identification message
-	var msg Message
-	if err := json.Unmarshal([]byte(line), &msg); err != nil {
-		logger.Error("❌ Failed to parse peer identification: %v", err)
+	// Read and verify the first message to identify the peer
+	reader := bufio.NewReader(secureConn)
+	msg, err := cm.readIdent(reader, secureConn)
+	if err != nil {
+		logger.Error("🚫 Rejecting connection from %s: %v", conn.RemoteAddr(), err)
 		conn.Close() // Close on error only
 		return
 	}
 
+	// Prove our own identity back so the dialer knows who answered
+	if err := cm.sendIdent(secureConn); err != nil {
+		logger.Error("❌ Failed to send identification to %s: %v", msg.Username, err)
+		conn.Close()
+		return
+	}
+
 	// Check if we already have a connection entry for this peer
 	cm.connMutex.Lock()
 	existing := cm.connections[msg.SenderID]
@@ -179,9 +262,6 @@ func (cm *ConnectionManager) handleIncomingConnection(conn net.Conn) {
 
 	if existing != nil {
 		// Update existing connection with new socket
-		existing.Conn = conn
-		existing.State = StateConnected
-		existing.LastSeen = time.Now()
 		existing.Address = conn.RemoteAddr().(*net.TCPAddr)
 		peerConn = existing
 
@@ -191,40 +271,41 @@ func (cm *ConnectionManager) handleIncomingConnection(conn net.Conn) {
 			PeerID:   msg.SenderID,
 			Username: msg.Username,
 			Address:  conn.RemoteAddr().(*net.TCPAddr),
-			Conn:     conn,
-			State:    StateConnected,
-			LastSeen: time.Now(),
 			SendChan: make(chan *Message, 100), // Buffer for outgoing messages
+			wake:     make(chan struct{}, 1),
 		}
-		peerConn.ctx, peerConn.cancel = context.WithCancel(cm.ctx)
 		cm.connections[msg.SenderID] = peerConn
 
 	}
 	cm.connMutex.Unlock()
 
-	logger.Debug("✅ Peer connected: %s (%s)", peerConn.Username, peerConn.PeerID)
-
-	// Start message handling goroutines
-	cm.wg.Add(2)
-	go cm.handlePeerMessages(peerConn, reader)
-	go cm.handlePeerSending(peerConn)
+	if !cm.startSession(peerConn, secureConn, newWire(msg, reader, secureConn), false) {
+		logger.Debug("🔁 Keeping our own connection to %s, closing the one they dialed", msg.Username)
+	}
 }
 
 // ConnectToPeer establishes an outgoing TCP connection to a discovered peer
+// Placeholders from peer.NewStaticPeer are dialed by address, see connectStatic
 func (cm *ConnectionManager) ConnectToPeer(p *peer.Peer) error {
+	if p.IsStatic() {
+		return cm.connectStatic(p)
+	}
+
 	// Check if already connected or connecting
 	cm.connMutex.RLock()
 	existing := cm.connections[p.ID]
+	busy := existing != nil && (existing.State == StateConnected || existing.State == StateConnecting)
 	cm.connMutex.RUnlock()
 
-	if existing != nil && (existing.State == StateConnected || existing.State == StateConnecting) {
-
+	if busy {
 		return nil // Already connected or connecting
 	}
 
 	// Leader election: Only connect if peer ID is smaller
 	// This prevents duplicate connections and race conditions
-	if cm.localPeerID >= p.ID {
+	// Exchanged peers may sit behind a VPN or NAT only one of us can cross, so both
+	// sides try once and keepsSession sorts out the duplicate
+	if cm.localPeerID >= p.ID && p.Source != peer.SourceExchange {
 		logger.Debug("⏳ Waiting for %s to connect to us (peer ID ordering)", p.Username)
 		return nil
 	}
@@ -238,8 +319,8 @@ func (cm *ConnectionManager) ConnectToPeer(p *peer.Peer) error {
 			Address:  p.Address,
 			State:    StateDisconnected,
 			SendChan: make(chan *Message, 100),
+			wake:     make(chan struct{}, 1),
 		}
-		existing.ctx, existing.cancel = context.WithCancel(cm.ctx)
 		cm.connections[p.ID] = existing
 	}
 	cm.connMutex.Unlock()
@@ -248,56 +329,237 @@ func (cm *ConnectionManager) ConnectToPeer(p *peer.Peer) error {
 	return cm.attemptConnection(existing)
 }
 
+// connectStatic dials a peer we were given the address of instead of discovering it
+// We don't know its ID to order by, and it may have no way to find us, so we always dial
+// and keep redialing, whichever ID is smaller
+func (cm *ConnectionManager) connectStatic(p *peer.Peer) error {
+	address := p.StaticAddress()
+
+	cm.connMutex.Lock()
+	peerConn := cm.findStatic(address)
+	if peerConn == nil {
+		peerConn = &PeerConnection{
+			PeerID:   p.ID,
+			Username: p.Username,
+			Address:  p.Address,
+			Static:   address,
+			State:    StateDisconnected,
+			SendChan: make(chan *Message, 100),
+			wake:     make(chan struct{}, 1),
+		}
+		cm.connections[p.ID] = peerConn
+	}
+	state := peerConn.State
+	cm.connMutex.Unlock()
+
+	if state == StateConnected || state == StateConnecting {
+		return nil // Already connected or connecting
+	}
+	return cm.attemptConnection(peerConn)
+}
+
+// findStatic returns the connection to the peer we were given address for, connMutex must be held
+func (cm *ConnectionManager) findStatic(address string) *PeerConnection {
+	for _, peerConn := range cm.connections {
+		if peerConn.Static == address {
+			return peerConn
+		}
+	}
+	return nil
+}
+
+// resolveStatic files a static placeholder under the ID the peer identified with
+// If we already know that peer, its entry takes over the address so it gets redialed too
+func (cm *ConnectionManager) resolveStatic(placeholder *PeerConnection, ident *Message) *PeerConnection {
+	cm.connMutex.Lock()
+	defer cm.connMutex.Unlock()
+
+	delete(cm.connections, placeholder.PeerID)
+	logger.Debug("🪪 %s is %s (%s)", placeholder.Static, ident.Username, ident.SenderID)
+
+	if existing := cm.connections[ident.SenderID]; existing != nil {
+		existing.Static = placeholder.Static
+		return existing
+	}
+	placeholder.PeerID = ident.SenderID
+	placeholder.Username = ident.Username
+	cm.connections[ident.SenderID] = placeholder
+	return placeholder
+}
+
 // attemptConnection tries to establish a TCP connection to a peer
 func (cm *ConnectionManager) attemptConnection(peerConn *PeerConnection) error {
+	cm.connMutex.Lock()
 	peerConn.State = StateConnecting
 	peerConn.LastAttempt = time.Now()
+	peerID, username, static := peerConn.PeerID, peerConn.Username, peerConn.Static
+	attempt := peerConn.RetryCount + 1
+
+	// By name for static peers so DNS changes are picked up
+	address := static
+	if address == "" {
+		address = peerConn.Address.String()
+	}
+	cm.connMutex.Unlock()
 
-	logger.Debug("🔗 Connecting to peer %s (%s) at %s (attempt %d)",
-		peerConn.Username, peerConn.PeerID, peerConn.Address, peerConn.RetryCount+1)
+	logger.Debug("🔗 Connecting to peer %s (%s) at %s (attempt %d)", username, peerID, address, attempt)
 
 	// Establish TCP connection
-	conn, err := net.DialTimeout("tcp", peerConn.Address.String(), 5*time.Second)
+	conn, err := net.DialTimeout("tcp", address, cm.timing.DialTimeout)
 	if err != nil {
-		peerConn.State = StateFailed
-		peerConn.RetryCount++
-		logger.Error("❌ Failed to connect to peer %s: %v (will retry)", peerConn.Username, err)
-		return fmt.Errorf("failed to connect to %s: %w", peerConn.Address, err)
+		cm.connectionFailed(peerConn, true)
+		logger.Error("❌ Failed to connect to peer %s: %v (will retry)", username, err)
+		return fmt.Errorf("failed to connect to %s: %w", address, err)
 	}
 
-	// Update connection
-	peerConn.Conn = conn
-	peerConn.State = StateConnected
-	peerConn.LastSeen = time.Now()
-	peerConn.RetryCount = 0
-
-	// Send identification message
-	identMsg := NewJoinMessage(cm.localPeerID, cm.localUsername, 0)
-	identJSON, _ := identMsg.ToJSON()
-
-	writer := bufio.NewWriter(conn)
-	_, err = writer.WriteString(string(identJSON) + "\n")
+	// Encrypt the connection before identifying ourselves
+	secureConn, err := secure.Client(conn, cm.identity.StaticKey)
 	if err != nil {
-		peerConn.State = StateFailed
+		cm.connectionFailed(peerConn, true)
+		conn.Close()
+		logger.Error("❌ Encrypted handshake failed with peer %s: %v (will retry)", username, err)
+		return fmt.Errorf("handshake with %s failed: %w", address, err)
+	}
+
+	// Send identification message, then check the responder is who discovery said it was
+	if err := cm.sendIdent(secureConn); err != nil {
+		cm.connectionFailed(peerConn, false)
 		conn.Close()
 		return fmt.Errorf("failed to send identification: %w", err)
 	}
-	err = writer.Flush()
+
+	reader := bufio.NewReader(secureConn)
+	reply, err := cm.readIdent(reader, secureConn)
+	if err == nil && peer.IsStaticID(peerID) {
+		peerConn = cm.resolveStatic(peerConn, reply) // Now we know who lives at the address
+	} else if err == nil && reply.SenderID != peerID {
+		err = fmt.Errorf("expected peer %s, got %s", peerID, reply.SenderID)
+	}
 	if err != nil {
-		peerConn.State = StateFailed
+		cm.connectionFailed(peerConn, true)
 		conn.Close()
-		return fmt.Errorf("failed to flush identification: %w", err)
+		logger.Error("🚫 Refusing %s (%s): %v", username, peerID, err)
+		return fmt.Errorf("identification from %s failed: %w", address, err)
+	}
+
+	cm.connMutex.Lock()
+	peerConn.RetryCount = 0
+	peerConn.Address = conn.RemoteAddr().(*net.TCPAddr) // Names of static peers are resolved now
+	cm.connMutex.Unlock()
+	if !cm.startSession(peerConn, secureConn, newWire(reply, reader, secureConn), true) {
+		logger.Debug("🔁 Already connected to %s, closing the duplicate", reply.Username)
 	}
 
-	logger.Debug("✅ Connected to peer: %s (%s)", peerConn.Username, peerConn.PeerID)
+	return nil
+}
 
-	// Start message handling
-	reader := bufio.NewReader(conn)
+// connectionFailed marks a dial attempt as failed so retryFailedConnections picks it up
+// countRetry makes the next attempt back off longer
+func (cm *ConnectionManager) connectionFailed(peerConn *PeerConnection, countRetry bool) {
+	cm.connMutex.Lock()
+	defer cm.connMutex.Unlock()
+
+	peerConn.State = StateFailed
+	if countRetry {
+		peerConn.RetryCount++
+	}
+}
+
+// startSession installs a freshly identified connection and starts its handlers
+// Every session gets its own context, so tearing down a stale socket after a
+// reconnect never cancels the handlers of the session that replaced it
+// It returns false if the peer's live session stays and secureConn was closed instead
+func (cm *ConnectionManager) startSession(peerConn *PeerConnection, secureConn *secure.Conn, w *wire, outbound bool) bool {
+	cm.connMutex.Lock()
+	if cm.keepsSession(peerConn, outbound) {
+		cm.connMutex.Unlock()
+		secureConn.Close()
+		return false
+	}
+	if peerConn.cancel != nil {
+		peerConn.cancel() // Stop the previous session's handlers, if any are left
+	}
+	if peerConn.Conn != nil && peerConn.Conn != secureConn {
+		peerConn.Conn.Close()
+	}
+	peerConn.ctx, peerConn.cancel = context.WithCancel(cm.ctx)
+	peerConn.Conn = secureConn
+	peerConn.RemoteKey = secureConn.RemoteStatic()
+	peerConn.State = StateConnected
+	peerConn.Outbound = outbound
+	peerConn.Protocol = w.version
+	peerConn.LastSeen = time.Now()
+	ctx := peerConn.ctx
+	cm.connMutex.Unlock()
+
+	logger.Debug("✅ Connected to peer: %s (%s) key %s, speaking %s", peerConn.Username, peerConn.PeerID, secure.Fingerprint(secureConn.RemoteStatic()), w.describe())
+
+	// Start message handling goroutines
 	cm.wg.Add(2)
-	go cm.handlePeerMessages(peerConn, reader)
-	go cm.handlePeerSending(peerConn)
+	go cm.handlePeerMessages(ctx, peerConn, secureConn, w)
+	go cm.handlePeerSending(ctx, peerConn, secureConn, w)
 
-	return nil
+	cm.notifyConnected(peerConn.PeerID)
+	return true
+}
+
+// keepsSession decides whether a peer's live session stays when another one comes up, connMutex must be held
+// Peers that have each other's address both dial, then the session the smaller ID dialed wins on both ends
+func (cm *ConnectionManager) keepsSession(peerConn *PeerConnection, outbound bool) bool {
+	if peerConn.State != StateConnected || peerConn.Conn == nil {
+		return false
+	}
+	if !outbound && !peerConn.Outbound {
+		return false // They dialed again, our end of the old socket is probably dead
+	}
+	if outbound && peerConn.Outbound {
+		return true // Never hang up on our own working session
+	}
+	return peerConn.Outbound == (cm.localPeerID < peerConn.PeerID)
+}
+
+// notifyConnected tells the connect handler that a peer link is ready for traffic
+func (cm *ConnectionManager) notifyConnected(peerID string) {
+	if cm.connectHandler != nil {
+		cm.connectHandler(peerID)
+	}
+}
+
+// sendIdent writes our signed identification, bound to this connection's handshake
+// It's a JSON line whatever protocol follows, so peers without versions can read it
+func (cm *ConnectionManager) sendIdent(secureConn *secure.Conn) error {
+	identMsg := NewIdentMessage(cm.identity, cm.localUsername, secureConn.HandshakeHash())
+	identMsg.Protocol, identMsg.Capabilities = ProtocolVersion, codecNames()
+	identJSON, err := identMsg.ToJSON()
+	if err != nil {
+		return err
+	}
+	_, err = secureConn.Write(append(identJSON, '\n'))
+	return err
+}
+
+// readIdent reads the peer's identification and verifies its signature
+func (cm *ConnectionManager) readIdent(reader *bufio.Reader, secureConn *secure.Conn) (*Message, error) {
+	secureConn.SetReadDeadline(time.Now().Add(secure.HandshakeTimeout))
+	defer secureConn.SetReadDeadline(time.Time{})
+
+	line, err := readLine(reader, maxIdentSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer identification: %w", err)
+	}
+
+	msg, err := FromJSON(line)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peer identification: %w", err)
+	}
+	if msg.SenderID == cm.localPeerID {
+		return nil, fmt.Errorf("peer claims our own ID")
+	}
+	if err := msg.VerifyIdent(secureConn.HandshakeHash()); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
 }
 
 // connectionRetryLoop periodically retries failed connections
@@ -319,47 +581,47 @@ func (cm *ConnectionManager) retryFailedConnections() {
 	cm.connMutex.RLock()
 	var failedPeers []*PeerConnection
 	for _, peerConn := range cm.connections {
-		if peerConn.State == StateFailed {
-			// Exponential backoff: wait longer after each failure
-			backoffDelay := time.Duration(1<<uint(min(peerConn.RetryCount, 6))) * time.Second // Max 64s
-			if time.Since(peerConn.LastAttempt) > backoffDelay {
-				failedPeers = append(failedPeers, peerConn)
-			}
+		// Only retry if we should initiate the connection (leader election), or were told where they are
+		dials := cm.localPeerID < peerConn.PeerID || peerConn.Static != ""
+		if peerConn.State != StateFailed || !dials {
+			continue
+		}
+		// Exponential backoff: wait longer after each failure
+		backoffDelay := time.Duration(1<<uint(min(peerConn.RetryCount, 6))) * time.Second // Max 64s
+		if time.Since(peerConn.LastAttempt) > backoffDelay {
+			failedPeers = append(failedPeers, peerConn)
 		}
 	}
 	cm.connMutex.RUnlock()
 
-	// Retry failed connections
+	// Retry failed connections, Stop waits for the dials
 	for _, peerConn := range failedPeers {
-		// Only retry if we should initiate the connection (leader election)
-		if cm.localPeerID < peerConn.PeerID {
-			go cm.attemptConnection(peerConn)
-		}
+		cm.wg.Add(1)
+		go func() {
+			defer cm.wg.Done()
+			cm.attemptConnection(peerConn)
+		}()
 	}
 }
 
-// min returns the minimum of two integers
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
-// handlePeerMessages reads incoming messages from a peer connection
-func (cm *ConnectionManager) handlePeerMessages(peerConn *PeerConnection, reader *bufio.Reader) {
+// handlePeerMessages reads incoming messages from one session of a peer connection
+func (cm *ConnectionManager) handlePeerMessages(ctx context.Context, peerConn *PeerConnection, conn *secure.Conn, w *wire) {
 	defer cm.wg.Done()
-	defer cm.disconnectPeer(peerConn)
+	defer cm.disconnectPeer(peerConn, conn)
 
 	for {
 		select {
-		case <-peerConn.ctx.Done():
+		case <-ctx.Done():
 			return
 		default:
 			// Set read timeout - longer for interactive chat
-			peerConn.Conn.SetReadDeadline(time.Now().Add(2 * time.Minute))
+			conn.SetReadDeadline(time.Now().Add(cm.timing.ReadTimeout))
 
-			line, err := reader.ReadString('\n')
+			msg, err := w.ReadMessage()
+			if errors.Is(err, errInvalidMessage) {
+				logger.Error("❌ Invalid message from peer %s: %v", peerConn.Username, err)
+				continue
+			}
 			if err != nil {
 				if err == io.EOF {
 					logger.Debug("📞 Peer %s disconnected", peerConn.Username)
@@ -369,15 +631,10 @@ func (cm *ConnectionManager) handlePeerMessages(peerConn *PeerConnection, reader
 				return
 			}
 
-			// Parse the message
-			msg, err := FromJSON([]byte(line))
-			if err != nil {
-				logger.Error("❌ Invalid message from peer %s: %v", peerConn.Username, err)
-				continue
-			}
-
 			// Update last seen
+			cm.connMutex.Lock()
 			peerConn.LastSeen = time.Now()
+			cm.connMutex.Unlock()
 
 			// Handle the message
 			if cm.messageHandler != nil {
@@ -387,68 +644,161 @@ func (cm *ConnectionManager) handlePeerMessages(peerConn *PeerConnection, reader
 	}
 }
 
-// handlePeerSending sends outgoing messages to a peer connection
-func (cm *ConnectionManager) handlePeerSending(peerConn *PeerConnection) {
+// handlePeerSending sends outgoing messages over one session of a peer connection
+func (cm *ConnectionManager) handlePeerSending(ctx context.Context, peerConn *PeerConnection, conn *secure.Conn, w *wire) {
 	defer cm.wg.Done()
 
-	writer := bufio.NewWriter(peerConn.Conn)
+	// Whatever waited while the peer was away goes out first
+	if err := cm.flushOutbox(peerConn, conn, w); err != nil {
+		logger.Error("❌ Failed to send message to peer %s: %v", peerConn.Username, err)
+		return
+	}
 
 	for {
 		select {
-		case <-peerConn.ctx.Done():
+		case <-ctx.Done():
 			return
-		case msg := <-peerConn.SendChan:
-			// Serialize message
-			jsonData, err := msg.ToJSON()
-			if err != nil {
-				logger.Error("❌ Failed to serialize message: %v", err)
-				continue
+		case <-peerConn.wake:
+			if err := cm.flushOutbox(peerConn, conn, w); err != nil {
+				logger.Error("❌ Failed to send message to peer %s: %v", peerConn.Username, err)
+				return
 			}
-
-			// Send message
-			peerConn.Conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
-			_, err = writer.WriteString(string(jsonData) + "\n")
-			if err != nil {
+		case msg := <-peerConn.SendChan:
+			if err := cm.writeMessage(peerConn, conn, w, msg); err != nil {
 				logger.Error("❌ Failed to send message to peer %s: %v", peerConn.Username, err)
 				return
 			}
+		}
+	}
+}
 
-			err = writer.Flush()
-			if err != nil {
-				logger.Error("❌ Failed to flush message to peer %s: %v", peerConn.Username, err)
-				return
+// writeMessage sends one message over a session, skipping messages too big to ever go out
+func (cm *ConnectionManager) writeMessage(peerConn *PeerConnection, conn *secure.Conn, w *wire, msg *Message) error {
+	conn.SetWriteDeadline(time.Now().Add(cm.timing.WriteTimeout))
+	err := w.WriteMessage(msg)
+	if errors.Is(err, ErrFrameTooLarge) {
+		logger.Error("❌ Not sending %s message to peer %s: %v", msg.Type, peerConn.Username, err)
+		return nil
+	}
+	return err
+}
+
+// flushOutbox sends what is in the send queue, then everything waiting in the peer's outbox
+// The send queue goes first: while the outbox holds anything, new messages queue behind it
+func (cm *ConnectionManager) flushOutbox(peerConn *PeerConnection, conn *secure.Conn, w *wire) error {
+	for queued := len(peerConn.SendChan); queued > 0; queued-- {
+		if err := cm.writeMessage(peerConn, conn, w, <-peerConn.SendChan); err != nil {
+			return err
+		}
+	}
+	return cm.outbox.drain(peerConn.PeerID, func(msg *Message) error {
+		return cm.writeMessage(peerConn, conn, w, msg)
+	})
+}
+
+// enqueue hands a message to a peer's session, or leaves it in the outbox when it can't go now
+// It returns an error only if the message was dropped
+func (cm *ConnectionManager) enqueue(peerConn *PeerConnection, msg *Message) error {
+	cm.connMutex.RLock()
+	hold, err := cm.deliver(peerConn, msg)
+	cm.connMutex.RUnlock()
+
+	if hold {
+		cm.hold(peerConn, msg)
+	}
+	return err
+}
+
+// deliver puts a message in a peer's send queue, or returns true if it has to wait in the outbox
+// It returns an error only if the message has to be dropped
+// This must be called with connMutex already locked!
+func (cm *ConnectionManager) deliver(peerConn *PeerConnection, msg *Message) (bool, error) {
+	keep := msg.SenderID == cm.localPeerID && isOutboxable(msg, peerConn.PeerID)
+
+	// Don't overtake what is already waiting
+	if !keep || cm.outbox.Pending(peerConn.PeerID) == 0 {
+		if peerConn.State != StateConnected && !keep {
+			return false, fmt.Errorf("peer %s not connected", peerConn.PeerID)
+		}
+		if peerConn.State == StateConnected {
+			select {
+			case peerConn.SendChan <- msg:
+				return false, nil
+			default:
+				if !keep {
+					return false, fmt.Errorf("send queue full for peer %s", peerConn.PeerID)
+				}
 			}
 		}
 	}
+	logger.Debug("📮 Holding %s message %s for %s (%s)", msg.Type, msg.ID, peerConn.Username, peerConn.State)
+	return true, nil
+}
+
+// hold puts a message in a peer's outbox and wakes its session to flush it
+// It writes the outbox file, so never call it with connMutex locked
+func (cm *ConnectionManager) hold(peerConn *PeerConnection, msg *Message) {
+	cm.outbox.Add(peerConn.PeerID, msg)
+	select {
+	case peerConn.wake <- struct{}{}:
+	default: // Already woken, the flush will pick this one up too
+	}
 }
 
 // Broadcast sends a message to all connected peers
+// Room-scoped messages that aren't relayed (typing) only go to members of the message's room
 func (cm *ConnectionManager) Broadcast(msg *Message) {
+	cm.BroadcastExcept(msg)
+}
+
+// BroadcastExcept sends a message to all connected peers but the excluded ones
+// Relays use it so a message never goes back to where it came from
+func (cm *ConnectionManager) BroadcastExcept(msg *Message, exclude ...string) {
 	cm.connMutex.RLock()
-	defer cm.connMutex.RUnlock()
+	skip := make(map[string]bool, len(exclude))
+	for _, peerID := range exclude {
+		skip[peerID] = true
+	}
 
 	connectedCount := 0
-	for _, peerConn := range cm.connections {
-		if peerConn.State == StateConnected {
+	for peerID, peerConn := range cm.connections {
+		if peerConn.State == StateConnected && cm.inRoom(msg, peerID) && !skip[peerID] {
 			connectedCount++
 		}
 	}
 
 	logger.Debug("📡 Broadcasting message to %d connected peers", connectedCount)
 
+	var held []*PeerConnection
 	for peerID, peerConn := range cm.connections {
-		if peerConn.State != StateConnected {
+		if !cm.inRoom(msg, peerID) || skip[peerID] {
 			continue
 		}
 
-		select {
-		case peerConn.SendChan <- msg:
-			// Message queued successfully
-		default:
+		// Peers that are away get what we wrote from the outbox once they are back
+		hold, err := cm.deliver(peerConn, msg)
+		if err != nil && peerConn.State == StateConnected {
 			// Send channel full, peer might be slow or disconnected
 			logger.Error("⚠️ Send queue full for peer %s, skipping message", peerID)
 		}
+		if hold {
+			held = append(held, peerConn)
+		}
+	}
+	cm.connMutex.RUnlock()
+
+	for _, peerConn := range held {
+		cm.hold(peerConn, msg)
+	}
+}
+
+// inRoom checks whether a peer should receive a message based on room membership
+// Relayed room traffic goes to every neighbour, members may only be reachable through non-members
+func (cm *ConnectionManager) inRoom(msg *Message, peerID string) bool {
+	if !msg.IsRoomScoped() || isRelayable(msg) || cm.roomFilter == nil {
+		return true
 	}
+	return cm.roomFilter(msg.RoomID, peerID)
 }
 
 // SendToPeer sends a message to a specific peer
@@ -457,35 +807,85 @@ func (cm *ConnectionManager) SendToPeer(peerID string, msg *Message) error {
 	peerConn, exists := cm.connections[peerID]
 	cm.connMutex.RUnlock()
 
-	if !exists || peerConn.State != StateConnected {
+	if !exists {
 		return fmt.Errorf("peer %s not connected", peerID)
 	}
+	return cm.enqueue(peerConn, msg)
+}
 
-	select {
-	case peerConn.SendChan <- msg:
-		return nil
-	default:
-		return fmt.Errorf("send queue full for peer %s", peerID)
+// isConnected returns true if a peer has a live session
+func (cm *ConnectionManager) isConnected(peerID string) bool {
+	cm.connMutex.RLock()
+	defer cm.connMutex.RUnlock()
+
+	peerConn := cm.connections[peerID]
+	return peerConn != nil && peerConn.State == StateConnected
+}
+
+// PendingMessages returns how many messages wait in the outbox for a peer
+func (cm *ConnectionManager) PendingMessages(peerID string) int {
+	return cm.outbox.Pending(peerID)
+}
+
+// unsent returns how many messages for connected peers aren't written to their links yet
+// Peers that are away don't count, their outbox waits for them to come back
+func (cm *ConnectionManager) unsent() int {
+	cm.connMutex.RLock()
+	defer cm.connMutex.RUnlock()
+
+	total := 0
+	for peerID, peerConn := range cm.connections {
+		if peerConn.State == StateConnected {
+			total += len(peerConn.SendChan) + cm.outbox.Pending(peerID)
+		}
 	}
+	return total
 }
 
-// disconnectPeer handles peer disconnection cleanup
-func (cm *ConnectionManager) disconnectPeer(peerConn *PeerConnection) {
+// disconnectPeer handles peer disconnection cleanup for the session running on conn
+func (cm *ConnectionManager) disconnectPeer(peerConn *PeerConnection, conn *secure.Conn) {
+	conn.Close()
+
+	cm.connMutex.Lock()
+	defer cm.connMutex.Unlock()
+
+	if peerConn.Conn != conn {
+		return // A newer session already replaced this one
+	}
 	peerConn.State = StateFailed
 	peerConn.cancel()
-	if peerConn.Conn != nil {
-		peerConn.Conn.Close()
-		peerConn.Conn = nil
-	}
+	peerConn.Conn = nil
 
 	logger.Debug("❌ Peer disconnected: %s (%s) - will retry connection", peerConn.Username, peerConn.PeerID)
 }
 
+// peerAddress returns the remote address of a peer's link, or nil
+func (cm *ConnectionManager) peerAddress(peerID string) *net.TCPAddr {
+	cm.connMutex.RLock()
+	defer cm.connMutex.RUnlock()
+
+	if peerConn := cm.connections[peerID]; peerConn != nil {
+		return peerConn.Address
+	}
+	return nil
+}
+
 // SetMessageHandler sets the callback for incoming messages
 func (cm *ConnectionManager) SetMessageHandler(handler func(*Message, string)) {
 	cm.messageHandler = handler
 }
 
+// SetConnectHandler sets the callback for when a peer connection is established
+// It fires for both outgoing and incoming connections, once the link can carry messages
+func (cm *ConnectionManager) SetConnectHandler(handler func(string)) {
+	cm.connectHandler = handler
+}
+
+// SetRoomFilter sets the membership check Broadcast uses for room-scoped messages
+func (cm *ConnectionManager) SetRoomFilter(filter func(roomID, peerID string) bool) {
+	cm.roomFilter = filter
+}
+
 // GetConnectedPeers returns a list of currently connected peers
 func (cm *ConnectionManager) GetConnectedPeers() []string {
 	cm.connMutex.RLock()
@@ -512,15 +912,17 @@ func (cm *ConnectionManager) Stop() error {
 		cm.retryTicker.Stop()
 	}
 
-	// Close listener
-	if cm.listener != nil {
-		cm.listener.Close()
+	// Close listeners
+	for _, listener := range cm.listeners {
+		listener.Close()
 	}
 
 	// Close all peer connections
 	cm.connMutex.Lock()
 	for _, peerConn := range cm.connections {
-		peerConn.cancel()
+		if peerConn.cancel != nil {
+			peerConn.cancel()
+		}
 		if peerConn.Conn != nil {
 			peerConn.Conn.Close()
 		}
@@ -529,6 +931,7 @@ func (cm *ConnectionManager) Stop() error {
 
 	// Wait for all goroutines to finish
 	cm.wg.Wait()
+	cm.outbox.Close()
 
 	logger.Debug("✅ Connection manager stopped")
 	return nil
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"sync"
+	"time"
+
+	"p2pchat/pkg/logger"
+)
+
+// Gossip relay: when some pairwise connections are firewalled the mesh isn't
+// full, so every peer re-broadcasts room traffic to its other connections -
+// members of the room or not, as the only path between two members may run
+// through someone who isn't. Non-members pass it on without keeping it.
+// Each message carries a TTL that relays decrement, and peers remember what
+// they already forwarded so floods die out instead of looping. Relayed copies
+// arrive from someone other than their author, which is why they must carry
+// a valid author signature.
+//
+// Direct messages are never relayed: links are encrypted hop by hop, so a
+// relay could read them. They still need a direct connection.
+const (
+	// DefaultTTL is how many hops a message we author may travel
+	DefaultTTL = 4
+
+	// relayMemory is how long forwarded message IDs are remembered
+	relayMemory = 10 * time.Minute
+)
+
+// isRelayable returns true for message types that are flooded across the mesh
+func isRelayable(msg *Message) bool {
+	switch msg.Type {
+	case MessageTypeChat, MessageTypeRoomJoin, MessageTypeRoomPart:
+		return true
+	case MessageTypeReaction, MessageTypeEdit, MessageTypeDelete:
+		return msg.RecipientID == "" // Annotations of DMs stay as private as the DM
+	case MessageTypeDelivered, MessageTypeRead:
+		return msg.RoomID != "" // Only receipts for room messages carry a room
+	default:
+		return false
+	}
+}
+
+// relayCache remembers recently forwarded message IDs
+// MessageHistory catches most duplicates, this also covers messages we relay
+// but don't keep (rooms we haven't joined, repeated membership notices)
+type relayCache struct {
+	seen  map[string]time.Time
+	mutex sync.Mutex
+}
+
+// newRelayCache creates an empty relay cache
+func newRelayCache() *relayCache {
+	return &relayCache{seen: make(map[string]time.Time)}
+}
+
+// markSeen records a message ID and returns true if it wasn't seen before
+func (c *relayCache) markSeen(messageID string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if seenAt, ok := c.seen[messageID]; ok && now.Sub(seenAt) < relayMemory {
+		return false
+	}
+	c.seen[messageID] = now
+
+	// Forget old entries once in a while so the cache stays small
+	if len(c.seen)%1000 == 0 {
+		for id, seenAt := range c.seen {
+			if now.Sub(seenAt) >= relayMemory {
+				delete(c.seen, id)
+			}
+		}
+	}
+	return true
+}
+
+// originate stamps and signs a message we author and gives it a full hop budget if it may be relayed
+func (cs *ChatService) originate(msg *Message) {
+	msg.Clock = cs.clock.tick()
+	msg.Sign(cs.identity)
+	if isRelayable(msg) {
+		msg.TTL = DefaultTTL
+		cs.relayed.markSeen(msg.ID) // Don't forward our own message when it comes back
+	}
+}
+
+// acceptRelayed checks a message that arrived from someone other than its author
+func (cs *ChatService) acceptRelayed(msg *Message, fromPeerID string) bool {
+	if msg.SenderID == fromPeerID {
+		return true // Peers speak for themselves, the connection already proved who they are
+	}
+	if !isRelayable(msg) {
+		logger.Error("🚫 Dropping %s message from %s claiming to be %s", msg.Type, fromPeerID, msg.SenderID)
+		return false
+	}
+	if err := msg.VerifySignature(); err != nil {
+		logger.Error("🚫 Dropping relayed message %s from %s: %v", msg.ID, fromPeerID, err)
+		return false
+	}
+	return true
+}
+
+// relay passes a message on to our other connections while it has hops left
+func (cs *ChatService) relay(msg *Message, fromPeerID string) {
+	if !isRelayable(msg) || msg.TTL <= 1 || msg.SenderID == cs.peerID {
+		return
+	}
+	if msg.RecipientID == cs.peerID {
+		return // Addressed to us, nobody else needs it
+	}
+	if msg.Signature == "" {
+		return // The next hop couldn't verify it
+	}
+	if cs.messageHistory.HasMessage(msg.ID) || !cs.relayed.markSeen(msg.ID) {
+		return // Already seen, whoever sent it to us first got it forwarded
+	}
+
+	forward := *msg
+	forward.TTL--
+	logger.Debug("🔁 Relaying %s from %s (%d hops left)", msg.ID, msg.Username, forward.TTL)
+	cs.connections.BroadcastExcept(&forward, fromPeerID, msg.SenderID)
+}
@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"testing"
+
+	"p2pchat/internal/peer"
+)
+
+func TestRelayReachesPeersWithoutDirectLink(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+	carol := newTestService(t, "carol")
+
+	// Alice and Carol are firewalled from each other, Bob sits in between
+	// Whatever peer exchange tells them about each other goes nowhere
+	for _, cs := range []*ChatService{alice, carol} {
+		cs.discovery.SetPeerEventHandlers(func(*peer.Peer) {}, func(*peer.Peer) {})
+	}
+	connectServices(t, alice, bob)
+	connectServices(t, bob, carol)
+	waitFor(t, "links", func() bool { return len(bob.connections.GetConnectedPeers()) == 2 })
+
+	alice.SendMessage("hello from alice")
+	waitFor(t, "relay to carol", func() bool { return carol.GetMessageCount() == 1 })
+
+	carol.SendMessage("hi alice")
+	waitFor(t, "relay to alice", func() bool { return alice.GetMessageCount() == 2 })
+	if alice.connections.isConnected(carol.peerID) {
+		t.Fatal("Expected no direct link between alice and carol")
+	}
+
+	// Rooms flood through peers outside them too, Bob passes #ops on without keeping it
+	carol.JoinRoom("ops")
+	waitFor(t, "carol's membership reaching alice", func() bool { return alice.rooms.IsMember("ops", carol.peerID) })
+	alice.JoinRoom("ops")
+	waitFor(t, "alice's membership reaching carol", func() bool { return carol.rooms.IsMember("ops", alice.peerID) })
+	alice.SendRoomMessage("ops", "deploying")
+	waitFor(t, "room relay to carol", func() bool { return hasContent(carol.GetRoomHistory("ops"), "deploying") })
+	if bob.GetMessageCount() != 2 || len(bob.GetRoomHistory("ops")) != 0 {
+		t.Errorf("Expected bob to keep nothing from #ops, got %d messages", bob.GetMessageCount())
+	}
+
+	// Our own relayed messages must not be mistaken for someone else's
+	if alice.rooms.IsMember("ops", alice.peerID) {
+		t.Error("Alice's own relayed join should not make her a remote member")
+	}
+}
+
+func TestRelayRejectsForgedMessages(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	// Bob claims Alice said something, without her signature
+	forged := NewChatMessage(alice.peerID, "alice", "I owe bob $100", 1)
+	forged.TTL = DefaultTTL
+	if alice.acceptRelayed(forged, bob.peerID) {
+		t.Error("Unsigned message relayed on behalf of someone else should be rejected")
+	}
+
+	// A real signed message from Alice is fine to relay
+	genuine := NewChatMessage(alice.peerID, "alice", "hello", 2)
+	alice.originate(genuine)
+	if !bob.acceptRelayed(genuine, "someone-else") {
+		t.Error("Signed message should be accepted from a relay")
+	}
+
+	// Tampering after signing breaks the signature
+	genuine.Content = "I owe bob $100"
+	if bob.acceptRelayed(genuine, "someone-else") {
+		t.Error("Tampered relay should be rejected")
+	}
+
+	// Direct messages never travel through relays
+	direct := NewDirectMessage(alice.peerID, "alice", bob.peerID, "psst", 3)
+	alice.originate(direct)
+	if direct.TTL != 0 || bob.acceptRelayed(direct, "someone-else") {
+		t.Error("Direct messages should not be relayable")
+	}
+}
+
+func TestRelayStopsWhenTTLRunsOut(t *testing.T) {
+	bob := newTestService(t, "bob")
+	author := newTestService(t, "alice")
+
+	msg := NewChatMessage(author.peerID, "alice", "last hop", 1)
+	author.originate(msg)
+	msg.TTL = 1
+
+	bob.relay(msg, author.peerID)
+	if !bob.relayed.markSeen(msg.ID) {
+		t.Error("A message without hops left should not be forwarded")
+	}
+
+	msg = NewChatMessage(author.peerID, "alice", "more hops", 2)
+	author.originate(msg)
+	bob.relay(msg, author.peerID)
+	if bob.relayed.markSeen(msg.ID) {
+		t.Error("A message with hops left should be marked as forwarded")
+	}
+}
+
+// hasContent reports whether any message has the given content
+func hasContent(messages []*Message, content string) bool {
+	for _, msg := range messages {
+		if msg.Content == content {
+			return true
+		}
+	}
+	return false
+}
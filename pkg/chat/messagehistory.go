@@ -1,19 +1,28 @@
 package chat
 
 import (
+	"slices"
 	"sort"
 	"sync"
+	"time"
 
 	"p2pchat/pkg/logger"
 )
 
-// MessageHistory manages chronologically ordered message storage
+// MessageHistory manages causally ordered message storage (see clock.go)
 // This handles in-memory storage, duplicate detection, and efficient retrieval
+// Messages are partitioned by conversation (room or DM) so each scrolls independently
+// An optional MessageStore persists everything added so history survives restarts
 type MessageHistory struct {
-	messages    []*Message      // Chronologically ordered messages
-	messageIDs  map[string]bool // Fast duplicate detection O(1) lookup
-	maxMessages int             // Maximum messages to keep in memory
-	mutex       sync.RWMutex    // Protects concurrent access
+	rooms       map[string][]*Message // conversation ID -> causally ordered messages
+	messageIDs  map[string]*Message   // Fast duplicate detection and lookup by ID (across all rooms)
+	pending     map[string][]*Message // target ID -> annotations that arrived before their target
+	replies     map[string][]*Message // parent ID -> replies, so threads don't need a scan
+	evicted     map[string]bool       // IDs pushed out by maxMessages, so they aren't taken back as new
+	evictOrder  []heldID              // Evicted messages oldest first, to forget the oldest
+	maxMessages int                   // Maximum messages to keep in memory per room
+	store       MessageStore          // Persistent backing store (nil = memory only)
+	mutex       sync.RWMutex          // Protects concurrent access
 }
 
 // NewMessageHistory creates a new message history manager
@@ -23,12 +32,27 @@ func NewMessageHistory(maxMessages int) *MessageHistory {
 	}
 
 	return &MessageHistory{
-		messages:    make([]*Message, 0, maxMessages),
-		messageIDs:  make(map[string]bool),
+		rooms:       make(map[string][]*Message),
+		messageIDs:  make(map[string]*Message),
+		pending:     make(map[string][]*Message),
+		replies:     make(map[string][]*Message),
+		evicted:     make(map[string]bool),
 		maxMessages: maxMessages,
 	}
 }
 
+const (
+	// maxEvictedIDs caps how many evicted message IDs are remembered, as many as a store keeps by default
+	maxEvictedIDs = 10000
+)
+
+// heldID is what history remembers of a message after evicting it
+type heldID struct {
+	ID        string
+	Type      MessageType
+	Timestamp time.Time
+}
+
 // AddMessage adds a message to history with duplicate detection and ordering
 func (h *MessageHistory) AddMessage(msg *Message) bool {
 	if msg == nil {
@@ -38,42 +62,139 @@ func (h *MessageHistory) AddMessage(msg *Message) bool {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
+	if !h.insert(msg) {
+		return false
+	}
+
+	// Persist after the in-memory add so duplicates never reach the store
+	if h.store != nil {
+		if err := h.store.Append(msg); err != nil {
+			logger.Error("❌ Failed to persist message %s: %v", msg.ID, err)
+		}
+	}
+	return true
+}
+
+// SetStore attaches a persistent store and loads its messages into history
+func (h *MessageHistory) SetStore(store MessageStore) error {
+	messages, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	loaded := 0
+	for _, msg := range messages {
+		if h.insert(msg) {
+			loaded++
+		}
+	}
+	h.store = store
+
+	logger.Debug("💾 Loaded %d messages from history store", loaded)
+	return nil
+}
+
+// Close releases the persistent store, if any
+func (h *MessageHistory) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.store == nil {
+		return nil
+	}
+	err := h.store.Close()
+	h.store = nil
+	return err
+}
+
+// insert adds a message to its room with duplicate detection and ordering
+// This must be called with mutex already locked!
+func (h *MessageHistory) insert(msg *Message) bool {
 	// Check for duplicates using message ID
-	if h.messageIDs[msg.ID] {
+	if h.messageIDs[msg.ID] != nil || h.evicted[msg.ID] {
 		logger.Debug("🔄 Duplicate message detected: %s (ID: %s)", msg.Content, msg.ID)
 		return false // Message already exists
 	}
 
-	// Skip heartbeat messages from history (they're just for connection health)
-	if msg.Type == MessageTypeHeartbeat {
+	// Skip heartbeat and typing messages from history (they only matter live)
+	if msg.Type == MessageTypeHeartbeat || msg.Type == MessageTypeTyping {
 		return false
 	}
 
-	// Add to messages and mark as seen
-	h.messages = append(h.messages, msg)
-	h.messageIDs[msg.ID] = true
+	// Reactions, edits and deletes must be allowed on their target (see annotations.go)
+	if msg.IsAnnotation() && !h.annotate(msg) {
+		return false
+	}
 
-	// Sort messages chronologically (important for multi-peer consistency)
-	sort.Slice(h.messages, func(i, j int) bool {
-		return h.messages[i].Timestamp.Before(h.messages[j].Timestamp)
-	})
+	// Add to the message's room and mark as seen
+	room := msg.ConversationID()
+	h.rooms[room] = insertOrdered(h.rooms[room], msg)
+	h.messageIDs[msg.ID] = msg
+	if msg.ReplyTo != "" {
+		h.replies[msg.ReplyTo] = append(h.replies[msg.ReplyTo], msg)
+	}
+	h.applyPending(msg) // After it's in place everywhere, this swaps in a changed copy
 
 	// Cleanup old messages if we exceed limit
-	h.cleanup()
+	h.cleanup(room)
 
-	logger.Debug("📚 Added message to history: %s (Total: %d)", msg.Content, len(h.messages))
+	logger.Debug("📚 Added message to #%s history: %s (Total: %d)", room, msg.Content, len(h.rooms[room]))
 	return true
 }
 
-// GetMessages returns all messages, optionally filtered by type
+// insertOrdered puts msg where it belongs in causally ordered messages
+// New messages almost always go at the end, so that is checked before searching
+func insertOrdered(messages []*Message, msg *Message) []*Message {
+	i := len(messages)
+	if i > 0 && causallyBefore(msg, messages[i-1]) {
+		i = sort.Search(len(messages), func(j int) bool {
+			return causallyBefore(msg, messages[j])
+		})
+	}
+	return slices.Insert(messages, i, msg)
+}
+
+// GetMessages returns all messages across every room, optionally filtered by type
 func (h *MessageHistory) GetMessages(messageTypes ...MessageType) []*Message {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 
+	typeMap := make(map[MessageType]bool)
+	for _, msgType := range messageTypes {
+		typeMap[msgType] = true
+	}
+
+	var result []*Message
+	for _, messages := range h.rooms {
+		for _, msg := range messages {
+			if len(typeMap) == 0 || typeMap[msg.Type] {
+				result = append(result, msg)
+			}
+		}
+	}
+
+	// Rooms are each sorted, merge them back into one timeline
+	sort.SliceStable(result, func(i, j int) bool {
+		return causallyBefore(result[i], result[j])
+	})
+
+	return result
+}
+
+// GetRoomMessages returns the messages of a single room, optionally filtered by type
+func (h *MessageHistory) GetRoomMessages(roomID string, messageTypes ...MessageType) []*Message {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	messages := h.rooms[roomID]
+
 	if len(messageTypes) == 0 {
 		// Return all messages (make a copy to prevent external modification)
-		result := make([]*Message, len(h.messages))
-		copy(result, h.messages)
+		result := make([]*Message, len(messages))
+		copy(result, messages)
 		return result
 	}
 
@@ -84,7 +205,7 @@ func (h *MessageHistory) GetMessages(messageTypes ...MessageType) []*Message {
 	}
 
 	var filtered []*Message
-	for _, msg := range h.messages {
+	for _, msg := range messages {
 		if typeMap[msg.Type] {
 			filtered = append(filtered, msg)
 		}
@@ -93,24 +214,33 @@ func (h *MessageHistory) GetMessages(messageTypes ...MessageType) []*Message {
 	return filtered
 }
 
-// GetRecentMessages returns the most recent N messages
-func (h *MessageHistory) GetRecentMessages(limit int) []*Message {
+// Conversations returns the IDs of every room and DM with stored messages
+func (h *MessageHistory) Conversations() []string {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 
-	totalMessages := len(h.messages)
+	conversations := make([]string, 0, len(h.rooms))
+	for room, messages := range h.rooms {
+		if len(messages) > 0 {
+			conversations = append(conversations, room)
+		}
+	}
+	sort.Strings(conversations)
+	return conversations
+}
+
+// GetRecentMessages returns the most recent N messages across every room
+func (h *MessageHistory) GetRecentMessages(limit int) []*Message {
+	messages := h.GetMessages()
+
+	totalMessages := len(messages)
 	if limit <= 0 || limit >= totalMessages {
 		// Return all messages
-		result := make([]*Message, totalMessages)
-		copy(result, h.messages)
-		return result
+		return messages
 	}
 
 	// Return the last N messages
-	startIndex := totalMessages - limit
-	result := make([]*Message, limit)
-	copy(result, h.messages[startIndex:])
-	return result
+	return messages[totalMessages-limit:]
 }
 
 // GetMessageCount returns the total number of stored messages
@@ -118,7 +248,11 @@ func (h *MessageHistory) GetMessageCount() int {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 
-	return len(h.messages)
+	total := 0
+	for _, messages := range h.rooms {
+		total += len(messages)
+	}
+	return total
 }
 
 // HasMessage checks if a message ID exists (for duplicate detection)
@@ -126,7 +260,7 @@ func (h *MessageHistory) HasMessage(messageID string) bool {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 
-	return h.messageIDs[messageID]
+	return h.messageIDs[messageID] != nil
 }
 
 // Clear removes all messages from history
@@ -134,33 +268,84 @@ func (h *MessageHistory) Clear() {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	h.messages = h.messages[:0] // Keep capacity but reset length
-	h.messageIDs = make(map[string]bool)
+	h.rooms = make(map[string][]*Message)
+	h.messageIDs = make(map[string]*Message)
+	h.pending = make(map[string][]*Message)
+	h.replies = make(map[string][]*Message)
+	h.evicted = make(map[string]bool)
+	h.evictOrder = nil
+
+	if h.store != nil {
+		if err := h.store.Clear(); err != nil {
+			logger.Error("❌ Failed to clear history store: %v", err)
+		}
+	}
 
 	logger.Debug("🗑️ Message history cleared")
 }
 
-// cleanup removes oldest messages when exceeding maxMessages limit
+// cleanup removes a room's oldest messages when exceeding maxMessages limit
 // This must be called with mutex already locked!
-func (h *MessageHistory) cleanup() {
-	if len(h.messages) <= h.maxMessages {
+func (h *MessageHistory) cleanup(room string) {
+	messages := h.rooms[room]
+	if len(messages) <= h.maxMessages {
 		return // No cleanup needed
 	}
 
 	// Calculate how many messages to remove
-	excessMessages := len(h.messages) - h.maxMessages
+	excessMessages := len(messages) - h.maxMessages
 
 	// Remove oldest messages and their IDs
 	for i := 0; i < excessMessages; i++ {
-		oldMsg := h.messages[i]
+		oldMsg := messages[i]
 		delete(h.messageIDs, oldMsg.ID)
+		delete(h.replies, oldMsg.ID)
+		h.evict(oldMsg)
 	}
 
 	// Shift remaining messages to beginning of slice
-	copy(h.messages, h.messages[excessMessages:])
-	h.messages = h.messages[:h.maxMessages]
+	copy(messages, messages[excessMessages:])
+	h.rooms[room] = messages[:h.maxMessages]
+
+	logger.Debug("🧹 Cleaned up %d old messages in #%s, %d remaining", excessMessages, room, h.maxMessages)
+}
+
+// evict remembers a message that no longer fits in memory
+// It's still in the store, so sync must neither send it again nor take it back as new
+// This must be called with mutex already locked!
+func (h *MessageHistory) evict(msg *Message) {
+	h.evicted[msg.ID] = true
+	h.evictOrder = append(h.evictOrder, heldID{ID: msg.ID, Type: msg.Type, Timestamp: msg.Timestamp})
+	if len(h.evictOrder) > maxEvictedIDs {
+		delete(h.evicted, h.evictOrder[0].ID)
+		h.evictOrder = h.evictOrder[1:]
+	}
+}
+
+// HeldIDs returns the IDs of messages newer than since that we hold, in memory or only in the store
+// Optionally filtered by type, this is what a sync summary is built from
+func (h *MessageHistory) HeldIDs(since time.Time, messageTypes ...MessageType) []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
 
-	logger.Debug("🧹 Cleaned up %d old messages, %d remaining", excessMessages, len(h.messages))
+	wanted := func(msgType MessageType, timestamp time.Time) bool {
+		return timestamp.After(since) && (len(messageTypes) == 0 || slices.Contains(messageTypes, msgType))
+	}
+
+	var ids []string
+	for _, held := range h.evictOrder {
+		if wanted(held.Type, held.Timestamp) {
+			ids = append(ids, held.ID)
+		}
+	}
+	for _, messages := range h.rooms {
+		for _, msg := range messages {
+			if wanted(msg.Type, msg.Timestamp) {
+				ids = append(ids, msg.ID)
+			}
+		}
+	}
+	return ids
 }
 
 // GetStats returns statistics about the message history
@@ -168,10 +353,16 @@ func (h *MessageHistory) GetStats() MessageHistoryStats {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 
+	total := 0
+	for _, messages := range h.rooms {
+		total += len(messages)
+	}
+
 	stats := MessageHistoryStats{
-		TotalMessages: len(h.messages),
+		TotalMessages: total,
 		MaxMessages:   h.maxMessages,
 		UniqueIDs:     len(h.messageIDs),
+		Rooms:         len(h.rooms),
 	}
 
 	return stats
@@ -182,4 +373,5 @@ type MessageHistoryStats struct {
 	TotalMessages int `json:"total_messages"`
 	MaxMessages   int `json:"max_messages"`
 	UniqueIDs     int `json:"unique_ids"`
+	Rooms         int `json:"rooms"`
 }
@@ -0,0 +1,354 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/logger"
+)
+
+// Outbox: messages we wrote that can't go out right now - the peer is
+// offline, still connecting, or its send queue is full - wait here instead of
+// being dropped. Every peer has its own queue in the order the messages were
+// sent. A new session drains it before anything else, and the session's
+// sending loop drains it again whenever more is queued. Messages only leave
+// the outbox once they were written to the link, and while a peer has any
+// waiting, everything else we write for it queues behind them so nothing
+// overtakes.
+//
+// Only what is worth delivering late is kept: chat, DMs, annotations and
+// receipts. Presence, typing, sync and file transfers are redone after every
+// reconnect anyway, and relayed messages are their authors' business.
+//
+// With a file (SetFile) the outbox survives restarts. The file is a log: Add
+// appends one line per message, and it's only rewritten with what is still
+// waiting (compacted) when it's loaded, after a drain sent something, and when
+// dropped messages make up most of it. Callers must not hold connMutex while
+// calling Add or drain, they write to disk.
+
+// OutboxLimits bounds how much waits for one peer and for how long
+type OutboxLimits struct {
+	MaxMessages int           // Per peer, the oldest are dropped to make room
+	MaxAge      time.Duration // Messages waiting longer than this are dropped
+}
+
+// DefaultOutboxLimits holds a busy day of chat for a peer that is away
+var DefaultOutboxLimits = OutboxLimits{
+	MaxMessages: 500,
+	MaxAge:      24 * time.Hour,
+}
+
+// Validate checks both limits are set
+func (l OutboxLimits) Validate() error {
+	if l.MaxMessages <= 0 || l.MaxAge <= 0 {
+		return fmt.Errorf("outbox size and age limits must be positive")
+	}
+	return nil
+}
+
+// outboxEntry is one message waiting for one peer, also the line format of the outbox file
+type outboxEntry struct {
+	PeerID  string    `json:"peer_id"`
+	Queued  time.Time `json:"queued"`
+	Message *Message  `json:"message"`
+}
+
+// Outbox holds the messages waiting for each peer
+type Outbox struct {
+	queues map[string][]outboxEntry // peerID -> oldest first
+	limits OutboxLimits
+	path   string   // Log of queued messages, empty to keep the outbox in memory
+	file   *os.File // Open for appending, nil until the first Add after a compaction
+	logged int      // Lines in the file, queued messages plus those since sent or dropped
+	mutex  sync.Mutex
+}
+
+// NewOutbox creates an empty outbox kept in memory
+func NewOutbox(limits OutboxLimits) *Outbox {
+	return &Outbox{
+		queues: make(map[string][]outboxEntry),
+		limits: limits,
+	}
+}
+
+// isOutboxable returns true for messages worth delivering to peerID after a delay
+func isOutboxable(msg *Message, peerID string) bool {
+	if peer.IsStaticID(peerID) {
+		return false // We don't know who that is yet, history sync covers them once we do
+	}
+	if msg.RecipientID != "" && msg.RecipientID != peerID {
+		return false // Addressed to someone else, peerID would only have relayed it
+	}
+	return isAnnotatable(msg) || msg.IsAnnotation() || msg.IsReceipt()
+}
+
+// SetLimits changes the limits, call it before anything is queued
+func (o *Outbox) SetLimits(limits OutboxLimits) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.limits = limits
+}
+
+// SetFile loads what was still waiting in path and keeps the outbox there from now on
+// A missing file is just an empty outbox
+func (o *Outbox) SetFile(path string) error {
+	entries, err := loadOutboxFile(path)
+	if err != nil {
+		return err
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for _, entry := range entries {
+		o.queues[entry.PeerID] = append(o.queues[entry.PeerID], entry)
+	}
+	o.path = path
+	o.trim()
+	o.prune(time.Now())
+	if len(entries) > 0 {
+		logger.Debug("📮 Loaded %d undelivered messages from %s", len(entries), path)
+	}
+	return o.compact()
+}
+
+// Add queues a message for a peer, dropping the oldest if the peer's queue is full
+func (o *Outbox) Add(peerID string, msg *Message) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	entry := outboxEntry{PeerID: peerID, Queued: time.Now(), Message: msg}
+	o.queues[peerID] = append(o.queues[peerID], entry)
+	o.trim()
+	o.prune(time.Now())
+
+	if err := o.append(entry); err != nil {
+		logger.Error("⚠️ Failed to save outbox: %v", err)
+	}
+}
+
+// Pending returns how many messages wait for a peer
+func (o *Outbox) Pending(peerID string) int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.queues[peerID])
+}
+
+// drain hands the messages waiting for a peer to send in order, until none are left or send fails
+// Messages queued while draining are sent too, they are behind the others anyway
+func (o *Outbox) drain(peerID string, send func(*Message) error) error {
+	for {
+		o.mutex.Lock()
+		o.prune(time.Now())
+		batch := append([]outboxEntry(nil), o.queues[peerID]...)
+		o.mutex.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		var err error
+		sent := make(map[string]bool, len(batch))
+		for _, entry := range batch {
+			if err = send(entry.Message); err != nil {
+				break
+			}
+			sent[entry.Message.ID] = true
+		}
+		logger.Debug("📮 Flushed %d of %d waiting messages to %s", len(sent), len(batch), peerID)
+
+		o.mutex.Lock()
+		if len(sent) > 0 {
+			o.remove(peerID, sent)
+			if saveErr := o.compact(); saveErr != nil {
+				logger.Error("⚠️ Failed to save outbox: %v", saveErr)
+			}
+		}
+		o.mutex.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// remove drops the sent messages from a peer's queue
+// This must be called with mutex already locked!
+func (o *Outbox) remove(peerID string, sent map[string]bool) {
+	var queue []outboxEntry
+	for _, entry := range o.queues[peerID] {
+		if !sent[entry.Message.ID] {
+			queue = append(queue, entry)
+		}
+	}
+	if len(queue) == 0 {
+		delete(o.queues, peerID)
+		return
+	}
+	o.queues[peerID] = queue
+}
+
+// trim drops the oldest messages of every queue that is over MaxMessages
+// This must be called with mutex already locked!
+func (o *Outbox) trim() {
+	for peerID, queue := range o.queues {
+		if over := len(queue) - o.limits.MaxMessages; over > 0 {
+			logger.Error("⚠️ Outbox for %s is full, dropping the %d oldest messages", peerID, over)
+			o.queues[peerID] = queue[over:]
+		}
+	}
+}
+
+// prune drops messages that waited longer than MaxAge
+// This must be called with mutex already locked!
+func (o *Outbox) prune(now time.Time) {
+	for peerID, queue := range o.queues {
+		expired := 0
+		for expired < len(queue) && now.Sub(queue[expired].Queued) > o.limits.MaxAge {
+			expired++
+		}
+		if expired == 0 {
+			continue
+		}
+		logger.Debug("📮 Dropping %d messages that waited too long for %s", expired, peerID)
+		if expired == len(queue) {
+			delete(o.queues, peerID)
+		} else {
+			o.queues[peerID] = queue[expired:]
+		}
+	}
+}
+
+// queued returns how many messages wait for all peers
+// This must be called with mutex already locked!
+func (o *Outbox) queued() int {
+	total := 0
+	for _, queue := range o.queues {
+		total += len(queue)
+	}
+	return total
+}
+
+// append adds one entry to the end of the outbox file, if there is one
+// The file is compacted instead once it's mostly messages that are gone
+// This must be called with mutex already locked!
+func (o *Outbox) append(entry outboxEntry) error {
+	if o.path == "" {
+		return nil
+	}
+	if o.logged >= 2*max(o.queued(), o.limits.MaxMessages) {
+		return o.compact()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox entry: %w", err)
+	}
+	if o.file == nil {
+		if err := os.MkdirAll(filepath.Dir(o.path), 0700); err != nil {
+			return fmt.Errorf("failed to create outbox directory: %w", err)
+		}
+		if o.file, err = os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			return fmt.Errorf("failed to open outbox: %w", err)
+		}
+	}
+	if _, err := o.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	o.logged++
+	return nil
+}
+
+// compact atomically rewrites the outbox file with only what is still waiting, if there is one
+// This must be called with mutex already locked!
+func (o *Outbox) compact() error {
+	if o.path == "" {
+		return nil
+	}
+	o.closeFile()
+	if err := os.MkdirAll(filepath.Dir(o.path), 0700); err != nil {
+		return fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	tmpPath := o.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	logged := 0
+	writer := bufio.NewWriter(tmp)
+	for _, queue := range o.queues {
+		for _, entry := range queue {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				continue
+			}
+			writer.Write(append(data, '\n'))
+			logged++
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	if err := os.Rename(tmpPath, o.path); err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	o.logged = logged
+	return nil
+}
+
+// closeFile closes the file Add appends to, the next Add opens it again
+// This must be called with mutex already locked!
+func (o *Outbox) closeFile() {
+	if o.file != nil {
+		o.file.Close()
+		o.file = nil
+	}
+}
+
+// Close closes the outbox file, what is waiting stays in it for next time
+func (o *Outbox) Close() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.closeFile()
+}
+
+// loadOutboxFile reads the entries saved in path, oldest first per peer
+// Lines that don't parse are skipped, a half-written outbox shouldn't lose the rest
+func loadOutboxFile(path string) ([]outboxEntry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox: %w", err)
+	}
+	defer file.Close()
+
+	var entries []outboxEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxFrameSize)
+	for scanner.Scan() {
+		var entry outboxEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Message == nil || entry.PeerID == "" {
+			logger.Error("⚠️ Skipping corrupt outbox entry in %s", path)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	return entries, nil
+}
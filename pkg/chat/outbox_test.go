@@ -0,0 +1,205 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"p2pchat/pkg/identity"
+)
+
+// drained empties an outbox queue and returns the contents of what was in it, in order
+func drained(t *testing.T, o *Outbox, peerID string) []string {
+	var contents []string
+	err := o.drain(peerID, func(msg *Message) error {
+		contents = append(contents, msg.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	return contents
+}
+
+// fileLines counts the lines in a file
+func fileLines(t *testing.T, path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestOutboxLimits(t *testing.T) {
+	o := NewOutbox(OutboxLimits{MaxMessages: 3, MaxAge: time.Hour})
+	for i := 1; i <= 5; i++ {
+		o.Add("bob", NewDirectMessage("alice", "alice", "bob", fmt.Sprint(i), uint64(i)))
+	}
+	if o.Pending("bob") != 3 {
+		t.Fatalf("Expected the queue capped at 3, got %d", o.Pending("bob"))
+	}
+	if got := drained(t, o, "bob"); !slices.Equal(got, []string{"3", "4", "5"}) {
+		t.Errorf("Expected the oldest to be dropped, got %v", got)
+	}
+	if o.Pending("bob") != 0 {
+		t.Errorf("Expected an empty queue after draining, got %d", o.Pending("bob"))
+	}
+
+	// Messages that waited too long are never sent
+	o.Add("carol", NewChatMessage("alice", "alice", "stale", 6))
+	o.Add("carol", NewChatMessage("alice", "alice", "fresh", 7))
+	o.queues["carol"][0].Queued = time.Now().Add(-2 * time.Hour)
+	if got := drained(t, o, "carol"); !slices.Equal(got, []string{"fresh"}) {
+		t.Errorf("Expected only the fresh message, got %v", got)
+	}
+
+	// A failed send keeps the rest for next time
+	o.Add("dave", NewChatMessage("alice", "alice", "a", 8))
+	o.Add("dave", NewChatMessage("alice", "alice", "b", 9))
+	o.drain("dave", func(msg *Message) error {
+		if msg.Content == "b" {
+			return fmt.Errorf("link went down")
+		}
+		return nil
+	})
+	if got := drained(t, o, "dave"); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("Expected only the unsent message to stay, got %v", got)
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p2pchat", "alice", "outbox.jsonl")
+
+	o := NewOutbox(DefaultOutboxLimits)
+	if err := o.SetFile(path); err != nil {
+		t.Fatalf("Failed to open outbox: %v", err)
+	}
+	o.Add("bob", NewDirectMessage("alice", "alice", "bob", "first", 1))
+	o.Add("carol", NewChatMessage("alice", "alice", "hi carol", 2))
+	o.Add("bob", NewDirectMessage("alice", "alice", "bob", "second", 3))
+	if lines := fileLines(t, path); lines != 3 {
+		t.Errorf("Expected one line appended per message, got %d", lines)
+	}
+
+	restored := NewOutbox(DefaultOutboxLimits)
+	if err := restored.SetFile(path); err != nil {
+		t.Fatalf("Failed to reload outbox: %v", err)
+	}
+	if got := drained(t, restored, "bob"); !slices.Equal(got, []string{"first", "second"}) {
+		t.Errorf("Expected bob's messages in order, got %v", got)
+	}
+	if lines := fileLines(t, path); lines != 1 {
+		t.Errorf("Expected the file compacted to carol's message after the drain, got %d lines", lines)
+	}
+
+	// What was sent is gone from the file too
+	again := NewOutbox(DefaultOutboxLimits)
+	if err := again.SetFile(path); err != nil {
+		t.Fatalf("Failed to reload outbox: %v", err)
+	}
+	if again.Pending("bob") != 0 || again.Pending("carol") != 1 {
+		t.Errorf("Expected only carol's message left, got %d for bob and %d for carol", again.Pending("bob"), again.Pending("carol"))
+	}
+}
+
+func TestOutboxKeepsOrderWhenSendQueueIsFull(t *testing.T) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	cm := NewConnectionManager(id, "alice", 0)
+	bob := &PeerConnection{PeerID: "bob", Username: "bob", State: StateConnected, SendChan: make(chan *Message, 1), wake: make(chan struct{}, 1)}
+
+	send := func(content string) error {
+		return cm.enqueue(bob, NewDirectMessage(cm.localPeerID, "alice", "bob", content, 1))
+	}
+	for _, content := range []string{"1", "2", "3"} {
+		if err := send(content); err != nil {
+			t.Fatalf("Expected %s to be queued, got %v", content, err)
+		}
+	}
+
+	// Ephemeral traffic and other people's messages aren't worth holding on to
+	if err := cm.enqueue(bob, NewHeartbeatMessage(cm.localPeerID, "alice", 4)); err == nil {
+		t.Error("Expected a heartbeat to a full queue to be dropped")
+	}
+	if err := cm.enqueue(bob, NewChatMessage("carol", "carol", "relayed", 5)); err == nil {
+		t.Error("Expected a relayed message to a full queue to be dropped")
+	}
+
+	if msg := <-bob.SendChan; msg.Content != "1" {
+		t.Errorf("Expected 1 in the send queue, got %s", msg.Content)
+	}
+	if got := drained(t, cm.outbox, "bob"); !slices.Equal(got, []string{"2", "3"}) {
+		t.Errorf("Expected 2 and 3 to wait in order, got %v", got)
+	}
+
+	// Nobody connected, only what we wrote for them is kept
+	bob.State = StateFailed
+	if err := send("4"); err != nil {
+		t.Errorf("Expected a DM to an offline peer to be held, got %v", err)
+	}
+	if err := cm.enqueue(bob, NewTypingMessage(cm.localPeerID, "alice", "", "bob", 6)); err == nil {
+		t.Error("Expected typing for an offline peer to be dropped")
+	}
+	if cm.PendingMessages("bob") != 1 {
+		t.Errorf("Expected 1 pending message, got %d", cm.PendingMessages("bob"))
+	}
+}
+
+func TestOutboxFlushesOnReconnect(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return alice.connections.isConnected(bob.peerID) })
+
+	// Bob goes away, Alice keeps writing to him
+	bob.connections.Stop()
+	waitFor(t, "disconnect", func() bool { return !alice.connections.isConnected(bob.peerID) })
+	for _, content := range []string{"are you there?", "the page is for you"} {
+		if err := alice.SendDirect(bob.peerID, content); err != nil {
+			t.Fatalf("Expected the DM to be held for bob, got %v", err)
+		}
+	}
+	alice.SendMessage("bob is away")
+
+	var pending int
+	for _, p := range alice.GetConnectedPeers() {
+		if p.PeerID == bob.peerID {
+			pending = p.Pending
+		}
+	}
+	if pending != 3 {
+		t.Errorf("Expected bob in the peer list with 3 pending messages, got %d", pending)
+	}
+	if unsent := alice.GetUnsentCount(); unsent != 0 {
+		t.Errorf("Expected messages for a peer that is away not to count as unsent, got %d", unsent)
+	}
+
+	// Bob is back on the same port with the same keys
+	back, err := NewChatService(bob.identity, "bob", bob.port, "224.0.0.1:9999")
+	if err != nil {
+		t.Fatalf("Failed to recreate bob: %v", err)
+	}
+	if err := back.connections.Start(); err != nil {
+		t.Fatalf("Failed to restart bob: %v", err)
+	}
+	t.Cleanup(func() { back.connections.Stop() })
+	connectServices(t, alice, back)
+
+	// Bob's history sync may fetch them first, either way both arrive and in order
+	waitFor(t, "held messages", func() bool { return len(back.GetDirectHistory(alice.peerID)) == 2 })
+	var direct []string
+	for _, msg := range back.GetDirectHistory(alice.peerID) {
+		direct = append(direct, msg.Content)
+	}
+	if !slices.Equal(direct, []string{"are you there?", "the page is for you"}) {
+		t.Errorf("Expected the held DMs in order, got %v", direct)
+	}
+	waitFor(t, "room message", func() bool { return hasContent(back.GetRoomHistory(DefaultRoom), "bob is away") })
+	waitFor(t, "empty outbox", func() bool { return alice.connections.PendingMessages(bob.peerID) == 0 })
+}
@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/discovery"
+	"p2pchat/pkg/identity"
+	"p2pchat/pkg/logger"
+)
+
+// Peer exchange (PEX): multicast doesn't cross subnets or VPNs, so connected
+// peers also tell each other who else they know. Right after a link comes up,
+// and every discovery.ExchangeInterval after that, we send each neighbour the
+// port we listen on and the peers we are connected to. Receivers merge those
+// into discovery's PeerRegistry as a second source next to beacons, which makes
+// one manually added peer enough to reach the whole mesh.
+//
+// We only vouch for peers we have a live link to, so entries for peers that
+// left can't circle the mesh forever. Exchanged entries are hearsay: they
+// expire unless somebody keeps repeating them, and connecting still requires
+// the peer to prove its ID in the handshake.
+const (
+	// MaxExchangePeers caps how many peers one exchange lists
+	MaxExchangePeers = 100
+)
+
+// PeerExchange is what one peer tells a neighbour about the mesh
+type PeerExchange struct {
+	Port  int             `json:"port"`  // Where the sender accepts connections, the IP is the link's
+	Peers []ExchangedPeer `json:"peers"` // Peers the sender is connected to right now
+}
+
+// ExchangedPeer is one entry of a PeerExchange
+type ExchangedPeer struct {
+	PeerID   string `json:"peer_id"`
+	Username string `json:"username"`
+	Address  string `json:"address"` // ip:port the sender reaches it at
+}
+
+// exchangeLoop shares our connected peers with every neighbour now and then
+func (cs *ChatService) exchangeLoop() {
+	defer cs.wg.Done()
+
+	ticker := time.NewTicker(discovery.ExchangeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cs.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, peerID := range cs.connections.GetConnectedPeers() {
+				cs.sendPeerExchange(peerID)
+			}
+		}
+	}
+}
+
+// sendPeerExchange tells one neighbour where we listen and who else we are connected to
+func (cs *ChatService) sendPeerExchange(peerID string) {
+	exchange := &PeerExchange{Port: cs.port, Peers: cs.exchangeablePeers(peerID)}
+	msg := NewPeerExchangeMessage(cs.peerID, cs.username, exchange, cs.nextSequence())
+	if err := cs.connections.SendToPeer(peerID, msg); err != nil {
+		logger.Error("⚠️ Failed to exchange peers with %s: %v", peerID, err)
+		return
+	}
+	logger.Debug("🤝 Told %s about %d peers", peerID, len(exchange.Peers))
+}
+
+// exchangeablePeers lists the connected peers we know a listening address for, as seen by a neighbour
+func (cs *ChatService) exchangeablePeers(to string) []ExchangedPeer {
+	// Beacons and exchanges give us listening addresses, links only do when we dialed
+	known := make(map[string]*net.TCPAddr)
+	for _, p := range cs.discovery.GetOnlinePeers() {
+		known[p.ID] = p.Address
+	}
+	cs.exchangeMutex.Lock()
+	for peerID, addr := range cs.listenAddrs {
+		if known[peerID] == nil {
+			known[peerID] = addr
+		}
+	}
+	cs.exchangeMutex.Unlock()
+
+	cs.connections.connMutex.RLock()
+	defer cs.connections.connMutex.RUnlock()
+
+	target := cs.connections.connections[to]
+	if target == nil {
+		return nil
+	}
+	// 127.0.0.1 means their own machine to a neighbour elsewhere
+	sameHost := target.Address != nil && target.Address.IP.IsLoopback()
+
+	var peers []ExchangedPeer
+	for peerID, peerConn := range cs.connections.connections {
+		if peerID == to || peerConn.State != StateConnected {
+			continue
+		}
+		addr := known[peerID]
+		if addr == nil && peerConn.Outbound {
+			addr = peerConn.Address
+		}
+		if addr == nil || addr.IP == nil || addr.IP.IsUnspecified() || (addr.IP.IsLoopback() && !sameHost) {
+			continue
+		}
+		if addr.IP.IsLinkLocalUnicast() {
+			continue // fe80:: needs our interface name, which means nothing on the neighbour's machine
+		}
+
+		peers = append(peers, ExchangedPeer{PeerID: peerID, Username: peerConn.Username, Address: addr.String()})
+		if len(peers) == MaxExchangePeers {
+			break
+		}
+	}
+	return peers
+}
+
+// handlePeerExchange merges a neighbour's peers into discovery, new ones get dialed by the join handler
+func (cs *ChatService) handlePeerExchange(msg *Message, fromPeerID string) {
+	if msg.Exchange == nil {
+		return
+	}
+
+	// Remember where they listen, so we can vouch for them even though they dialed us
+	if remote := cs.connections.peerAddress(fromPeerID); remote != nil && msg.Exchange.Port > 0 && msg.Exchange.Port <= 65535 {
+		cs.exchangeMutex.Lock()
+		cs.listenAddrs[fromPeerID] = &net.TCPAddr{IP: remote.IP, Port: msg.Exchange.Port, Zone: remote.Zone}
+		cs.exchangeMutex.Unlock()
+	}
+	cs.discovery.TouchPeer(fromPeerID) // They are talking to us, that's better than hearsay
+
+	entries := msg.Exchange.Peers
+	if len(entries) > MaxExchangePeers {
+		entries = entries[:MaxExchangePeers]
+	}
+
+	var learned []*peer.Peer
+	for _, entry := range entries {
+		if entry.PeerID == cs.peerID || entry.PeerID == fromPeerID {
+			continue
+		}
+		p, err := exchangedPeer(entry)
+		if err != nil {
+			logger.Error("🚫 Ignoring exchanged peer from %s: %v", fromPeerID, err)
+			continue
+		}
+		learned = append(learned, p)
+	}
+	if len(learned) == 0 {
+		return
+	}
+
+	// The join handler dials new peers, keep that off the connection's read loop
+	cs.wg.Add(1)
+	go func() {
+		defer cs.wg.Done()
+		for _, p := range learned {
+			cs.discovery.AddExchangedPeer(p)
+		}
+	}()
+}
+
+// exchangedPeer checks an entry from a neighbour and turns it into a peer
+func exchangedPeer(entry ExchangedPeer) (*peer.Peer, error) {
+	if _, err := hex.DecodeString(entry.PeerID); err != nil || len(entry.PeerID) != identity.PeerIDLength {
+		return nil, fmt.Errorf("invalid peer ID %q", entry.PeerID)
+	}
+
+	// Only IP literals, a neighbour shouldn't make us look up names
+	addrPort, err := netip.ParseAddrPort(entry.Address)
+	if err != nil || addrPort.Port() == 0 || addrPort.Addr().IsUnspecified() || addrPort.Addr().Zone() != "" {
+		return nil, fmt.Errorf("invalid address %q for %s", entry.Address, entry.PeerID)
+	}
+
+	username := strings.Join(strings.Fields(entry.Username), " ")
+	if username == "" || len([]rune(username)) > 64 {
+		return nil, fmt.Errorf("invalid username for %s", entry.PeerID)
+	}
+
+	return &peer.Peer{
+		ID:       entry.PeerID,
+		Username: username,
+		Address:  net.TCPAddrFromAddrPort(addrPort),
+		Source:   peer.SourceExchange,
+	}, nil
+}
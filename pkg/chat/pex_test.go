@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/identity"
+)
+
+// meshThroughMiddle links a-b and b-c, and waits until b knows where both listen
+func meshThroughMiddle(t *testing.T) (a, b, c *ChatService) {
+	a, b, c = newTestService(t, "alice"), newTestService(t, "bob"), newTestService(t, "carol")
+	connectServices(t, a, b)
+	connectServices(t, b, c)
+
+	// Both neighbours tell bob their port as soon as the link is up
+	waitFor(t, "listening ports", func() bool {
+		b.exchangeMutex.Lock()
+		defer b.exchangeMutex.Unlock()
+		return b.listenAddrs[a.peerID] != nil && b.listenAddrs[c.peerID] != nil
+	})
+	return a, b, c
+}
+
+func TestPeerExchangeConnectsAcrossTheMesh(t *testing.T) {
+	alice, bob, carol := meshThroughMiddle(t)
+
+	// Don't wait for the ticker
+	bob.sendPeerExchange(alice.peerID)
+	bob.sendPeerExchange(carol.peerID)
+
+	// Alice and Carol never saw each other's beacons, Bob introduced them
+	// Carol may dial Alice before Bob's word reaches her, so wait for both
+	waitFor(t, "alice-carol link via pex", func() bool {
+		for _, info := range alice.GetConnectedPeers() {
+			if info.PeerID == carol.peerID {
+				return info.Connected && info.Discovered && info.Source == string(peer.SourceExchange)
+			}
+		}
+		return false
+	})
+
+	if err := alice.SendMessage("hi carol"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitFor(t, "message", func() bool { return carol.GetMessageCount() == 1 })
+}
+
+func TestPeerExchangeSkipsUnreachableAddresses(t *testing.T) {
+	alice, bob, carol := meshThroughMiddle(t)
+
+	peers := bob.exchangeablePeers(alice.peerID)
+	if len(peers) != 1 || peers[0].PeerID != carol.peerID || peers[0].Username != "carol" {
+		t.Fatalf("Expected bob to vouch for carol only, got %+v", peers)
+	}
+
+	// To a neighbour on another machine, 127.0.0.1 would point at itself
+	bob.connections.connMutex.Lock()
+	bob.connections.connections[alice.peerID].Address = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: alice.port}
+	bob.connections.connMutex.Unlock()
+	if peers := bob.exchangeablePeers(alice.peerID); len(peers) != 0 {
+		t.Errorf("Loopback addresses should not be sent off the machine, got %+v", peers)
+	}
+
+	if peers := bob.exchangeablePeers("not-connected"); peers != nil {
+		t.Errorf("Expected nothing for an unknown neighbour, got %+v", peers)
+	}
+}
+
+func TestExchangedPeerValidation(t *testing.T) {
+	validID := strings.Repeat("ab", identity.PeerIDLength/2)
+
+	p, err := exchangedPeer(ExchangedPeer{PeerID: validID, Username: "  bob \t smith ", Address: "10.0.0.5:8080"})
+	if err != nil {
+		t.Fatalf("Expected a valid entry, got %v", err)
+	}
+	if p.Username != "bob smith" || p.Address.String() != "10.0.0.5:8080" || p.Source != peer.SourceExchange {
+		t.Errorf("Unexpected peer %+v", p)
+	}
+
+	for _, entry := range []ExchangedPeer{
+		{PeerID: "short", Username: "bob", Address: "10.0.0.5:8080"},
+		{PeerID: strings.Repeat("z", len(validID)), Username: "bob", Address: "10.0.0.5:8080"},
+		{PeerID: validID, Username: "bob", Address: "chat.example.com:8080"},
+		{PeerID: validID, Username: "bob", Address: "10.0.0.5:0"},
+		{PeerID: validID, Username: "bob", Address: "0.0.0.0:8080"},
+		{PeerID: validID, Username: "bob", Address: "10.0.0.5"},
+		{PeerID: validID, Username: "bob", Address: "[fe80::1%eth0]:8080"}, // Our neighbour's eth0 isn't ours
+		{PeerID: validID, Username: " \t ", Address: "10.0.0.5:8080"},
+		{PeerID: validID, Username: strings.Repeat("b", 65), Address: "10.0.0.5:8080"},
+	} {
+		if _, err := exchangedPeer(entry); err == nil {
+			t.Errorf("Expected %+v to be rejected", entry)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"p2pchat/pkg/logger"
+)
+
+// Plugins: small in-process automations. A plugin gets a PluginHost when it
+// is registered and uses it to hook into messages and peer events, to add
+// slash commands, and to talk - either as us, or labelled as a bot (the Bot
+// field, which is signed like the rest of the message). Hooks of a plugin run
+// one after another on a goroutine of its own, so a slow plugin doesn't hold
+// up the network and a panicking one doesn't take the chat down with it.
+// Anything a plugin does in the background goes through PluginHost.Go, so
+// stopping the chat service can wait for it.
+//
+// Bots see every message, including those of other bots. Ignore messages
+// with Bot set unless you want two echo bots to talk forever.
+const (
+	// pluginQueueSize is how many events may wait for a busy plugin before we drop some
+	pluginQueueSize = 100
+
+	// MaxBotName limits the label bot messages carry
+	MaxBotName = 20
+)
+
+// Plugin is an in-process extension of the chat service
+type Plugin interface {
+	// Name identifies the plugin, it is also the label of its bot messages
+	Name() string
+
+	// Init registers hooks and commands, it runs once when the plugin is registered
+	Init(host *PluginHost) error
+}
+
+// CommandFunc runs a slash command, the returned text is shown to the local user only
+type CommandFunc func(cmd Command) (string, error)
+
+// Command is one invocation of a plugin's slash command
+type Command struct {
+	Name           string   // Without the slash
+	Args           []string // Whitespace separated arguments
+	Text           string   // Everything after the command name
+	ConversationID string   // Room or DM the user typed it in
+}
+
+// CommandInfo describes a slash command for /help
+type CommandInfo struct {
+	Name   string
+	Usage  string
+	Help   string
+	Plugin string
+}
+
+// pluginCommand is a registered slash command
+type pluginCommand struct {
+	info CommandInfo
+	run  CommandFunc
+}
+
+// pluginRegistry holds the registered plugins and their commands
+type pluginRegistry struct {
+	hosts    []*PluginHost
+	commands map[string]*pluginCommand
+	tasks    sync.WaitGroup // Goroutines plugins started with Go
+	mutex    sync.RWMutex
+}
+
+// newPluginRegistry creates an empty plugin registry
+func newPluginRegistry() *pluginRegistry {
+	return &pluginRegistry{commands: make(map[string]*pluginCommand)}
+}
+
+// PluginHost is a plugin's handle on the chat service
+type PluginHost struct {
+	cs     *ChatService
+	name   string
+	events chan func()
+
+	// Hooks, only added during Init
+	onMessage   []func(*Message)
+	onSend      []func(*Message)
+	onPeerJoin  []func(PeerInfo)
+	onPeerLeave []func(PeerInfo)
+}
+
+// RegisterPlugin initializes a plugin and starts delivering events to it
+func (cs *ChatService) RegisterPlugin(plugin Plugin) error {
+	name := plugin.Name()
+	if name == "" || len(name) > MaxBotName || strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("invalid plugin name %q", name)
+	}
+
+	cs.plugins.mutex.Lock()
+	for _, existing := range cs.plugins.hosts {
+		if existing.name == name {
+			cs.plugins.mutex.Unlock()
+			return fmt.Errorf("plugin %s is already registered", name)
+		}
+	}
+	cs.plugins.mutex.Unlock()
+
+	host := &PluginHost{cs: cs, name: name, events: make(chan func(), pluginQueueSize)}
+	if err := plugin.Init(host); err != nil {
+		cs.plugins.removeCommands(name)
+		return fmt.Errorf("failed to initialize plugin %s: %w", name, err)
+	}
+
+	cs.plugins.mutex.Lock()
+	cs.plugins.hosts = append(cs.plugins.hosts, host)
+	cs.plugins.mutex.Unlock()
+
+	cs.wg.Add(1)
+	go host.run()
+
+	logger.Debug("🧩 Plugin %s registered", name)
+	return nil
+}
+
+// GetCommands returns the slash commands plugins added, sorted by name
+func (cs *ChatService) GetCommands() []CommandInfo {
+	cs.plugins.mutex.RLock()
+	defer cs.plugins.mutex.RUnlock()
+
+	commands := make([]CommandInfo, 0, len(cs.plugins.commands))
+	for _, command := range cs.plugins.commands {
+		commands = append(commands, command.info)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// RunCommand runs a plugin's slash command typed in a room or DM
+// Returns false if no plugin registered the command
+func (cs *ChatService) RunCommand(conversationID, line string) (bool, string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false, "", nil
+	}
+	name := strings.ToLower(strings.TrimPrefix(fields[0], "/"))
+
+	cs.plugins.mutex.RLock()
+	command := cs.plugins.commands[name]
+	cs.plugins.mutex.RUnlock()
+	if command == nil {
+		return false, "", nil
+	}
+
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+	output, err := command.call(Command{
+		Name:           name,
+		Args:           fields[1:],
+		Text:           text,
+		ConversationID: conversationID,
+	})
+	return true, output, err
+}
+
+// call runs a command, turning a panic into an error
+func (c *pluginCommand) call(cmd Command) (output string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("💥 Plugin %s panicked in /%s: %v", c.info.Plugin, cmd.Name, r)
+			err = fmt.Errorf("/%s failed", cmd.Name)
+		}
+	}()
+	return c.run(cmd)
+}
+
+// removeCommands forgets the commands of a plugin that failed to initialize
+func (r *pluginRegistry) removeCommands(pluginName string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for name, command := range r.commands {
+		if command.info.Plugin == pluginName {
+			delete(r.commands, name)
+		}
+	}
+}
+
+// messageReceived runs the message hooks for a message a peer sent
+func (r *pluginRegistry) messageReceived(msg *Message) {
+	r.each(func(host *PluginHost) {
+		if len(host.onMessage) > 0 {
+			host.enqueue(func() {
+				for _, hook := range host.onMessage {
+					hook(msg)
+				}
+			})
+		}
+	})
+}
+
+// messageSent runs the send hooks for a message we authored
+func (r *pluginRegistry) messageSent(msg *Message) {
+	r.each(func(host *PluginHost) {
+		if len(host.onSend) > 0 {
+			host.enqueue(func() {
+				for _, hook := range host.onSend {
+					hook(msg)
+				}
+			})
+		}
+	})
+}
+
+// peerJoined runs the peer join hooks
+func (r *pluginRegistry) peerJoined(info PeerInfo) {
+	r.each(func(host *PluginHost) {
+		if len(host.onPeerJoin) > 0 {
+			host.enqueue(func() {
+				for _, hook := range host.onPeerJoin {
+					hook(info)
+				}
+			})
+		}
+	})
+}
+
+// peerLeft runs the peer leave hooks
+func (r *pluginRegistry) peerLeft(info PeerInfo) {
+	r.each(func(host *PluginHost) {
+		if len(host.onPeerLeave) > 0 {
+			host.enqueue(func() {
+				for _, hook := range host.onPeerLeave {
+					hook(info)
+				}
+			})
+		}
+	})
+}
+
+// each calls fn for every registered plugin
+func (r *pluginRegistry) each(fn func(host *PluginHost)) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, host := range r.hosts {
+		fn(host)
+	}
+}
+
+// wait blocks until every goroutine plugins started with Go has returned
+// Cancel the chat service's context first, or it may wait for a long time
+func (r *pluginRegistry) wait() {
+	// Nothing can start after this, Go checks the context under the mutex
+	r.mutex.Lock()
+	r.mutex.Unlock()
+	r.tasks.Wait()
+}
+
+// enqueue hands an event to the plugin's goroutine without blocking the caller
+func (h *PluginHost) enqueue(event func()) {
+	select {
+	case h.events <- event:
+	default:
+		logger.Error("⚠️ Plugin %s is too slow, dropping an event", h.name)
+	}
+}
+
+// run delivers events to the plugin until the chat service stops
+func (h *PluginHost) run() {
+	defer h.cs.wg.Done()
+
+	for {
+		select {
+		case <-h.cs.ctx.Done():
+			return
+		case event := <-h.events:
+			h.safely(event)
+		}
+	}
+}
+
+// safely runs one event, a panicking plugin loses the event but keeps running
+func (h *PluginHost) safely(event func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("💥 Plugin %s panicked: %v", h.name, r)
+		}
+	}()
+	event()
+}
+
+// Name returns the plugin's name, which labels its bot messages
+func (h *PluginHost) Name() string {
+	return h.name
+}
+
+// PeerID returns our own peer ID, to tell our messages from everyone else's
+func (h *PluginHost) PeerID() string {
+	return h.cs.peerID
+}
+
+// Username returns our current username
+func (h *PluginHost) Username() string {
+	return h.cs.GetUsername()
+}
+
+// Context is cancelled when the chat service stops, use it for timers and goroutines
+func (h *PluginHost) Context() context.Context {
+	return h.cs.ctx
+}
+
+// Go runs fn on a goroutine the chat service waits for when it stops
+// fn must return once ctx is cancelled. Nothing runs if the service already stopped
+func (h *PluginHost) Go(fn func(ctx context.Context)) {
+	h.cs.plugins.mutex.RLock()
+	defer h.cs.plugins.mutex.RUnlock()
+
+	if h.cs.ctx.Err() != nil {
+		return
+	}
+	h.cs.plugins.tasks.Add(1)
+	go func() {
+		defer h.cs.plugins.tasks.Done()
+		h.safely(func() { fn(h.cs.ctx) })
+	}()
+}
+
+// OnMessage calls fn for every new message a peer sends us: chat, DMs, annotations, joins
+func (h *PluginHost) OnMessage(fn func(msg *Message)) {
+	h.onMessage = append(h.onMessage, fn)
+}
+
+// OnSend calls fn for every message we send, including those of bots
+func (h *PluginHost) OnSend(fn func(msg *Message)) {
+	h.onSend = append(h.onSend, fn)
+}
+
+// OnPeerJoin calls fn when discovery finds a peer
+func (h *PluginHost) OnPeerJoin(fn func(p PeerInfo)) {
+	h.onPeerJoin = append(h.onPeerJoin, fn)
+}
+
+// OnPeerLeave calls fn when a peer leaves
+func (h *PluginHost) OnPeerLeave(fn func(p PeerInfo)) {
+	h.onPeerLeave = append(h.onPeerLeave, fn)
+}
+
+// RegisterCommand adds a slash command, built-in commands of the UI take precedence
+func (h *PluginHost) RegisterCommand(name, usage, help string, run CommandFunc) error {
+	name = strings.ToLower(strings.TrimPrefix(name, "/"))
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("invalid command name %q", name)
+	}
+
+	h.cs.plugins.mutex.Lock()
+	defer h.cs.plugins.mutex.Unlock()
+
+	if existing, ok := h.cs.plugins.commands[name]; ok {
+		return fmt.Errorf("/%s is already registered by %s", name, existing.info.Plugin)
+	}
+	h.cs.plugins.commands[name] = &pluginCommand{
+		info: CommandInfo{Name: name, Usage: usage, Help: help, Plugin: h.name},
+		run:  run,
+	}
+	return nil
+}
+
+// Say posts to a room or DM as this plugin's bot
+func (h *PluginHost) Say(conversationID, text string) error {
+	return h.cs.sendTo(conversationID, text, "", h.name)
+}
+
+// Reply answers a message in its room or DM as this plugin's bot
+func (h *PluginHost) Reply(to *Message, text string) error {
+	return h.cs.sendTo(to.ConversationID(), text, to.ID, h.name)
+}
+
+// SendAsUser posts to a room or DM as if we had typed it
+func (h *PluginHost) SendAsUser(conversationID, text string) error {
+	return h.cs.sendTo(conversationID, text, "", "")
+}
+
+// Notify shows text to the local user only, in a room or DM, nothing is sent
+func (h *PluginHost) Notify(conversationID, text string) {
+	h.cs.forwardToUI(NewNoticeMessage(h.cs.peerID, h.name, conversationID, text))
+}
@@ -0,0 +1,220 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPlugin is a plugin made of whatever Init the test needs
+type testPlugin struct {
+	name string
+	init func(host *PluginHost) error
+}
+
+func (p *testPlugin) Name() string                { return p.name }
+func (p *testPlugin) Init(host *PluginHost) error { return p.init(host) }
+
+// messageLog collects what a hook saw, hooks run on the plugin's goroutine
+type messageLog struct {
+	messages []*Message
+	mutex    sync.Mutex
+}
+
+func (l *messageLog) add(msg *Message) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+func (l *messageLog) snapshot() []*Message {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]*Message(nil), l.messages...)
+}
+
+func TestPluginBotMessagesReachPeers(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	// Alice's bot answers /ping, Bob's plugin watches what arrives
+	err := alice.RegisterPlugin(&testPlugin{name: "pinger", init: func(host *PluginHost) error {
+		return host.RegisterCommand("ping", "/ping", "Say pong", func(cmd Command) (string, error) {
+			return "sent", host.Say(cmd.ConversationID, "pong "+cmd.Text)
+		})
+	}})
+	if err != nil {
+		t.Fatalf("Failed to register plugin: %v", err)
+	}
+
+	var received, sent messageLog
+	err = bob.RegisterPlugin(&testPlugin{name: "watcher", init: func(host *PluginHost) error {
+		host.OnMessage(received.add)
+		host.OnSend(sent.add)
+		return nil
+	}})
+	if err != nil {
+		t.Fatalf("Failed to register plugin: %v", err)
+	}
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	handled, output, err := alice.RunCommand(DefaultRoom, "/PING  from alice")
+	if !handled || err != nil || output != "sent" {
+		t.Fatalf("Expected /ping to run, got %v %q %v", handled, output, err)
+	}
+	waitFor(t, "bot message", func() bool { return len(received.snapshot()) == 1 })
+
+	msg := received.snapshot()[0]
+	if msg.Bot != "pinger" || msg.Username != "alice" || msg.Content != "pong from alice" {
+		t.Errorf("Expected pinger's pong on alice's behalf, got bot %q user %q %q", msg.Bot, msg.Username, msg.Content)
+	}
+
+	if err := bob.SendMessage("nice"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitFor(t, "send hook", func() bool { return len(sent.snapshot()) == 1 })
+	if sent.snapshot()[0].Bot != "" {
+		t.Error("Messages we type ourselves should not carry a bot label")
+	}
+}
+
+func TestPluginCommands(t *testing.T) {
+	cs := newTestService(t, "alice")
+
+	if handled, _, _ := cs.RunCommand(DefaultRoom, "/nothing"); handled {
+		t.Error("Commands nobody registered should not be handled")
+	}
+
+	err := cs.RegisterPlugin(&testPlugin{name: "broken", init: func(host *PluginHost) error {
+		host.RegisterCommand("half", "/half", "Never finished", nil)
+		return errors.New("missing config")
+	}})
+	if err == nil {
+		t.Fatal("Init errors should fail the registration")
+	}
+	if len(cs.GetCommands()) != 0 {
+		t.Error("A plugin that failed to start should leave no commands behind")
+	}
+
+	err = cs.RegisterPlugin(&testPlugin{name: "crashy", init: func(host *PluginHost) error {
+		if err := host.RegisterCommand("/crash", "/crash", "Panic", func(Command) (string, error) {
+			panic("boom")
+		}); err != nil {
+			return err
+		}
+		if err := host.RegisterCommand("crash", "/crash", "Again", nil); err == nil {
+			t.Error("Registering a command twice should fail")
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatalf("Failed to register plugin: %v", err)
+	}
+
+	if handled, _, err := cs.RunCommand(DefaultRoom, "/crash"); !handled || err == nil || !strings.Contains(err.Error(), "/crash failed") {
+		t.Errorf("A panicking command should turn into an error, got %v %v", handled, err)
+	}
+
+	if commands := cs.GetCommands(); len(commands) != 1 || commands[0].Name != "crash" || commands[0].Plugin != "crashy" {
+		t.Errorf("Expected crashy's /crash, got %+v", commands)
+	}
+
+	if err := cs.RegisterPlugin(&testPlugin{name: "crashy", init: func(*PluginHost) error { return nil }}); err == nil {
+		t.Error("Plugin names should be unique")
+	}
+	if err := cs.RegisterPlugin(&testPlugin{name: "two words", init: func(*PluginHost) error { return nil }}); err == nil {
+		t.Error("Plugin names should be single words")
+	}
+}
+
+func TestPluginSurvivesPanickingHook(t *testing.T) {
+	cs := newTestService(t, "alice")
+
+	var seen messageLog
+	err := cs.RegisterPlugin(&testPlugin{name: "fragile", init: func(host *PluginHost) error {
+		host.OnSend(func(msg *Message) {
+			if msg.Content == "boom" {
+				panic("can't handle this")
+			}
+			seen.add(msg)
+		})
+		return nil
+	}})
+	if err != nil {
+		t.Fatalf("Failed to register plugin: %v", err)
+	}
+
+	cs.SendMessage("boom")
+	cs.SendMessage("still there?")
+	waitFor(t, "second message", func() bool { return len(seen.snapshot()) == 1 })
+
+	if msg := seen.snapshot()[0]; msg.Content != "still there?" {
+		t.Errorf("Expected the hook to keep running, got %q", msg.Content)
+	}
+}
+
+func TestPluginGoroutinesStopWithTheService(t *testing.T) {
+	alice := newTestService(t, "alice")
+
+	var host *PluginHost
+	err := alice.RegisterPlugin(&testPlugin{name: "timer", init: func(h *PluginHost) error {
+		host = h
+		return nil
+	}})
+	if err != nil {
+		t.Fatalf("Failed to register plugin: %v", err)
+	}
+
+	started, done := make(chan struct{}), make(chan struct{})
+	host.Go(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond) // Still finishing up when the service is stopping
+		close(done)
+	})
+	<-started
+
+	alice.cancel()
+	alice.plugins.wait()
+	select {
+	case <-done:
+	default:
+		t.Fatal("Expected the wait to last until the plugin's goroutine returned")
+	}
+
+	// Once stopped, nothing new starts
+	ran := false
+	host.Go(func(context.Context) { ran = true })
+	alice.plugins.wait()
+	if ran {
+		t.Error("Expected no goroutine to start after the service stopped")
+	}
+}
+
+func TestNoticeStaysLocal(t *testing.T) {
+	cs := newTestService(t, "alice")
+
+	err := cs.RegisterPlugin(&testPlugin{name: "nag", init: func(host *PluginHost) error {
+		host.Notify(DirectConversationID(cs.peerID, "bob"), "call bob")
+		return nil
+	}})
+	if err != nil {
+		t.Fatalf("Failed to register plugin: %v", err)
+	}
+
+	notice := <-cs.GetMessages()
+	if notice.Type != MessageTypeNotice || notice.Bot != "nag" || notice.Content != "call bob" {
+		t.Fatalf("Expected nag's notice, got %+v", notice)
+	}
+	if notice.ConversationID() != DirectConversationID(cs.peerID, "bob") {
+		t.Errorf("Notice should belong to the DM with bob, got %s", notice.ConversationID())
+	}
+	if cs.GetMessageCount() != 0 {
+		t.Error("Notices should not be stored")
+	}
+}
@@ -0,0 +1,243 @@
+package chat
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/logger"
+)
+
+// Presence and typing: both are ephemeral, nothing here touches MessageHistory.
+// Presence is set with /away, /busy and /back and rides along in discovery
+// announcements and in the heartbeats every connection carries, so peers that
+// can't hear our multicast still see it. Typing notifications go to the room
+// (or DM partner) at most once per TypingInterval and expire on the receiving
+// side after TypingTimeout, so a peer that vanishes mid-sentence doesn't stay
+// "typing" forever.
+const (
+	// HeartbeatInterval is how often connected peers hear our presence
+	HeartbeatInterval = 15 * time.Second
+
+	// TypingInterval throttles typing notifications per conversation
+	TypingInterval = 3 * time.Second
+
+	// TypingTimeout is how long "is typing" shows after the last notification
+	TypingTimeout = 6 * time.Second
+)
+
+// presenceState is what one peer told us about their availability
+type presenceState struct {
+	presence peer.Presence
+	reason   string
+}
+
+// typingEntry is one peer typing in one conversation
+type typingEntry struct {
+	username string
+	until    time.Time
+}
+
+// typingTracker remembers who is typing where and when we last said we were
+type typingTracker struct {
+	typing map[string]map[string]typingEntry // conversation -> peer ID -> entry
+	sent   map[string]time.Time              // conversation -> last notification we sent
+	mutex  sync.Mutex
+}
+
+// newTypingTracker creates an empty typing tracker
+func newTypingTracker() *typingTracker {
+	return &typingTracker{
+		typing: make(map[string]map[string]typingEntry),
+		sent:   make(map[string]time.Time),
+	}
+}
+
+// shouldSend returns true if enough time passed since our last notification to a conversation
+func (t *typingTracker) shouldSend(conversationID string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.sent[conversationID]) < TypingInterval {
+		return false
+	}
+	t.sent[conversationID] = now
+	return true
+}
+
+// resetSent lets the next keystroke notify again (we just sent the message)
+func (t *typingTracker) resetSent(conversationID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.sent, conversationID)
+}
+
+// mark records that a peer is typing in a conversation
+func (t *typingTracker) mark(conversationID, peerID, username string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.typing[conversationID] == nil {
+		t.typing[conversationID] = make(map[string]typingEntry)
+	}
+	t.typing[conversationID][peerID] = typingEntry{username: username, until: time.Now().Add(TypingTimeout)}
+}
+
+// clear forgets a peer typing in a conversation (their message arrived)
+func (t *typingTracker) clear(conversationID, peerID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.typing[conversationID], peerID)
+}
+
+// clearPeer forgets a peer everywhere (they left)
+func (t *typingTracker) clearPeer(peerID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, typers := range t.typing {
+		delete(typers, peerID)
+	}
+}
+
+// active returns the usernames still typing per conversation, sorted, dropping expired entries
+func (t *typingTracker) active() map[string][]string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	result := make(map[string][]string)
+	for conversationID, typers := range t.typing {
+		for peerID, entry := range typers {
+			if now.After(entry.until) {
+				delete(typers, peerID)
+				continue
+			}
+			result[conversationID] = append(result[conversationID], entry.username)
+		}
+		if len(typers) == 0 {
+			delete(t.typing, conversationID)
+		}
+		sort.Strings(result[conversationID])
+	}
+	return result
+}
+
+// NotifyTyping tells the other side of a room or DM that we are typing
+// Calls are throttled, so it is fine to call this on every keystroke
+func (cs *ChatService) NotifyTyping(conversationID string) {
+	if !cs.typing.shouldSend(conversationID) {
+		return
+	}
+
+	if IsDirectConversation(conversationID) {
+		peerID := DirectPeer(conversationID, cs.peerID)
+		msg := NewTypingMessage(cs.peerID, cs.username, "", peerID, cs.nextSequence())
+		cs.connections.SendToPeer(peerID, msg) // Best effort, it's only a hint
+		return
+	}
+	if !cs.rooms.IsJoined(conversationID) {
+		return
+	}
+	msg := NewTypingMessage(cs.peerID, cs.username, conversationID, "", cs.nextSequence())
+	cs.connections.Broadcast(msg) // Room filter keeps it to members
+}
+
+// GetTyping returns who is typing right now, keyed by room or DM conversation ID
+func (cs *ChatService) GetTyping() map[string][]string {
+	return cs.typing.active()
+}
+
+// handleTyping records a typing notification if it's for a conversation we are in
+func (cs *ChatService) handleTyping(msg *Message) bool {
+	if msg.RecipientID != "" {
+		if msg.RecipientID != cs.peerID {
+			return false
+		}
+	} else if !cs.rooms.IsJoined(msg.ConversationID()) {
+		return false
+	}
+
+	cs.typing.mark(msg.ConversationID(), msg.SenderID, msg.Username)
+	return true
+}
+
+// SetPresence marks us away, busy or available and tells every peer right away
+func (cs *ChatService) SetPresence(presence peer.Presence, reason string) error {
+	if !presence.IsValid() {
+		return fmt.Errorf("unknown presence %q", presence)
+	}
+	if len([]rune(reason)) > peer.MaxPresenceReason {
+		return fmt.Errorf("reason too long (max %d characters)", peer.MaxPresenceReason)
+	}
+	presence, reason = peer.CleanPresence(presence, reason)
+
+	cs.presenceMutex.Lock()
+	cs.presence = presenceState{presence: presence, reason: reason}
+	cs.presenceMutex.Unlock()
+
+	cs.discovery.SetPresence(presence, reason)
+	cs.SendHeartbeat()
+
+	logger.Debug("🌙 Presence set to %s %s", presence, reason)
+	return nil
+}
+
+// GetPresence returns our own presence and reason
+func (cs *ChatService) GetPresence() (peer.Presence, string) {
+	cs.presenceMutex.RLock()
+	defer cs.presenceMutex.RUnlock()
+	return cs.presence.presence, cs.presence.reason
+}
+
+// handleHeartbeat records the presence a connected peer sent us
+func (cs *ChatService) handleHeartbeat(msg *Message) {
+	presence, reason := peer.CleanPresence(msg.Presence, msg.Content)
+
+	cs.presenceMutex.Lock()
+	cs.peerPresence[msg.SenderID] = presenceState{presence: presence, reason: reason}
+	cs.presenceMutex.Unlock()
+}
+
+// forgetPeer drops the presence and typing state of a peer that left
+func (cs *ChatService) forgetPeer(peerID string) {
+	cs.presenceMutex.Lock()
+	delete(cs.peerPresence, peerID)
+	cs.presenceMutex.Unlock()
+
+	cs.typing.clearPeer(peerID)
+
+	cs.exchangeMutex.Lock()
+	delete(cs.listenAddrs, peerID)
+	cs.exchangeMutex.Unlock()
+}
+
+// peerPresenceOf returns what a peer last told us over TCP, falling back to their announcement
+func (cs *ChatService) peerPresenceOf(p *peer.Peer) presenceState {
+	cs.presenceMutex.RLock()
+	defer cs.presenceMutex.RUnlock()
+
+	if state, ok := cs.peerPresence[p.ID]; ok {
+		return state // Heartbeats come straight from the peer, prefer them
+	}
+	return presenceState{presence: p.Presence, reason: p.PresenceReason}
+}
+
+// heartbeatLoop keeps connected peers up to date on our presence
+func (cs *ChatService) heartbeatLoop() {
+	defer cs.wg.Done()
+
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cs.ctx.Done():
+			return
+		case <-ticker.C:
+			cs.SendHeartbeat()
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"p2pchat/internal/peer"
+)
+
+func TestTypingIndicatorIsEphemeral(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	alice.NotifyTyping(DefaultRoom)
+	waitFor(t, "typing indicator", func() bool { return len(bob.GetTyping()[DefaultRoom]) == 1 })
+	if name := bob.GetTyping()[DefaultRoom][0]; name != "alice" {
+		t.Errorf("Expected alice to be typing, got %s", name)
+	}
+
+	// Keystrokes right after the first one are throttled
+	if alice.typing.shouldSend(DefaultRoom) {
+		t.Error("A second notification within TypingInterval should be throttled")
+	}
+
+	// The message itself clears the indicator
+	alice.SendMessage("done typing")
+	waitFor(t, "message", func() bool { return bob.GetMessageCount() == 1 })
+	if typing := bob.GetTyping()[DefaultRoom]; len(typing) != 0 {
+		t.Errorf("Indicator should clear when the message arrives, still showing %v", typing)
+	}
+
+	for _, msg := range bob.GetMessageHistory() {
+		if msg.Type == MessageTypeTyping {
+			t.Error("Typing notifications must never be stored")
+		}
+	}
+}
+
+func TestTypingIndicatorExpires(t *testing.T) {
+	tracker := newTypingTracker()
+	tracker.mark(DefaultRoom, "peer1", "alice")
+	tracker.typing[DefaultRoom]["peer1"] = typingEntry{username: "alice", until: time.Now().Add(-time.Second)}
+	tracker.mark(DefaultRoom, "peer2", "bob")
+
+	typing := tracker.active()[DefaultRoom]
+	if len(typing) != 1 || typing[0] != "bob" {
+		t.Errorf("Expected only bob after alice timed out, got %v", typing)
+	}
+}
+
+func TestPresenceTravelsInHeartbeats(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	if err := alice.SetPresence(peer.PresenceAway, "lunch, back at 1"); err != nil {
+		t.Fatalf("SetPresence failed: %v", err)
+	}
+	// Discovery saw alice before she went away, the heartbeat must win
+	announced := &peer.Peer{ID: alice.peerID, Presence: peer.PresenceAvailable}
+	waitFor(t, "away heartbeat", func() bool {
+		return bob.peerPresenceOf(announced).presence == peer.PresenceAway
+	})
+	if reason := bob.peerPresenceOf(announced).reason; reason != "lunch, back at 1" {
+		t.Errorf("Expected the away reason to arrive, got %q", reason)
+	}
+
+	alice.SetPresence(peer.PresenceAvailable, "")
+	waitFor(t, "back heartbeat", func() bool {
+		return bob.peerPresenceOf(announced).presence == peer.PresenceAvailable
+	})
+
+	if err := alice.SetPresence("sleeping", ""); err == nil {
+		t.Error("Unknown presence values should be rejected")
+	}
+}
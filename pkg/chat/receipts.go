@@ -0,0 +1,207 @@
+package chat
+
+import (
+	"sort"
+	"sync"
+
+	"p2pchat/pkg/logger"
+)
+
+// Receipts: every peer that accepts a chat or direct message answers its
+// author with a "delivered" receipt, and a "read" receipt once the message
+// has been on their screen (unless they turned read receipts off). Receipts
+// point at the message through TargetID and go straight to the author when
+// we are connected to them. When we aren't, the message reached us through
+// a relay, so receipts about room messages flood back through relays the same
+// way. Receipts for direct messages stay as private as the DM.
+//
+// Backfilled messages aren't acknowledged, a sync batch would answer with a
+// receipt per message and flood the send queue. They get their read receipt
+// once they are on screen, which counts as delivered too.
+//
+// Receipts are never stored: delivery state lives in memory and only for
+// messages we wrote, so it starts over at "sent" after a restart.
+const (
+	// maxTrackedDeliveries caps how many of our messages remember their receipts
+	maxTrackedDeliveries = 1000
+)
+
+// DeliveryState is how far one of our messages got
+type DeliveryState struct {
+	Delivered []string `json:"delivered,omitempty"` // Usernames of peers that received it, sorted
+	Read      []string `json:"read,omitempty"`      // Usernames of peers that had it on screen, sorted
+}
+
+// IsReceipt returns true for delivery and read receipts
+func (m *Message) IsReceipt() bool {
+	return m.Type == MessageTypeDelivered || m.Type == MessageTypeRead
+}
+
+// NewReceiptMessage acknowledges target to its author, msgType says whether it was delivered or read
+func NewReceiptMessage(msgType MessageType, senderID, username string, target *Message, sequence uint64) *Message {
+	msg := NewDirectMessage(senderID, username, target.SenderID, "", sequence)
+	msg.Type = msgType
+	msg.TargetID = target.ID
+	if !IsDirectConversation(target.ConversationID()) {
+		msg.RoomID = target.ConversationID() // Lets relays carry it, see isRelayable
+	}
+	return msg
+}
+
+// delivery is who acknowledged one of our messages, peer ID -> username
+type delivery struct {
+	delivered map[string]string
+	read      map[string]string
+}
+
+// receiptTracker remembers the receipts for our messages and the read receipts we sent
+type receiptTracker struct {
+	deliveries map[string]*delivery // message ID -> receipts
+	order      []string             // Message IDs oldest first, to forget the oldest
+	readSent   map[string]bool      // Messages of others we already sent a read receipt for
+	sendReads  bool
+	mutex      sync.Mutex
+}
+
+// newReceiptTracker creates an empty tracker that sends read receipts
+func newReceiptTracker() *receiptTracker {
+	return &receiptTracker{
+		deliveries: make(map[string]*delivery),
+		readSent:   make(map[string]bool),
+		sendReads:  true,
+	}
+}
+
+// record notes a receipt from a peer and returns true if it changed anything
+// A read receipt counts as delivered too, it may overtake the delivered one
+func (r *receiptTracker) record(messageID string, msgType MessageType, peerID, username string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	d := r.deliveries[messageID]
+	if d == nil {
+		d = &delivery{delivered: make(map[string]string), read: make(map[string]string)}
+		r.deliveries[messageID] = d
+		r.order = append(r.order, messageID)
+
+		// Forget the oldest once there are too many, nobody scrolls back that far
+		if len(r.order) > maxTrackedDeliveries {
+			delete(r.deliveries, r.order[0])
+			r.order = r.order[1:]
+		}
+	}
+
+	_, wasDelivered := d.delivered[peerID]
+	_, wasRead := d.read[peerID]
+	d.delivered[peerID] = username
+	if msgType == MessageTypeRead {
+		d.read[peerID] = username
+	}
+	return !wasDelivered || (msgType == MessageTypeRead && !wasRead)
+}
+
+// state returns the delivery state of a message, empty if no receipt came yet
+func (r *receiptTracker) state(messageID string) DeliveryState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	d := r.deliveries[messageID]
+	if d == nil {
+		return DeliveryState{}
+	}
+	return DeliveryState{Delivered: sortedNames(d.delivered), Read: sortedNames(d.read)}
+}
+
+// markRead returns true the first time it is called for a message, while read receipts are on
+func (r *receiptTracker) markRead(messageID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.sendReads || r.readSent[messageID] {
+		return false
+	}
+	if len(r.readSent) >= maxTrackedDeliveries {
+		clear(r.readSent) // Start over, a repeated receipt changes nothing
+	}
+	r.readSent[messageID] = true
+	return true
+}
+
+// sortedNames returns the usernames of a peer ID -> username map, sorted
+func sortedNames(peers map[string]string) []string {
+	if len(peers) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(peers))
+	for _, username := range peers {
+		names = append(names, username)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// acknowledge tells the author of a message we just accepted that it arrived
+func (cs *ChatService) acknowledge(msg *Message) {
+	if !isAnnotatable(msg) || msg.SenderID == cs.peerID {
+		return
+	}
+	cs.sendReceipt(NewReceiptMessage(MessageTypeDelivered, cs.peerID, cs.username, msg, cs.nextSequence()))
+}
+
+// sendReceipt sends a receipt to the author, through relays if we have no link to them
+// Without a link and a relay the outbox keeps it until the author is back
+func (cs *ChatService) sendReceipt(receipt *Message) {
+	cs.originate(receipt)
+	if !cs.connections.isConnected(receipt.RecipientID) && isRelayable(receipt) {
+		cs.connections.Broadcast(receipt)
+		return
+	}
+	if err := cs.connections.SendToPeer(receipt.RecipientID, receipt); err != nil {
+		logger.Debug("⏩ No way to send a %s receipt for %s to %s: %v", receipt.Type, receipt.TargetID, receipt.RecipientID, err)
+	}
+}
+
+// handleReceipt records a receipt for one of our messages
+// Returns true if the delivery state changed
+func (cs *ChatService) handleReceipt(msg *Message) bool {
+	if msg.RecipientID != cs.peerID {
+		return false // Someone else's, we only relayed it
+	}
+	target := cs.messageHistory.GetMessage(msg.TargetID)
+	if target == nil || target.SenderID != cs.peerID {
+		logger.Debug("⏩ Ignoring %s receipt from %s for a message that isn't ours: %s", msg.Type, msg.Username, msg.TargetID)
+		return false
+	}
+	if target.RecipientID != "" && target.RecipientID != msg.SenderID {
+		logger.Debug("⏩ Ignoring %s receipt from %s for a direct message to someone else: %s", msg.Type, msg.Username, msg.TargetID)
+		return false
+	}
+	return cs.receipts.record(msg.TargetID, msg.Type, msg.SenderID, msg.Username)
+}
+
+// MarkRead sends read receipts for messages that are on our screen
+// Calling it again for the same messages is cheap, each gets one receipt
+func (cs *ChatService) MarkRead(messageIDs ...string) {
+	for _, messageID := range messageIDs {
+		msg := cs.messageHistory.GetMessage(messageID)
+		if msg == nil || !isAnnotatable(msg) || msg.SenderID == cs.peerID {
+			continue
+		}
+		if !cs.receipts.markRead(messageID) {
+			continue
+		}
+		cs.sendReceipt(NewReceiptMessage(MessageTypeRead, cs.peerID, cs.username, msg, cs.nextSequence()))
+	}
+}
+
+// SetReadReceipts turns read receipts on or off, delivery receipts are always sent
+func (cs *ChatService) SetReadReceipts(enabled bool) {
+	cs.receipts.mutex.Lock()
+	defer cs.receipts.mutex.Unlock()
+	cs.receipts.sendReads = enabled
+}
+
+// GetDeliveryState returns who received and who read one of our messages
+func (cs *ChatService) GetDeliveryState(messageID string) DeliveryState {
+	return cs.receipts.state(messageID)
+}
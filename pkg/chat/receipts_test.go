@@ -0,0 +1,127 @@
+package chat
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDeliveryAndReadReceipts(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	alice.SendMessage("prod is down, anyone?")
+	waitFor(t, "message", func() bool { return bob.GetMessageCount() == 1 })
+	id := bob.GetRoomHistory(DefaultRoom)[0].ID
+
+	waitFor(t, "delivery receipt", func() bool {
+		return slices.Equal(alice.GetDeliveryState(id).Delivered, []string{"bob"})
+	})
+	if read := alice.GetDeliveryState(id).Read; len(read) != 0 {
+		t.Errorf("Nobody looked at the message yet, got read by %v", read)
+	}
+
+	// Bob's screen shows it, twice, which is still one reader
+	bob.MarkRead(id)
+	bob.MarkRead(id)
+	waitFor(t, "read receipt", func() bool {
+		return slices.Equal(alice.GetDeliveryState(id).Read, []string{"bob"})
+	})
+
+	// Direct messages are acknowledged the same way
+	alice.SendDirect(bob.peerID, "you're on call")
+	waitFor(t, "direct message", func() bool { return bob.GetMessageCount() == 2 })
+	dm := bob.GetDirectHistory(alice.peerID)[0].ID
+	waitFor(t, "direct delivery receipt", func() bool { return len(alice.GetDeliveryState(dm).Delivered) == 1 })
+
+	// Receipts are about their messages, never stored as messages of their own
+	if alice.GetMessageCount() != 2 || bob.GetMessageCount() != 2 {
+		t.Errorf("Expected 2 messages on each side, got %d and %d", alice.GetMessageCount(), bob.GetMessageCount())
+	}
+	if state := bob.GetDeliveryState(id); len(state.Delivered) != 0 {
+		t.Errorf("Bob should have no delivery state for Alice's message, got %+v", state)
+	}
+}
+
+func TestReadReceiptsCanBeTurnedOff(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+	bob.SetReadReceipts(false)
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	alice.SendMessage("first")
+	waitFor(t, "message", func() bool { return bob.GetMessageCount() == 1 })
+	first := bob.GetRoomHistory(DefaultRoom)[0].ID
+	bob.MarkRead(first)
+
+	// The link keeps order, so once the next delivery receipt is in a read receipt would be too
+	alice.SendMessage("second")
+	waitFor(t, "message", func() bool { return bob.GetMessageCount() == 2 })
+	second := bob.GetRoomHistory(DefaultRoom)[1].ID
+	waitFor(t, "delivery receipt", func() bool { return len(alice.GetDeliveryState(second).Delivered) == 1 })
+
+	if state := alice.GetDeliveryState(first); len(state.Delivered) != 1 || len(state.Read) != 0 {
+		t.Errorf("Expected delivered but not read with read receipts off, got %+v", state)
+	}
+}
+
+func TestReceiptsThroughRelay(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+	carol := newTestService(t, "carol")
+
+	// Carol only hears Alice through Bob, her receipts have to take the same way back
+	connectServices(t, alice, bob)
+	connectServices(t, bob, carol)
+	waitFor(t, "links", func() bool { return len(bob.connections.GetConnectedPeers()) == 2 })
+
+	alice.SendMessage("page: disk full on db1")
+	waitFor(t, "relay to carol", func() bool { return carol.GetMessageCount() == 1 })
+	id := carol.GetRoomHistory(DefaultRoom)[0].ID
+
+	carol.MarkRead(id)
+	waitFor(t, "receipts from both", func() bool {
+		state := alice.GetDeliveryState(id)
+		return slices.Equal(state.Delivered, []string{"bob", "carol"}) && slices.Equal(state.Read, []string{"carol"})
+	})
+}
+
+func TestReceiptsOnlyCountForOurMessages(t *testing.T) {
+	alice := newTestService(t, "alice")
+
+	ours := NewDirectMessage(alice.peerID, "alice", "bob-id", "psst", 1)
+	theirs := NewChatMessage("carol-id", "carol", "hello", 1)
+	alice.messageHistory.AddMessage(ours)
+	alice.messageHistory.AddMessage(theirs)
+
+	for _, tc := range []struct {
+		receipt *Message
+		counted bool
+	}{
+		{NewReceiptMessage(MessageTypeDelivered, "bob-id", "bob", ours, 1), true},
+		{NewReceiptMessage(MessageTypeRead, "bob-id", "bob", ours, 2), true},
+		{NewReceiptMessage(MessageTypeRead, "bob-id", "bob", ours, 3), false},        // Nothing new
+		{NewReceiptMessage(MessageTypeRead, "mallory-id", "bob", ours, 1), false},    // Wasn't sent the DM
+		{NewReceiptMessage(MessageTypeDelivered, "bob-id", "bob", theirs, 4), false}, // Addressed to Carol
+	} {
+		if got := alice.handleReceipt(tc.receipt); got != tc.counted {
+			t.Errorf("%s from %s for %s: expected %v, got %v", tc.receipt.Type, tc.receipt.SenderID, tc.receipt.TargetID, tc.counted, got)
+		}
+	}
+
+	// Pretend Carol's message was addressed to us anyway, it still isn't ours to count
+	forged := NewReceiptMessage(MessageTypeDelivered, "bob-id", "bob", theirs, 5)
+	forged.RecipientID = alice.peerID
+	if alice.handleReceipt(forged) {
+		t.Error("Receipts for someone else's message should be ignored")
+	}
+
+	state := alice.GetDeliveryState(ours.ID)
+	if !slices.Equal(state.Delivered, []string{"bob"}) || !slices.Equal(state.Read, []string{"bob"}) {
+		t.Errorf("Expected delivered to and read by bob, got %+v", state)
+	}
+}
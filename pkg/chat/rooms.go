@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultRoom is the room every peer is in and cannot leave
+const DefaultRoom = "general"
+
+// MaxRoomNameLength limits room names so they fit in the sidebar
+const MaxRoomNameLength = 32
+
+// RoomRegistry tracks which rooms we are in and which rooms each peer is in
+// Broadcast uses this to decide who should receive a room's messages
+type RoomRegistry struct {
+	joined  map[string]bool            // Rooms the local user has joined
+	members map[string]map[string]bool // roomID -> set of remote peer IDs
+	mutex   sync.RWMutex               // Protects both maps
+}
+
+// NewRoomRegistry creates a registry with the local user in the default room
+func NewRoomRegistry() *RoomRegistry {
+	return &RoomRegistry{
+		joined:  map[string]bool{DefaultRoom: true},
+		members: make(map[string]map[string]bool),
+	}
+}
+
+// NormalizeRoomName turns user input like "#Standup" into a room ID like "standup"
+func NormalizeRoomName(name string) (string, error) {
+	room := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+	if room == "" {
+		return "", fmt.Errorf("room name cannot be empty")
+	}
+	if len(room) > MaxRoomNameLength {
+		return "", fmt.Errorf("room name too long (max %d characters)", MaxRoomNameLength)
+	}
+	for _, r := range room {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return "", fmt.Errorf("room name may only contain letters, digits, '-' and '_'")
+		}
+	}
+	return room, nil
+}
+
+// Join adds the local user to a room, returns false if already a member
+func (r *RoomRegistry) Join(roomID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.joined[roomID] {
+		return false
+	}
+	r.joined[roomID] = true
+	return true
+}
+
+// Part removes the local user from a room, returns false if not a member
+func (r *RoomRegistry) Part(roomID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.joined[roomID] {
+		return false
+	}
+	delete(r.joined, roomID)
+	return true
+}
+
+// IsJoined returns true if the local user is in the room
+func (r *RoomRegistry) IsJoined(roomID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.joined[roomID]
+}
+
+// JoinedRooms returns the local user's rooms, default room first then alphabetical
+func (r *RoomRegistry) JoinedRooms() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	rooms := make([]string, 0, len(r.joined))
+	for roomID := range r.joined {
+		if roomID != DefaultRoom {
+			rooms = append(rooms, roomID)
+		}
+	}
+	sort.Strings(rooms)
+	return append([]string{DefaultRoom}, rooms...)
+}
+
+// AddMember records that a remote peer is in a room, returns false if already known
+func (r *RoomRegistry) AddMember(roomID, peerID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.members[roomID][peerID] {
+		return false
+	}
+	if r.members[roomID] == nil {
+		r.members[roomID] = make(map[string]bool)
+	}
+	r.members[roomID][peerID] = true
+	return true
+}
+
+// RemoveMember records that a remote peer left a room, returns false if they weren't in it
+func (r *RoomRegistry) RemoveMember(roomID, peerID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.members[roomID][peerID] {
+		return false
+	}
+	delete(r.members[roomID], peerID)
+	if len(r.members[roomID]) == 0 {
+		delete(r.members, roomID)
+	}
+	return true
+}
+
+// RemovePeer forgets every room membership of a peer (they left the chat)
+func (r *RoomRegistry) RemovePeer(peerID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for roomID, peers := range r.members {
+		delete(peers, peerID)
+		if len(peers) == 0 {
+			delete(r.members, roomID)
+		}
+	}
+}
+
+// IsMember returns true if a remote peer should receive messages for a room
+// Every peer is implicitly in the default room
+func (r *RoomRegistry) IsMember(roomID, peerID string) bool {
+	if roomID == "" || roomID == DefaultRoom {
+		return true
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.members[roomID][peerID]
+}
+
+// Members returns the remote peer IDs known to be in a room
+func (r *RoomRegistry) Members(roomID string) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	peers := make([]string, 0, len(r.members[roomID]))
+	for peerID := range r.members[roomID] {
+		peers = append(peers, peerID)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// Direct conversations share the history partitioning with rooms, keyed by both peer IDs
+
+// directPrefix marks a conversation ID as a DM rather than a room
+const directPrefix = "dm:"
+
+// DirectConversationID returns the same key for a DM conversation on both sides
+func DirectConversationID(peerA, peerB string) string {
+	if peerB < peerA {
+		peerA, peerB = peerB, peerA
+	}
+	return directPrefix + peerA + ":" + peerB
+}
+
+// IsDirectConversation returns true if a conversation ID belongs to a DM
+func IsDirectConversation(conversationID string) bool {
+	return strings.HasPrefix(conversationID, directPrefix)
+}
+
+// DirectPeer returns the other participant of a DM conversation
+func DirectPeer(conversationID, localPeerID string) string {
+	peers := strings.SplitN(strings.TrimPrefix(conversationID, directPrefix), ":", 2)
+	if len(peers) != 2 {
+		return ""
+	}
+	if peers[0] == localPeerID {
+		return peers[1]
+	}
+	return peers[0]
+}
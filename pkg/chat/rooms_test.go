@@ -0,0 +1,129 @@
+package chat
+
+import "testing"
+
+func TestNormalizeRoomName(t *testing.T) {
+	valid := map[string]string{
+		"#Standup": "standup",
+		"incident": "incident",
+		" #ops-1 ": "ops-1",
+		"#on_call": "on_call",
+		"#GENERAL": "general",
+	}
+	for input, expected := range valid {
+		room, err := NormalizeRoomName(input)
+		if err != nil {
+			t.Errorf("NormalizeRoomName(%q) returned error: %v", input, err)
+			continue
+		}
+		if room != expected {
+			t.Errorf("NormalizeRoomName(%q) = %q, expected %q", input, room, expected)
+		}
+	}
+
+	invalid := []string{"", "#", "#has space", "#emoji🔥", "#this-room-name-is-way-too-long-to-fit"}
+	for _, input := range invalid {
+		if _, err := NormalizeRoomName(input); err == nil {
+			t.Errorf("NormalizeRoomName(%q) should return an error", input)
+		}
+	}
+}
+
+func TestRoomRegistryMembership(t *testing.T) {
+	rooms := NewRoomRegistry()
+
+	// Everyone is in the default room
+	if !rooms.IsJoined(DefaultRoom) {
+		t.Error("Local user should start in the default room")
+	}
+	if !rooms.IsMember(DefaultRoom, "peer1") {
+		t.Error("Every peer should be a member of the default room")
+	}
+
+	// Remote membership is tracked per room
+	if rooms.IsMember("standup", "peer1") {
+		t.Error("peer1 should not be in #standup before announcing it")
+	}
+	if !rooms.AddMember("standup", "peer1") {
+		t.Error("First AddMember should report a change")
+	}
+	if rooms.AddMember("standup", "peer1") {
+		t.Error("Repeated AddMember should not report a change")
+	}
+	if !rooms.IsMember("standup", "peer1") {
+		t.Error("peer1 should be in #standup after joining")
+	}
+
+	// Leaving the chat removes the peer from every room
+	rooms.AddMember("incident", "peer1")
+	rooms.RemovePeer("peer1")
+	if rooms.IsMember("standup", "peer1") || rooms.IsMember("incident", "peer1") {
+		t.Error("RemovePeer should drop all of peer1's rooms")
+	}
+
+	// Joined rooms list keeps the default room first
+	rooms.Join("zeta")
+	rooms.Join("alpha")
+	joined := rooms.JoinedRooms()
+	expected := []string{DefaultRoom, "alpha", "zeta"}
+	if len(joined) != len(expected) {
+		t.Fatalf("Expected rooms %v, got %v", expected, joined)
+	}
+	for i := range expected {
+		if joined[i] != expected[i] {
+			t.Errorf("Expected rooms %v, got %v", expected, joined)
+			break
+		}
+	}
+}
+
+func TestMessageHistoryRoomPartitions(t *testing.T) {
+	history := NewMessageHistory(2)
+
+	history.AddMessage(NewRoomMessage("peer1", "alice", "standup", "yesterday I...", 1))
+	history.AddMessage(NewRoomMessage("peer1", "alice", "incident", "db is down", 2))
+	history.AddMessage(NewChatMessage("peer1", "alice", "hi all", 3))
+
+	if got := len(history.GetRoomMessages("standup")); got != 1 {
+		t.Errorf("Expected 1 message in #standup, got %d", got)
+	}
+	if got := len(history.GetRoomMessages(DefaultRoom)); got != 1 {
+		t.Errorf("Expected 1 message in #general, got %d", got)
+	}
+	if got := history.GetMessageCount(); got != 3 {
+		t.Errorf("Expected 3 messages across rooms, got %d", got)
+	}
+
+	// The limit applies per room, a busy room doesn't evict the others
+	history.AddMessage(NewRoomMessage("peer1", "alice", "incident", "still down", 4))
+	history.AddMessage(NewRoomMessage("peer1", "alice", "incident", "back up", 5))
+	if got := len(history.GetRoomMessages("incident")); got != 2 {
+		t.Errorf("Expected #incident capped at 2 messages, got %d", got)
+	}
+	if got := len(history.GetRoomMessages("standup")); got != 1 {
+		t.Errorf("Expected #standup untouched by cleanup, got %d", got)
+	}
+}
+
+func TestDirectConversationID(t *testing.T) {
+	// Both participants must file the DM under the same key
+	fromAlice := NewDirectMessage("alice_1", "alice", "bob_2", "hi bob", 1)
+	fromBob := NewDirectMessage("bob_2", "bob", "alice_1", "hi alice", 1)
+
+	if fromAlice.ConversationID() != fromBob.ConversationID() {
+		t.Errorf("Conversation IDs differ: %s vs %s", fromAlice.ConversationID(), fromBob.ConversationID())
+	}
+	if !IsDirectConversation(fromAlice.ConversationID()) {
+		t.Error("Direct message should map to a direct conversation")
+	}
+	if IsDirectConversation(NewChatMessage("alice_1", "alice", "hi", 2).ConversationID()) {
+		t.Error("Room message should not map to a direct conversation")
+	}
+
+	if peer := DirectPeer(fromAlice.ConversationID(), "alice_1"); peer != "bob_2" {
+		t.Errorf("Expected alice's DM peer to be bob_2, got %s", peer)
+	}
+	if peer := DirectPeer(fromAlice.ConversationID(), "bob_2"); peer != "alice_1" {
+		t.Errorf("Expected bob's DM peer to be alice_1, got %s", peer)
+	}
+}
@@ -0,0 +1,159 @@
+package chat
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/logger"
+)
+
+// Static peers are for networks that drop multicast (corporate Wi-Fi, most VPNs)
+// They come from -peer, the config file or /connect, and are dialed by address
+// instead of waiting for discovery. ConnectionManager keeps redialing them with
+// the usual backoff, and /connect remembers them in the peer file for next time
+
+// AddStaticPeer registers a host:port to dial when the service starts
+func (cs *ChatService) AddStaticPeer(address string) error {
+	if _, err := peer.NewStaticPeer(address); err != nil {
+		return err
+	}
+
+	cs.staticMutex.Lock()
+	defer cs.staticMutex.Unlock()
+	for _, known := range cs.staticPeers {
+		if known == address {
+			return nil
+		}
+	}
+	cs.staticPeers = append(cs.staticPeers, address)
+	return nil
+}
+
+// SetPeerFile remembers /connect addresses in path and dials the ones it already holds
+// Call this before Start, like SetMessageStore
+func (cs *ChatService) SetPeerFile(path string) error {
+	addresses, err := loadPeerFile(path)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if err := cs.AddStaticPeer(address); err != nil {
+			logger.Error("⚠️ Skipping %s from %s: %v", address, path, err)
+		}
+	}
+
+	cs.staticMutex.Lock()
+	cs.peerFile = path
+	cs.staticMutex.Unlock()
+	return nil
+}
+
+// ConnectTo dials a peer by address and keeps redialing it from now on
+// The address is remembered even if the first attempt fails, the peer may just not be up yet
+func (cs *ChatService) ConnectTo(address string) error {
+	p, err := peer.NewStaticPeer(address)
+	if err != nil {
+		return err
+	}
+	if err := cs.AddStaticPeer(address); err != nil {
+		return err
+	}
+	if err := cs.rememberPeer(address); err != nil {
+		logger.Error("⚠️ Failed to remember %s: %v", address, err)
+	}
+
+	if err := cs.connections.ConnectToPeer(p); err != nil {
+		return fmt.Errorf("%w (will keep trying)", err)
+	}
+	return nil
+}
+
+// GetStaticPeers returns the addresses we dial without waiting for discovery
+func (cs *ChatService) GetStaticPeers() []string {
+	cs.staticMutex.Lock()
+	defer cs.staticMutex.Unlock()
+	return append([]string(nil), cs.staticPeers...)
+}
+
+// dialStaticPeers connects to every static peer in the background, Start calls it
+func (cs *ChatService) dialStaticPeers() {
+	for _, address := range cs.GetStaticPeers() {
+		p, err := peer.NewStaticPeer(address)
+		if err != nil {
+			continue // AddStaticPeer already checked it
+		}
+
+		cs.wg.Add(1)
+		go func() {
+			defer cs.wg.Done()
+			if err := cs.connections.ConnectToPeer(p); err != nil {
+				logger.Error("❌ Failed to connect to static peer %s: %v", address, err)
+			}
+		}()
+	}
+}
+
+// rememberPeer appends an address to the peer file if it isn't there yet
+func (cs *ChatService) rememberPeer(address string) error {
+	cs.staticMutex.Lock()
+	defer cs.staticMutex.Unlock()
+
+	if cs.peerFile == "" {
+		return nil // Nowhere to remember it, like -no-history
+	}
+	addresses, err := loadPeerFile(cs.peerFile)
+	if err != nil {
+		return err
+	}
+	for _, known := range addresses {
+		if known == address {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cs.peerFile), 0700); err != nil {
+		return fmt.Errorf("failed to create peer file directory: %w", err)
+	}
+	file, err := os.OpenFile(cs.peerFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open peer file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintln(file, address); err != nil {
+		return fmt.Errorf("failed to write peer file: %w", err)
+	}
+	return nil
+}
+
+// loadPeerFile reads one host:port per line, blank lines and # comments are skipped
+// A missing file is just an empty list
+func loadPeerFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open peer file: %w", err)
+	}
+	defer file.Close()
+
+	var addresses []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addresses = append(addresses, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read peer file: %w", err)
+	}
+	return addresses, nil
+}
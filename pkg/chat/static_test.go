@@ -0,0 +1,178 @@
+package chat
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderByID returns the services with the smaller peer ID first
+func orderByID(a, b *ChatService) (*ChatService, *ChatService) {
+	if a.peerID > b.peerID {
+		return b, a
+	}
+	return a, b
+}
+
+// sessionTo returns our connection entry for a peer, or nil
+func sessionTo(cs *ChatService, peerID string) *PeerConnection {
+	cs.connections.connMutex.RLock()
+	defer cs.connections.connMutex.RUnlock()
+	return cs.connections.connections[peerID]
+}
+
+func TestConnectToStaticPeer(t *testing.T) {
+	// The larger ID dials, which discovery would never let it do
+	low, high := orderByID(newTestService(t, "alice"), newTestService(t, "bob"))
+	peerFile := filepath.Join(t.TempDir(), "peers")
+	if err := high.SetPeerFile(peerFile); err != nil {
+		t.Fatalf("SetPeerFile failed: %v", err)
+	}
+
+	address := fmt.Sprintf("127.0.0.1:%d", low.port)
+	if err := high.ConnectTo(address); err != nil {
+		t.Fatalf("ConnectTo failed: %v", err)
+	}
+	waitFor(t, "link", func() bool { return len(low.connections.GetConnectedPeers()) == 1 })
+
+	link := sessionTo(high, low.peerID)
+	if link == nil || link.Static != address || link.Username != low.username || !link.Outbound {
+		t.Fatalf("Expected the placeholder to become %s's entry, got %+v", low.username, link)
+	}
+	if len(high.connections.connections) != 1 {
+		t.Error("The placeholder should be gone once the peer identified itself")
+	}
+
+	// Discovery never saw either of them, they still show up
+	peers := high.GetConnectedPeers()
+	if len(peers) != 1 || peers[0].PeerID != low.peerID || !peers[0].Connected || peers[0].Discovered {
+		t.Errorf("Expected an undiscovered, connected %s, got %+v", low.username, peers)
+	}
+	if peers := low.GetConnectedPeers(); len(peers) != 1 || peers[0].Username != high.username {
+		t.Errorf("The dialed side should list the dialer too, got %+v", peers)
+	}
+
+	// Connecting again doesn't add a second entry or line
+	if err := high.ConnectTo(address); err != nil {
+		t.Errorf("Connecting to a connected peer should be a no-op, got %v", err)
+	}
+	data, _ := os.ReadFile(peerFile)
+	if string(data) != address+"\n" {
+		t.Errorf("Expected the peer file to hold %s once, got %q", address, data)
+	}
+
+	// Next run dials it again
+	restarted := newTestService(t, "bob")
+	if err := restarted.SetPeerFile(peerFile); err != nil {
+		t.Fatalf("SetPeerFile failed: %v", err)
+	}
+	if static := restarted.GetStaticPeers(); len(static) != 1 || static[0] != address {
+		t.Errorf("Expected %s from the peer file, got %v", address, static)
+	}
+}
+
+func TestStaticPeerIsRedialed(t *testing.T) {
+	low, high := orderByID(newTestService(t, "alice"), newTestService(t, "bob"))
+
+	if err := high.ConnectTo(fmt.Sprintf("127.0.0.1:%d", low.port)); err != nil {
+		t.Fatalf("ConnectTo failed: %v", err)
+	}
+	waitFor(t, "link", func() bool { return len(low.connections.GetConnectedPeers()) == 1 })
+
+	link := sessionTo(high, low.peerID)
+	link.Conn.Close()
+	waitFor(t, "disconnect", func() bool { return len(high.connections.GetConnectedPeers()) == 0 })
+
+	// Skip the backoff, the retry loop would get there on its own
+	high.connections.connMutex.Lock()
+	link.LastAttempt = time.Now().Add(-time.Minute)
+	high.connections.connMutex.Unlock()
+	high.connections.retryFailedConnections()
+
+	waitFor(t, "redial", func() bool { return len(high.connections.GetConnectedPeers()) == 1 })
+}
+
+func TestStaticPeersDialingEachOther(t *testing.T) {
+	low, high := orderByID(newTestService(t, "alice"), newTestService(t, "bob"))
+
+	// Both were told about the other, so both dial at once
+	var wg sync.WaitGroup
+	for _, pair := range [][2]*ChatService{{low, high}, {high, low}} {
+		wg.Add(1)
+		go func(from, to *ChatService) {
+			defer wg.Done()
+			from.ConnectTo(fmt.Sprintf("127.0.0.1:%d", to.port))
+		}(pair[0], pair[1])
+	}
+	wg.Wait()
+
+	// Whatever order things happened in, both end up on the session the smaller ID dialed
+	waitFor(t, "one session", func() bool {
+		lowLink, highLink := sessionTo(low, high.peerID), sessionTo(high, low.peerID)
+		return lowLink != nil && highLink != nil &&
+			lowLink.State == StateConnected && highLink.State == StateConnected &&
+			lowLink.Outbound && !highLink.Outbound
+	})
+
+	if err := low.SendMessage("only once"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitFor(t, "message", func() bool { return high.GetMessageCount() == 1 })
+}
+
+func TestStaticPeerAddresses(t *testing.T) {
+	cs := newTestService(t, "alice")
+
+	for _, address := range []string{"", "localhost", "localhost:", ":8080", "host:http", "host:70000"} {
+		if err := cs.AddStaticPeer(address); err == nil {
+			t.Errorf("Expected %q to be rejected", address)
+		}
+	}
+
+	cs.AddStaticPeer("10.0.0.5:8080")
+	cs.AddStaticPeer("chat.example.com:8080")
+	cs.AddStaticPeer("[fe80::1%eth0]:8080")
+	cs.AddStaticPeer("10.0.0.5:8080")
+	if static := cs.GetStaticPeers(); len(static) != 3 {
+		t.Errorf("Expected duplicates to be dropped, got %v", static)
+	}
+
+	peerFile := filepath.Join(t.TempDir(), "peers")
+	os.WriteFile(peerFile, []byte("# office\n10.0.0.6:8080\n\nnot an address\n"), 0600)
+	if err := cs.SetPeerFile(peerFile); err != nil {
+		t.Fatalf("SetPeerFile failed: %v", err)
+	}
+	if static := strings.Join(cs.GetStaticPeers(), " "); static != "10.0.0.5:8080 chat.example.com:8080 [fe80::1%eth0]:8080 10.0.0.6:8080" {
+		t.Errorf("Expected the file's valid address to be added, got %s", static)
+	}
+}
+
+func TestConnectOverIPv6(t *testing.T) {
+	if conn, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("No IPv6 loopback here: %v", err)
+	} else {
+		conn.Close()
+	}
+	low, high := orderByID(newTestService(t, "alice"), newTestService(t, "bob"))
+
+	// One port takes both families, IPv6 gets no listener of its own to forget
+	address := fmt.Sprintf("[::1]:%d", low.port)
+	if err := high.ConnectTo(address); err != nil {
+		t.Fatalf("ConnectTo failed: %v", err)
+	}
+	waitFor(t, "link", func() bool { return len(low.connections.GetConnectedPeers()) == 1 })
+
+	if addr := low.connections.peerAddress(high.peerID); addr == nil || addr.IP.To4() != nil {
+		t.Errorf("Expected bob's address to stay IPv6, got %v", addr)
+	}
+
+	if err := high.SendMessage("over v6"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitFor(t, "message", func() bool { return low.GetMessageCount() == 1 })
+}
@@ -0,0 +1,284 @@
+package chat
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"p2pchat/pkg/logger"
+)
+
+// MessageStore persists message history so it survives restarts
+// MessageHistory appends every message it accepts and loads the store once at startup
+type MessageStore interface {
+	Append(msg *Message) error // Persist one message
+	Load() ([]*Message, error) // Read back everything kept by the retention policy
+	Clear() error              // Forget all stored messages
+	Close() error              // Flush and release the store
+}
+
+// RetentionPolicy limits how much history a store keeps
+type RetentionPolicy struct {
+	MaxAge      time.Duration // Drop messages older than this (0 = keep forever)
+	MaxMessages int           // Keep at most this many messages in total (0 = unlimited)
+}
+
+// DefaultRetention keeps a month of history, capped at 10000 messages
+var DefaultRetention = RetentionPolicy{
+	MaxAge:      30 * 24 * time.Hour,
+	MaxMessages: 10000,
+}
+
+// compactSlack is how far past its retention limits the log may grow before Append compacts it
+// In tenths, so with the default retention that's 1000 messages or 3 days
+const compactSlack = 1
+
+// FileStore is an append-only log of JSON messages, one per line
+// Retention is applied on Load, which rewrites the file when anything was dropped,
+// and again on Append once the log is compactSlack past either limit
+type FileStore struct {
+	path      string
+	retention RetentionPolicy
+	readOnly  bool      // Another instance owns the log, never write or compact it
+	file      *os.File  // Open for appending, nil if read-only
+	lines     int       // Entries in the log
+	oldest    time.Time // Timestamp of the oldest entry, zero if there are none
+	mutex     sync.Mutex
+}
+
+// NewFileStore opens (or creates) the history log at path
+func NewFileStore(path string, retention RetentionPolicy) (*FileStore, error) {
+	// History is private, only the owner may read it
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+
+	return &FileStore{
+		path:      path,
+		retention: retention,
+		file:      file,
+	}, nil
+}
+
+// NewReadOnlyFileStore reads the history log at path without ever changing it
+// For commands that run next to the instance that owns the log, a missing file is empty history
+func NewReadOnlyFileStore(path string, retention RetentionPolicy) *FileStore {
+	return &FileStore{
+		path:      path,
+		retention: retention,
+		readOnly:  true,
+	}
+}
+
+// Append writes a message to the end of the log, read-only stores keep it in memory only
+func (s *FileStore) Append(msg *Message) error {
+	if s.readOnly {
+		return nil
+	}
+
+	data, err := msg.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("history store is closed")
+	}
+	if s.retention.MaxAge > 0 && time.Since(msg.Timestamp) > s.retention.MaxAge {
+		return nil // Synced from long ago, the next compaction would only drop it again
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to append to history: %w", err)
+	}
+	s.lines++
+	if s.oldest.IsZero() || msg.Timestamp.Before(s.oldest) {
+		s.oldest = msg.Timestamp
+	}
+
+	// A daemon may run for months without another Load
+	if s.overRetention() {
+		if _, err := s.load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overRetention returns true once the log is compactSlack past the message or age limit
+// This must be called with mutex already locked!
+func (s *FileStore) overRetention() bool {
+	r := s.retention
+	if r.MaxMessages > 0 && s.lines > r.MaxMessages+r.MaxMessages*compactSlack/10 {
+		return true
+	}
+	return r.MaxAge > 0 && !s.oldest.IsZero() && time.Since(s.oldest) > r.MaxAge+r.MaxAge*compactSlack/10
+}
+
+// Load reads the log, applies the retention policy and compacts the file if needed
+func (s *FileStore) Load() ([]*Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.load()
+}
+
+// load reads the log, applies the retention policy and compacts the file if needed
+// This must be called with mutex already locked!
+func (s *FileStore) load() ([]*Message, error) {
+	file, err := os.Open(s.path)
+	if s.readOnly && errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer file.Close()
+
+	var messages []*Message
+	total := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		total++
+
+		msg, err := FromJSON(scanner.Bytes())
+		if err != nil {
+			// A crash mid-write can leave a partial last line, skip it
+			logger.Error("⚠️ Skipping unreadable history entry %d: %v", total, err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	messages = s.retention.apply(messages)
+
+	// Deleted messages keep their place in the log but not their text
+	redacted := redactDeleted(messages)
+
+	// Rewrite the log without expired, corrupt or deleted entries
+	// A read-only store leaves that to the owner, whose last line may still be half-written
+	if !s.readOnly && (len(messages) < total || redacted > 0) {
+		if err := s.rewrite(messages); err != nil {
+			return nil, err
+		}
+		logger.Debug("🧹 Compacted history: kept %d of %d entries", len(messages), total)
+	} else {
+		s.lines = total
+		s.oldest = time.Time{}
+		if len(messages) > 0 {
+			s.oldest = messages[0].Timestamp // Sorted by retention
+		}
+	}
+
+	return messages, nil
+}
+
+// Clear truncates the log
+func (s *FileStore) Clear() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.readOnly {
+		return fmt.Errorf("history store is read-only")
+	}
+	return s.rewrite(nil)
+}
+
+// Close releases the log file
+func (s *FileStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// rewrite atomically replaces the log with messages and reopens it for appending
+// This must be called with mutex already locked!
+func (s *FileStore) rewrite(messages []*Message) error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, msg := range messages {
+		data, err := msg.ToJSON()
+		if err != nil {
+			continue
+		}
+		writer.Write(append(data, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+	s.lines = len(messages)
+	s.oldest = time.Time{}
+	for _, msg := range messages {
+		if s.oldest.IsZero() || msg.Timestamp.Before(s.oldest) {
+			s.oldest = msg.Timestamp
+		}
+	}
+
+	// The old append handle points at the replaced file
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen history file: %w", err)
+	}
+	return nil
+}
+
+// apply drops messages outside the retention limits, keeping the newest
+func (r RetentionPolicy) apply(messages []*Message) []*Message {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+
+	if r.MaxAge > 0 {
+		cutoff := time.Now().Add(-r.MaxAge)
+		first := sort.Search(len(messages), func(i int) bool {
+			return !messages[i].Timestamp.Before(cutoff)
+		})
+		messages = messages[first:]
+	}
+
+	if r.MaxMessages > 0 && len(messages) > r.MaxMessages {
+		messages = messages[len(messages)-r.MaxMessages:]
+	}
+
+	return messages
+}
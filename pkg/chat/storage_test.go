@@ -0,0 +1,203 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistorySurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p2pchat", "alice", "history.jsonl")
+
+	store, err := NewFileStore(path, DefaultRetention)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	history := NewMessageHistory(100)
+	if err := history.SetStore(store); err != nil {
+		t.Fatalf("Failed to load empty store: %v", err)
+	}
+
+	history.AddMessage(NewChatMessage("peer1", "alice", "yesterday's discussion", 1))
+	history.AddMessage(NewRoomMessage("peer2", "bob", "standup", "in #standup", 1))
+	history.AddMessage(NewDirectMessage("peer1", "alice", "peer2", "psst", 2))
+	history.AddMessage(NewHeartbeatMessage("peer1", "alice", 3)) // Not kept, not persisted
+	history.Close()
+
+	// Simulate a restart with a fresh history and store
+	store, err = NewFileStore(path, DefaultRetention)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	restored := NewMessageHistory(100)
+	if err := restored.SetStore(store); err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	defer restored.Close()
+
+	if restored.GetMessageCount() != 3 {
+		t.Fatalf("Expected 3 messages after restart, got %d", restored.GetMessageCount())
+	}
+	general := restored.GetRoomMessages(DefaultRoom)
+	if len(general) != 1 || general[0].Content != "yesterday's discussion" {
+		t.Errorf("General room was not restored: %v", general)
+	}
+	if len(restored.GetRoomMessages(DirectConversationID("peer1", "peer2"))) != 1 {
+		t.Error("Direct message was not restored")
+	}
+
+	// Reloaded messages must still be caught as duplicates (e.g. when a peer resends them)
+	if restored.AddMessage(general[0]) {
+		t.Error("Reloaded message should be detected as a duplicate")
+	}
+}
+
+func TestRetentionDropsOldMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	store, err := NewFileStore(path, RetentionPolicy{MaxAge: 24 * time.Hour, MaxMessages: 2})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	old := NewChatMessage("peer1", "alice", "last week", 1)
+	old.Timestamp = time.Now().Add(-7 * 24 * time.Hour)
+	store.Append(old)
+	for i, content := range []string{"one", "two", "three"} {
+		msg := NewChatMessage("peer1", "alice", content, uint64(i+2))
+		msg.Timestamp = time.Now().Add(time.Duration(i) * time.Second)
+		store.Append(msg)
+	}
+
+	messages, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Content != "two" || messages[1].Content != "three" {
+		t.Fatalf("Expected the newest 2 messages, got %d", len(messages))
+	}
+
+	// The file itself should have been compacted
+	messages, err = store.Load()
+	if err != nil || len(messages) != 2 {
+		t.Errorf("Compacted log should reload the same 2 messages, got %d (%v)", len(messages), err)
+	}
+}
+
+func TestCorruptEntrySkipped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	store, err := NewFileStore(path, RetentionPolicy{})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	store.Append(NewChatMessage("peer1", "alice", "before the crash", 1))
+
+	// A crash mid-write leaves a truncated line at the end
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString(`{"id":"abc","type":"chat","con`)
+	file.Close()
+
+	messages, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load should tolerate a truncated entry: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Errorf("Expected 1 readable message, got %d", len(messages))
+	}
+
+	// New messages must land on their own line after the damaged one was dropped
+	store.Append(NewChatMessage("peer1", "alice", "after the crash", 2))
+	messages, _ = store.Load()
+	if len(messages) != 2 {
+		t.Errorf("Expected 2 messages after appending, got %d", len(messages))
+	}
+}
+
+func TestReadOnlyStoreLeavesTheLogAlone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	// The running instance owns the log and is halfway through writing a line
+	owner, err := NewFileStore(path, RetentionPolicy{MaxMessages: 1})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer owner.Close()
+	owner.Append(NewChatMessage("peer1", "alice", "one", 1))
+	owner.Append(NewChatMessage("peer1", "alice", "two", 2))
+	owner.file.WriteString(`{"id":"abc","type":"chat","con`)
+	before, _ := os.ReadFile(path)
+
+	reader := NewReadOnlyFileStore(path, RetentionPolicy{MaxMessages: 1})
+	messages, err := reader.Load()
+	if err != nil || len(messages) != 1 || messages[0].Content != "two" {
+		t.Fatalf("Expected retention applied in memory, got %d messages (%v)", len(messages), err)
+	}
+	reader.Append(NewChatMessage("peer1", "alice", "from a command", 3))
+	if reader.Clear() == nil {
+		t.Error("Expected a read-only store to refuse to clear the log")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Errorf("Expected the log untouched, got %q", after)
+	}
+
+	// Nothing to read yet is just empty history
+	if messages, err := NewReadOnlyFileStore(path+".missing", DefaultRetention).Load(); err != nil || len(messages) != 0 {
+		t.Errorf("Expected a missing log to be empty history, got %d messages (%v)", len(messages), err)
+	}
+}
+
+func TestRetentionAppliesWhileRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	store, err := NewFileStore(path, RetentionPolicy{MaxAge: time.Hour, MaxMessages: 10})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	if _, err := store.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	lines := func() int {
+		data, _ := os.ReadFile(path)
+		return strings.Count(string(data), "\n")
+	}
+
+	// Nobody calls Load again in a daemon that runs for months
+	for i := 1; i <= 12; i++ {
+		msg := NewChatMessage("peer1", "alice", fmt.Sprint(i), uint64(i))
+		msg.Timestamp = time.Now().Add(time.Duration(i)*time.Millisecond - time.Minute)
+		if err := store.Append(msg); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if got := lines(); got != 10 {
+		t.Errorf("Expected the log compacted to 10 messages, got %d", got)
+	}
+
+	// Old messages are dropped on the next append once the oldest is well past MaxAge
+	store.oldest = time.Now().Add(-2 * time.Hour)
+	store.Append(NewChatMessage("peer1", "alice", "new", 13))
+	if got := lines(); got != 10 {
+		t.Errorf("Expected the age check to compact the log, got %d lines", got)
+	}
+	messages, _ := store.Load()
+	if len(messages) != 10 || messages[9].Content != "new" {
+		t.Errorf("Expected the newest 10 messages, got %d", len(messages))
+	}
+
+	// Synced messages already past MaxAge aren't written at all
+	old := NewChatMessage("peer1", "alice", "last week", 14)
+	old.Timestamp = time.Now().Add(-7 * 24 * time.Hour)
+	store.Append(old)
+	if got := lines(); got != 10 {
+		t.Errorf("Expected an expired message to stay out of the log, got %d lines", got)
+	}
+}
@@ -0,0 +1,248 @@
+package chat
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+
+	"p2pchat/pkg/logger"
+)
+
+// History sync: right after a connection is identified both sides send a
+// SyncSummary of the message IDs they already hold. Each side answers with
+// SyncBatch messages containing whatever the other one is missing, and the
+// receiver feeds them through MessageHistory.AddMessage which drops duplicates.
+// Since every peer syncs with every peer it connects to, history converges
+// across the mesh without a server.
+const (
+	// SyncWindow is how far back a peer asks to be backfilled
+	SyncWindow = 7 * 24 * time.Hour
+
+	// MaxSyncMessages caps how many messages one sync request can return (newest win)
+	MaxSyncMessages = 1000
+
+	// SyncBatchSize is how many messages travel in one sync batch at most
+	SyncBatchSize = 50
+
+	// maxSyncBatchBytes caps the encoded messages of one batch, half a frame leaves room for the rest
+	// Long messages with edits and reactions would otherwise overflow MaxFrameSize and be dropped whole
+	maxSyncBatchBytes = MaxFrameSize / 2
+
+	// syncFalsePositiveRate is the Bloom filter's target error rate. A false positive
+	// means a message is skipped in this round, the next sync uses a new salt.
+	syncFalsePositiveRate = 0.01
+)
+
+// syncableTypes are the message types that are backfilled
+// Join/leave and membership notices only make sense live
+var syncableTypes = []MessageType{
+	MessageTypeChat, MessageTypeDirect,
+	MessageTypeReaction, MessageTypeEdit, MessageTypeDelete,
+}
+
+// SyncSummary describes which messages a peer already holds
+type SyncSummary struct {
+	Since  time.Time `json:"since"`  // Only messages newer than this are wanted
+	Filter []byte    `json:"filter"` // Bloom filter of held message IDs
+	Hashes int       `json:"hashes"` // Number of hash functions used by Filter
+	Salt   uint64    `json:"salt"`   // Per-request hash salt so false positives differ each round
+}
+
+// NewSyncSummary builds a summary of the given message IDs
+func NewSyncSummary(since time.Time, messageIDs []string) *SyncSummary {
+	// Standard Bloom filter sizing: m = -n ln p / (ln 2)^2, k = m/n ln 2
+	n := math.Max(float64(len(messageIDs)), 1)
+	bits := math.Ceil(-n * math.Log(syncFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	bits = math.Max(bits, 512)
+	hashes := int(math.Max(math.Round(bits/n*math.Ln2), 1))
+
+	summary := &SyncSummary{
+		Since:  since,
+		Filter: make([]byte, int(bits+7)/8),
+		Hashes: hashes,
+		Salt:   rand.Uint64(),
+	}
+	for _, id := range messageIDs {
+		summary.add(id)
+	}
+	return summary
+}
+
+// Has reports whether the summary (probably) contains a message ID
+func (s *SyncSummary) Has(messageID string) bool {
+	if len(s.Filter) == 0 || s.Hashes <= 0 {
+		return false // Empty summary, the requester has nothing
+	}
+	for _, bit := range s.positions(messageID) {
+		if s.Filter[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add sets the filter bits for a message ID
+func (s *SyncSummary) add(messageID string) {
+	for _, bit := range s.positions(messageID) {
+		s.Filter[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// positions returns the filter bits for a message ID using double hashing
+func (s *SyncSummary) positions(messageID string) []uint64 {
+	// FNV-128 gives two independent 64-bit halves, splitting one 64-bit hash
+	// in two correlates them and pushes the false positive rate well past target
+	hasher := fnv.New128a()
+	var salt [8]byte
+	binary.BigEndian.PutUint64(salt[:], s.Salt)
+	hasher.Write(salt[:])
+	hasher.Write([]byte(messageID))
+	sum := hasher.Sum(nil)
+
+	h1, h2 := binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:])|1
+	size := uint64(len(s.Filter)) * 8
+	hashes := min(s.Hashes, 32) // Don't let a peer make us do unbounded work
+
+	positions := make([]uint64, hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % size
+	}
+	return positions
+}
+
+// isSyncable returns true for message types that are backfilled
+func isSyncable(msg *Message) bool {
+	for _, msgType := range syncableTypes {
+		if msg.Type == msgType {
+			return true
+		}
+	}
+	return false
+}
+
+// requestSync sends a peer the summary of what we hold so it can fill the gaps
+func (cs *ChatService) requestSync(peerID string) {
+	since := time.Now().Add(-SyncWindow)
+
+	// Messages only the store still has count too, or every sync would send them again
+	ids := cs.messageHistory.HeldIDs(since, syncableTypes...)
+
+	request := NewSyncRequestMessage(cs.peerID, cs.username, NewSyncSummary(since, ids), cs.nextSequence())
+	if err := cs.connections.SendToPeer(peerID, request); err != nil {
+		logger.Error("⚠️ Failed to request history sync from %s: %v", peerID, err)
+		return
+	}
+	logger.Debug("🔄 Requested history sync from %s (%d messages held)", peerID, len(ids))
+}
+
+// handleSyncRequest streams the messages a peer is missing and is allowed to see
+func (cs *ChatService) handleSyncRequest(msg *Message, fromPeerID string) {
+	if msg.Sync == nil {
+		return
+	}
+
+	var missing []*Message
+	for _, stored := range cs.messageHistory.GetMessages(syncableTypes...) {
+		if !stored.Timestamp.After(msg.Sync.Since) || msg.Sync.Has(stored.ID) {
+			continue
+		}
+		// Never leak rooms they haven't joined or DMs between other people
+		if stored.RecipientID != "" {
+			if stored.SenderID != fromPeerID && stored.RecipientID != fromPeerID {
+				continue
+			}
+		} else if !cs.rooms.IsMember(stored.ConversationID(), fromPeerID) {
+			continue
+		}
+		// Only signed messages can be checked by the receiver, deleted ones no longer verify
+		if stored.Signature == "" || stored.Deleted {
+			continue
+		}
+		missing = append(missing, stored)
+	}
+
+	// Newest messages matter most
+	if len(missing) > MaxSyncMessages {
+		missing = missing[len(missing)-MaxSyncMessages:]
+	}
+
+	sent := 0
+	for _, messages := range syncBatches(missing) {
+		batch := NewSyncBatchMessage(cs.peerID, cs.username, messages, cs.nextSequence())
+		if err := cs.connections.SendToPeer(fromPeerID, batch); err != nil {
+			logger.Error("⚠️ History sync to %s stopped after %d messages: %v", fromPeerID, sent, err)
+			return
+		}
+		sent += len(messages)
+	}
+
+	if len(missing) > 0 {
+		logger.Debug("📤 Backfilled %d messages to %s", len(missing), fromPeerID)
+	}
+}
+
+// syncBatches splits messages into batches of at most SyncBatchSize messages and maxSyncBatchBytes
+// A message that is bigger than that on its own travels alone
+func syncBatches(messages []*Message) [][]*Message {
+	var batches [][]*Message
+	var batch []*Message
+	size := 0
+	for _, msg := range messages {
+		data, err := msg.ToJSON()
+		if err != nil {
+			continue
+		}
+		if len(batch) > 0 && (len(batch) == SyncBatchSize || size+len(data) > maxSyncBatchBytes) {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, msg)
+		size += len(data)
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// handleSyncBatch adds backfilled messages to history and tells the UI what arrived
+func (cs *ChatService) handleSyncBatch(msg *Message, fromPeerID string) {
+	var added []*Message
+	for _, synced := range msg.Messages {
+		if synced == nil || !isSyncable(synced) {
+			continue
+		}
+		// The batch comes from a peer, but each message must come from its author
+		if err := synced.VerifySignature(); err != nil {
+			logger.Error("🚫 Dropping synced message %s from %s: %v", synced.ID, fromPeerID, err)
+			continue
+		}
+		cs.clock.witness(synced.Clock)
+		if synced.RecipientID != "" {
+			if synced.SenderID != cs.peerID && synced.RecipientID != cs.peerID {
+				continue
+			}
+		} else if !cs.rooms.IsJoined(synced.ConversationID()) {
+			continue
+		}
+
+		if cs.messageHistory.AddMessage(synced) {
+			added = append(added, synced)
+		}
+	}
+
+	if len(added) == 0 {
+		return
+	}
+	logger.Debug("📥 Synced %d missed messages from %s", len(added), fromPeerID)
+
+	// One notification per batch so a big backfill can't flood the UI channel
+	notice := NewSyncBatchMessage(fromPeerID, msg.Username, added, 0)
+	select {
+	case cs.incomingMessages <- notice:
+	default:
+		logger.Error("⚠️ UI message buffer full, history will show synced messages on reload")
+	}
+}
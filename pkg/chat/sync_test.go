@@ -0,0 +1,233 @@
+package chat
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/identity"
+)
+
+func TestSyncSummaryMembership(t *testing.T) {
+	var held []string
+	for i := 0; i < 500; i++ {
+		held = append(held, fmt.Sprintf("held-%d", i))
+	}
+	summary := NewSyncSummary(time.Now(), held)
+
+	for _, id := range held {
+		if !summary.Has(id) {
+			t.Fatalf("Summary lost message %s (Bloom filters have no false negatives)", id)
+		}
+	}
+
+	// Roughly 1% false positives are expected, allow some slack
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if summary.Has(fmt.Sprintf("missing-%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("Too many false positives: %d/1000", falsePositives)
+	}
+
+	if (&SyncSummary{}).Has("anything") {
+		t.Error("An empty summary should not claim to hold anything")
+	}
+}
+
+// newTestService creates a chat service listening on loopback without UDP discovery
+func newTestService(t *testing.T, username string) *ChatService {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	cs, err := NewChatService(id, username, port, "224.0.0.1:9999")
+	if err != nil {
+		t.Fatalf("Failed to create chat service: %v", err)
+	}
+	if err := cs.connections.Start(); err != nil {
+		t.Fatalf("Failed to start connections: %v", err)
+	}
+	t.Cleanup(func() { cs.connections.Stop() })
+	return cs
+}
+
+// connectServices dials from whichever side wins the peer ID ordering
+func connectServices(t *testing.T, a, b *ChatService) {
+	if a.peerID > b.peerID {
+		a, b = b, a
+	}
+	target := &peer.Peer{
+		ID:       b.peerID,
+		Username: b.username,
+		Address:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: b.port},
+	}
+	if err := a.connections.ConnectToPeer(target); err != nil {
+		t.Fatalf("Failed to connect %s to %s: %v", a.username, b.username, err)
+	}
+}
+
+// waitFor polls until condition holds or the test times out
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func TestReconnectBackfillsMissedMessages(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	// Alice talks before Bob is around
+	alice.SendMessage("morning all")
+	alice.SendMessage("standup in 5")
+
+	// Unsigned messages can't be verified by Bob, so they must not be synced
+	alice.messageHistory.AddMessage(NewChatMessage(alice.peerID, "alice", "unsigned", 99))
+
+	connectServices(t, alice, bob)
+	waitFor(t, "initial sync", func() bool { return bob.GetMessageCount() == 2 })
+
+	// Drop the link, Alice keeps talking, then reconnect
+	alice.connections.connMutex.RLock()
+	link := alice.connections.connections[bob.peerID]
+	alice.connections.connMutex.RUnlock()
+	link.Conn.Close()
+	waitFor(t, "disconnect", func() bool {
+		return len(alice.connections.GetConnectedPeers()) == 0 && len(bob.connections.GetConnectedPeers()) == 0
+	})
+
+	alice.SendMessage("you missed this")
+	connectServices(t, alice, bob)
+	waitFor(t, "sync after reconnect", func() bool { return bob.GetMessageCount() == 3 })
+
+	// The reconnected session must carry live traffic too
+	alice.SendMessage("still here?")
+	waitFor(t, "live message after reconnect", func() bool { return bob.GetMessageCount() == 4 })
+
+	for _, msg := range bob.GetRoomHistory(DefaultRoom) {
+		if msg.Content == "unsigned" {
+			t.Error("Unsigned message should not have been synced")
+		}
+	}
+}
+
+func TestSyncBatchesFitInAFrame(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	// Together far more than one frame, a fixed count per batch would lose them all
+	for i := range 20 {
+		alice.SendMessage(fmt.Sprintf("%d %s", i, strings.Repeat("long message ", 8000)))
+	}
+	alice.SendMessage("short one")
+
+	for _, batch := range syncBatches(alice.GetRoomHistory(DefaultRoom)) {
+		data, err := NewSyncBatchMessage(alice.peerID, "alice", batch, 1).ToJSON()
+		if err != nil || len(data) > MaxFrameSize {
+			t.Errorf("Expected every batch to fit in a frame, got %d bytes (%v)", len(data), err)
+		}
+	}
+
+	connectServices(t, alice, bob)
+	waitFor(t, "sync of long messages", func() bool { return bob.GetMessageCount() == 21 })
+}
+
+func TestSyncRespectsRoomsAndDirectMessages(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+	carol := newTestService(t, "carol")
+
+	alice.JoinRoom("secret")
+	alice.SendRoomMessage("secret", "bob isn't in here")
+
+	// A DM Alice exchanged with Carol earlier
+	direct := NewDirectMessage(alice.peerID, "alice", carol.peerID, "just for carol", 3)
+	direct.Sign(alice.identity)
+	alice.messageHistory.AddMessage(direct)
+	alice.SendMessage("hello everyone")
+
+	connectServices(t, alice, bob)
+	waitFor(t, "sync", func() bool { return bob.GetMessageCount() >= 1 })
+	time.Sleep(200 * time.Millisecond) // Give any leaked messages time to arrive
+
+	if bob.GetMessageCount() != 1 {
+		for _, msg := range bob.GetMessageHistory() {
+			t.Logf("Bob got: %s", msg)
+		}
+		t.Errorf("Bob should only get the general message, got %d messages", bob.GetMessageCount())
+	}
+}
+
+// countingStore is a MessageStore in memory that counts appends
+type countingStore struct {
+	messages []*Message
+	appends  int
+}
+
+func (s *countingStore) Append(msg *Message) error {
+	s.messages = append(s.messages, msg)
+	s.appends++
+	return nil
+}
+func (s *countingStore) Load() ([]*Message, error) { return s.messages, nil }
+func (s *countingStore) Clear() error              { s.messages = nil; return nil }
+func (s *countingStore) Close() error              { return nil }
+
+func TestEvictedMessagesAreNotTakenBackAsNew(t *testing.T) {
+	store := &countingStore{}
+	history := NewMessageHistory(3)
+	history.SetStore(store)
+
+	var sent []*Message
+	for i := 0; i < 6; i++ {
+		msg := NewChatMessage("alice-id", "alice", fmt.Sprint(i), uint64(i))
+		msg.Timestamp = time.Now().Add(time.Duration(i-6) * time.Minute)
+		sent = append(sent, msg)
+		history.AddMessage(msg)
+	}
+
+	// A peer backfilling what fell out of memory must not get it stored again
+	for _, msg := range sent[:3] {
+		if history.AddMessage(msg) {
+			t.Errorf("Evicted message %s was accepted again", msg.Content)
+		}
+	}
+	if store.appends != 6 {
+		t.Errorf("Expected 6 appends to the store, got %d", store.appends)
+	}
+
+	// And the summary we ask with says we have them
+	held := history.HeldIDs(time.Now().Add(-time.Hour), syncableTypes...)
+	if len(held) != 6 {
+		t.Errorf("Expected all 6 messages in the sync summary, got %d", len(held))
+	}
+
+	// The same goes for a restart, where the store holds more than fits in memory
+	restored := NewMessageHistory(3)
+	restored.SetStore(store)
+	if restored.AddMessage(sent[0]) {
+		t.Error("A message loaded from the store and evicted was accepted again")
+	}
+	if len(restored.HeldIDs(time.Now().Add(-time.Hour))) != 6 {
+		t.Error("Expected the restored summary to cover the whole store")
+	}
+}
@@ -0,0 +1,90 @@
+package chat
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Threads: a reply is an ordinary chat or direct message with ReplyTo set to
+// the ID of the message it answers. Replies can be answered too, so a thread
+// is the tree under the oldest ancestor we still hold. MessageHistory indexes
+// replies by parent ID, which keeps both lookups cheap.
+
+// maxThreadDepth stops walking up a reply chain that is suspiciously long (or loops)
+const maxThreadDepth = 100
+
+// GetReplies returns the direct replies to a message in causal order
+func (h *MessageHistory) GetReplies(parentID string) []*Message {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	replies := make([]*Message, len(h.replies[parentID]))
+	copy(replies, h.replies[parentID])
+	sort.SliceStable(replies, func(i, j int) bool {
+		return causallyBefore(replies[i], replies[j])
+	})
+	return replies
+}
+
+// GetThread returns every message in the thread a message belongs to, root first
+// Only messages from the same conversation count, a reply can't pull in another room
+func (h *MessageHistory) GetThread(messageID string) []*Message {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	start := h.messageIDs[messageID]
+	if start == nil {
+		return nil
+	}
+	conversationID := start.ConversationID()
+
+	// Walk up to the oldest ancestor we have
+	root := start
+	for depth := 0; root.ReplyTo != "" && depth < maxThreadDepth; depth++ {
+		parent := h.messageIDs[root.ReplyTo]
+		if parent == nil || parent.ConversationID() != conversationID {
+			break
+		}
+		root = parent
+	}
+
+	// Then collect everything below it
+	thread := []*Message{root}
+	seen := map[string]bool{root.ID: true}
+	for i := 0; i < len(thread); i++ {
+		for _, reply := range h.replies[thread[i].ID] {
+			if !seen[reply.ID] && reply.ConversationID() == conversationID {
+				seen[reply.ID] = true
+				thread = append(thread, reply)
+			}
+		}
+	}
+
+	sort.SliceStable(thread[1:], func(i, j int) bool {
+		return causallyBefore(thread[i+1], thread[j+1])
+	})
+	return thread
+}
+
+// SendReply answers a message in whichever room or DM it was sent
+func (cs *ChatService) SendReply(parentID, content string) error {
+	parent := cs.messageHistory.GetMessage(parentID)
+	if parent == nil {
+		return fmt.Errorf("message %s not found", parentID)
+	}
+	if !isAnnotatable(parent) || parent.Deleted {
+		return fmt.Errorf("cannot reply to that message")
+	}
+
+	return cs.sendTo(parent.ConversationID(), content, parentID, "")
+}
+
+// GetReplies returns the direct replies to a message
+func (cs *ChatService) GetReplies(parentID string) []*Message {
+	return cs.messageHistory.GetReplies(parentID)
+}
+
+// GetThread returns the whole thread a message belongs to, root first
+func (cs *ChatService) GetThread(messageID string) []*Message {
+	return cs.messageHistory.GetThread(messageID)
+}
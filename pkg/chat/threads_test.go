@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThreadIndex(t *testing.T) {
+	history := NewMessageHistory(100)
+	base := time.Now()
+
+	root := NewChatMessage("alice-id", "alice", "who broke the build?", 1)
+	root.Timestamp = base
+	answer := NewChatMessage("bob-id", "bob", "me, fixing", 1)
+	answer.ReplyTo, answer.Timestamp = root.ID, base.Add(time.Second)
+	thanks := NewChatMessage("alice-id", "alice", "thanks!", 2)
+	thanks.ReplyTo, thanks.Timestamp = answer.ID, base.Add(2*time.Second)
+	other := NewChatMessage("carol-id", "carol", "unrelated", 1)
+	other.Timestamp = base.Add(3 * time.Second)
+	elsewhere := NewRoomMessage("carol-id", "carol", "random", "replying across rooms", 2)
+	elsewhere.ReplyTo = root.ID
+
+	// Arrival order shouldn't matter
+	for _, msg := range []*Message{thanks, other, root, elsewhere, answer} {
+		history.AddMessage(msg)
+	}
+
+	if replies := history.GetReplies(root.ID); len(replies) != 2 {
+		t.Errorf("Expected 2 indexed replies to the root, got %d", len(replies))
+	}
+
+	// Any message in the thread finds the whole thread
+	for _, start := range []*Message{root, answer, thanks} {
+		thread := history.GetThread(start.ID)
+		if len(thread) != 3 {
+			t.Fatalf("Thread from %q should have 3 messages, got %d", start.Content, len(thread))
+		}
+		if thread[0].ID != root.ID || thread[1].ID != answer.ID || thread[2].ID != thanks.ID {
+			t.Errorf("Thread should be root first then chronological, got %v", thread)
+		}
+	}
+
+	if thread := history.GetThread(other.ID); len(thread) != 1 {
+		t.Errorf("A message without replies is a thread of one, got %d", len(thread))
+	}
+}
+
+func TestReplyReachesPeers(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	alice.SendMessage("deploy at 5?")
+	waitFor(t, "message", func() bool { return bob.GetMessageCount() == 1 })
+	question := bob.GetRoomHistory(DefaultRoom)[0]
+
+	if err := bob.SendReply(question.ID, "make it 6"); err != nil {
+		t.Fatalf("SendReply failed: %v", err)
+	}
+	waitFor(t, "reply", func() bool { return len(alice.GetReplies(question.ID)) == 1 })
+
+	if reply := alice.GetReplies(question.ID)[0]; reply.Content != "make it 6" || reply.RoomID != DefaultRoom {
+		t.Errorf("Reply arrived wrong: %v", reply)
+	}
+	if err := bob.SendReply("no-such-message", "hm"); err == nil {
+		t.Error("Replying to an unknown message should fail")
+	}
+}
@@ -0,0 +1,803 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"p2pchat/pkg/logger"
+)
+
+// File transfer runs over the existing peer connection:
+//
+//	sender                         receiver
+//	file_offer  ----------------->  (user runs /accept)
+//	            <-----------------  file_accept {next}
+//	file_chunk  ----------------->  verify chunk checksum, append to .part file
+//	            <-----------------  file_ack {next}
+//	...                             last chunk: verify file checksum, rename
+//
+// Only verified chunks are written, so the .part file is always a valid prefix.
+// It is named after the file checksum, so when the same file is offered again
+// (after a reconnect or a restart on either side) the receiver accepts from
+// the first chunk it doesn't have yet. Only one transfer writes a .part file
+// at a time, a second offer of the same file can't be accepted while the
+// first is active and takes over its download once that one is interrupted.
+const (
+	// FileChunkSize is how much file data travels in one chunk
+	FileChunkSize = 32 * 1024
+
+	// maxChunkSize bounds the chunk size a peer may ask us to handle
+	maxChunkSize = 48 * 1024
+
+	// fileWindow is how many chunks may be in flight without an ack
+	fileWindow = 16
+
+	// fileStallTimeout is how long a transfer may go without progress before it is
+	// considered interrupted
+	fileStallTimeout = 30 * time.Second
+)
+
+// FileTransfer is the payload of the file_* messages (only the fields a step needs are set)
+type FileTransfer struct {
+	ID        string `json:"id"`
+	Name      string `json:"name,omitempty"`       // Base name of the file (file_offer)
+	Size      int64  `json:"size,omitempty"`       // Total size in bytes (file_offer)
+	Checksum  string `json:"checksum,omitempty"`   // SHA-256 of the whole file (file_offer)
+	ChunkSize int    `json:"chunk_size,omitempty"` // Bytes per chunk (file_offer)
+	Index     int    `json:"index,omitempty"`      // Chunk number (file_chunk)
+	Data      []byte `json:"data,omitempty"`       // Chunk contents (file_chunk)
+	ChunkSum  string `json:"chunk_sum,omitempty"`  // SHA-256 of Data (file_chunk)
+	Next      int    `json:"next,omitempty"`       // First chunk the receiver still needs (file_accept, file_ack)
+	Reason    string `json:"reason,omitempty"`     // Why it was rejected or cancelled
+}
+
+// TransferState tracks where a file transfer is
+type TransferState string
+
+const (
+	TransferOffered     TransferState = "offered"     // Waiting for the receiver to accept
+	TransferActive      TransferState = "active"      // Chunks are flowing
+	TransferInterrupted TransferState = "interrupted" // Stalled or disconnected, resumes when the peer is back
+	TransferCompleted   TransferState = "completed"   // File verified and saved
+	TransferRejected    TransferState = "rejected"    // Receiver said no
+	TransferFailed      TransferState = "failed"      // Cancelled or corrupt
+)
+
+// IsFinished returns true once a transfer can't make any more progress
+func (s TransferState) IsFinished() bool {
+	return s == TransferCompleted || s == TransferRejected || s == TransferFailed
+}
+
+// Transfer is a snapshot of one file transfer for display
+type Transfer struct {
+	ID          string
+	PeerID      string
+	Username    string
+	Name        string
+	Size        int64
+	Transferred int64 // Bytes verified by the receiver
+	Incoming    bool
+	State       TransferState
+	Path        string // Source file when sending, saved file when receiving
+	Error       string
+	Started     time.Time
+}
+
+// Progress returns how far the transfer got, from 0 to 1
+func (t Transfer) Progress() float64 {
+	if t.Size == 0 {
+		if t.State == TransferCompleted {
+			return 1
+		}
+		return 0
+	}
+	return float64(t.Transferred) / float64(t.Size)
+}
+
+// transfer is the live state behind a Transfer
+type transfer struct {
+	Transfer
+	offer  *FileTransfer
+	chunks int
+
+	// Sending side
+	events chan *Message // Accepts, acks, rejects and cancels from the receiver
+
+	// Receiving side
+	part         *os.File  // Verified prefix of the file
+	partPath     string    // Where the prefix lives until it is complete
+	sum          hash.Hash // Running checksum of the prefix
+	next         int       // Next chunk we need
+	lastActivity time.Time
+}
+
+// TransferManager runs file transfers in both directions
+type TransferManager struct {
+	downloadDir string
+	send        func(peerID string, msgType MessageType, file *FileTransfer) error
+
+	transfers map[string]*transfer
+	mutex     sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewTransferManager creates a transfer manager saving files to downloadDir
+// send delivers file_* messages to a connected peer
+func NewTransferManager(downloadDir string, send func(peerID string, msgType MessageType, file *FileTransfer) error) *TransferManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TransferManager{
+		downloadDir: downloadDir,
+		send:        send,
+		transfers:   make(map[string]*transfer),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// SetDownloadDir changes where received files are saved
+func (tm *TransferManager) SetDownloadDir(dir string) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.downloadDir = dir
+}
+
+// Offer starts sending a file to a peer and returns the transfer ID
+func (tm *TransferManager) Offer(peerID, username, path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return "", err
+	}
+
+	offer := &FileTransfer{
+		ID:        generateMessageID()[:8],
+		Name:      filepath.Base(path),
+		Size:      info.Size(),
+		Checksum:  checksum,
+		ChunkSize: FileChunkSize,
+	}
+	t := &transfer{
+		Transfer: Transfer{
+			ID:       offer.ID,
+			PeerID:   peerID,
+			Username: username,
+			Name:     offer.Name,
+			Size:     offer.Size,
+			State:    TransferOffered,
+			Path:     path,
+			Started:  time.Now(),
+		},
+		offer:  offer,
+		chunks: chunkCount(offer.Size, offer.ChunkSize),
+		events: make(chan *Message, 2*fileWindow),
+	}
+
+	// Register first, a quick receiver may accept before send returns
+	tm.mutex.Lock()
+	tm.transfers[t.ID] = t
+	tm.mutex.Unlock()
+
+	if err := tm.send(peerID, MessageTypeFileOffer, offer); err != nil {
+		tm.mutex.Lock()
+		delete(tm.transfers, t.ID)
+		tm.mutex.Unlock()
+		return "", err
+	}
+
+	logger.Debug("📎 Offered %s (%d bytes) to %s as %s", offer.Name, offer.Size, username, offer.ID)
+
+	tm.wg.Add(1)
+	go tm.runSender(t)
+	return t.ID, nil
+}
+
+// Accept starts receiving an offered file
+func (tm *TransferManager) Accept(id string) error {
+	tm.mutex.Lock()
+	t, err := tm.find(id, true)
+	if err == nil && t.State != TransferOffered {
+		err = fmt.Errorf("transfer %s is %s", t.ID, t.State)
+	}
+	if err == nil {
+		err = tm.openPart(t)
+	}
+	tm.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return tm.sendAccept(t)
+}
+
+// Reject declines an offered file
+func (tm *TransferManager) Reject(id string) error {
+	tm.mutex.Lock()
+	t, err := tm.find(id, true)
+	if err == nil && t.State != TransferOffered {
+		err = fmt.Errorf("transfer %s is %s", t.ID, t.State)
+	}
+	if err == nil {
+		t.State = TransferRejected
+	}
+	tm.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return tm.send(t.PeerID, MessageTypeFileReject, &FileTransfer{ID: t.ID, Reason: "declined"})
+}
+
+// Cancel stops a transfer in either direction
+func (tm *TransferManager) Cancel(id string) error {
+	tm.mutex.Lock()
+	t, err := tm.findAny(id)
+	if err == nil && t.State.IsFinished() {
+		err = fmt.Errorf("transfer %s is already %s", t.ID, t.State)
+	}
+	if err == nil {
+		tm.fail(t, "cancelled", true)
+	}
+	tm.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Wake the sender goroutine so it stops, then tell the other side
+	if !t.Incoming {
+		select {
+		case t.events <- &Message{Type: MessageTypeFileCancel, File: &FileTransfer{ID: t.ID}}:
+		default:
+		}
+	}
+	tm.send(t.PeerID, MessageTypeFileCancel, &FileTransfer{ID: t.ID, Reason: "cancelled"})
+	return nil
+}
+
+// Resume re-offers unfinished outgoing transfers when a peer reconnects
+// The receiver answers with the first chunk it is missing
+func (tm *TransferManager) Resume(peerID string) {
+	tm.mutex.Lock()
+	var pending []*transfer
+	for _, t := range tm.transfers {
+		if !t.Incoming && t.PeerID == peerID && !t.State.IsFinished() {
+			pending = append(pending, t)
+		}
+	}
+	tm.mutex.Unlock()
+
+	for _, t := range pending {
+		logger.Debug("🔁 Re-offering %s to %s", t.Name, t.Username)
+		if err := tm.send(peerID, MessageTypeFileOffer, t.offer); err != nil {
+			logger.Error("⚠️ Failed to resume %s: %v", t.Name, err)
+		}
+	}
+}
+
+// HandleMessage processes a file_* message from a peer
+// It returns true when the message is a new offer the user has to answer
+func (tm *TransferManager) HandleMessage(msg *Message, fromPeerID string) bool {
+	if msg.File == nil {
+		return false
+	}
+
+	switch msg.Type {
+	case MessageTypeFileOffer:
+		return tm.handleOffer(msg, fromPeerID)
+	case MessageTypeFileChunk:
+		tm.handleChunk(msg.File, fromPeerID)
+	case MessageTypeFileCancel:
+		tm.mutex.Lock()
+		if t := tm.transfers[msg.File.ID]; t != nil && t.PeerID == fromPeerID && t.Incoming && !t.State.IsFinished() {
+			tm.fail(t, "cancelled by "+t.Username, true)
+		}
+		tm.mutex.Unlock()
+		fallthrough // Outgoing transfers learn about it through their events
+	case MessageTypeFileAccept, MessageTypeFileAck, MessageTypeFileReject:
+		tm.mutex.Lock()
+		t := tm.transfers[msg.File.ID]
+		tm.mutex.Unlock()
+		if t == nil || t.Incoming || t.PeerID != fromPeerID {
+			return false
+		}
+		select {
+		case t.events <- msg:
+		default:
+			// Acks are cumulative, dropping one under pressure is harmless
+		}
+	}
+	return false
+}
+
+// Transfers returns every known transfer, newest first
+func (tm *TransferManager) Transfers() []Transfer {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	result := make([]Transfer, 0, len(tm.transfers))
+	for _, t := range tm.transfers {
+		snapshot := t.Transfer
+		// A receiver only notices a dead sender by the silence
+		if t.Incoming && t.State == TransferActive && time.Since(t.lastActivity) > fileStallTimeout {
+			snapshot.State = TransferInterrupted
+		}
+		result = append(result, snapshot)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Started.After(result[j].Started)
+	})
+	return result
+}
+
+// Stop aborts all running transfers, partial downloads are kept for resuming
+func (tm *TransferManager) Stop() {
+	tm.cancel()
+	tm.wg.Wait()
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	for _, t := range tm.transfers {
+		if t.part != nil {
+			t.part.Close()
+			t.part = nil
+		}
+	}
+}
+
+// runSender streams chunks for one outgoing transfer, driven by the receiver's acks
+func (tm *TransferManager) runSender(t *transfer) {
+	defer tm.wg.Done()
+
+	var source *os.File
+	defer func() {
+		if source != nil {
+			source.Close()
+		}
+	}()
+
+	acked, sent := 0, 0
+	sending := false
+
+	for {
+		// Without progress for a while, assume the link is gone and offer again
+		var stall <-chan time.Time
+		if sending {
+			stall = time.After(fileStallTimeout)
+		}
+
+		select {
+		case <-tm.ctx.Done():
+			return
+
+		case <-stall:
+			if tm.isFinished(t) {
+				return
+			}
+			tm.setState(t, TransferInterrupted)
+			sending = false
+			logger.Error("⏸️ Transfer of %s to %s stalled at chunk %d, waiting to resume", t.Name, t.Username, acked)
+			tm.send(t.PeerID, MessageTypeFileOffer, t.offer) // Works if the peer is still connected
+
+		case msg := <-t.events:
+			switch msg.Type {
+			case MessageTypeFileAccept:
+				// (Re)start from where the receiver is, which is also how resume works
+				if source == nil {
+					var err error
+					if source, err = os.Open(t.Path); err != nil {
+						tm.abort(t, fmt.Sprintf("cannot read %s: %v", t.Path, err))
+						return
+					}
+				}
+				acked = max(0, min(msg.File.Next, t.chunks))
+				sent = acked
+				sending = true
+				tm.setState(t, TransferActive)
+			case MessageTypeFileAck:
+				acked = max(acked, min(msg.File.Next, t.chunks))
+				sent = max(sent, acked)
+			case MessageTypeFileReject:
+				tm.setState(t, TransferRejected)
+				return
+			case MessageTypeFileCancel:
+				tm.mutex.Lock()
+				if !t.State.IsFinished() {
+					tm.fail(t, "cancelled by "+t.Username, false)
+				}
+				tm.mutex.Unlock()
+				return
+			}
+
+			tm.mutex.Lock()
+			t.Transferred = min(int64(acked)*int64(t.offer.ChunkSize), t.Size)
+			tm.mutex.Unlock()
+			if tm.isFinished(t) {
+				return // Cancelled locally
+			}
+			if sending && acked >= t.chunks {
+				tm.setState(t, TransferCompleted)
+				logger.Debug("✅ Sent %s to %s", t.Name, t.Username)
+				return
+			}
+		}
+
+		// Keep the window full
+		for sending && sent < t.chunks && sent < acked+fileWindow {
+			data, err := readChunk(source, sent, t.offer.ChunkSize)
+			if err != nil {
+				tm.abort(t, fmt.Sprintf("cannot read %s: %v", t.Path, err))
+				return
+			}
+			sum := sha256.Sum256(data)
+			chunk := &FileTransfer{ID: t.ID, Index: sent, Data: data, ChunkSum: hex.EncodeToString(sum[:])}
+			if err := tm.send(t.PeerID, MessageTypeFileChunk, chunk); err != nil {
+				break // Queue full or link down, acks or the stall timer pick it up again
+			}
+			sent++
+		}
+	}
+}
+
+// handleOffer registers an incoming offer, or resumes one we already accepted
+func (tm *TransferManager) handleOffer(msg *Message, fromPeerID string) bool {
+	offer := msg.File
+	partName, err := partFileName(offer.Checksum)
+	if err != nil || offer.ChunkSize <= 0 || offer.ChunkSize > maxChunkSize || offer.Size < 0 {
+		logger.Error("🚫 Ignoring malformed file offer from %s", msg.Username)
+		return false
+	}
+
+	tm.mutex.Lock()
+	existing := tm.transfers[offer.ID]
+	if existing != nil {
+		resume := existing.Incoming && existing.PeerID == fromPeerID &&
+			(existing.State == TransferActive || existing.State == TransferInterrupted)
+		tm.mutex.Unlock()
+		if resume {
+			// The sender lost track of us, pick up where the .part file ends
+			logger.Debug("🔁 Resuming %s from %s at chunk %d", existing.Name, existing.Username, existing.next)
+			tm.sendAccept(existing)
+		}
+		return false
+	}
+
+	t := &transfer{
+		Transfer: Transfer{
+			ID:       offer.ID,
+			PeerID:   fromPeerID,
+			Username: msg.Username,
+			Name:     safeFileName(offer.Name),
+			Size:     offer.Size,
+			Incoming: true,
+			State:    TransferOffered,
+			Started:  time.Now(),
+		},
+		offer:    offer,
+		chunks:   chunkCount(offer.Size, offer.ChunkSize),
+		partPath: filepath.Join(tm.downloadDir, partName),
+	}
+	tm.transfers[t.ID] = t
+	tm.mutex.Unlock()
+
+	logger.Debug("📎 %s offers %s (%d bytes) as %s", msg.Username, offer.Name, offer.Size, offer.ID)
+	return true
+}
+
+// handleChunk verifies and stores the next chunk of an incoming file
+func (tm *TransferManager) handleChunk(chunk *FileTransfer, fromPeerID string) {
+	tm.mutex.Lock()
+	t := tm.transfers[chunk.ID]
+	if t == nil || !t.Incoming || t.PeerID != fromPeerID || t.State != TransferActive {
+		tm.mutex.Unlock()
+		return
+	}
+	if chunk.Index != t.next {
+		tm.mutex.Unlock()
+		return // Left over from before a resume
+	}
+
+	sum := sha256.Sum256(chunk.Data)
+	if hex.EncodeToString(sum[:]) != chunk.ChunkSum || len(chunk.Data) != chunkLength(t.offer, chunk.Index) {
+		tm.mutex.Unlock()
+		logger.Error("⚠️ Chunk %d of %s failed verification, asking again", chunk.Index, t.Name)
+		tm.sendAccept(t)
+		return
+	}
+
+	if _, err := t.part.Write(chunk.Data); err != nil {
+		tm.fail(t, fmt.Sprintf("cannot write %s: %v", t.partPath, err), false)
+		tm.mutex.Unlock()
+		tm.send(t.PeerID, MessageTypeFileCancel, &FileTransfer{ID: t.ID, Reason: "receiver could not write the file"})
+		return
+	}
+	t.sum.Write(chunk.Data)
+	t.next++
+	t.Transferred = min(int64(t.next)*int64(t.offer.ChunkSize), t.Size)
+	t.lastActivity = time.Now()
+
+	if t.next == t.chunks {
+		if err := tm.finish(t); err != nil {
+			tm.mutex.Unlock()
+			logger.Error("❌ Transfer of %s failed: %v", t.Name, err)
+			tm.send(t.PeerID, MessageTypeFileCancel, &FileTransfer{ID: t.ID, Reason: err.Error()})
+			return
+		}
+	}
+	next := t.next
+	tm.mutex.Unlock()
+
+	tm.send(t.PeerID, MessageTypeFileAck, &FileTransfer{ID: t.ID, Next: next})
+}
+
+// sendAccept tells the sender which chunk to continue from
+func (tm *TransferManager) sendAccept(t *transfer) error {
+	tm.mutex.Lock()
+	next := t.next
+	tm.mutex.Unlock()
+
+	return tm.send(t.PeerID, MessageTypeFileAccept, &FileTransfer{ID: t.ID, Next: next})
+}
+
+// openPart opens (or reopens) the partial download and works out where to resume
+// This must be called with mutex already locked!
+func (tm *TransferManager) openPart(t *transfer) error {
+	if err := os.MkdirAll(tm.downloadDir, 0700); err != nil {
+		return fmt.Errorf("failed to create download directory: %w", err)
+	}
+	partName, err := partFileName(t.offer.Checksum)
+	if err != nil {
+		return err
+	}
+	t.partPath = filepath.Join(tm.downloadDir, partName)
+
+	// Several peers may offer the same file, only one transfer writes its .part file
+	for _, other := range tm.transfers {
+		if other == t || other.part == nil || other.partPath != t.partPath {
+			continue
+		}
+		if other.State == TransferActive {
+			return fmt.Errorf("already receiving the same file from %s (%s)", other.Username, other.ID)
+		}
+		// Stalled, this offer picks up its download instead
+		tm.fail(other, fmt.Sprintf("continued in %s", t.ID), false)
+	}
+
+	part, err := os.OpenFile(t.partPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open download: %w", err)
+	}
+	info, err := part.Stat()
+	if err != nil {
+		part.Close()
+		return fmt.Errorf("failed to open download: %w", err)
+	}
+
+	// Keep whole chunks only, a partial last chunk is simply fetched again
+	verified := min(int(info.Size()/int64(t.offer.ChunkSize)), t.chunks)
+	if int64(verified)*int64(t.offer.ChunkSize) > t.Size {
+		verified = 0
+	}
+	prefix := int64(verified) * int64(t.offer.ChunkSize)
+	if err := part.Truncate(prefix); err != nil {
+		part.Close()
+		return fmt.Errorf("failed to resume download: %w", err)
+	}
+
+	// Rebuild the running checksum over what we already have
+	t.sum = sha256.New()
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		part.Close()
+		return fmt.Errorf("failed to resume download: %w", err)
+	}
+	if _, err := io.CopyN(t.sum, part, prefix); err != nil {
+		part.Close()
+		return fmt.Errorf("failed to resume download: %w", err)
+	}
+
+	t.part = part
+	t.next = verified
+	t.Transferred = min(prefix, t.Size)
+	t.State = TransferActive
+	t.lastActivity = time.Now()
+	if verified > 0 {
+		logger.Debug("🔁 Resuming %s at chunk %d of %d", t.Name, verified, t.chunks)
+	}
+
+	// Nothing left to fetch (empty file, or everything arrived before a crash)
+	if t.next == t.chunks {
+		return tm.finish(t)
+	}
+	return nil
+}
+
+// finish checks the whole file and moves it into the download directory
+// This must be called with mutex already locked!
+func (tm *TransferManager) finish(t *transfer) error {
+	t.part.Close()
+	t.part = nil
+
+	if hex.EncodeToString(t.sum.Sum(nil)) != t.offer.Checksum {
+		tm.fail(t, "checksum mismatch", true)
+		return fmt.Errorf("checksum mismatch")
+	}
+
+	destination := uniquePath(filepath.Join(tm.downloadDir, t.Name))
+	if err := os.Rename(t.partPath, destination); err != nil {
+		tm.fail(t, fmt.Sprintf("cannot save file: %v", err), false)
+		return err
+	}
+
+	t.Path = destination
+	t.Transferred = t.Size
+	t.State = TransferCompleted
+	logger.Debug("✅ Received %s from %s -> %s", t.Name, t.Username, destination)
+	return nil
+}
+
+// fail marks a transfer failed and optionally drops the partial download
+// This must be called with mutex already locked!
+func (tm *TransferManager) fail(t *transfer, reason string, discard bool) {
+	t.State = TransferFailed
+	t.Error = reason
+	if t.part != nil {
+		t.part.Close()
+		t.part = nil
+	}
+	if discard && t.Incoming && t.partPath != "" {
+		os.Remove(t.partPath)
+	}
+}
+
+// abort fails an outgoing transfer and tells the receiver
+func (tm *TransferManager) abort(t *transfer, reason string) {
+	tm.mutex.Lock()
+	tm.fail(t, reason, false)
+	tm.mutex.Unlock()
+
+	logger.Error("❌ Transfer of %s failed: %s", t.Name, reason)
+	tm.send(t.PeerID, MessageTypeFileCancel, &FileTransfer{ID: t.ID, Reason: "sender could not read the file"})
+}
+
+// isFinished checks a transfer's state under the lock
+func (tm *TransferManager) isFinished(t *transfer) bool {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	return t.State.IsFinished()
+}
+
+// setState updates a transfer's state unless it already finished
+func (tm *TransferManager) setState(t *transfer, state TransferState) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	if !t.State.IsFinished() {
+		t.State = state
+	}
+}
+
+// find looks up a transfer by ID prefix, restricted to one direction
+// This must be called with mutex already locked!
+func (tm *TransferManager) find(id string, incoming bool) (*transfer, error) {
+	t, err := tm.findAny(id)
+	if err != nil {
+		return nil, err
+	}
+	if t.Incoming != incoming {
+		return nil, fmt.Errorf("transfer %s is not an incoming offer", t.ID)
+	}
+	return t, nil
+}
+
+// findAny looks up a transfer by ID prefix
+// This must be called with mutex already locked!
+func (tm *TransferManager) findAny(id string) (*transfer, error) {
+	if t := tm.transfers[id]; t != nil {
+		return t, nil
+	}
+
+	var match *transfer
+	for transferID, t := range tm.transfers {
+		if id != "" && strings.HasPrefix(transferID, id) {
+			if match != nil {
+				return nil, fmt.Errorf("transfer ID %s is ambiguous", id)
+			}
+			match = t
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no transfer %s", id)
+	}
+	return match, nil
+}
+
+// fileChecksum hashes a whole file
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, file); err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// readChunk reads chunk index of a file
+func readChunk(file *os.File, index, chunkSize int) ([]byte, error) {
+	buffer := make([]byte, chunkSize)
+	n, err := file.ReadAt(buffer, int64(index)*int64(chunkSize))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buffer[:n], nil
+}
+
+// chunkCount returns how many chunks a file of size bytes is split into
+func chunkCount(size int64, chunkSize int) int {
+	return int((size + int64(chunkSize) - 1) / int64(chunkSize))
+}
+
+// chunkLength returns the expected length of chunk index (only the last one is short)
+func chunkLength(offer *FileTransfer, index int) int {
+	remaining := offer.Size - int64(index)*int64(offer.ChunkSize)
+	return int(min(remaining, int64(offer.ChunkSize)))
+}
+
+// safeFileName strips directories so an offer can't write outside the download dir
+func safeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	switch {
+	case name == "." || name == ".." || name == "/" || name == "":
+		return "download"
+	case strings.HasPrefix(name, "."):
+		return "download" + name // Don't drop hidden files into the downloads folder
+	}
+	return name
+}
+
+// partFileName returns the name of the partial download for a file with checksum
+// It's built from the decoded digest, the raw string comes from a peer and could hold a path
+func partFileName(checksum string) (string, error) {
+	digest, err := hex.DecodeString(checksum)
+	if err != nil || len(digest) != sha256.Size {
+		return "", fmt.Errorf("invalid file checksum")
+	}
+	return "." + hex.EncodeToString(digest[:8]) + ".part", nil
+}
+
+// uniquePath adds " (1)", " (2)", ... before the extension until path is free
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+
+	extension := filepath.Ext(path)
+	base := strings.TrimSuffix(path, extension)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, extension)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package chat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRandomFile creates a file of size random bytes and returns its path and contents
+func writeRandomFile(t *testing.T, name string, size int) (string, []byte) {
+	data := make([]byte, size)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	return path, data
+}
+
+// transferState returns the state of a transfer as seen by cs
+func transferState(cs *ChatService, id string) (Transfer, bool) {
+	for _, transfer := range cs.GetTransfers() {
+		if transfer.ID == id {
+			return transfer, true
+		}
+	}
+	return Transfer{}, false
+}
+
+func TestFileTransferRoundTrip(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+	bob.SetDownloadDir(t.TempDir())
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	// Several windows worth of chunks plus a short last chunk
+	path, data := writeRandomFile(t, "server.log", 40*FileChunkSize+123)
+	id, err := alice.transfers.Offer(bob.peerID, "bob", path)
+	if err != nil {
+		t.Fatalf("Offer failed: %v", err)
+	}
+
+	waitFor(t, "offer", func() bool { _, ok := transferState(bob, id); return ok })
+	if err := bob.AcceptFile(id[:4]); err != nil {
+		t.Fatalf("Accept by ID prefix failed: %v", err)
+	}
+
+	waitFor(t, "completion", func() bool {
+		received, _ := transferState(bob, id)
+		sent, _ := transferState(alice, id)
+		return received.State == TransferCompleted && sent.State == TransferCompleted
+	})
+
+	received, _ := transferState(bob, id)
+	saved, err := os.ReadFile(received.Path)
+	if err != nil {
+		t.Fatalf("Saved file missing: %v", err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Error("Received file differs from the original")
+	}
+	if filepath.Base(received.Path) != "server.log" {
+		t.Errorf("Expected the original name, got %s", received.Path)
+	}
+}
+
+func TestFileTransferResumesFromPartialDownload(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+	downloads := t.TempDir()
+	bob.SetDownloadDir(downloads)
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	path, data := writeRandomFile(t, "config.tar", 10*FileChunkSize+7)
+
+	// An earlier attempt got 4 verified chunks and half of the fifth before dying
+	sum := sha256.Sum256(data)
+	partPath := filepath.Join(downloads, "."+hex.EncodeToString(sum[:])[:16]+".part")
+	os.WriteFile(partPath, data[:4*FileChunkSize+FileChunkSize/2], 0600)
+
+	id, err := alice.transfers.Offer(bob.peerID, "bob", path)
+	if err != nil {
+		t.Fatalf("Offer failed: %v", err)
+	}
+	waitFor(t, "offer", func() bool { _, ok := transferState(bob, id); return ok })
+	if err := bob.AcceptFile(id); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+
+	// Resuming starts from the last whole chunk, not from zero
+	resumed, _ := transferState(bob, id)
+	if resumed.Transferred < 4*FileChunkSize {
+		t.Errorf("Expected to resume at chunk 4, had %d bytes", resumed.Transferred)
+	}
+
+	waitFor(t, "completion", func() bool {
+		received, _ := transferState(bob, id)
+		return received.State == TransferCompleted
+	})
+	received, _ := transferState(bob, id)
+	saved, _ := os.ReadFile(received.Path)
+	if !bytes.Equal(saved, data) {
+		t.Error("Resumed file differs from the original")
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Error("Partial download should be gone once the file is saved")
+	}
+}
+
+func TestFileTransferReject(t *testing.T) {
+	alice := newTestService(t, "alice")
+	bob := newTestService(t, "bob")
+	bob.SetDownloadDir(t.TempDir())
+
+	connectServices(t, alice, bob)
+	waitFor(t, "link", func() bool { return len(alice.connections.GetConnectedPeers()) == 1 })
+
+	path, _ := writeRandomFile(t, "huge.iso", 1000)
+	id, _ := alice.transfers.Offer(bob.peerID, "bob", path)
+	waitFor(t, "offer", func() bool { _, ok := transferState(bob, id); return ok })
+
+	if err := bob.RejectFile(id); err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	waitFor(t, "rejection", func() bool {
+		sent, _ := transferState(alice, id)
+		return sent.State == TransferRejected
+	})
+}
+
+func TestSafeFileName(t *testing.T) {
+	tests := map[string]string{
+		"report.log":         "report.log",
+		"../../etc/passwd":   "passwd",
+		"..\\..\\boot.ini":   "boot.ini",
+		"/absolute/path.txt": "path.txt",
+		"..":                 "download",
+		".bashrc":            "download.bashrc",
+		"":                   "download",
+	}
+	for input, expected := range tests {
+		if got := safeFileName(input); got != expected {
+			t.Errorf("safeFileName(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestFileOfferWithBadChecksumIsIgnored(t *testing.T) {
+	downloads := filepath.Join(t.TempDir(), "downloads")
+	tm := NewTransferManager(downloads, func(string, MessageType, *FileTransfer) error { return nil })
+
+	for _, checksum := range []string{
+		"/../../../evil" + strings.Repeat("a", 50), // Right length, but a path
+		strings.Repeat("zz", sha256.Size),          // Not hex
+		strings.Repeat("ab", sha256.Size-1),        // Too short
+		"",
+	} {
+		offer := &FileTransfer{ID: "t-" + checksum, Name: "x.bin", Size: 10, ChunkSize: FileChunkSize, Checksum: checksum}
+		if tm.HandleMessage(NewFileMessage("mallory-id", "mallory", "alice-id", MessageTypeFileOffer, offer, 1), "mallory-id") {
+			t.Errorf("Expected the offer with checksum %q to be ignored", checksum)
+		}
+	}
+	if len(tm.Transfers()) != 0 {
+		t.Errorf("Expected no transfers, got %d", len(tm.Transfers()))
+	}
+
+	// A good checksum names the part file after the digest, inside the download dir
+	sum := sha256.Sum256([]byte("hello"))
+	name, err := partFileName(strings.ToUpper(hex.EncodeToString(sum[:])))
+	if err != nil || name != "."+hex.EncodeToString(sum[:8])+".part" {
+		t.Errorf("Expected a part file named after the digest, got %q (%v)", name, err)
+	}
+}
+
+func TestSameFileIsReceivedOnceAtATime(t *testing.T) {
+	tm := NewTransferManager(t.TempDir(), func(string, MessageType, *FileTransfer) error { return nil })
+
+	// Bob and Carol both offer the same file
+	data := make([]byte, 3*FileChunkSize)
+	sum := sha256.Sum256(data)
+	for _, from := range []string{"bob", "carol"} {
+		offer := &FileTransfer{ID: from + "-offer", Name: "build.zip", Size: int64(len(data)), ChunkSize: FileChunkSize, Checksum: hex.EncodeToString(sum[:])}
+		if !tm.HandleMessage(NewFileMessage(from+"-id", from, "alice-id", MessageTypeFileOffer, offer, 1), from+"-id") {
+			t.Fatalf("Expected %s's offer to be registered", from)
+		}
+	}
+
+	if err := tm.Accept("bob-offer"); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	if err := tm.Accept("carol-offer"); err == nil {
+		t.Error("Expected a second download of the same file to be refused while the first is active")
+	}
+
+	// Once Bob's stalls, Carol's offer takes over the partial download
+	tm.mutex.Lock()
+	tm.transfers["bob-offer"].State = TransferInterrupted
+	tm.mutex.Unlock()
+	if err := tm.Accept("carol-offer"); err != nil {
+		t.Fatalf("Expected the interrupted download to be taken over, got %v", err)
+	}
+	for _, transfer := range tm.Transfers() {
+		if transfer.ID == "bob-offer" && transfer.State != TransferFailed {
+			t.Errorf("Expected bob's transfer to give up its download, got %s", transfer.State)
+		}
+	}
+	tm.Stop()
+}
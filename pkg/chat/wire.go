@@ -0,0 +1,189 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Wire protocol of a peer link, inside the encrypted stream:
+//
+// Both sides start with their signed ident as one JSON line, like peers from
+// before protocol versions did. The ident says which protocol version we
+// speak and which frame encodings we understand. Once both idents are read,
+// both sides pick the same protocol: the lower of the two versions, and for
+// frames the first of our encodings the peer knows too.
+//
+// Version 1 is JSON terminated by "\n" (peers that send no version speak it).
+// Version 2 sends every message as a frame: a 4-byte big-endian length, then
+// the encoded message. Either way nothing longer than MaxFrameSize is read,
+// a peer that tries is disconnected.
+
+const (
+	ProtocolJSONLines = 1 // Newline terminated JSON, what peers without a version speak
+	ProtocolFramed    = 2 // Length-prefixed frames in the negotiated encoding
+	ProtocolVersion   = ProtocolFramed
+
+	// MaxFrameSize bounds one message on the wire, a full sync batch fits easily
+	MaxFrameSize = 1 << 20
+
+	// maxIdentSize bounds the ident line, read before we know who is talking
+	maxIdentSize = 16 * 1024
+)
+
+// ErrFrameTooLarge is returned for messages that don't fit in MaxFrameSize
+var ErrFrameTooLarge = errors.New("message exceeds the maximum frame size")
+
+// errInvalidMessage wraps messages that arrived whole but don't decode, the next one may be fine
+var errInvalidMessage = errors.New("invalid message")
+
+// frameCodec turns messages into frame bodies and back
+type frameCodec struct {
+	name   string
+	encode func(*Message) ([]byte, error)
+	decode func([]byte) (*Message, error)
+}
+
+// frameCodecs are the encodings we offer, most preferred first
+// A compact encoding (CBOR, protobuf) goes in front of JSON once we have one
+var frameCodecs = []frameCodec{
+	{name: "json", encode: (*Message).ToJSON, decode: FromJSON},
+}
+
+// codecNames lists our encodings for the ident
+func codecNames() []string {
+	names := make([]string, len(frameCodecs))
+	for i, codec := range frameCodecs {
+		names[i] = codec.name
+	}
+	return names
+}
+
+// negotiateProtocol picks the protocol for a link from the peer's ident
+// Both ends run this on each other's ident and end up with the same answer
+func negotiateProtocol(theirs *Message) (int, *frameCodec) {
+	version := min(max(theirs.Protocol, ProtocolJSONLines), ProtocolVersion)
+	if version < ProtocolFramed {
+		return ProtocolJSONLines, nil
+	}
+	for i, codec := range frameCodecs {
+		if slices.Contains(theirs.Capabilities, codec.name) {
+			return version, &frameCodecs[i]
+		}
+	}
+	return ProtocolJSONLines, nil // Frames but no encoding in common, lines always work
+}
+
+// wire reads and writes the messages of one session in the negotiated protocol
+type wire struct {
+	version int
+	codec   *frameCodec // nil for JSON lines
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+}
+
+// newWire wraps a session in the protocol negotiated with the peer's ident
+// reader is the one the ident was read with, it may hold the first messages already
+func newWire(theirs *Message, reader *bufio.Reader, w io.Writer) *wire {
+	version, codec := negotiateProtocol(theirs)
+	return &wire{version: version, codec: codec, reader: reader, writer: bufio.NewWriter(w)}
+}
+
+// describe names the protocol for logs, e.g. "framed/json"
+func (w *wire) describe() string {
+	if w.codec == nil {
+		return "json lines"
+	}
+	return "framed/" + w.codec.name
+}
+
+// ReadMessage reads the next message
+// Only errInvalidMessage leaves the stream usable, anything else ends the session
+func (w *wire) ReadMessage() (*Message, error) {
+	var data []byte
+	var err error
+	decode := FromJSON
+	if w.codec == nil {
+		data, err = readLine(w.reader, MaxFrameSize)
+	} else {
+		data, err = readFrame(w.reader)
+		decode = w.codec.decode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidMessage, err)
+	}
+	return msg, nil
+}
+
+// WriteMessage sends one message and flushes it
+// ErrFrameTooLarge means nothing was written and the session can go on
+func (w *wire) WriteMessage(msg *Message) error {
+	var data []byte
+	var err error
+	if w.codec == nil {
+		data, err = msg.ToJSON()
+	} else {
+		data, err = w.codec.encode(msg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
+	if len(data) > MaxFrameSize {
+		return fmt.Errorf("%w: %d bytes (max %d)", ErrFrameTooLarge, len(data), MaxFrameSize)
+	}
+
+	if w.codec == nil {
+		data = append(data, '\n')
+	} else {
+		var header [4]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+		if _, err := w.writer.Write(header[:]); err != nil {
+			return err
+		}
+	}
+	if _, err := w.writer.Write(data); err != nil {
+		return err
+	}
+	return w.writer.Flush()
+}
+
+// readFrame reads one length-prefixed frame, refusing lengths over MaxFrameSize
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MaxFrameSize {
+		return nil, fmt.Errorf("%w: peer announced %d bytes (max %d)", ErrFrameTooLarge, length, MaxFrameSize)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// readLine reads up to and including the next newline, giving up after limit bytes
+// Without a limit a peer that never sends a newline would have us buffer forever
+func readLine(r *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return nil, fmt.Errorf("%w: line longer than %d bytes", ErrFrameTooLarge, limit)
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
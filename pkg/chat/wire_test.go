@@ -0,0 +1,211 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"p2pchat/pkg/identity"
+	"p2pchat/pkg/secure"
+)
+
+func TestNegotiateProtocol(t *testing.T) {
+	for _, tc := range []struct {
+		theirs  Message
+		version int
+		codec   string
+	}{
+		{Message{}, ProtocolJSONLines, ""}, // Peers from before versions
+		{Message{Protocol: ProtocolFramed, Capabilities: []string{"json"}}, ProtocolFramed, "json"},
+		{Message{Protocol: 9, Capabilities: []string{"cbor", "json"}}, ProtocolFramed, "json"},
+		{Message{Protocol: ProtocolFramed, Capabilities: []string{"cbor"}}, ProtocolJSONLines, ""},
+		{Message{Protocol: -3}, ProtocolJSONLines, ""},
+	} {
+		version, codec := negotiateProtocol(&tc.theirs)
+		name := ""
+		if codec != nil {
+			name = codec.name
+		}
+		if version != tc.version || name != tc.codec {
+			t.Errorf("%d %v: expected %d/%q, got %d/%q", tc.theirs.Protocol, tc.theirs.Capabilities, tc.version, tc.codec, version, name)
+		}
+	}
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	for _, theirs := range []*Message{{}, {Protocol: ProtocolVersion, Capabilities: codecNames()}} {
+		var stream bytes.Buffer
+		w := newWire(theirs, bufio.NewReader(&stream), &stream)
+
+		sent := []*Message{
+			NewChatMessage("alice", "alice", "hello\nwith a newline", 1),
+			NewChatMessage("alice", "alice", strings.Repeat("x", 100*1024), 2),
+		}
+		for _, msg := range sent {
+			if err := w.WriteMessage(msg); err != nil {
+				t.Fatalf("%s: write failed: %v", w.describe(), err)
+			}
+		}
+
+		// Too big for a frame, nothing is written and the stream stays in step
+		huge := NewChatMessage("alice", "alice", strings.Repeat("x", MaxFrameSize), 3)
+		if err := w.WriteMessage(huge); !errors.Is(err, ErrFrameTooLarge) {
+			t.Errorf("%s: expected ErrFrameTooLarge, got %v", w.describe(), err)
+		}
+
+		for _, want := range sent {
+			got, err := w.ReadMessage()
+			if err != nil {
+				t.Fatalf("%s: read failed: %v", w.describe(), err)
+			}
+			if got.ID != want.ID || got.Content != want.Content {
+				t.Errorf("%s: expected %s, got %s", w.describe(), want.ID, got.ID)
+			}
+		}
+		if _, err := w.ReadMessage(); err != io.EOF {
+			t.Errorf("%s: expected the stream to end, got %v", w.describe(), err)
+		}
+	}
+}
+
+func TestWireRefusesOversizedInput(t *testing.T) {
+	framed := &Message{Protocol: ProtocolVersion, Capabilities: codecNames()}
+
+	// A frame header asking for more than we allow is refused before anything is allocated
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], MaxFrameSize+1)
+	w := newWire(framed, bufio.NewReader(bytes.NewReader(header[:])), io.Discard)
+	if _, err := w.ReadMessage(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge for a huge frame, got %v", err)
+	}
+
+	// A line that never ends is given up on, not buffered forever
+	endless := io.LimitReader(neverNewline{}, 10*MaxFrameSize)
+	w = newWire(&Message{}, bufio.NewReader(endless), io.Discard)
+	if _, err := w.ReadMessage(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge for an endless line, got %v", err)
+	}
+
+	// Garbage that arrives whole only costs that one message
+	var stream bytes.Buffer
+	w = newWire(framed, bufio.NewReader(&stream), &stream)
+	binary.BigEndian.PutUint32(header[:], 5)
+	stream.Write(append(header[:], "nope!"...))
+	w.WriteMessage(NewChatMessage("alice", "alice", "still here", 1))
+	if _, err := w.ReadMessage(); !errors.Is(err, errInvalidMessage) {
+		t.Errorf("Expected errInvalidMessage, got %v", err)
+	}
+	if msg, err := w.ReadMessage(); err != nil || msg.Content != "still here" {
+		t.Errorf("Expected the next message to read fine, got %v (%v)", msg, err)
+	}
+}
+
+// neverNewline is an endless stream of 'a's
+type neverNewline struct{}
+
+func (neverNewline) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+// dialAsOldPeer connects to cs the way peers from before protocol versions did
+// and returns the encrypted stream after both idents were exchanged
+func dialAsOldPeer(t *testing.T, cs *ChatService, id *identity.Identity) (*secure.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cs.port))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	secureConn, err := secure.Client(conn, id.StaticKey)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	// No protocol, no capabilities
+	ident, _ := NewIdentMessage(id, "oldtimer", secureConn.HandshakeHash()).ToJSON()
+	secureConn.Write(append(ident, '\n'))
+
+	reader := bufio.NewReader(secureConn)
+	secureConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("No ident back: %v", err)
+	}
+	reply, err := FromJSON([]byte(line))
+	if err != nil || reply.VerifyIdent(secureConn.HandshakeHash()) != nil {
+		t.Fatalf("Expected a verified ident line, got %q (%v)", line, err)
+	}
+	if reply.Protocol != ProtocolVersion {
+		t.Errorf("Expected the ident to offer protocol %d, got %d", ProtocolVersion, reply.Protocol)
+	}
+	return secureConn, reader
+}
+
+func TestOldPeersKeepJSONLines(t *testing.T) {
+	cs := newTestService(t, "alice")
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	conn, reader := dialAsOldPeer(t, cs, id)
+	waitFor(t, "link", func() bool { return len(cs.connections.GetConnectedPeers()) == 1 })
+	if link := sessionTo(cs, id.PeerID()); link.Protocol != ProtocolJSONLines {
+		t.Errorf("Expected protocol %d with an old peer, got %d", ProtocolJSONLines, link.Protocol)
+	}
+
+	// Our chat reaches them as a JSON line
+	if err := cs.SendMessage("hi oldtimer"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected JSON lines, reading failed: %v", err)
+		}
+		msg, err := FromJSON([]byte(line))
+		if err != nil {
+			t.Fatalf("Expected JSON lines, got %q: %v", line, err)
+		}
+		if msg.Type == MessageTypeChat && msg.Content == "hi oldtimer" {
+			break
+		}
+	}
+
+	// And theirs reaches us
+	msg := NewChatMessage(id.PeerID(), "oldtimer", "hi alice", 1)
+	msg.Sign(id)
+	data, _ := msg.ToJSON()
+	conn.Write(append(data, '\n'))
+	waitFor(t, "message", func() bool { return cs.GetMessageCount() == 2 })
+
+	// A line without an end gets them disconnected before it eats our memory
+	conn.Write(bytes.Repeat([]byte("a"), MaxFrameSize+64*1024))
+	waitFor(t, "disconnect", func() bool { return len(cs.connections.GetConnectedPeers()) == 0 })
+}
+
+func TestNewPeersUseFrames(t *testing.T) {
+	alice, bob := newTestService(t, "alice"), newTestService(t, "bob")
+	connectServices(t, alice, bob)
+
+	for _, pair := range [][2]*ChatService{{alice, bob}, {bob, alice}} {
+		if link := sessionTo(pair[0], pair[1].peerID); link == nil || link.Protocol != ProtocolFramed {
+			t.Errorf("Expected %s to speak protocol %d with %s, got %+v", pair[0].username, ProtocolFramed, pair[1].username, link)
+		}
+	}
+
+	// A message bigger than the old bufio default goes through whole
+	long := strings.Repeat("lorem ipsum ", 10_000)
+	if err := alice.SendMessage(long); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitFor(t, "message", func() bool { return bob.GetMessageCount() == 1 })
+}
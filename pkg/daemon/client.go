@@ -0,0 +1,431 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/chat"
+	"p2pchat/pkg/logger"
+)
+
+// callTimeout bounds how long a single request may take
+const callTimeout = 10 * time.Second
+
+// ErrClosed is returned by calls after the connection to the daemon is gone
+var ErrClosed = errors.New("connection to daemon closed")
+
+// Client talks to a running daemon over its control socket
+// It has the same methods as chat.ChatService, so the TUI can attach to a daemon
+type Client struct {
+	conn   net.Conn
+	peerID string
+
+	// Requests waiting for their response
+	nextID       uint64
+	pending      map[uint64]chan *Response
+	pendingMutex sync.Mutex
+	writeMutex   sync.Mutex
+
+	// Pushed message events, after Subscribe
+	messages chan *chat.Message
+
+	// Closed when the connection drops
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Dial connects to the daemon listening on path
+func Dial(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+
+	c := &Client{
+		conn:     conn,
+		pending:  make(map[uint64]chan *Response),
+		messages: make(chan *chat.Message, 100),
+		done:     make(chan struct{}),
+	}
+	go c.readLoop()
+
+	// The peer ID never changes, ask once instead of on every GetPeerID
+	status, err := c.GetStatus()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.peerID = status.PeerID
+	return c, nil
+}
+
+// Close hangs up on the daemon (which keeps running)
+func (c *Client) Close() error {
+	c.shutdown(ErrClosed)
+	return nil
+}
+
+// Done is closed once the connection to the daemon is gone
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection closed, after Done
+func (c *Client) Err() error {
+	<-c.done
+	return c.err
+}
+
+// shutdown closes the connection and fails every waiting call
+func (c *Client) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+// readLoop routes responses to their callers and events to the message channel
+func (c *Client) readLoop() {
+	decoder := json.NewDecoder(c.conn)
+	for {
+		var resp Response
+		if err := decoder.Decode(&resp); err != nil {
+			select {
+			case <-c.done:
+				// We hung up ourselves
+			default:
+				logger.Error("🔌 Lost connection to daemon: %v", err)
+				c.shutdown(fmt.Errorf("%w: %v", ErrClosed, err))
+			}
+			return
+		}
+
+		if resp.Event == EventMessage {
+			var view MessageView
+			if err := json.Unmarshal(resp.Result, &view); err != nil {
+				logger.Error("❌ Bad message event from daemon: %v", err)
+				continue
+			}
+			if msg := view.message(); msg != nil {
+				select {
+				case c.messages <- msg:
+				default:
+					logger.Error("⚠️ Message buffer full, dropping %s from daemon", msg.Type)
+				}
+			}
+			continue
+		}
+
+		c.pendingMutex.Lock()
+		waiting := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.pendingMutex.Unlock()
+		if waiting != nil {
+			waiting <- &resp
+		}
+	}
+}
+
+// call sends a request and decodes its result into result (unless nil)
+func (c *Client) call(method string, params Params, result any) error {
+	id := atomic.AddUint64(&c.nextID, 1)
+	waiting := make(chan *Response, 1)
+
+	c.pendingMutex.Lock()
+	c.pending[id] = waiting
+	c.pendingMutex.Unlock()
+	defer func() {
+		c.pendingMutex.Lock()
+		delete(c.pending, id)
+		c.pendingMutex.Unlock()
+	}()
+
+	data, err := json.Marshal(Request{ID: id, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+	c.writeMutex.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(callTimeout))
+	_, err = c.conn.Write(append(data, '\n'))
+	c.writeMutex.Unlock()
+	if err != nil {
+		c.shutdown(fmt.Errorf("%w: %v", ErrClosed, err))
+		return c.err
+	}
+
+	select {
+	case resp := <-waiting:
+		if resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		if result == nil || len(resp.Result) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", method, err)
+		}
+		return nil
+	case <-c.done:
+		return c.err
+	case <-time.After(callTimeout):
+		return fmt.Errorf("daemon did not answer %s in time", method)
+	}
+}
+
+// query is call for methods that can't report errors, it logs them instead
+func (c *Client) query(method string, params Params, result any) {
+	if err := c.call(method, params, result); err != nil {
+		logger.Error("❌ Daemon %s failed: %v", method, err)
+	}
+}
+
+// Subscribe asks the daemon to push every message it sees to GetMessages
+func (c *Client) Subscribe() error {
+	return c.call(MethodSubscribe, Params{}, nil)
+}
+
+// GetMessages returns the channel pushed messages arrive on (see Subscribe)
+func (c *Client) GetMessages() <-chan *chat.Message {
+	return c.messages
+}
+
+// GetStatus returns the daemon's service status
+func (c *Client) GetStatus() (chat.ServiceStatus, error) {
+	var status chat.ServiceStatus
+	err := c.call(MethodStatus, Params{}, &status)
+	return status, err
+}
+
+// GetPeerID returns the daemon's peer ID
+func (c *Client) GetPeerID() string {
+	return c.peerID
+}
+
+// GetConnectedPeers returns the peers the daemon knows about
+func (c *Client) GetConnectedPeers() []chat.PeerInfo {
+	var peers []chat.PeerInfo
+	c.query(MethodPeers, Params{}, &peers)
+	return peers
+}
+
+// ResolvePeer finds a connected peer by username or peer ID
+func (c *Client) ResolvePeer(name string) (chat.PeerInfo, error) {
+	var info chat.PeerInfo
+	err := c.call(MethodResolve, Params{Peer: name}, &info)
+	return info, err
+}
+
+// ConnectTo has the daemon dial a peer by address and keep redialing it
+func (c *Client) ConnectTo(address string) error {
+	return c.call(MethodConnect, Params{Address: address}, nil)
+}
+
+// SendRoomMessage sends a chat message to a room
+func (c *Client) SendRoomMessage(roomID, content string) error {
+	return c.call(MethodSend, Params{Room: roomID, Content: content}, nil)
+}
+
+// SendDirect sends a private message to a connected peer
+func (c *Client) SendDirect(peerID, content string) error {
+	return c.call(MethodSend, Params{Peer: peerID, Content: content}, nil)
+}
+
+// SendReply answers an earlier message in its room or DM
+func (c *Client) SendReply(parentID, content string) error {
+	return c.call(MethodSend, Params{ReplyTo: parentID, Content: content}, nil)
+}
+
+// GetHistory returns the newest limit messages of a room, or of the DMs with peerName if set
+func (c *Client) GetHistory(roomID, peerName string, limit int) ([]*chat.Message, error) {
+	var views []*MessageView
+	err := c.call(MethodHistory, Params{Room: roomID, Peer: peerName, Limit: limit}, &views)
+	return messages(views), err
+}
+
+// GetRoomHistory returns the stored messages of one room
+func (c *Client) GetRoomHistory(roomID string) []*chat.Message {
+	history, err := c.GetHistory(roomID, "", 0)
+	if err != nil {
+		logger.Error("❌ Daemon %s failed: %v", MethodHistory, err)
+	}
+	return history
+}
+
+// GetDirectHistory returns the stored DMs exchanged with a peer, by peer ID or username
+func (c *Client) GetDirectHistory(peerID string) []*chat.Message {
+	history, err := c.GetHistory("", peerID, 0)
+	if err != nil {
+		logger.Error("❌ Daemon %s failed: %v", MethodHistory, err)
+	}
+	return history
+}
+
+// GetMessage returns a stored message by ID, or nil
+func (c *Client) GetMessage(messageID string) *chat.Message {
+	var view *MessageView
+	c.query(MethodMessage, Params{MessageID: messageID}, &view)
+	return view.message()
+}
+
+// GetReplies returns the direct answers to a message
+func (c *Client) GetReplies(parentID string) []*chat.Message {
+	var views []*MessageView
+	c.query(MethodReplies, Params{MessageID: parentID}, &views)
+	return messages(views)
+}
+
+// GetThread returns the whole thread a message belongs to
+func (c *Client) GetThread(messageID string) []*chat.Message {
+	var views []*MessageView
+	c.query(MethodThread, Params{MessageID: messageID}, &views)
+	return messages(views)
+}
+
+// GetDeliveryState returns who received and who read one of the daemon's messages
+func (c *Client) GetDeliveryState(messageID string) chat.DeliveryState {
+	var state chat.DeliveryState
+	c.query(MethodDelivery, Params{MessageID: messageID}, &state)
+	return state
+}
+
+// MarkRead sends read receipts for messages that are on screen
+func (c *Client) MarkRead(messageIDs ...string) {
+	c.query(MethodMarkRead, Params{MessageIDs: messageIDs}, nil)
+}
+
+// React toggles an emoji reaction on a message
+func (c *Client) React(targetID, emoji string) error {
+	return c.call(MethodReact, Params{MessageID: targetID, Emoji: emoji}, nil)
+}
+
+// EditMessage replaces the text of one of our messages
+func (c *Client) EditMessage(targetID, content string) error {
+	return c.call(MethodEdit, Params{MessageID: targetID, Content: content}, nil)
+}
+
+// DeleteMessage tombstones one of our messages for everyone
+func (c *Client) DeleteMessage(targetID string) error {
+	return c.call(MethodDelete, Params{MessageID: targetID}, nil)
+}
+
+// GetJoinedRooms returns the rooms the daemon is in
+func (c *Client) GetJoinedRooms() []string {
+	var rooms []string
+	c.query(MethodRooms, Params{}, &rooms)
+	return rooms
+}
+
+// GetRoomMembers returns the IDs of connected peers known to be in a room
+func (c *Client) GetRoomMembers(roomID string) []string {
+	var members []string
+	c.query(MethodMembers, Params{Room: roomID}, &members)
+	return members
+}
+
+// JoinRoom joins a room and returns its normalized name
+func (c *Client) JoinRoom(name string) (string, error) {
+	var roomID string
+	err := c.call(MethodJoin, Params{Room: name}, &roomID)
+	return roomID, err
+}
+
+// PartRoom leaves a room and returns its normalized name
+func (c *Client) PartRoom(name string) (string, error) {
+	var roomID string
+	err := c.call(MethodPart, Params{Room: name}, &roomID)
+	return roomID, err
+}
+
+// GetDirectConversations returns the peers we have stored DMs with
+func (c *Client) GetDirectConversations() map[string]string {
+	conversations := make(map[string]string)
+	c.query(MethodDirects, Params{}, &conversations)
+	return conversations
+}
+
+// GetTyping returns who is typing right now, keyed by conversation
+func (c *Client) GetTyping() map[string][]string {
+	typing := make(map[string][]string)
+	c.query(MethodTyping, Params{}, &typing)
+	return typing
+}
+
+// NotifyTyping tells a room or DM that we are typing
+func (c *Client) NotifyTyping(conversationID string) {
+	c.query(MethodNotify, Params{Conversation: conversationID}, nil)
+}
+
+// GetPresence returns the daemon's presence and reason
+func (c *Client) GetPresence() (peer.Presence, string) {
+	var presence Presence
+	c.query(MethodPresence, Params{}, &presence)
+	return peer.Presence(presence.Presence), presence.Reason
+}
+
+// SetPresence marks us away, busy or available
+func (c *Client) SetPresence(presence peer.Presence, reason string) error {
+	return c.call(MethodSetPresence, Params{Presence: string(presence), Reason: reason}, nil)
+}
+
+// ChangeUsername changes the daemon's display name
+func (c *Client) ChangeUsername(newUsername string) error {
+	return c.call(MethodNick, Params{Username: newUsername}, nil)
+}
+
+// GetTransfers returns the daemon's file transfers
+func (c *Client) GetTransfers() []chat.Transfer {
+	var transfers []chat.Transfer
+	c.query(MethodFiles, Params{}, &transfers)
+	return transfers
+}
+
+// SendFile offers a file to a peer, a relative path means our working directory, not the daemon's
+func (c *Client) SendFile(name, path string) (string, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	var id string
+	err = c.call(MethodSendFile, Params{Peer: name, Path: absolute}, &id)
+	return id, err
+}
+
+// AcceptFile starts (or resumes) downloading an offered file into the daemon's download directory
+func (c *Client) AcceptFile(id string) error {
+	return c.call(MethodAccept, Params{Transfer: id}, nil)
+}
+
+// RejectFile declines an offered file
+func (c *Client) RejectFile(id string) error {
+	return c.call(MethodReject, Params{Transfer: id}, nil)
+}
+
+// CancelFile stops a transfer in either direction
+func (c *Client) CancelFile(id string) error {
+	return c.call(MethodCancel, Params{Transfer: id}, nil)
+}
+
+// RunCommand runs a plugin's slash command in the daemon
+func (c *Client) RunCommand(conversationID, line string) (bool, string, error) {
+	var result CommandResult
+	if err := c.call(MethodCommand, Params{Conversation: conversationID, Content: line}, &result); err != nil {
+		return true, "", err
+	}
+	return result.Handled, result.Output, nil
+}
+
+// GetCommands returns the slash commands of the daemon's plugins
+func (c *Client) GetCommands() []chat.CommandInfo {
+	var commands []chat.CommandInfo
+	c.query(MethodCommands, Params{}, &commands)
+	return commands
+}
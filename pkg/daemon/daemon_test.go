@@ -0,0 +1,231 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/chat"
+	"p2pchat/pkg/identity"
+)
+
+// newTestDaemon serves a chat service without networking on a socket in a temp dir
+func newTestDaemon(t *testing.T, username string) (*chat.ChatService, *Server) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	cs, err := chat.NewChatService(id, username, 9000, "224.0.0.1:9999")
+	if err != nil {
+		t.Fatalf("Failed to create chat service: %v", err)
+	}
+
+	server := NewServer(cs, filepath.Join(t.TempDir(), "p2pchat", username+".sock"))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return cs, server
+}
+
+// dialTestDaemon connects a client that hangs up when the test ends
+func dialTestDaemon(t *testing.T, server *Server) *Client {
+	client, err := Dial(server.Path())
+	if err != nil {
+		t.Fatalf("Failed to dial daemon: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// nextMessage waits for a pushed message event
+func nextMessage(t *testing.T, client *Client) *chat.Message {
+	select {
+	case msg := <-client.GetMessages():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message event")
+		return nil
+	}
+}
+
+func TestClientSendAndHistory(t *testing.T) {
+	cs, server := newTestDaemon(t, "buildbot")
+	client := dialTestDaemon(t, server)
+
+	if client.GetPeerID() != cs.GetPeerID() {
+		t.Errorf("Expected peer ID %s, got %s", cs.GetPeerID(), client.GetPeerID())
+	}
+	if err := client.Subscribe(); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	if err := client.SendRoomMessage(chat.DefaultRoom, "build #42 is green"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	sent := nextMessage(t, client)
+	if sent.Content != "build #42 is green" || sent.SenderID != cs.GetPeerID() {
+		t.Fatalf("Expected our own message as an event, got %+v", sent)
+	}
+
+	history := client.GetRoomHistory(chat.DefaultRoom)
+	if len(history) != 1 || history[0].ID != sent.ID {
+		t.Fatalf("Expected the message in history, got %d messages", len(history))
+	}
+
+	if err := client.SendRoomMessage("no-such-room", "hello?"); err == nil {
+		t.Error("Sending to a room we are not in should fail")
+	}
+}
+
+func TestClientSeesAnnotations(t *testing.T) {
+	_, server := newTestDaemon(t, "buildbot")
+	client := dialTestDaemon(t, server)
+
+	client.SendRoomMessage(chat.DefaultRoom, "deploying to staging")
+	target := client.GetRoomHistory(chat.DefaultRoom)[0]
+
+	if err := client.React(target.ID, "👍"); err != nil {
+		t.Fatalf("React failed: %v", err)
+	}
+	if err := client.EditMessage(target.ID, "deploying to prod"); err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+
+	msg := client.GetMessage(target.ID)
+	if msg == nil {
+		t.Fatal("Message not found through the daemon")
+	}
+	if msg.Text() != "deploying to prod" || !msg.IsEdited() {
+		t.Errorf("Expected the edited text, got %q (edited %v)", msg.Text(), msg.IsEdited())
+	}
+	if users := msg.Reactions["👍"]; len(users) != 1 || users[0] != "buildbot" {
+		t.Errorf("Expected buildbot's 👍, got %v", msg.Reactions)
+	}
+
+	if client.GetMessage("missing") != nil {
+		t.Error("Unknown messages should come back as nil")
+	}
+}
+
+func TestClientRoomsAndPresence(t *testing.T) {
+	_, server := newTestDaemon(t, "buildbot")
+	client := dialTestDaemon(t, server)
+
+	roomID, err := client.JoinRoom("#CI")
+	if err != nil || roomID != "ci" {
+		t.Fatalf("Expected to join ci, got %q, %v", roomID, err)
+	}
+	if rooms := client.GetJoinedRooms(); len(rooms) != 2 || rooms[1] != "ci" {
+		t.Errorf("Expected general and ci, got %v", rooms)
+	}
+
+	if err := client.SetPresence(peer.PresenceBusy, "running the nightly"); err != nil {
+		t.Fatalf("SetPresence failed: %v", err)
+	}
+	if presence, reason := client.GetPresence(); presence != peer.PresenceBusy || reason != "running the nightly" {
+		t.Errorf("Expected busy, got %s %q", presence, reason)
+	}
+	if err := client.SetPresence("asleep", ""); err == nil {
+		t.Error("Unknown presence should be rejected by the daemon")
+	}
+
+	if peers := client.GetConnectedPeers(); len(peers) != 0 {
+		t.Errorf("Expected no peers, got %v", peers)
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	_, server := newTestDaemon(t, "buildbot")
+
+	// Talk to the socket by hand, the way a shell script would
+	conn, err := net.Dial("unix", server.Path())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte(`{"id":7,"method":"launch_missiles"}` + "\n"))
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if resp.ID != 7 || !strings.Contains(resp.Error, "unknown method") {
+		t.Errorf("Expected an unknown method error for request 7, got %+v", resp)
+	}
+}
+
+func TestSecondDaemonRefused(t *testing.T) {
+	cs, server := newTestDaemon(t, "buildbot")
+
+	if err := NewServer(cs, server.Path()).Start(); err == nil {
+		t.Fatal("A second daemon on the same socket should be refused")
+	}
+
+	// Once the first one is gone its socket can be reused
+	client := dialTestDaemon(t, server)
+	server.Stop()
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not notice the daemon stopping")
+	}
+
+	restarted := NewServer(cs, server.Path())
+	if err := restarted.Start(); err != nil {
+		t.Fatalf("Failed to restart on the same socket: %v", err)
+	}
+	restarted.Stop()
+}
+
+func TestStaleSocketRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+
+	// A daemon that crashed leaves its socket file behind
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	if err := removeStaleSocket(path); err != nil {
+		t.Fatalf("Stale socket should be removed, got %v", err)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		t.Errorf("A missing socket is fine, got %v", err)
+	}
+}
+
+// shout is a plugin with one command, to check commands run in the daemon
+type shout struct{}
+
+func (shout) Name() string { return "shout" }
+func (shout) Init(host *chat.PluginHost) error {
+	return host.RegisterCommand("shout", "/shout <text>", "Shout", func(cmd chat.Command) (string, error) {
+		return strings.ToUpper(cmd.Text), nil
+	})
+}
+
+func TestClientRunsPluginCommands(t *testing.T) {
+	cs, server := newTestDaemon(t, "buildbot")
+	if err := cs.RegisterPlugin(shout{}); err != nil {
+		t.Fatalf("Failed to register plugin: %v", err)
+	}
+	client := dialTestDaemon(t, server)
+
+	handled, output, err := client.RunCommand(chat.DefaultRoom, "/shout deploy is done")
+	if !handled || err != nil || output != "DEPLOY IS DONE" {
+		t.Errorf("Expected the daemon's plugin to answer, got %v %q %v", handled, output, err)
+	}
+	if handled, _, _ := client.RunCommand(chat.DefaultRoom, "/whisper hi"); handled {
+		t.Error("Unknown commands should come back unhandled")
+	}
+	if commands := client.GetCommands(); len(commands) != 1 || commands[0].Plugin != "shout" {
+		t.Errorf("Expected shout's command, got %+v", commands)
+	}
+}
@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"encoding/json"
+
+	"p2pchat/pkg/chat"
+)
+
+// The control socket speaks newline-delimited JSON, so it works from any
+// language and even from `nc -U`. Each line a client writes is a Request,
+// each line the daemon writes is a Response with the same ID. After a
+// "subscribe" request the daemon also pushes every message the chat service
+// sees as a Response with Event set and no ID.
+//
+//	{"id":1,"method":"send","params":{"room":"general","content":"build #42 is green"}}
+//	{"id":1}
+//	{"id":2,"method":"history","params":{"room":"general","limit":1}}
+//	{"id":2,"result":[{"id":"...","type":"chat","content":"build #42 is green",...}]}
+
+// Methods the daemon understands
+const (
+	MethodStatus      = "status"       // -> chat.ServiceStatus
+	MethodPeers       = "peers"        // -> []chat.PeerInfo
+	MethodResolve     = "resolve"      // peer -> chat.PeerInfo
+	MethodConnect     = "connect"      // address, dials a peer discovery can't find
+	MethodSend        = "send"         // room or peer, content, reply_to
+	MethodHistory     = "history"      // room or peer, limit -> []MessageView
+	MethodMessage     = "message"      // message_id -> MessageView (null if unknown)
+	MethodReplies     = "replies"      // message_id -> []MessageView
+	MethodThread      = "thread"       // message_id -> []MessageView
+	MethodReact       = "react"        // message_id, emoji
+	MethodEdit        = "edit"         // message_id, content
+	MethodDelete      = "delete"       // message_id
+	MethodDelivery    = "delivery"     // message_id -> chat.DeliveryState
+	MethodMarkRead    = "mark_read"    // message_ids, sends read receipts
+	MethodRooms       = "rooms"        // -> []string
+	MethodMembers     = "members"      // room -> []string
+	MethodJoin        = "join"         // room -> string
+	MethodPart        = "part"         // room -> string
+	MethodDirects     = "directs"      // -> map peer ID -> username
+	MethodTyping      = "typing"       // -> map conversation -> usernames
+	MethodNotify      = "notify"       // conversation, tells it we are typing
+	MethodPresence    = "presence"     // -> Presence
+	MethodSetPresence = "set_presence" // presence, reason
+	MethodNick        = "nick"         // username
+	MethodFiles       = "files"        // -> []chat.Transfer
+	MethodSendFile    = "send_file"    // peer, path -> transfer ID
+	MethodAccept      = "accept"       // transfer
+	MethodReject      = "reject"       // transfer
+	MethodCancel      = "cancel"       // transfer
+	MethodCommand     = "command"      // conversation, content -> CommandResult
+	MethodCommands    = "commands"     // -> []chat.CommandInfo
+	MethodSubscribe   = "subscribe"    // start pushing message events
+)
+
+// EventMessage is the event pushed for every message the chat service sees
+const EventMessage = "message"
+
+// Request is one call from a client
+type Request struct {
+	ID     uint64 `json:"id"`
+	Method string `json:"method"`
+	Params Params `json:"params"`
+}
+
+// Params holds the arguments of every method, each uses only the ones it needs
+type Params struct {
+	Room         string   `json:"room,omitempty"`
+	Peer         string   `json:"peer,omitempty"` // Username or peer ID
+	Content      string   `json:"content,omitempty"`
+	ReplyTo      string   `json:"reply_to,omitempty"`
+	MessageID    string   `json:"message_id,omitempty"`
+	MessageIDs   []string `json:"message_ids,omitempty"` // Mark read only
+	Emoji        string   `json:"emoji,omitempty"`
+	Conversation string   `json:"conversation,omitempty"`
+	Presence     string   `json:"presence,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+	Username     string   `json:"username,omitempty"`
+	Path         string   `json:"path,omitempty"`
+	Transfer     string   `json:"transfer,omitempty"`
+	Address      string   `json:"address,omitempty"` // host:port
+	Limit        int      `json:"limit,omitempty"`   // History only: newest N messages
+}
+
+// Response answers a Request, or carries an event when Event is set
+type Response struct {
+	ID     uint64          `json:"id,omitempty"`
+	Event  string          `json:"event,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Presence is our own availability as the daemon reports it
+type Presence struct {
+	Presence string `json:"presence"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// CommandResult answers a plugin slash command
+type CommandResult struct {
+	Handled bool   `json:"handled"` // False if no plugin has the command
+	Output  string `json:"output,omitempty"`
+}
+
+// MessageView is a message with what MessageHistory applied to it
+// Reactions, edits and deletes are local state that plain JSON leaves out
+type MessageView struct {
+	*chat.Message
+	Text      string              `json:"text"`
+	Reactions map[string][]string `json:"reactions,omitempty"`
+	Edited    bool                `json:"edited,omitempty"`
+	Deleted   bool                `json:"deleted,omitempty"`
+}
+
+// NewMessageView wraps a message for the wire
+func NewMessageView(msg *chat.Message) *MessageView {
+	if msg == nil {
+		return nil
+	}
+	return &MessageView{
+		Message:   msg,
+		Text:      msg.Text(),
+		Reactions: msg.Reactions,
+		Edited:    msg.IsEdited(),
+		Deleted:   msg.Deleted,
+	}
+}
+
+// NewMessageViews wraps a list of messages for the wire
+func NewMessageViews(messages []*chat.Message) []*MessageView {
+	views := make([]*MessageView, 0, len(messages))
+	for _, msg := range messages {
+		views = append(views, NewMessageView(msg))
+	}
+	return views
+}
+
+// message rebuilds a chat message whose Text, IsEdited and Reactions match the daemon's
+func (v *MessageView) message() *chat.Message {
+	if v == nil || v.Message == nil {
+		return nil
+	}
+	msg := v.Message
+	msg.Reactions = v.Reactions
+	msg.Deleted = v.Deleted
+	if v.Edited {
+		msg.Edits = []*chat.Message{{Content: v.Text}} // Only the latest version matters here
+	}
+	return msg
+}
+
+// messages rebuilds a list of chat messages
+func messages(views []*MessageView) []*chat.Message {
+	result := make([]*chat.Message, 0, len(views))
+	for _, view := range views {
+		if msg := view.message(); msg != nil {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
@@ -0,0 +1,414 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/chat"
+	"p2pchat/pkg/logger"
+)
+
+const (
+	// sessionBuffer is how many responses and events may queue for a slow client
+	sessionBuffer = 256
+
+	// dialTimeout bounds how long we wait for a daemon that might be stuck
+	dialTimeout = time.Second
+)
+
+// Server runs the control socket of a headless chat service
+// Anyone who can open the socket can chat as us, so it is only accessible to our user
+type Server struct {
+	chatService *chat.ChatService
+	path        string
+	listener    net.Listener
+
+	// Clients that asked for message events
+	sessions     map[*session]bool
+	sessionMutex sync.Mutex
+
+	// Lifecycle
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// session is one connected client
+type session struct {
+	conn       net.Conn
+	outgoing   chan *Response
+	subscribed bool
+}
+
+// NewServer creates a control socket server for chatService at path
+func NewServer(chatService *chat.ChatService, path string) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		chatService: chatService,
+		path:        path,
+		sessions:    make(map[*session]bool),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Path returns where the control socket lives
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Start listens on the control socket and starts forwarding chat messages to subscribers
+func (s *Server) Start() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := removeStaleSocket(s.path); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.path, err)
+	}
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	s.listener = listener
+
+	s.wg.Add(2)
+	go s.acceptLoop()
+	go s.forwardMessages()
+
+	logger.Debug("🔌 Control socket listening on %s", s.path)
+	return nil
+}
+
+// Stop closes the control socket and disconnects every client
+func (s *Server) Stop() error {
+	s.cancel()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close() // Also removes the socket file
+	}
+
+	s.sessionMutex.Lock()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.sessionMutex.Unlock()
+
+	s.wg.Wait()
+	logger.Debug("✅ Control socket closed")
+	return err
+}
+
+// removeStaleSocket clears a socket left behind by a daemon that didn't shut down cleanly
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, dialTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("a daemon is already running on %s", path)
+	}
+
+	logger.Debug("🧹 Removing stale control socket %s", path)
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
+
+// acceptLoop hands every new client its own session
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				return
+			default:
+				logger.Error("❌ Control socket accept failed: %v", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+		}
+
+		sess := &session{conn: conn, outgoing: make(chan *Response, sessionBuffer)}
+		s.sessionMutex.Lock()
+		s.sessions[sess] = true
+		s.sessionMutex.Unlock()
+
+		s.wg.Add(2)
+		go s.readLoop(sess)
+		go s.writeLoop(sess)
+	}
+}
+
+// readLoop answers one client's requests in order until they hang up
+func (s *Server) readLoop(sess *session) {
+	defer s.wg.Done()
+	defer s.closeSession(sess)
+
+	decoder := json.NewDecoder(sess.conn)
+	for {
+		var req Request
+		if err := decoder.Decode(&req); err != nil {
+			if err != io.EOF && s.ctx.Err() == nil {
+				logger.Debug("🔌 Control client went away: %v", err)
+			}
+			return
+		}
+
+		resp := &Response{ID: req.ID}
+		result, err := s.handle(sess, &req)
+		if err != nil {
+			resp.Error = err.Error()
+		} else if result != nil {
+			data, err := json.Marshal(result)
+			if err != nil {
+				resp.Error = fmt.Sprintf("failed to encode result: %v", err)
+			} else {
+				resp.Result = data
+			}
+		}
+
+		select {
+		case sess.outgoing <- resp:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// writeLoop writes queued responses and events to one client
+func (s *Server) writeLoop(sess *session) {
+	defer s.wg.Done()
+
+	encoder := json.NewEncoder(sess.conn)
+	for resp := range sess.outgoing {
+		if err := encoder.Encode(resp); err != nil {
+			sess.conn.Close() // readLoop notices and cleans up
+			for range sess.outgoing {
+				// Drain so nobody blocks on a dead client
+			}
+			return
+		}
+	}
+}
+
+// closeSession forgets a client once it disconnected
+func (s *Server) closeSession(sess *session) {
+	s.sessionMutex.Lock()
+	delete(s.sessions, sess)
+	s.sessionMutex.Unlock()
+
+	sess.conn.Close()
+	close(sess.outgoing)
+}
+
+// forwardMessages pushes everything the chat service receives to subscribed clients
+// The daemon reads the message channel even without subscribers, it would fill up otherwise
+func (s *Server) forwardMessages() {
+	defer s.wg.Done()
+
+	incoming := s.chatService.GetMessages()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case msg, ok := <-incoming:
+			if !ok {
+				return // Chat service stopped
+			}
+			s.publish(msg)
+		}
+	}
+}
+
+// publish sends a message event to every subscribed client
+func (s *Server) publish(msg *chat.Message) {
+	data, err := json.Marshal(NewMessageView(msg))
+	if err != nil {
+		logger.Error("❌ Failed to encode %s event: %v", msg.Type, err)
+		return
+	}
+	event := &Response{Event: EventMessage, Result: data}
+
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	for sess := range s.sessions {
+		if !sess.subscribed {
+			continue
+		}
+		select {
+		case sess.outgoing <- event:
+		default:
+			logger.Error("⚠️ Control client is too slow, dropping %s event", msg.Type)
+		}
+	}
+}
+
+// handle runs one request against the chat service
+func (s *Server) handle(sess *session, req *Request) (any, error) {
+	cs := s.chatService
+	p := req.Params
+
+	switch req.Method {
+	case MethodStatus:
+		return cs.GetStatus(), nil
+	case MethodPeers:
+		return cs.GetConnectedPeers(), nil
+	case MethodResolve:
+		return cs.ResolvePeer(p.Peer)
+	case MethodConnect:
+		return nil, cs.ConnectTo(p.Address)
+
+	case MethodSend:
+		switch {
+		case p.ReplyTo != "":
+			return nil, cs.SendReply(p.ReplyTo, p.Content)
+		case p.Peer != "":
+			target, err := cs.ResolvePeer(p.Peer)
+			if err != nil {
+				return nil, err
+			}
+			return nil, cs.SendDirect(target.PeerID, p.Content)
+		default:
+			roomID, err := s.roomID(p.Room)
+			if err != nil {
+				return nil, err
+			}
+			return nil, cs.SendRoomMessage(roomID, p.Content)
+		}
+
+	case MethodHistory:
+		var history []*chat.Message
+		if p.Peer != "" {
+			history = cs.GetDirectHistory(s.peerID(p.Peer))
+		} else {
+			roomID, err := s.roomID(p.Room)
+			if err != nil {
+				return nil, err
+			}
+			history = cs.GetRoomHistory(roomID)
+		}
+		if p.Limit > 0 && len(history) > p.Limit {
+			history = history[len(history)-p.Limit:]
+		}
+		return NewMessageViews(history), nil
+
+	case MethodMessage:
+		return NewMessageView(cs.GetMessage(p.MessageID)), nil
+	case MethodReplies:
+		return NewMessageViews(cs.GetReplies(p.MessageID)), nil
+	case MethodThread:
+		return NewMessageViews(cs.GetThread(p.MessageID)), nil
+	case MethodDelivery:
+		return cs.GetDeliveryState(p.MessageID), nil
+	case MethodMarkRead:
+		cs.MarkRead(p.MessageIDs...)
+		return nil, nil
+	case MethodReact:
+		return nil, cs.React(p.MessageID, p.Emoji)
+	case MethodEdit:
+		return nil, cs.EditMessage(p.MessageID, p.Content)
+	case MethodDelete:
+		return nil, cs.DeleteMessage(p.MessageID)
+
+	case MethodRooms:
+		return cs.GetJoinedRooms(), nil
+	case MethodMembers:
+		return cs.GetRoomMembers(p.Room), nil
+	case MethodJoin:
+		return cs.JoinRoom(p.Room)
+	case MethodPart:
+		return cs.PartRoom(p.Room)
+	case MethodDirects:
+		return cs.GetDirectConversations(), nil
+
+	case MethodTyping:
+		return cs.GetTyping(), nil
+	case MethodNotify:
+		cs.NotifyTyping(p.Conversation)
+		return nil, nil
+	case MethodPresence:
+		presence, reason := cs.GetPresence()
+		return Presence{Presence: string(presence), Reason: reason}, nil
+	case MethodSetPresence:
+		return nil, cs.SetPresence(peer.Presence(p.Presence), p.Reason)
+	case MethodNick:
+		return nil, cs.ChangeUsername(p.Username)
+
+	case MethodFiles:
+		return cs.GetTransfers(), nil
+	case MethodSendFile:
+		return cs.SendFile(p.Peer, p.Path)
+	case MethodAccept:
+		return nil, cs.AcceptFile(p.Transfer)
+	case MethodReject:
+		return nil, cs.RejectFile(p.Transfer)
+	case MethodCancel:
+		return nil, cs.CancelFile(p.Transfer)
+
+	case MethodCommand:
+		handled, output, err := cs.RunCommand(p.Conversation, p.Content)
+		if err != nil {
+			return nil, err
+		}
+		return CommandResult{Handled: handled, Output: output}, nil
+	case MethodCommands:
+		return cs.GetCommands(), nil
+
+	case MethodSubscribe:
+		s.sessionMutex.Lock()
+		sess.subscribed = true
+		s.sessionMutex.Unlock()
+		return nil, nil
+
+	default:
+		return nil, fmt.Errorf("unknown method %q", req.Method)
+	}
+}
+
+// roomID normalizes a room name, defaulting to the default room
+func (s *Server) roomID(name string) (string, error) {
+	if name == "" {
+		return chat.DefaultRoom, nil
+	}
+	return chat.NormalizeRoomName(name)
+}
+
+// peerID finds the peer a DM history request means
+// Peers we only have history with may be offline, so usernames are looked up there too
+func (s *Server) peerID(name string) string {
+	if p, err := s.chatService.ResolvePeer(name); err == nil {
+		return p.PeerID
+	}
+	for peerID, username := range s.chatService.GetDirectConversations() {
+		if username == name {
+			return peerID
+		}
+	}
+	return name
+}
@@ -0,0 +1,64 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"p2pchat/internal/peer"
+)
+
+// Backend is one way of announcing ourselves and hearing other peers on the LAN
+// DiscoveryService signs the announcements and runs every backend it has, the
+// peers they find all end up in the same PeerRegistry
+type Backend interface {
+	// Source is what peers found by this backend are marked with
+	Source() peer.Source
+
+	Start() error
+	Stop() error
+
+	// Send announces a signed message, a leave message says goodbye
+	Send(msg *DiscoveryMessage) error
+
+	// ReceiveWithTimeout waits for the next announcement from another peer
+	ReceiveWithTimeout(timeout time.Duration) (*DiscoveryMessage, *net.UDPAddr, error)
+
+	// GetLocalAddr is the address we send from, once started
+	GetLocalAddr() *net.UDPAddr
+}
+
+const (
+	BackendMulticast = "multicast" // Our own JSON beacons on a multicast group
+	BackendMDNS      = "mdns"      // DNS-SD records over mDNS, visible to avahi-browse and friends
+	BackendBroadcast = "broadcast" // Multicast's beacons sent to each subnet's broadcast address
+
+	DefaultBackends = BackendMulticast
+)
+
+// BackendNames lists the backends -discovery accepts
+func BackendNames() []string {
+	return []string{BackendMulticast, BackendMDNS, BackendBroadcast}
+}
+
+// ParseBackends checks a comma separated list like "multicast,mdns", duplicates are dropped
+func ParseBackends(list string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if name != BackendMulticast && name != BackendMDNS && name != BackendBroadcast {
+			return nil, fmt.Errorf("unknown discovery backend %q (available: %s)", name, strings.Join(BackendNames(), ", "))
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no discovery backend given (available: %s)", strings.Join(BackendNames(), ", "))
+	}
+	return names, nil
+}
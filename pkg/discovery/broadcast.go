@@ -0,0 +1,183 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"syscall"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/logger"
+)
+
+// Some networks (and some containers) allow subnet broadcast but refuse
+// multicast group joins. BroadcastService sends the same JSON beacons as
+// multicast to the directed broadcast address of every interface, e.g.
+// 192.168.1.255 for 192.168.1.7/24. DiscoveryService falls back to it on its
+// own when joining the multicast group fails, or use -discovery broadcast.
+
+// BroadcastService handles UDP broadcast for peer discovery
+type BroadcastService struct {
+	port      int
+	conn      *net.UDPConn
+	localAddr *net.UDPAddr
+
+	interfaces []string // Only broadcast on these, nil for every interface
+}
+
+// NewBroadcastService creates a broadcast backend that sends and listens on port
+func NewBroadcastService(port int) (*BroadcastService, error) {
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid broadcast port %d", port)
+	}
+	return &BroadcastService{port: port}, nil
+}
+
+// Source marks peers found through broadcast beacons
+func (bs *BroadcastService) Source() peer.Source {
+	return peer.SourceBroadcast
+}
+
+// Start begins listening for broadcast messages
+func (bs *BroadcastService) Start() error {
+	config := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				// Other instances on this machine listen on the same port
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+				if sockErr == nil {
+					sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+				}
+			})
+			return errors.Join(err, sockErr)
+		},
+	}
+
+	conn, err := config.ListenPacket(context.Background(), "udp4", fmt.Sprintf(":%d", bs.port))
+	if err != nil {
+		return fmt.Errorf("failed to listen for broadcasts: %w", err)
+	}
+	bs.conn = conn.(*net.UDPConn)
+	bs.localAddr = bs.conn.LocalAddr().(*net.UDPAddr)
+
+	logger.Debug("📢 Broadcast service listening on %s", bs.localAddr)
+	return nil
+}
+
+// Stop closes the broadcast connection
+func (bs *BroadcastService) Stop() error {
+	if bs.conn != nil {
+		err := bs.conn.Close()
+		bs.conn = nil // Prevent double-close
+		return err
+	}
+	return nil
+}
+
+// Send broadcasts a message on every interface that has a broadcast address
+func (bs *BroadcastService) Send(message *DiscoveryMessage) error {
+	if bs.conn == nil {
+		return fmt.Errorf("broadcast service not started")
+	}
+
+	data, err := encodeBeacon(message)
+	if err != nil {
+		return err
+	}
+
+	targets := broadcastAddrs(bs.interfaces)
+	if len(targets) == 0 {
+		return fmt.Errorf("no interface with a broadcast address")
+	}
+
+	// One unreachable interface shouldn't keep the others quiet
+	var sendErr error
+	sent := 0
+	for _, ip := range targets {
+		if _, err := bs.conn.WriteToUDP(data, &net.UDPAddr{IP: ip, Port: bs.port}); err != nil {
+			sendErr = fmt.Errorf("failed to send broadcast to %s: %w", ip, err)
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return sendErr
+	}
+
+	logger.Debug("📤 Sent: %s (%d bytes) to %d broadcast addresses", message.String(), len(data), sent)
+	return nil
+}
+
+// ReceiveWithTimeout listens for incoming discovery messages with custom timeout
+func (bs *BroadcastService) ReceiveWithTimeout(timeout time.Duration) (*DiscoveryMessage, *net.UDPAddr, error) {
+	if bs.conn == nil {
+		return nil, nil, fmt.Errorf("broadcast service not started")
+	}
+	return receiveBeacon(bs.conn, timeout)
+}
+
+// GetLocalAddr returns local UDP address
+func (bs *BroadcastService) GetLocalAddr() *net.UDPAddr {
+	return bs.localAddr
+}
+
+// broadcastAddrs returns the directed broadcast address of every IPv4 interface that is up
+// Interfaces come and go (Wi-Fi, VPNs), so this is looked up for every beacon
+// names limits it to those interfaces, nil means all of them
+func broadcastAddrs(names []string) []net.IP {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var addrs []net.IP
+	seen := make(map[string]bool)
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		if len(names) > 0 && !slices.Contains(names, iface.Name) {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range ifaceAddrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ip := directedBroadcast(ipNet); ip != nil && !seen[ip.String()] {
+				seen[ip.String()] = true
+				addrs = append(addrs, ip)
+			}
+		}
+	}
+	return addrs
+}
+
+// directedBroadcast turns 192.168.1.7/24 into 192.168.1.255
+// Point-to-point subnets (/31, /32) and IPv6 have no broadcast address
+func directedBroadcast(ipNet *net.IPNet) net.IP {
+	ip, mask := ipNet.IP.To4(), ipNet.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:] // An IPv4 mask in IPv6 form
+	}
+	if ip == nil || len(mask) != net.IPv4len {
+		return nil
+	}
+	if ones, _ := mask.Size(); ones >= 31 {
+		return nil
+	}
+
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = ip[i] | ^mask[i]
+	}
+	return broadcast
+}
@@ -0,0 +1,119 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/identity"
+)
+
+// failingBackend stands in for a multicast join the network refuses
+type failingBackend struct{ *MulticastService }
+
+func (failingBackend) Start() error { return fmt.Errorf("setsockopt: no such device") }
+
+// freeUDPPort finds a port nobody listens on
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestDirectedBroadcast(t *testing.T) {
+	for cidr, want := range map[string]string{
+		"192.168.1.7/24": "192.168.1.255",
+		"10.1.2.3/8":     "10.255.255.255",
+		"172.16.5.9/20":  "172.16.15.255",
+		"10.0.0.1/31":    "<nil>",
+		"10.0.0.1/32":    "<nil>",
+		"fd00::1/64":     "<nil>",
+	} {
+		ip, ipNet, _ := net.ParseCIDR(cidr)
+		ipNet.IP = ip
+		if got := directedBroadcast(ipNet).String(); got != want {
+			t.Errorf("%s: expected %s, got %s", cidr, want, got)
+		}
+	}
+}
+
+func TestFallbackToBroadcast(t *testing.T) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	port := freeUDPPort(t)
+	ds, err := NewDiscoveryService(id, "alice", 8080, fmt.Sprintf("224.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	ds.backends = []Backend{failingBackend{ds.backends[0].(*MulticastService)}}
+
+	if err := ds.Start(); err != nil {
+		// Without any broadcast capable interface there is nothing to fall back to
+		t.Skipf("Broadcast unavailable here: %v", err)
+	}
+	defer ds.Stop()
+
+	if len(ds.backends) != 1 || ds.backends[0].Source() != peer.SourceBroadcast {
+		t.Fatalf("Expected broadcast to replace multicast, got %v", ds.backends)
+	}
+	if addr := ds.backends[0].GetLocalAddr(); addr.Port != port {
+		t.Errorf("Expected broadcast on the multicast group's port %d, got %s", port, addr)
+	}
+}
+
+func TestBroadcastBeacons(t *testing.T) {
+	if len(broadcastAddrs(nil)) == 0 {
+		t.Skip("No interface with a broadcast address")
+	}
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	port := freeUDPPort(t)
+	sender, _ := NewBroadcastService(port)
+	receiver, _ := NewBroadcastService(port)
+	for _, bs := range []*BroadcastService{sender, receiver} {
+		if err := bs.Start(); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		defer bs.Stop()
+	}
+
+	msg := NewAnnounceMessage(id.PeerID(), "alice", 8080)
+	msg.Sign(id)
+	if err := sender.Send(msg); err != nil {
+		t.Skipf("Broadcast not allowed here: %v", err)
+	}
+
+	// Both instances share the port, each hears the beacon
+	got, from, err := receiver.ReceiveWithTimeout(2 * time.Second)
+	if err != nil {
+		t.Fatalf("No beacon received: %v", err)
+	}
+	if got.PeerID != id.PeerID() || got.Verify() != nil {
+		t.Errorf("Expected alice's signed beacon, got %+v", got)
+	}
+
+	// The registry takes it like a multicast beacon
+	registry := NewPeerRegistry()
+	if err := registry.AddOrUpdatePeer(got, from, sender.Source(), ArrivalInterface(from)); err != nil {
+		t.Fatalf("AddOrUpdatePeer failed: %v", err)
+	}
+	if peers := registry.GetAllPeers(); len(peers) != 1 || peers[0].Source != peer.SourceBroadcast || peers[0].Address.Port != 8080 {
+		t.Errorf("Unexpected registry entry %+v", peers)
+	}
+
+	// Oversized beacons are refused, like on multicast
+	msg.PresenceReason = string(make([]byte, MaxMessageSize))
+	if err := sender.Send(msg); err == nil {
+		t.Error("Expected a beacon over MaxMessageSize to be refused")
+	}
+}
@@ -0,0 +1,320 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Just enough of the DNS wire format (RFC 1035) for mDNS: questions, and
+// PTR, SRV, TXT, A and AAAA records. We never compress names we write, but
+// other responders (avahi, Bonjour) do, so parsing follows pointers.
+
+const (
+	dnsTypeA    uint16 = 1
+	dnsTypePTR  uint16 = 12
+	dnsTypeTXT  uint16 = 16
+	dnsTypeAAAA uint16 = 28
+	dnsTypeSRV  uint16 = 33
+	dnsTypeANY  uint16 = 255
+
+	dnsClassIN uint16 = 1
+
+	// mDNS reuses the top bit of the class: "flush your cache" on records, "answer me directly" on questions
+	dnsCacheFlush uint16 = 0x8000
+
+	dnsFlagResponse       uint16 = 0x8000
+	dnsFlagAuthoritative  uint16 = 0x0400
+	dnsMaxLabel                  = 63
+	dnsMaxName                   = 255
+	dnsMaxCompressionHops        = 16
+)
+
+var errDNSTruncated = errors.New("truncated DNS message")
+
+// dnsQuestion asks for records of one name and type
+type dnsQuestion struct {
+	Name  string // Absolute, with the trailing dot
+	Type  uint16
+	Class uint16
+}
+
+// dnsRecord is one resource record, only the fields of its type are set
+type dnsRecord struct {
+	Name  string
+	Type  uint16
+	Class uint16 // Including dnsCacheFlush
+	TTL   uint32 // Seconds, 0 means the record is going away
+
+	Target string   // PTR and SRV
+	Port   uint16   // SRV
+	Text   []string // TXT
+	IP     net.IP   // A and AAAA
+}
+
+// dnsMessage is a query or a response
+type dnsMessage struct {
+	ID        uint16
+	Flags     uint16
+	Questions []dnsQuestion
+	Answers   []dnsRecord
+	Extra     []dnsRecord // Authority and additional sections, mDNS puts useful records there too
+}
+
+// isResponse tells answers from questions
+func (m *dnsMessage) isResponse() bool {
+	return m.Flags&dnsFlagResponse != 0
+}
+
+// records returns answers and extra records together
+func (m *dnsMessage) records() []dnsRecord {
+	return append(append([]dnsRecord(nil), m.Answers...), m.Extra...)
+}
+
+// pack encodes the message, answers go in the answer section and extra records in the additional one
+func (m *dnsMessage) pack() ([]byte, error) {
+	buf := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(buf[0:], m.ID)
+	binary.BigEndian.PutUint16(buf[2:], m.Flags)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(buf[10:], uint16(len(m.Extra)))
+
+	var err error
+	for _, q := range m.Questions {
+		if buf, err = appendName(buf, q.Name); err != nil {
+			return nil, err
+		}
+		buf = binary.BigEndian.AppendUint16(buf, q.Type)
+		buf = binary.BigEndian.AppendUint16(buf, q.Class)
+	}
+	for _, r := range m.records() {
+		if buf, err = appendRecord(buf, r); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendRecord encodes one resource record
+func appendRecord(buf []byte, r dnsRecord) ([]byte, error) {
+	buf, err := appendName(buf, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	buf = binary.BigEndian.AppendUint16(buf, r.Type)
+	buf = binary.BigEndian.AppendUint16(buf, r.Class)
+	buf = binary.BigEndian.AppendUint32(buf, r.TTL)
+
+	// Leave room for the length, fill it in once the data is written
+	lengthAt := len(buf)
+	buf = append(buf, 0, 0)
+
+	switch r.Type {
+	case dnsTypePTR:
+		buf, err = appendName(buf, r.Target)
+	case dnsTypeSRV:
+		buf = binary.BigEndian.AppendUint16(buf, 0) // Priority
+		buf = binary.BigEndian.AppendUint16(buf, 0) // Weight
+		buf = binary.BigEndian.AppendUint16(buf, r.Port)
+		buf, err = appendName(buf, r.Target)
+	case dnsTypeTXT:
+		if len(r.Text) == 0 {
+			buf = append(buf, 0) // An empty TXT record still holds one empty string
+		}
+		for _, s := range r.Text {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("TXT string too long: %d bytes (max 255)", len(s))
+			}
+			buf = append(buf, byte(len(s)))
+			buf = append(buf, s...)
+		}
+	case dnsTypeA:
+		ip := r.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("A record with non-IPv4 address %s", r.IP)
+		}
+		buf = append(buf, ip...)
+	case dnsTypeAAAA:
+		ip := r.IP.To16()
+		if ip == nil {
+			return nil, fmt.Errorf("AAAA record with invalid address %s", r.IP)
+		}
+		buf = append(buf, ip...)
+	default:
+		return nil, fmt.Errorf("can't encode DNS record type %d", r.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	binary.BigEndian.PutUint16(buf[lengthAt:], uint16(len(buf)-lengthAt-2))
+	return buf, nil
+}
+
+// appendName encodes an absolute name like "_p2pchat._tcp.local." label by label
+func appendName(buf []byte, name string) ([]byte, error) {
+	if len(name) > dnsMaxName {
+		return nil, fmt.Errorf("DNS name too long: %q", name)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue // The root
+		}
+		if len(label) > dnsMaxLabel {
+			return nil, fmt.Errorf("DNS label too long: %q", label)
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0), nil
+}
+
+// parseDNSMessage decodes a packet, records of types we don't know are skipped
+func parseDNSMessage(data []byte) (*dnsMessage, error) {
+	if len(data) < 12 {
+		return nil, errDNSTruncated
+	}
+	m := &dnsMessage{
+		ID:    binary.BigEndian.Uint16(data[0:]),
+		Flags: binary.BigEndian.Uint16(data[2:]),
+	}
+	questions := int(binary.BigEndian.Uint16(data[4:]))
+	answers := int(binary.BigEndian.Uint16(data[6:]))
+	extra := int(binary.BigEndian.Uint16(data[8:])) + int(binary.BigEndian.Uint16(data[10:]))
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		name, next, err := readName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(data) {
+			return nil, errDNSTruncated
+		}
+		m.Questions = append(m.Questions, dnsQuestion{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(data[next:]),
+			Class: binary.BigEndian.Uint16(data[next+2:]),
+		})
+		offset = next + 4
+	}
+
+	for i := 0; i < answers+extra; i++ {
+		record, next, known, err := readRecord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+		if !known {
+			continue
+		}
+		if i < answers {
+			m.Answers = append(m.Answers, record)
+		} else {
+			m.Extra = append(m.Extra, record)
+		}
+	}
+	return m, nil
+}
+
+// readRecord decodes the record at offset, known is false for types we skip
+func readRecord(data []byte, offset int) (record dnsRecord, next int, known bool, err error) {
+	record.Name, offset, err = readName(data, offset)
+	if err != nil {
+		return record, 0, false, err
+	}
+	if offset+10 > len(data) {
+		return record, 0, false, errDNSTruncated
+	}
+	record.Type = binary.BigEndian.Uint16(data[offset:])
+	record.Class = binary.BigEndian.Uint16(data[offset+2:])
+	record.TTL = binary.BigEndian.Uint32(data[offset+4:])
+	length := int(binary.BigEndian.Uint16(data[offset+8:]))
+	start, end := offset+10, offset+10+length
+	if end > len(data) {
+		return record, 0, false, errDNSTruncated
+	}
+	rdata := data[start:end]
+
+	switch record.Type {
+	case dnsTypePTR:
+		record.Target, _, err = readName(data, start)
+	case dnsTypeSRV:
+		if length < 7 {
+			return record, 0, false, errDNSTruncated
+		}
+		record.Port = binary.BigEndian.Uint16(rdata[4:])
+		record.Target, _, err = readName(data, start+6)
+	case dnsTypeTXT:
+		for i := 0; i < len(rdata); {
+			size := int(rdata[i])
+			if i+1+size > len(rdata) {
+				return record, 0, false, errDNSTruncated
+			}
+			if size > 0 {
+				record.Text = append(record.Text, string(rdata[i+1:i+1+size]))
+			}
+			i += 1 + size
+		}
+	case dnsTypeA, dnsTypeAAAA:
+		if (record.Type == dnsTypeA && length != net.IPv4len) || (record.Type == dnsTypeAAAA && length != net.IPv6len) {
+			return record, 0, false, fmt.Errorf("bad address length %d", length)
+		}
+		record.IP = append(net.IP(nil), rdata...)
+	default:
+		return record, end, false, nil
+	}
+	if err != nil {
+		return record, 0, false, err
+	}
+	return record, end, true, nil
+}
+
+// readName decodes a possibly compressed name and returns the offset right after it
+func readName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1 // Where parsing continues, set at the first pointer
+	length := 0
+
+	for hops := 0; ; {
+		if offset >= len(data) {
+			return "", 0, errDNSTruncated
+		}
+		size := int(data[offset])
+		switch {
+		case size == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+
+		case size&0xC0 == 0xC0:
+			if offset+1 >= len(data) {
+				return "", 0, errDNSTruncated
+			}
+			if hops++; hops > dnsMaxCompressionHops {
+				return "", 0, errors.New("too many DNS compression pointers")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(data[offset:]) & 0x3FFF)
+
+		case size > dnsMaxLabel:
+			return "", 0, fmt.Errorf("bad DNS label length %d", size)
+
+		default:
+			if offset+1+size > len(data) {
+				return "", 0, errDNSTruncated
+			}
+			if length += size + 1; length > dnsMaxName {
+				return "", 0, errors.New("DNS name too long")
+			}
+			labels = append(labels, string(data[offset+1:offset+1+size]))
+			offset += 1 + size
+		}
+	}
+}
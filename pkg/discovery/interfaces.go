@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Laptops have Wi-Fi, docker0 and a VPN tunnel or two. Left to itself the
+// kernel sends multicast out of one of them, often not the one the other
+// peers are on. By default discovery joins the group on every interface that
+// can multicast and beacons on each, -interface picks them by name.
+
+// ParseInterfaces splits a comma separated list like "eth0,wlan0", duplicates are dropped
+// An empty list means every interface, names are checked once the backends are built
+func ParseInterfaces(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// multicastInterfaces lists the interfaces that are up, do multicast and have an address of the family
+// names picks interfaces by name, loopback included, nil means every one but loopback
+func multicastInterfaces(names []string, ipv6 bool) ([]net.Interface, error) {
+	var candidates []net.Interface
+	if len(names) == 0 {
+		interfaces, err := net.Interfaces()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list interfaces: %w", err)
+		}
+		for _, iface := range interfaces {
+			// Beacons looped back on the other interfaces already reach this machine
+			if iface.Flags&net.FlagLoopback == 0 {
+				candidates = append(candidates, iface)
+			}
+		}
+	} else {
+		for _, name := range names {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return nil, fmt.Errorf("unknown interface %s: %w", name, err)
+			}
+			candidates = append(candidates, *iface)
+		}
+	}
+
+	var usable []net.Interface
+	for _, iface := range candidates {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || interfaceIP(&iface, ipv6) == nil {
+			continue
+		}
+		usable = append(usable, iface)
+	}
+	return usable, nil
+}
+
+// interfaceIP returns an address of the family on iface, nil if it has none
+// For IPv6 the link-local one comes first, it's what ff02:: beacons are sent from
+func interfaceIP(iface *net.Interface, ipv6 bool) net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var found net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		if !ipv6 {
+			return ipNet.IP.To4()
+		}
+		if ipNet.IP.IsLinkLocalUnicast() {
+			return ipNet.IP
+		}
+		if found == nil {
+			found = ipNet.IP
+		}
+	}
+	return found
+}
+
+// ArrivalInterface names the interface a datagram from addr came in on, "" if we can't tell
+// Link-local senders carry it as their zone, anyone else is on the subnet of one of ours
+func ArrivalInterface(addr *net.UDPAddr) string {
+	if addr == nil {
+		return ""
+	}
+	if addr.Zone != "" {
+		return addr.Zone
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, ifaceAddr := range addrs {
+			if ipNet, ok := ifaceAddr.(*net.IPNet); ok && ipNet.Contains(addr.IP) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"p2pchat/pkg/identity"
+)
+
+// firstMulticastInterface returns an interface that can do IPv4 multicast, or skips
+func firstMulticastInterface(t *testing.T) net.Interface {
+	interfaces, err := multicastInterfaces(nil, false)
+	if err != nil || len(interfaces) == 0 {
+		t.Skipf("No interface can do IPv4 multicast here (%v)", err)
+	}
+	return interfaces[0]
+}
+
+func TestArrivalInterface(t *testing.T) {
+	if got := ArrivalInterface(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Zone: "wlan0"}); got != "wlan0" {
+		t.Errorf("Expected the zone to name the interface, got %q", got)
+	}
+	if got := ArrivalInterface(&net.UDPAddr{IP: net.IPv4(203, 0, 113, 9)}); got != "" {
+		t.Errorf("Expected no interface for a sender on none of our subnets, got %q", got)
+	}
+
+	// Another host on one of our subnets came in on that interface
+	iface := firstMulticastInterface(t)
+	neighbour := interfaceIP(&iface, false).To4()
+	neighbour = net.IPv4(neighbour[0], neighbour[1], neighbour[2], neighbour[3]^1)
+	if got := ArrivalInterface(&net.UDPAddr{IP: neighbour}); got != iface.Name {
+		t.Errorf("Expected %s to arrive on %s, got %q", neighbour, iface.Name, got)
+	}
+}
+
+func TestBoundServiceOnlyHearsItsInterface(t *testing.T) {
+	iface := firstMulticastInterface(t)
+	ms, err := NewInterfaceMulticastService(DefaultMulticastAddr, &iface)
+	if err != nil {
+		t.Fatalf("Failed to create multicast service: %v", err)
+	}
+
+	own := interfaceIP(&iface, false)
+	for addr, want := range map[string]bool{
+		own.String():  true,  // Another instance on this machine
+		"203.0.113.9": true,  // On none of our subnets, someone has to take it
+		"127.0.0.1":   false, // Came in on lo, that service takes it
+	} {
+		if got := ms.hears(&net.UDPAddr{IP: net.ParseIP(addr)}); got != want {
+			t.Errorf("hears(%s) on %s: expected %v, got %v", addr, iface.Name, want, got)
+		}
+	}
+
+	if _, err := NewInterfaceMulticastService("[ff02::1%no-such-interface0]:9999", &iface); err == nil {
+		t.Error("Expected a group scoped to another interface to be rejected")
+	}
+}
+
+func TestSetInterfaces(t *testing.T) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	iface := firstMulticastInterface(t)
+
+	group := fmt.Sprintf("224.0.0.1:%d", freeUDPPort(t))
+	ds, err := NewDiscoveryService(id, "alice", 8080, group)
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	ds.SetMulticastAddr6("")
+	before := len(ds.backends)
+
+	if err := ds.SetInterfaces([]string{"no-such-interface0"}); err == nil {
+		t.Error("Expected an unknown interface to be rejected")
+	}
+	if len(ds.backends) != before || ds.interfaces != nil {
+		t.Errorf("A rejected interface list shouldn't change the backends")
+	}
+
+	if err := ds.SetInterfaces([]string{iface.Name}); err != nil {
+		t.Fatalf("SetInterfaces failed: %v", err)
+	}
+	if len(ds.backends) != 1 || ds.backends[0].(*MulticastService).InterfaceName() != iface.Name {
+		t.Fatalf("Expected one multicast backend on %s, got %v", iface.Name, ds.backends)
+	}
+
+	// A second instance bound to the same interface hears our beacon
+	listener, _ := NewInterfaceMulticastService(group, &iface)
+	if err := listener.Start(); err != nil {
+		t.Skipf("Can't join %s on %s: %v", group, iface.Name, err)
+	}
+	defer listener.Stop()
+	if err := ds.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer ds.Stop()
+
+	msg, from, err := listener.ReceiveWithTimeout(2 * time.Second)
+	if err != nil {
+		t.Fatalf("No beacon received: %v", err)
+	}
+	if msg.Verify() != nil {
+		t.Error("Expected a signed beacon")
+	}
+	want := net.JoinHostPort(interfaceIP(&iface, false).String(), "8080")
+	if msg.Address != want {
+		t.Errorf("Expected the beacon to carry %s, got %q", want, msg.Address)
+	}
+	if got := ArrivalInterface(from); got != iface.Name {
+		t.Errorf("Expected the beacon to arrive on %s, got %q", iface.Name, got)
+	}
+}
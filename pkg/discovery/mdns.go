@@ -0,0 +1,411 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/logger"
+)
+
+// The mDNS backend advertises us as a DNS-SD service, so `avahi-browse
+// _p2pchat._tcp` or any Bonjour browser sees who is on the chat:
+//
+//	_p2pchat._tcp.local.                        PTR  alice@<peer id>._p2pchat._tcp.local.
+//	alice@<peer id>._p2pchat._tcp.local.        SRV  <peer id>.local.:8080
+//	alice@<peer id>._p2pchat._tcp.local.        TXT  id=... user=alice port=8080 ts=... pk=... sig=...
+//	<peer id>.local.                            A    192.168.1.7
+//
+// The TXT record carries every signed field of a DiscoveryMessage, so peers
+// found this way are verified exactly like multicast beacons. We announce on
+// every beacon instead of only at startup, the registry expects to hear from
+// peers regularly, and answer queries for the service so browsers that start
+// later see us right away. Probing and known-answer suppression are skipped:
+// instance names contain the peer ID, they don't collide.
+
+const (
+	MDNSAddr        = "224.0.0.251:5353"
+	MDNSServiceType = "_p2pchat._tcp.local."
+
+	mdnsPort        = 5353
+	mdnsServiceList = "_services._dns-sd._udp.local." // Lets `avahi-browse -a` find our service type
+	mdnsTTL         = 120                             // Seconds other caches may keep our records
+	mdnsLegacyTTL   = 10                              // RFC 6762 caps answers to one-shot queriers at 10s
+	mdnsMaxPacket   = 9000
+	mdnsTxtVersion  = "1"
+)
+
+// MDNSService handles DNS-SD announcements over mDNS
+type MDNSService struct {
+	addr      *net.UDPAddr
+	conn      *net.UDPConn
+	localAddr *net.UDPAddr
+
+	mu      sync.Mutex
+	current *DiscoveryMessage // Our latest announcement, queries are answered with it
+	pending []mdnsSighting    // Announcements of a packet that held more than one
+}
+
+// mdnsSighting is an announcement and who sent it
+type mdnsSighting struct {
+	msg  *DiscoveryMessage
+	from *net.UDPAddr
+}
+
+// NewMDNSService creates an mDNS backend on the standard group and port
+func NewMDNSService() (*MDNSService, error) {
+	addr, err := net.ResolveUDPAddr("udp4", MDNSAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mDNS address: %w", err)
+	}
+	return &MDNSService{addr: addr}, nil
+}
+
+// Source marks peers found through their DNS-SD records
+func (ms *MDNSService) Source() peer.Source {
+	return peer.SourceMDNS
+}
+
+// Start joins the mDNS group and asks who is already there
+func (ms *MDNSService) Start() error {
+	// Go sets SO_REUSEADDR, so we share port 5353 with avahi and other chat instances
+	conn, err := net.ListenMulticastUDP("udp4", nil, ms.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for mDNS: %w", err)
+	}
+	ms.conn = conn
+	ms.localAddr = conn.LocalAddr().(*net.UDPAddr)
+
+	rawConn, err := conn.SyscallConn()
+	if err == nil {
+		rawConn.Control(func(fd uintptr) {
+			// Other instances on this machine must hear us too
+			syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+			// RFC 6762 wants 255, receivers may check it to be sure we're on their link
+			syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, 255)
+		})
+	}
+
+	logger.Debug("🔎 mDNS service listening on %s (local: %s)", ms.addr, ms.localAddr)
+
+	query := &dnsMessage{Questions: []dnsQuestion{{Name: MDNSServiceType, Type: dnsTypePTR, Class: dnsClassIN}}}
+	if err := ms.write(query, ms.addr); err != nil {
+		logger.Error("⚠️  Failed to query for mDNS peers: %v", err)
+	}
+	return nil
+}
+
+// Stop closes the mDNS connection
+func (ms *MDNSService) Stop() error {
+	if ms.conn != nil {
+		err := ms.conn.Close()
+		ms.conn = nil // Prevent double-close
+		return err
+	}
+	return nil
+}
+
+// Send publishes our records, or withdraws them for a leave message
+func (ms *MDNSService) Send(message *DiscoveryMessage) error {
+	if ms.conn == nil {
+		return fmt.Errorf("mDNS service not started")
+	}
+
+	ms.mu.Lock()
+	if message.Type == MessageTypeLeave {
+		ms.current = nil // Don't answer queries for someone who left
+	} else {
+		ms.current = message
+	}
+	ms.mu.Unlock()
+
+	records, err := mdnsRecords(message, localIPs(), mdnsTTL)
+	if err != nil {
+		return err
+	}
+	response := &dnsMessage{Flags: dnsFlagResponse | dnsFlagAuthoritative, Answers: records}
+	if err := ms.write(response, ms.addr); err != nil {
+		return err
+	}
+
+	logger.Debug("📤 Sent mDNS: %s", message.String())
+	return nil
+}
+
+// ReceiveWithTimeout waits for another peer's announcement, answering queries on the way
+func (ms *MDNSService) ReceiveWithTimeout(timeout time.Duration) (*DiscoveryMessage, *net.UDPAddr, error) {
+	if ms.conn == nil {
+		return nil, nil, fmt.Errorf("mDNS service not started")
+	}
+	if sighting, ok := ms.nextPending(); ok {
+		return sighting.msg, sighting.from, nil
+	}
+
+	ms.conn.SetReadDeadline(time.Now().Add(timeout))
+	buffer := make([]byte, mdnsMaxPacket)
+	for {
+		n, senderAddr, err := ms.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, nil, fmt.Errorf("read timeout after %v: %w", timeout, err)
+			}
+			return nil, nil, fmt.Errorf("failed to read mDNS packet: %w", err)
+		}
+
+		packet, err := parseDNSMessage(buffer[:n])
+		if err != nil {
+			continue // Not every packet on 5353 is ours to understand
+		}
+		if !packet.isResponse() {
+			ms.answer(packet, senderAddr)
+			continue
+		}
+
+		messages := mdnsAnnouncements(packet)
+		if len(messages) == 0 {
+			continue // Someone else's service
+		}
+
+		ms.mu.Lock()
+		for _, msg := range messages[1:] {
+			ms.pending = append(ms.pending, mdnsSighting{msg: msg, from: senderAddr})
+		}
+		ms.mu.Unlock()
+
+		logger.Debug("📥 Received mDNS: %s from %s (%d bytes)", messages[0].String(), senderAddr, n)
+		return messages[0], senderAddr, nil
+	}
+}
+
+// GetLocalAddr returns local UDP address
+func (ms *MDNSService) GetLocalAddr() *net.UDPAddr {
+	return ms.localAddr
+}
+
+// nextPending pops an announcement left over from an earlier packet
+func (ms *MDNSService) nextPending() (mdnsSighting, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if len(ms.pending) == 0 {
+		return mdnsSighting{}, false
+	}
+	sighting := ms.pending[0]
+	ms.pending = ms.pending[1:]
+	return sighting, true
+}
+
+// answer replies to questions about our service, instance or host
+func (ms *MDNSService) answer(query *dnsMessage, from *net.UDPAddr) {
+	ms.mu.Lock()
+	current := ms.current
+	ms.mu.Unlock()
+
+	var answers []dnsRecord
+	for _, q := range query.Questions {
+		if strings.EqualFold(q.Name, mdnsServiceList) {
+			answers = append(answers, dnsRecord{Name: mdnsServiceList, Type: dnsTypePTR, Class: dnsClassIN, TTL: mdnsTTL, Target: MDNSServiceType})
+		}
+	}
+	if current != nil && asksAbout(query, current) {
+		records, err := mdnsRecords(current, localIPs(), mdnsTTL)
+		if err != nil {
+			logger.Error("⚠️  Failed to answer mDNS query: %v", err)
+			return
+		}
+		answers = append(answers, records...)
+	}
+	if len(answers) == 0 {
+		return
+	}
+
+	response := &dnsMessage{Flags: dnsFlagResponse | dnsFlagAuthoritative, Answers: answers}
+	to := ms.addr
+	if from.Port != mdnsPort {
+		// A one-shot query (dig -p 5353 @224.0.0.251), answer it like a unicast DNS server would
+		response.ID, response.Questions, to = query.ID, query.Questions, from
+		for i := range response.Answers {
+			response.Answers[i].TTL = min(response.Answers[i].TTL, mdnsLegacyTTL)
+			response.Answers[i].Class &^= dnsCacheFlush
+		}
+	}
+	if err := ms.write(response, to); err != nil {
+		logger.Error("⚠️  Failed to answer mDNS query from %s: %v", from, err)
+	}
+}
+
+// asksAbout reports whether a query is for our service type, our instance or our host
+func asksAbout(query *dnsMessage, msg *DiscoveryMessage) bool {
+	for _, q := range query.Questions {
+		for _, name := range []string{MDNSServiceType, mdnsInstance(msg), mdnsHost(msg)} {
+			if strings.EqualFold(q.Name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// write sends a DNS message
+func (ms *MDNSService) write(m *dnsMessage, to *net.UDPAddr) error {
+	data, err := m.pack()
+	if err != nil {
+		return fmt.Errorf("failed to encode mDNS message: %w", err)
+	}
+	if _, err := ms.conn.WriteToUDP(data, to); err != nil {
+		return fmt.Errorf("failed to send mDNS message: %w", err)
+	}
+	return nil
+}
+
+// mdnsInstance names our service instance: "alice@<peer id>._p2pchat._tcp.local."
+func mdnsInstance(msg *DiscoveryMessage) string {
+	// Dots would split the label, and the whole label has to fit in 63 bytes
+	username := strings.ReplaceAll(msg.Username, ".", "_")
+	room := dnsMaxLabel - len(msg.PeerID) - 1
+	for len(username) > room {
+		runes := []rune(username)
+		username = string(runes[:len(runes)-1])
+	}
+	return username + "@" + msg.PeerID + "." + MDNSServiceType
+}
+
+// mdnsHost is the host name our SRV record points at
+func mdnsHost(msg *DiscoveryMessage) string {
+	return msg.PeerID + ".local."
+}
+
+// mdnsRecords turns an announcement into DNS-SD records, leave messages get TTL 0 (goodbye)
+func mdnsRecords(msg *DiscoveryMessage, ips []net.IP, ttl uint32) ([]dnsRecord, error) {
+	if msg.Type == MessageTypeLeave {
+		ttl = 0
+	}
+	if msg.Port <= 0 || msg.Port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", msg.Port)
+	}
+	instance, host := mdnsInstance(msg), mdnsHost(msg)
+
+	records := []dnsRecord{
+		{Name: MDNSServiceType, Type: dnsTypePTR, Class: dnsClassIN, TTL: ttl, Target: instance},
+		{Name: instance, Type: dnsTypeSRV, Class: dnsClassIN | dnsCacheFlush, TTL: ttl, Target: host, Port: uint16(msg.Port)},
+		{Name: instance, Type: dnsTypeTXT, Class: dnsClassIN | dnsCacheFlush, TTL: ttl, Text: mdnsText(msg)},
+	}
+	for _, ip := range ips {
+		recordType := dnsTypeAAAA
+		if ip.To4() != nil {
+			recordType = dnsTypeA
+		}
+		records = append(records, dnsRecord{Name: host, Type: recordType, Class: dnsClassIN | dnsCacheFlush, TTL: ttl, IP: ip})
+	}
+	return records, nil
+}
+
+// mdnsText puts the signed fields of an announcement into TXT strings
+func mdnsText(msg *DiscoveryMessage) []string {
+	text := []string{
+		"txtvers=" + mdnsTxtVersion,
+		"id=" + msg.PeerID,
+		"user=" + msg.Username,
+		"port=" + strconv.Itoa(msg.Port),
+		"ts=" + strconv.FormatInt(msg.Timestamp.UnixNano(), 10),
+	}
+	if msg.Sequence != 0 {
+		text = append(text, "seq="+strconv.FormatUint(msg.Sequence, 10))
+	}
+	if msg.Address != "" {
+		text = append(text, "addr="+msg.Address)
+	}
+	if msg.Presence != "" {
+		text = append(text, "presence="+string(msg.Presence))
+	}
+	if msg.PresenceReason != "" {
+		text = append(text, "reason="+msg.PresenceReason)
+	}
+	return append(text, "pk="+msg.PublicKey, "sig="+msg.Signature)
+}
+
+// mdnsAnnouncements finds our TXT records in a response and turns them back into messages
+// Signatures are checked later, by whoever handles the message
+func mdnsAnnouncements(packet *dnsMessage) []*DiscoveryMessage {
+	var messages []*DiscoveryMessage
+	for _, record := range packet.records() {
+		if record.Type != dnsTypeTXT || !strings.HasSuffix(strings.ToLower(record.Name), "."+MDNSServiceType) {
+			continue
+		}
+		msg, err := mdnsMessage(record)
+		if err != nil {
+			logger.Debug("❓ Ignoring mDNS record %s: %v", record.Name, err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// mdnsMessage rebuilds an announcement from one TXT record
+func mdnsMessage(record dnsRecord) (*DiscoveryMessage, error) {
+	fields := make(map[string]string)
+	for _, s := range record.Text {
+		key, value, _ := strings.Cut(s, "=")
+		fields[strings.ToLower(key)] = value
+	}
+	if fields["txtvers"] != mdnsTxtVersion {
+		return nil, fmt.Errorf("unsupported txtvers %q", fields["txtvers"])
+	}
+
+	port, err := strconv.Atoi(fields["port"])
+	if err != nil {
+		return nil, fmt.Errorf("bad port: %w", err)
+	}
+	nanos, err := strconv.ParseInt(fields["ts"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad timestamp: %w", err)
+	}
+	var sequence uint64
+	if seq, ok := fields["seq"]; ok {
+		if sequence, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return nil, fmt.Errorf("bad sequence: %w", err)
+		}
+	}
+
+	msgType := MessageTypeAnnounce
+	if record.TTL == 0 {
+		msgType = MessageTypeLeave // A goodbye, signed as one so announcements can't be replayed as leaves
+	}
+
+	return &DiscoveryMessage{
+		Type:           msgType,
+		PeerID:         fields["id"],
+		Username:       fields["user"],
+		Address:        fields["addr"],
+		Port:           port,
+		Timestamp:      time.Unix(0, nanos),
+		Sequence:       sequence,
+		Presence:       peer.Presence(fields["presence"]),
+		PresenceReason: fields["reason"],
+		PublicKey:      fields["pk"],
+		Signature:      fields["sig"],
+	}, nil
+}
+
+// localIPs lists the addresses our host record points at, loopback and link-local ones aren't useful to others
+func localIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	return ips
+}
@@ -0,0 +1,156 @@
+package discovery
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/identity"
+)
+
+func TestDNSMessageRoundTrip(t *testing.T) {
+	original := &dnsMessage{
+		ID:        7,
+		Flags:     dnsFlagResponse | dnsFlagAuthoritative,
+		Questions: []dnsQuestion{{Name: MDNSServiceType, Type: dnsTypePTR, Class: dnsClassIN}},
+		Answers: []dnsRecord{
+			{Name: MDNSServiceType, Type: dnsTypePTR, Class: dnsClassIN, TTL: 120, Target: "alice@abc." + MDNSServiceType},
+			{Name: "alice@abc." + MDNSServiceType, Type: dnsTypeSRV, Class: dnsClassIN | dnsCacheFlush, TTL: 120, Target: "abc.local.", Port: 8080},
+			{Name: "alice@abc." + MDNSServiceType, Type: dnsTypeTXT, Class: dnsClassIN | dnsCacheFlush, TTL: 120, Text: []string{"a=1", "b="}},
+		},
+		Extra: []dnsRecord{
+			{Name: "abc.local.", Type: dnsTypeA, Class: dnsClassIN, TTL: 120, IP: net.IPv4(192, 168, 1, 7).To4()},
+			{Name: "abc.local.", Type: dnsTypeAAAA, Class: dnsClassIN, TTL: 120, IP: net.ParseIP("fd00::7")},
+		},
+	}
+
+	data, err := original.pack()
+	if err != nil {
+		t.Fatalf("pack failed: %v", err)
+	}
+	parsed, err := parseDNSMessage(data)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, original) {
+		t.Errorf("Round trip changed the message:\n got  %+v\n want %+v", parsed, original)
+	}
+
+	// Every cut short version is an error, not a panic
+	for i := 0; i < len(data); i++ {
+		if _, err := parseDNSMessage(data[:i]); err == nil {
+			t.Fatalf("Expected a message cut at %d bytes to be rejected", i)
+		}
+	}
+}
+
+func TestDNSCompressedNames(t *testing.T) {
+	// avahi compresses: the PTR target points back at the question's name
+	data := []byte{
+		0, 0, 0x84, 0, 0, 1, 0, 1, 0, 0, 0, 0,
+		8, '_', 'p', '2', 'p', 'c', 'h', 'a', 't', 4, '_', 't', 'c', 'p', 5, 'l', 'o', 'c', 'a', 'l', 0, // offset 12
+		0, 12, 0, 1,
+		0xC0, 12, 0, 12, 0, 1, 0, 0, 0, 120, 0, 7,
+		4, 'b', 'o', 'b', '!', 0xC0, 12,
+	}
+	parsed, err := parseDNSMessage(data)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(parsed.Answers) != 1 || parsed.Answers[0].Name != MDNSServiceType || parsed.Answers[0].Target != "bob!."+MDNSServiceType {
+		t.Errorf("Compressed names were not followed: %+v", parsed.Answers)
+	}
+
+	// A pointer to itself must not hang us
+	loop := []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 12, 0, 12, 0, 1}
+	if _, err := parseDNSMessage(loop); err == nil {
+		t.Error("Expected a compression loop to be rejected")
+	}
+}
+
+func TestMDNSAnnouncementRoundTrip(t *testing.T) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	announce := NewAnnounceMessage(id.PeerID(), "alice.smith", 8080)
+	announce.Presence, announce.PresenceReason = peer.PresenceAway, "lunch"
+	announce.Sign(id)
+
+	records, err := mdnsRecords(announce, []net.IP{net.IPv4(192, 168, 1, 7)}, mdnsTTL)
+	if err != nil {
+		t.Fatalf("mdnsRecords failed: %v", err)
+	}
+	if instance := records[0].Target; instance != "alice_smith@"+id.PeerID()+"."+MDNSServiceType {
+		t.Errorf("Unexpected instance name %s", instance)
+	}
+
+	packet := &dnsMessage{Flags: dnsFlagResponse, Answers: records}
+	data, err := packet.pack()
+	if err != nil {
+		t.Fatalf("pack failed: %v", err)
+	}
+	parsed, err := parseDNSMessage(data)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	messages := mdnsAnnouncements(parsed)
+	if len(messages) != 1 {
+		t.Fatalf("Expected one announcement, got %d", len(messages))
+	}
+	got := messages[0]
+	if err := got.Verify(); err != nil {
+		t.Fatalf("Announcement from TXT doesn't verify: %v", err)
+	}
+	if got.Type != MessageTypeAnnounce || got.Username != "alice.smith" || got.Port != 8080 || got.PresenceReason != "lunch" {
+		t.Errorf("Unexpected announcement %+v", got)
+	}
+
+	// Goodbyes have TTL 0 and are signed as leave messages
+	leave := &DiscoveryMessage{Type: MessageTypeLeave, PeerID: id.PeerID(), Username: "alice.smith", Port: 8080, Timestamp: announce.Timestamp}
+	leave.Sign(id)
+	records, _ = mdnsRecords(leave, nil, mdnsTTL)
+	messages = mdnsAnnouncements(&dnsMessage{Answers: records})
+	if len(messages) != 1 || messages[0].Type != MessageTypeLeave || messages[0].Verify() != nil {
+		t.Errorf("Expected a verified leave message, got %+v", messages)
+	}
+
+	// An announcement turned into a goodbye by a forged TTL doesn't verify
+	records, _ = mdnsRecords(announce, nil, 0)
+	if messages := mdnsAnnouncements(&dnsMessage{Answers: records}); len(messages) != 1 || messages[0].Verify() == nil {
+		t.Error("Expected a replayed announcement with TTL 0 to fail verification")
+	}
+
+	// Other services on the network are none of our business
+	other := dnsRecord{Name: "printer._ipp._tcp.local.", Type: dnsTypeTXT, TTL: 120, Text: []string{"txtvers=1"}}
+	if messages := mdnsAnnouncements(&dnsMessage{Answers: []dnsRecord{other}}); len(messages) != 0 {
+		t.Errorf("Expected foreign TXT records to be ignored, got %+v", messages)
+	}
+}
+
+func TestMDNSInstanceNameFitsALabel(t *testing.T) {
+	msg := &DiscoveryMessage{PeerID: strings.Repeat("a", identity.PeerIDLength), Username: strings.Repeat("é", 40)}
+	label := strings.TrimSuffix(mdnsInstance(msg), "."+MDNSServiceType)
+	if len(label) > dnsMaxLabel {
+		t.Errorf("Instance label is %d bytes, DNS allows %d", len(label), dnsMaxLabel)
+	}
+	if _, err := appendName(nil, mdnsInstance(msg)); err != nil {
+		t.Errorf("Instance name doesn't encode: %v", err)
+	}
+}
+
+func TestParseBackends(t *testing.T) {
+	names, err := ParseBackends(" mDNS, multicast,mdns ")
+	if err != nil || !reflect.DeepEqual(names, []string{BackendMDNS, BackendMulticast}) {
+		t.Errorf("Expected [mdns multicast], got %v (%v)", names, err)
+	}
+	for _, list := range []string{"", " , ", "bonjour", "multicast,carrier-pigeon"} {
+		if _, err := ParseBackends(list); err == nil {
+			t.Errorf("Expected %q to be rejected", list)
+		}
+	}
+}
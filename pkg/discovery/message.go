@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"net"
 	"time"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/identity"
 )
 
 // DiscoveryMessage represents a peer announcement on the network
@@ -16,6 +19,14 @@ type DiscoveryMessage struct {
 	Port      int         `json:"port"`    // TCP port for chat connections
 	Timestamp time.Time   `json:"timestamp"`
 	Sequence  uint64      `json:"sequence"` // Message counter for ordering
+
+	// User-set availability, so peers see "away" before a TCP link exists
+	Presence       peer.Presence `json:"presence,omitempty"`
+	PresenceReason string        `json:"presence_reason,omitempty"`
+
+	// Authentication - PeerID must be the fingerprint of PublicKey
+	PublicKey string `json:"public_key,omitempty"` // Sender's Ed25519 public key (base64)
+	Signature string `json:"signature,omitempty"`  // Signature over signingBytes (base64)
 }
 
 // MessageType defines the kind of discovery message
@@ -54,6 +65,35 @@ func FromJSON(data []byte) (*DiscoveryMessage, error) {
 	return &msg, nil
 }
 
+// Sign attaches our public key and a signature so receivers can verify the sender
+func (m *DiscoveryMessage) Sign(id *identity.Identity) {
+	m.PublicKey = id.PublicKeyString()
+	m.Signature = id.Sign(m.signingBytes())
+}
+
+// Verify checks that the message was signed by the owner of PeerID
+func (m *DiscoveryMessage) Verify() error {
+	return identity.Verify(m.PeerID, m.PublicKey, m.Signature, m.signingBytes())
+}
+
+// signingBytes returns the canonical encoding of every field covered by the signature
+func (m *DiscoveryMessage) signingBytes() []byte {
+	data, _ := json.Marshal(struct {
+		Context   string      `json:"context"`
+		Type      MessageType `json:"type"`
+		PeerID    string      `json:"peer_id"`
+		Username  string      `json:"username"`
+		Address   string      `json:"address"`
+		Port      int         `json:"port"`
+		Timestamp int64       `json:"timestamp"`
+		Sequence  uint64      `json:"sequence"`
+		Presence  string      `json:"presence"`
+		Reason    string      `json:"presence_reason"`
+	}{"p2pchat-discovery", m.Type, m.PeerID, m.Username, m.Address, m.Port, m.Timestamp.UnixNano(), m.Sequence,
+		string(m.Presence), m.PresenceReason})
+	return data
+}
+
 // GetSenderAddr returns the sender's address for TCP connections
 func (m *DiscoveryMessage) GetSenderAddr() (*net.TCPAddr, error) {
 	return net.ResolveTCPAddr("tcp", m.Address)
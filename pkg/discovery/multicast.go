@@ -6,12 +6,14 @@ import (
 	"syscall"
 	"time"
 
+	"p2pchat/internal/peer"
 	"p2pchat/pkg/logger"
 )
 
 const (
-	DefaultMulticastAddr = "224.0.0.1:9999"
-	MaxMessageSize       = 1024 // bytes
+	DefaultMulticastAddr  = "224.0.0.1:9999"
+	DefaultMulticastAddr6 = "[ff02::1]:9999" // All nodes on the link, IPv6's 224.0.0.1
+	MaxMessageSize        = 1024             // bytes
 )
 
 // MulticastService handles UDP multicast for peer discovery
@@ -19,10 +21,20 @@ type MulticastService struct {
 	multicastAddr *net.UDPAddr
 	conn          *net.UDPConn
 	localAddr     *net.UDPAddr
+
+	// The interface we join the group on and send from, nil leaves it to the kernel
+	iface *net.Interface
 }
 
 // NewMulticastService creates a new multicast service
+// The kernel picks the interface, see NewInterfaceMulticastService to pick it yourself
 func NewMulticastService(multicastAddress string) (*MulticastService, error) {
+	return NewInterfaceMulticastService(multicastAddress, nil)
+}
+
+// NewInterfaceMulticastService creates a multicast service that only uses iface
+// Multi-homed hosts run one per interface, see DiscoveryService.SetInterfaces
+func NewInterfaceMulticastService(multicastAddress string, iface *net.Interface) (*MulticastService, error) {
 	// Parse the multicast address
 	addr, err := net.ResolveUDPAddr("udp", multicastAddress)
 	if err != nil {
@@ -34,23 +46,48 @@ func NewMulticastService(multicastAddress string) (*MulticastService, error) {
 		return nil, fmt.Errorf("address %s is not a multicast address", addr.IP)
 	}
 
+	// A zone already names the interface of an IPv6 group
+	if iface != nil && addr.Zone != "" && addr.Zone != iface.Name {
+		return nil, fmt.Errorf("multicast address %s is scoped to %s, not %s", multicastAddress, addr.Zone, iface.Name)
+	}
+
 	return &MulticastService{
 		multicastAddr: addr,
+		iface:         iface,
 	}, nil
 }
 
+// Source marks peers found through our own beacons
+func (ms *MulticastService) Source() peer.Source {
+	return peer.SourceMulticast
+}
+
+// IsIPv6 tells an IPv6 group from an IPv4 one
+func (ms *MulticastService) IsIPv6() bool {
+	return ms.multicastAddr.IP.To4() == nil
+}
+
 // Start begins listening for multicast messages
 func (ms *MulticastService) Start() error {
+	if ms.IsIPv6() {
+		return ms.startIPv6()
+	}
+
 	// Listen on the multicast address
-	conn, err := net.ListenMulticastUDP("udp", nil, ms.multicastAddr)
+	conn, err := net.ListenMulticastUDP("udp4", ms.iface, ms.multicastAddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on multicast address: %w", err)
 	}
 
 	ms.conn = conn
 
-	// Get our local address for logging
+	// Get our local address for logging, beacons carry the interface's own
 	ms.localAddr = conn.LocalAddr().(*net.UDPAddr)
+	if ms.iface != nil {
+		if ip := interfaceIP(ms.iface, false); ip != nil {
+			ms.localAddr = &net.UDPAddr{IP: ip, Port: ms.multicastAddr.Port}
+		}
+	}
 
 	// ENABLE MULTICAST LOOPBACK - This is the key fix!
 	rawConn, err := ms.conn.SyscallConn()
@@ -63,8 +100,8 @@ func (ms *MulticastService) Start() error {
 		})
 	}
 
-	logger.Debug("🔊 Multicast service listening on %s (local: %s)",
-		ms.multicastAddr, ms.localAddr)
+	logger.Debug("🔊 Multicast service listening on %s (local: %s, interface: %s)",
+		ms.multicastAddr, ms.localAddr, ms.InterfaceName())
 
 	return nil
 }
@@ -85,24 +122,22 @@ func (ms *MulticastService) Send(message *DiscoveryMessage) error {
 		return fmt.Errorf("multicast service not started")
 	}
 
-	// Convert message to JSON
-	data, err := message.ToJSON()
+	data, err := encodeBeacon(message)
 	if err != nil {
-		return fmt.Errorf("failed to serialize message: %w", err)
+		return err
 	}
 
-	// Check message size
-	if len(data) > MaxMessageSize {
-		return fmt.Errorf("message too large: %d bytes (max %d)", len(data), MaxMessageSize)
+	// Send to multicast group, link-local IPv6 groups need the interface as zone
+	target := ms.multicastAddr
+	if ms.IsIPv6() && ms.iface != nil {
+		target = &net.UDPAddr{IP: target.IP, Port: target.Port, Zone: ms.iface.Name}
 	}
-
-	// Send to multicast group
-	_, err = ms.conn.WriteToUDP(data, ms.multicastAddr)
+	_, err = ms.conn.WriteToUDP(data, target)
 	if err != nil {
 		return fmt.Errorf("failed to send multicast message: %w", err)
 	}
 
-	logger.Debug("📤 Sent: %s (%d bytes)", message.String(), len(data))
+	logger.Debug("📤 Sent: %s (%d bytes) on %s", message.String(), len(data), ms.InterfaceName())
 	return nil
 }
 
@@ -112,17 +147,79 @@ func (ms *MulticastService) ReceiveWithTimeout(timeout time.Duration) (*Discover
 		return nil, nil, fmt.Errorf("multicast service not started")
 	}
 
+	// Every socket on the group's port hears every interface's beacons,
+	// the service bound to the interface a beacon came in on takes it
+	deadline := time.Now().Add(timeout)
+	for {
+		msg, senderAddr, err := receiveBeacon(ms.conn, time.Until(deadline))
+		if err != nil || ms.hears(senderAddr) {
+			return msg, senderAddr, err
+		}
+	}
+}
+
+// hears reports whether a beacon from addr is ours to take
+// Senders on none of our subnets are taken by everyone, rather than by no one
+func (ms *MulticastService) hears(addr *net.UDPAddr) bool {
+	if ms.iface == nil {
+		return true
+	}
+	name := ArrivalInterface(addr)
+	return name == "" || name == ms.iface.Name
+}
+
+// InterfaceName is the interface the service is bound to, "default" if the kernel picks
+func (ms *MulticastService) InterfaceName() string {
+	if ms.iface == nil {
+		return "default"
+	}
+	return ms.iface.Name
+}
+
+// Receive listens for incoming discovery messages with default timeout
+func (ms *MulticastService) Receive() (*DiscoveryMessage, *net.UDPAddr, error) {
+	return ms.ReceiveWithTimeout(5 * time.Second) // Production timeout
+}
+
+// GetLocalAddr returns local UDP address
+func (ms *MulticastService) GetLocalAddr() *net.UDPAddr {
+	return ms.localAddr
+}
+
+// Port is the UDP port of the multicast group, broadcast fallback uses the same one
+func (ms *MulticastService) Port() int {
+	return ms.multicastAddr.Port
+}
+
+// encodeBeacon serializes a message and checks it fits in one datagram
+// Multicast and broadcast send the same JSON beacons
+func encodeBeacon(message *DiscoveryMessage) ([]byte, error) {
+	// Convert message to JSON
+	data, err := message.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	// Check message size
+	if len(data) > MaxMessageSize {
+		return nil, fmt.Errorf("message too large: %d bytes (max %d)", len(data), MaxMessageSize)
+	}
+	return data, nil
+}
+
+// receiveBeacon reads one JSON beacon from conn
+func receiveBeacon(conn *net.UDPConn, timeout time.Duration) (*DiscoveryMessage, *net.UDPAddr, error) {
 	// Set custom read timeout
-	ms.conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetReadDeadline(time.Now().Add(timeout))
 
 	// Read from network
 	buffer := make([]byte, MaxMessageSize)
-	n, senderAddr, err := ms.conn.ReadFromUDP(buffer)
+	n, senderAddr, err := conn.ReadFromUDP(buffer)
 	if err != nil {
 		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 			return nil, nil, fmt.Errorf("read timeout after %v: %w", timeout, err)
 		}
-		return nil, nil, fmt.Errorf("failed to read multicast message: %w", err)
+		return nil, nil, fmt.Errorf("failed to read discovery message: %w", err)
 	}
 
 	// Parse the JSON message
@@ -136,13 +233,3 @@ func (ms *MulticastService) ReceiveWithTimeout(timeout time.Duration) (*Discover
 
 	return message, senderAddr, nil
 }
-
-// Receive listens for incoming discovery messages with default timeout
-func (ms *MulticastService) Receive() (*DiscoveryMessage, *net.UDPAddr, error) {
-	return ms.ReceiveWithTimeout(5 * time.Second) // Production timeout
-}
-
-// GetLocalAddr returns local UDP address
-func (ms *MulticastService) GetLocalAddr() *net.UDPAddr {
-	return ms.localAddr
-}
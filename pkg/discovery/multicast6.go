@@ -0,0 +1,87 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"p2pchat/pkg/logger"
+)
+
+// IPv6 multicast needs more care than IPv4: ff02:: groups are link-local, so
+// "the group" is really one group per interface and there is no default one
+// for the kernel to pick. Each service joins on exactly one interface and
+// sends with it as zone. Replies come from fe80:: addresses, whose zone (the
+// interface name) ReadFromUDP fills in and the registry keeps, or they
+// couldn't be dialed.
+
+// startIPv6 listens on the group's port and joins the group on our interface
+// Without one, the zone of the group address ([ff02::1%eth0]:9999) or else the
+// first interface that can do IPv6 multicast is used
+func (ms *MulticastService) startIPv6() error {
+	if ms.iface == nil {
+		var names []string
+		if ms.multicastAddr.Zone != "" {
+			names = []string{ms.multicastAddr.Zone}
+		}
+		interfaces, err := multicastInterfaces(names, true)
+		if err != nil {
+			return err
+		}
+		if len(interfaces) == 0 {
+			return fmt.Errorf("no interface can do IPv6 multicast")
+		}
+		ms.iface = &interfaces[0]
+	}
+
+	config := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				// Share the port with other instances, and leave IPv4 beacons to the IPv4 socket
+				sockErr = errors.Join(
+					syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1),
+					syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 1),
+				)
+			})
+			return errors.Join(err, sockErr)
+		},
+	}
+	conn, err := config.ListenPacket(context.Background(), "udp6", net.JoinHostPort("::", fmt.Sprint(ms.multicastAddr.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen on multicast address: %w", err)
+	}
+	udpConn := conn.(*net.UDPConn)
+
+	rawConn, err := udpConn.SyscallConn()
+	if err != nil {
+		udpConn.Close()
+		return fmt.Errorf("failed to set up multicast socket: %w", err)
+	}
+	var joinErr error
+	rawConn.Control(func(fd uintptr) {
+		// Other instances on this machine must hear us, nobody past the link should
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 1)
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 1)
+
+		mreq := &syscall.IPv6Mreq{Interface: uint32(ms.iface.Index)}
+		copy(mreq.Multiaddr[:], ms.multicastAddr.IP.To16())
+		joinErr = syscall.SetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_JOIN_GROUP, mreq)
+	})
+	if joinErr != nil {
+		udpConn.Close()
+		return fmt.Errorf("failed to join %s on %s: %w", ms.multicastAddr.IP, ms.iface.Name, joinErr)
+	}
+
+	ms.conn = udpConn
+	ms.localAddr = udpConn.LocalAddr().(*net.UDPAddr)
+	if ip := interfaceIP(ms.iface, true); ip != nil {
+		ms.localAddr = &net.UDPAddr{IP: ip, Port: ms.multicastAddr.Port, Zone: ms.iface.Name}
+	}
+
+	logger.Debug("🔊 Multicast service listening on %s (local: %s, interface: %s)",
+		ms.multicastAddr, ms.localAddr, ms.iface.Name)
+	return nil
+}
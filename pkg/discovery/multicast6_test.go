@@ -0,0 +1,120 @@
+package discovery
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"p2pchat/pkg/identity"
+)
+
+func TestIPv6MulticastBeacons(t *testing.T) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	group := fmt.Sprintf("[ff02::1]:%d", freeUDPPort(t))
+	sender, err := NewMulticastService(group)
+	if err != nil {
+		t.Fatalf("Failed to create multicast service: %v", err)
+	}
+	receiver, _ := NewMulticastService(group)
+	if !sender.IsIPv6() {
+		t.Fatalf("Expected %s to be an IPv6 group", group)
+	}
+	if err := sender.Start(); err != nil {
+		t.Skipf("IPv6 multicast unavailable here: %v", err)
+	}
+	defer sender.Stop()
+	if err := receiver.Start(); err != nil {
+		t.Fatalf("Second instance couldn't share the port: %v", err)
+	}
+	defer receiver.Stop()
+
+	msg := NewAnnounceMessage(id.PeerID(), "alice", 8080)
+	msg.Sign(id)
+	if err := sender.Send(msg); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	got, from, err := receiver.ReceiveWithTimeout(2 * time.Second)
+	if err != nil {
+		t.Fatalf("No beacon received: %v", err)
+	}
+	if got.PeerID != id.PeerID() || got.Verify() != nil {
+		t.Errorf("Expected alice's signed beacon, got %+v", got)
+	}
+
+	// A link-local sender is only reachable through the interface it came in on
+	registry := NewPeerRegistry()
+	if err := registry.AddOrUpdatePeer(got, from, sender.Source(), ArrivalInterface(from)); err != nil {
+		t.Fatalf("AddOrUpdatePeer failed: %v", err)
+	}
+	addr := registry.GetAllPeers()[0].Address
+	if addr.Port != 8080 || !addr.IP.Equal(from.IP) {
+		t.Errorf("Expected %s port 8080, got %s", from.IP, addr)
+	}
+	if from.IP.IsLinkLocalUnicast() && (from.Zone == "" || addr.Zone != from.Zone) {
+		t.Errorf("Expected the zone of %s to be kept, got %q", from, addr.Zone)
+	}
+}
+
+func TestIPv6MulticastGroupZone(t *testing.T) {
+	if _, err := multicastInterfaces([]string{"no-such-interface0"}, true); err == nil {
+		t.Error("Expected an unknown zone to leave no interface to join on")
+	}
+
+	ms, err := NewMulticastService("[ff02::1%no-such-interface0]:9999")
+	if err != nil {
+		t.Fatalf("Failed to create multicast service: %v", err)
+	}
+	if err := ms.Start(); err == nil {
+		ms.Stop()
+		t.Error("Expected Start to fail on an unknown interface")
+	}
+}
+
+// multicastGroups counts the IPv4 and IPv6 multicast backends, one per group and interface
+func multicastGroups(backends []Backend) (ipv4, ipv6 int) {
+	for _, backend := range backends {
+		if multicast, ok := backend.(*MulticastService); ok && multicast.IsIPv6() {
+			ipv6++
+		} else if ok {
+			ipv4++
+		}
+	}
+	return ipv4, ipv6
+}
+
+func TestSetMulticastAddr6(t *testing.T) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	ds, err := NewDiscoveryService(id, "alice", 8080, DefaultMulticastAddr)
+	if err != nil {
+		t.Fatalf("Failed to create discovery service: %v", err)
+	}
+	ipv4, ipv6 := multicastGroups(ds.backends)
+	if ipv4 == 0 || ipv6 == 0 {
+		t.Fatalf("Expected IPv4 and IPv6 multicast backends, got %d and %d", ipv4, ipv6)
+	}
+
+	for _, addr := range []string{"224.0.0.1:9999", "[fd00::1]:9999", "not an address"} {
+		if err := ds.SetMulticastAddr6(addr); err == nil {
+			t.Errorf("Expected %q to be rejected", addr)
+		}
+	}
+	if v4, v6 := multicastGroups(ds.backends); v4 != ipv4 || v6 != ipv6 {
+		t.Errorf("A rejected group shouldn't change the backends, got %d and %d", v4, v6)
+	}
+
+	// Empty turns IPv6 off
+	if err := ds.SetMulticastAddr6(""); err != nil {
+		t.Fatalf("SetMulticastAddr6 failed: %v", err)
+	}
+	if v4, v6 := multicastGroups(ds.backends); v4 != ipv4 || v6 != 0 {
+		t.Errorf("Expected IPv4 only, got %d and %d", v4, v6)
+	}
+}
@@ -10,6 +10,10 @@ import (
 	"p2pchat/pkg/logger"
 )
 
+// ExchangeInterval is how often connected peers repeat the peers they know (see chat's pex.go)
+// Exchanged peers are only dropped after missing a few of these, beacons come much more often
+const ExchangeInterval = 30 * time.Second
+
 // PeerRegistry manages the list of discovered peers
 type PeerRegistry struct {
 	mu    sync.RWMutex
@@ -28,11 +32,20 @@ type PeerRegistry struct {
 func NewPeerRegistry() *PeerRegistry {
 	return &PeerRegistry{
 		peers:          make(map[string]*peer.Peer),
-		staleTimeout:   10 * time.Second,
-		offlineTimeout: 30 * time.Second,
+		staleTimeout:   DefaultTiming.StaleTimeout,
+		offlineTimeout: DefaultTiming.OfflineTimeout,
 	}
 }
 
+// SetTimeouts changes how long peers may stay silent before they go stale and offline
+func (pr *PeerRegistry) SetTimeouts(stale, offline time.Duration) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.staleTimeout = stale
+	pr.offlineTimeout = offline
+}
+
 // SetEventHandlers sets callbacks for peer join/leave events
 func (pr *PeerRegistry) SetEventHandlers(onJoin, onLeave func(*peer.Peer)) {
 	pr.mu.Lock()
@@ -43,39 +56,104 @@ func (pr *PeerRegistry) SetEventHandlers(onJoin, onLeave func(*peer.Peer)) {
 }
 
 // AddOrUpdatePeer adds a new peer or updates existing peer's last seen time
-func (pr *PeerRegistry) AddOrUpdatePeer(msg *DiscoveryMessage, senderAddr *net.UDPAddr) {
+// Announcements whose signature doesn't verify are rejected, source is the backend that heard it
+// and iface the interface it came in on ("" if unknown)
+func (pr *PeerRegistry) AddOrUpdatePeer(msg *DiscoveryMessage, senderAddr *net.UDPAddr, source peer.Source, iface string) error {
+	if err := msg.Verify(); err != nil {
+		return fmt.Errorf("rejected announcement: %w", err)
+	}
+
 	pr.mu.Lock()
-	defer pr.mu.Unlock()
 
 	// Convert UDP address to TCP address for connections
 	tcpAddr := &net.TCPAddr{
 		IP:   senderAddr.IP,
-		Port: msg.Port, // Use the port from the message
+		Port: msg.Port,        // Use the port from the message
+		Zone: senderAddr.Zone, // fe80:: addresses only mean something on the interface they came in on
 	}
 
 	existingPeer, exists := pr.peers[msg.PeerID]
+	presence, reason := peer.CleanPresence(msg.Presence, msg.PresenceReason)
 
 	if exists {
 		// Update existing peer
 		existingPeer.UpdateLastSeen()
+		existingPeer.Presence = presence
+		existingPeer.PresenceReason = reason
+		if existingPeer.Source == peer.SourceExchange {
+			// Hearing it ourselves beats hearsay, and the beacon's address is one we can reach
+			// Between backends the first one to find a peer keeps it, so the source doesn't flap
+			existingPeer.Source = source
+			existingPeer.Address = tcpAddr
+			existingPeer.Interface = iface
+		}
 		logger.Debug("📱 Updated peer: %s (%s)", msg.Username, tcpAddr)
+		pr.mu.Unlock()
 	} else {
 		// Add new peer
 		newPeer := &peer.Peer{
-			ID:       msg.PeerID,
-			Username: msg.Username,
-			Address:  tcpAddr,
-			LastSeen: time.Now(),
-			Status:   peer.PeerStatusOnline,
+			ID:        msg.PeerID,
+			Username:  msg.Username,
+			Address:   tcpAddr,
+			LastSeen:  time.Now(),
+			Status:    peer.PeerStatusOnline,
+			Source:    source,
+			Interface: iface,
+
+			Presence:       presence,
+			PresenceReason: reason,
 		}
 
 		pr.peers[msg.PeerID] = newPeer
 		logger.Debug("✅ New peer joined: %s (%s)", msg.Username, tcpAddr)
+		pr.notifyJoin(newPeer)
+	}
 
-		// Notify about new peer
-		if pr.onPeerJoin != nil {
-			pr.onPeerJoin(newPeer)
+	return nil
+}
+
+// AddExchangedPeer adds a peer a connected peer told us about, or keeps it from expiring
+// Peers we hear beacons from (multicast or mDNS) are left alone, their beacons are better evidence
+// Returns true if the peer is new
+func (pr *PeerRegistry) AddExchangedPeer(p *peer.Peer) bool {
+	pr.mu.Lock()
+
+	if existingPeer, exists := pr.peers[p.ID]; exists {
+		if existingPeer.Source == peer.SourceExchange {
+			existingPeer.UpdateLastSeen()
 		}
+		pr.mu.Unlock()
+		return false
+	}
+
+	newPeer := *p
+	newPeer.Source = peer.SourceExchange
+	newPeer.UpdateLastSeen()
+	pr.peers[p.ID] = &newPeer
+	logger.Debug("🤝 Learned about peer: %s (%s) via exchange", p.Username, p.Address)
+	pr.notifyJoin(&newPeer)
+	return true
+}
+
+// notifyJoin releases the lock and then tells the join handler about a new peer
+// The handler dials the peer, and the new session reads the registry right away
+func (pr *PeerRegistry) notifyJoin(p *peer.Peer) {
+	onJoin := pr.onPeerJoin
+	peerCopy := *p
+	pr.mu.Unlock()
+
+	if onJoin != nil {
+		onJoin(&peerCopy)
+	}
+}
+
+// TouchPeer keeps an exchanged peer from expiring, for when it talks to us directly
+func (pr *PeerRegistry) TouchPeer(peerID string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if p, exists := pr.peers[peerID]; exists && p.Source == peer.SourceExchange {
+		p.UpdateLastSeen()
 	}
 }
 
@@ -130,7 +208,11 @@ func (pr *PeerRegistry) CleanupStalePeers() {
 	var toRemove []string
 
 	for peerID, p := range pr.peers {
-		p.CheckTimeout(pr.staleTimeout, pr.offlineTimeout)
+		if p.Source == peer.SourceExchange {
+			p.CheckTimeout(max(pr.staleTimeout, 2*ExchangeInterval), max(pr.offlineTimeout, 3*ExchangeInterval))
+		} else {
+			p.CheckTimeout(pr.staleTimeout, pr.offlineTimeout)
+		}
 
 		if p.Status == peer.PeerStatusOffline {
 			toRemove = append(toRemove, peerID)
@@ -0,0 +1,50 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/identity"
+)
+
+func TestExchangedPeerUpgradesOnBeacon(t *testing.T) {
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	registry := NewPeerRegistry()
+	joined := 0
+	registry.SetEventHandlers(func(*peer.Peer) { joined++ }, nil)
+
+	// A neighbour tells us about bob first, at an address we may not be able to reach
+	exchanged := &peer.Peer{ID: id.PeerID(), Username: "bob", Address: &net.TCPAddr{IP: net.IPv4(10, 8, 0, 2), Port: 8080}}
+	if !registry.AddExchangedPeer(exchanged) {
+		t.Fatal("Expected bob to be new")
+	}
+	if registry.AddExchangedPeer(exchanged) {
+		t.Error("Hearing about bob twice should not add him twice")
+	}
+
+	// Then his own beacon arrives
+	msg := NewAnnounceMessage(id.PeerID(), "bob", 9090)
+	msg.Sign(id)
+	if err := registry.AddOrUpdatePeer(msg, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 7), Port: 9999}, peer.SourceMulticast, "wlan0"); err != nil {
+		t.Fatalf("AddOrUpdatePeer failed: %v", err)
+	}
+
+	peers := registry.GetAllPeers()
+	if len(peers) != 1 || peers[0].Source != peer.SourceMulticast || peers[0].Address.String() != "192.168.1.7:9090" || peers[0].Interface != "wlan0" {
+		t.Fatalf("Expected bob to become a multicast peer at his beacon's address on wlan0, got %+v", peers[0])
+	}
+	if joined != 1 {
+		t.Errorf("Expected one join event, got %d", joined)
+	}
+
+	// Hearsay doesn't overwrite what we heard ourselves
+	registry.AddExchangedPeer(exchanged)
+	if p := registry.GetAllPeers()[0]; p.Source != peer.SourceMulticast {
+		t.Errorf("Exchange should not downgrade a multicast peer, got %s", p.Source)
+	}
+}
@@ -4,23 +4,37 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"p2pchat/internal/peer"
+	"p2pchat/pkg/identity"
 	"p2pchat/pkg/logger"
 )
 
-// DiscoveryService coordinates peer discovery via UDP multicast
+// DiscoveryService coordinates peer discovery via its backends (UDP multicast, mDNS)
 type DiscoveryService struct {
 	// Core components
-	multicast *MulticastService
-	registry  *PeerRegistry
+	backends       []Backend // Set before Start, the ones that started are running
+	backendNames   []string  // What backends were built from, see SetBackends
+	multicastAddr  string
+	multicastAddr6 string   // IPv6 group the multicast backend joins too, "" for none
+	interfaces     []string // Interfaces multicast and broadcast use, nil for every one that can
+	registry       *PeerRegistry
 
 	// Local peer info
+	identity      *identity.Identity // Signs every announcement
 	localPeerID   string
 	localUsername string
 	localTCPPort  int
 
+	// User-set presence, included in every announcement
+	presence       peer.Presence
+	presenceReason string
+	presenceMutex  sync.RWMutex
+
 	// Configuration
 	beaconInterval  time.Duration
 	cleanupInterval time.Duration
@@ -30,28 +44,229 @@ type DiscoveryService struct {
 	cancel context.CancelFunc
 }
 
-// NewDiscoveryService creates a new discovery service
-func NewDiscoveryService(peerID, username string, tcpPort int, multicastAddr string) (*DiscoveryService, error) {
-	// Create multicast service
-	multicast, err := NewMulticastService(multicastAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create multicast service: %w", err)
+// Timing holds discovery's intervals and timeouts
+type Timing struct {
+	BeaconInterval  time.Duration // How often we announce ourselves
+	CleanupInterval time.Duration // How often silent peers are checked
+	StaleTimeout    time.Duration // Silence before a peer counts as stale
+	OfflineTimeout  time.Duration // Silence before a peer is dropped
+}
+
+// DefaultTiming suits a LAN: a peer that misses a few beacons is gone within half a minute
+var DefaultTiming = Timing{
+	BeaconInterval:  5 * time.Second,
+	CleanupInterval: 10 * time.Second,
+	StaleTimeout:    10 * time.Second,
+	OfflineTimeout:  30 * time.Second,
+}
+
+// Validate checks that peers get a chance to announce themselves before they are dropped
+func (t Timing) Validate() error {
+	if t.BeaconInterval <= 0 || t.CleanupInterval <= 0 || t.StaleTimeout <= 0 || t.OfflineTimeout <= 0 {
+		return fmt.Errorf("discovery intervals and timeouts must be positive")
+	}
+	if t.StaleTimeout <= t.BeaconInterval {
+		return fmt.Errorf("stale timeout (%s) must be longer than the beacon interval (%s)", t.StaleTimeout, t.BeaconInterval)
 	}
+	if t.OfflineTimeout < t.StaleTimeout {
+		return fmt.Errorf("offline timeout (%s) must not be shorter than the stale timeout (%s)", t.OfflineTimeout, t.StaleTimeout)
+	}
+	return nil
+}
 
+// NewDiscoveryService creates a new discovery service that announces id on the network
+// Multicast uses multicastAddr and DefaultMulticastAddr6, see SetMulticastAddr6
+// The groups are only joined in Start, which falls back to broadcast if that fails
+func NewDiscoveryService(id *identity.Identity, username string, tcpPort int, multicastAddr string) (*DiscoveryService, error) {
 	// Create peer registry
 	registry := NewPeerRegistry()
 
-	// Use the provided peer ID to ensure consistency across services
+	// The peer ID comes from the identity to ensure consistency across services
 
-	return &DiscoveryService{
-		multicast:       multicast,
+	ds := &DiscoveryService{
+		backendNames:    []string{BackendMulticast},
+		multicastAddr:   multicastAddr,
+		multicastAddr6:  DefaultMulticastAddr6,
 		registry:        registry,
-		localPeerID:     peerID,
+		identity:        id,
+		localPeerID:     id.PeerID(),
 		localUsername:   username,
 		localTCPPort:    tcpPort,
-		beaconInterval:  5 * time.Second,  // Announce every 5 seconds
-		cleanupInterval: 10 * time.Second, // Cleanup every 10 seconds
-	}, nil
+		beaconInterval:  DefaultTiming.BeaconInterval,
+		cleanupInterval: DefaultTiming.CleanupInterval,
+	}
+	if err := ds.buildBackends(); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// SetTiming changes the intervals and timeouts, call it before Start
+func (ds *DiscoveryService) SetTiming(timing Timing) {
+	ds.beaconInterval = timing.BeaconInterval
+	ds.cleanupInterval = timing.CleanupInterval
+	ds.registry.SetTimeouts(timing.StaleTimeout, timing.OfflineTimeout)
+}
+
+// SetBackends picks the backends by name (see ParseBackends), call it before Start
+// The default is multicast only
+func (ds *DiscoveryService) SetBackends(names []string) error {
+	previous := ds.backendNames
+	ds.backendNames = names
+	if err := ds.buildBackends(); err != nil {
+		ds.backendNames = previous
+		return err
+	}
+	return nil
+}
+
+// SetInterfaces limits multicast and broadcast to the named interfaces, nil means every one that can multicast
+// Call it before Start
+func (ds *DiscoveryService) SetInterfaces(names []string) error {
+	previous := ds.interfaces
+	ds.interfaces = names
+	if err := ds.buildBackends(); err != nil {
+		ds.interfaces = previous
+		return err
+	}
+	return nil
+}
+
+// SetMulticastAddr6 changes the IPv6 group multicast joins next to the IPv4 one, "" turns IPv6 off
+// Call it before Start
+func (ds *DiscoveryService) SetMulticastAddr6(addr string) error {
+	if addr != "" {
+		group, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil || group.IP.To4() != nil {
+			return fmt.Errorf("invalid IPv6 multicast address %s", addr)
+		}
+	}
+
+	previous := ds.multicastAddr6
+	ds.multicastAddr6 = addr
+	if err := ds.buildBackends(); err != nil {
+		ds.multicastAddr6 = previous
+		return err
+	}
+	return nil
+}
+
+// buildBackends creates the backends for the current names and addresses
+// Multicast gets one backend per group and interface, they run side by side
+func (ds *DiscoveryService) buildBackends() error {
+	var backends []Backend
+	for _, name := range ds.backendNames {
+		switch name {
+		case BackendMulticast:
+			found := false
+			for _, addr := range []string{ds.multicastAddr, ds.multicastAddr6} {
+				if addr == "" {
+					continue
+				}
+				multicast, err := ds.newMulticastServices(addr)
+				if err != nil {
+					return fmt.Errorf("failed to create multicast service: %w", err)
+				}
+				for _, service := range multicast {
+					backends = append(backends, service)
+				}
+				found = found || len(multicast) > 0
+			}
+			if !found {
+				return fmt.Errorf("none of the interfaces %s can multicast", strings.Join(ds.interfaces, ", "))
+			}
+		case BackendMDNS:
+			mdns, err := NewMDNSService()
+			if err != nil {
+				return fmt.Errorf("failed to create mdns discovery: %w", err)
+			}
+			backends = append(backends, mdns)
+		case BackendBroadcast:
+			broadcast, err := ds.newBroadcastService()
+			if err != nil {
+				return fmt.Errorf("failed to create broadcast discovery: %w", err)
+			}
+			backends = append(backends, broadcast)
+		default:
+			return fmt.Errorf("unknown discovery backend %q", name)
+		}
+	}
+	if len(backends) == 0 {
+		return fmt.Errorf("no discovery backend given")
+	}
+
+	ds.backends = backends
+	return nil
+}
+
+// newMulticastServices creates a multicast backend on every interface that can join the group
+// With no interface to choose from, one backend leaves the choice to the kernel
+func (ds *DiscoveryService) newMulticastServices(addr string) ([]*MulticastService, error) {
+	group, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multicast address %s: %w", addr, err)
+	}
+	if group.Zone != "" {
+		// [ff02::1%eth0]:9999 already picked its interface
+		multicast, err := NewMulticastService(addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*MulticastService{multicast}, nil
+	}
+
+	interfaces, err := multicastInterfaces(ds.interfaces, group.IP.To4() == nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(interfaces) == 0 {
+		if len(ds.interfaces) > 0 {
+			return nil, nil // The interfaces we were given don't do this family
+		}
+		multicast, err := NewMulticastService(addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*MulticastService{multicast}, nil
+	}
+
+	var services []*MulticastService
+	for i := range interfaces {
+		multicast, err := NewInterfaceMulticastService(addr, &interfaces[i])
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, multicast)
+	}
+	return services, nil
+}
+
+// newBroadcastService creates a broadcast backend on the multicast group's port
+func (ds *DiscoveryService) newBroadcastService() (*BroadcastService, error) {
+	_, port, err := net.SplitHostPort(ds.multicastAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multicast address %s: %w", ds.multicastAddr, err)
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multicast port %s: %w", port, err)
+	}
+	broadcast, err := NewBroadcastService(portNum)
+	if err != nil {
+		return nil, err
+	}
+	broadcast.interfaces = ds.interfaces
+	return broadcast, nil
+}
+
+// hasSource reports whether one of the backends finds peers of the given source
+func hasSource(backends []Backend, source peer.Source) bool {
+	for _, backend := range backends {
+		if backend.Source() == source {
+			return true
+		}
+	}
+	return false
 }
 
 // SetPeerEventHandlers sets callbacks for when peers join/leave
@@ -60,12 +275,40 @@ func (ds *DiscoveryService) SetPeerEventHandlers(onJoin, onLeave func(*peer.Peer
 }
 
 // Start begins the discovery service
+// A backend that can't start is skipped, as long as one of them runs
+// If no multicast group can be joined, broadcast takes multicast's place
 func (ds *DiscoveryService) Start() error {
-	// Start multicast listening
-	if err := ds.multicast.Start(); err != nil {
-		return fmt.Errorf("failed to start multicast: %w", err)
+	var running []Backend
+	var startErr error
+	multicastFailed := false
+	for _, backend := range ds.backends {
+		if err := backend.Start(); err != nil {
+			logger.Error("⚠️  Failed to start %s discovery: %v", backend.Source(), err)
+			startErr = fmt.Errorf("failed to start %s discovery: %w", backend.Source(), err)
+			multicastFailed = multicastFailed || backend.Source() == peer.SourceMulticast
+			continue
+		}
+		running = append(running, backend)
 	}
 
+	// An IPv6 group alone keeps IPv6-only networks going, broadcast is only for when neither works
+	if multicastFailed && !hasSource(running, peer.SourceMulticast) && !hasSource(ds.backends, peer.SourceBroadcast) {
+		logger.Error("⚠️  Multicast join failed, falling back to broadcast")
+		fallback, err := ds.newBroadcastService()
+		if err == nil {
+			err = fallback.Start()
+		}
+		if err != nil {
+			startErr = fmt.Errorf("failed to start broadcast discovery: %w", err)
+		} else {
+			running = append(running, fallback)
+		}
+	}
+	if len(running) == 0 {
+		return startErr
+	}
+	ds.backends = running
+
 	// Create context for coordinating goroutines
 	ds.ctx, ds.cancel = context.WithCancel(context.Background())
 
@@ -75,7 +318,9 @@ func (ds *DiscoveryService) Start() error {
 
 	// Start background tasks
 	go ds.beaconLoop()
-	go ds.receiveLoop()
+	for _, backend := range ds.backends {
+		go ds.receiveLoop(backend)
+	}
 	go ds.cleanupLoop()
 
 	// Send initial announcement
@@ -95,9 +340,15 @@ func (ds *DiscoveryService) Stop() error {
 		// Stop background tasks
 		ds.cancel()
 
-		// Stop multicast
-		if err := ds.multicast.Stop(); err != nil {
-			return fmt.Errorf("failed to stop multicast: %w", err)
+		// Stop the backends
+		var stopErr error
+		for _, backend := range ds.backends {
+			if err := backend.Stop(); err != nil {
+				stopErr = fmt.Errorf("failed to stop %s discovery: %w", backend.Source(), err)
+			}
+		}
+		if stopErr != nil {
+			return stopErr
 		}
 
 		logger.Debug("👋 Discovery service stopped")
@@ -105,6 +356,31 @@ func (ds *DiscoveryService) Stop() error {
 	return nil
 }
 
+// SetPresence changes the presence we announce and tells the network right away
+func (ds *DiscoveryService) SetPresence(presence peer.Presence, reason string) {
+	ds.presenceMutex.Lock()
+	ds.presence, ds.presenceReason = presence, reason
+	ds.presenceMutex.Unlock()
+
+	if ds.cancel == nil {
+		return // Not started, the first beacon will carry it
+	}
+	if err := ds.sendAnnouncement(); err != nil {
+		logger.Error("⚠️  Failed to announce presence: %v", err)
+	}
+}
+
+// AddExchangedPeer adds a peer learned from a connected peer instead of a beacon
+// It fires the join handler like a beacon would if the peer is new
+func (ds *DiscoveryService) AddExchangedPeer(p *peer.Peer) bool {
+	return ds.registry.AddExchangedPeer(p)
+}
+
+// TouchPeer keeps an exchanged peer from expiring while it talks to us
+func (ds *DiscoveryService) TouchPeer(peerID string) {
+	ds.registry.TouchPeer(peerID)
+}
+
 // GetPeers returns current list of discovered peers
 func (ds *DiscoveryService) GetAllPeers() []*peer.Peer {
 	return ds.registry.GetAllPeers()
@@ -138,23 +414,23 @@ func (ds *DiscoveryService) beaconLoop() {
 	}
 }
 
-// receiveLoop listens for incoming discovery messages
-func (ds *DiscoveryService) receiveLoop() {
+// receiveLoop listens for incoming discovery messages on one backend
+func (ds *DiscoveryService) receiveLoop(backend Backend) {
 	for {
 		select {
 		case <-ds.ctx.Done():
-			logger.Debug("📡 Receive loop stopping")
+			logger.Debug("📡 Receive loop stopping (%s)", backend.Source())
 			return
 		default:
 			// Try to receive a message
-			msg, senderAddr, err := ds.multicast.ReceiveWithTimeout(1 * time.Second)
+			msg, senderAddr, err := backend.ReceiveWithTimeout(1 * time.Second)
 			if err != nil {
 				// Timeout is normal, continue
 				continue
 			}
 
 			// Handle the message
-			ds.handleDiscoveryMessage(msg, senderAddr)
+			ds.handleDiscoveryMessage(msg, senderAddr, backend.Source())
 		}
 	}
 }
@@ -175,17 +451,29 @@ func (ds *DiscoveryService) cleanupLoop() {
 	}
 }
 
-// sendAnnouncement broadcasts presence
+// sendAnnouncement broadcasts presence on every backend
 func (ds *DiscoveryService) sendAnnouncement() error {
-	msg := NewAnnounceMessage(ds.localPeerID, ds.localUsername, ds.localTCPPort)
+	var sendErr error
+	for _, backend := range ds.backends {
+		msg := NewAnnounceMessage(ds.localPeerID, ds.localUsername, ds.localTCPPort)
+
+		// Set our address (will be overridden by receiver, but good for debugging)
+		// Backends bound to an interface know its address, the others only know 0.0.0.0
+		localAddr := backend.GetLocalAddr()
+		if localAddr != nil && !localAddr.IP.IsUnspecified() && !localAddr.IP.IsMulticast() {
+			msg.Address = net.JoinHostPort(localAddr.IP.String(), strconv.Itoa(ds.localTCPPort))
+		}
 
-	// Set our address (will be overridden by receiver, but good for debugging)
-	localAddr := ds.multicast.GetLocalAddr()
-	if localAddr != nil {
-		msg.Address = fmt.Sprintf("%s:%d", localAddr.IP, ds.localTCPPort)
-	}
+		ds.presenceMutex.RLock()
+		msg.Presence, msg.PresenceReason = ds.presence, ds.presenceReason
+		ds.presenceMutex.RUnlock()
 
-	return ds.multicast.Send(msg)
+		msg.Sign(ds.identity)
+		if err := backend.Send(msg); err != nil {
+			sendErr = fmt.Errorf("%s: %w", backend.Source(), err)
+		}
+	}
+	return sendErr
 }
 
 // sendLeaveMessage announces going offline
@@ -197,16 +485,19 @@ func (ds *DiscoveryService) sendLeaveMessage() {
 		Port:      ds.localTCPPort,
 		Timestamp: time.Now(),
 	}
+	msg.Sign(ds.identity)
 
 	// Best effort - don't wait for errors
-	ds.multicast.Send(msg)
+	for _, backend := range ds.backends {
+		backend.Send(msg)
+	}
 
 	// Give it a moment to send
 	time.Sleep(100 * time.Millisecond)
 }
 
 // handleDiscoveryMessage processes incoming discovery messages
-func (ds *DiscoveryService) handleDiscoveryMessage(msg *DiscoveryMessage, senderAddr *net.UDPAddr) {
+func (ds *DiscoveryService) handleDiscoveryMessage(msg *DiscoveryMessage, senderAddr *net.UDPAddr, source peer.Source) {
 	// Ignore our own messages
 	if msg.PeerID == ds.localPeerID {
 		return
@@ -219,18 +510,20 @@ func (ds *DiscoveryService) handleDiscoveryMessage(msg *DiscoveryMessage, sender
 	}
 
 	switch msg.Type {
-	case MessageTypeAnnounce, MessageTypePing:
-		// Add or update peer
-		ds.registry.AddOrUpdatePeer(msg, senderAddr)
+	case MessageTypeAnnounce, MessageTypePing, MessageTypePong:
+		// Add or update peer (signature is checked by the registry)
+		if err := ds.registry.AddOrUpdatePeer(msg, senderAddr, source, ArrivalInterface(senderAddr)); err != nil {
+			logger.Error("🚫 %v (from %s)", err, senderAddr)
+		}
 
 	case MessageTypeLeave:
-		// Remove peer gracefully
+		// Only the peer itself may announce that it is leaving
+		if err := msg.Verify(); err != nil {
+			logger.Error("🚫 Rejected leave message: %v (from %s)", err, senderAddr)
+			return
+		}
 		ds.registry.RemovePeer(msg.PeerID)
 
-	case MessageTypePong:
-		// Update peer's last seen time
-		ds.registry.AddOrUpdatePeer(msg, senderAddr)
-
 	default:
 		logger.Debug("❓ Unknown message type: %s from %s", msg.Type, msg.Username)
 	}
@@ -0,0 +1,144 @@
+package identity
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"p2pchat/pkg/secure"
+)
+
+// PeerIDLength is the number of hex characters in a peer ID (80 bits of the key hash)
+const PeerIDLength = 20
+
+// Identity is a peer's long-term cryptographic identity
+// The Ed25519 key signs announcements and proves who we are, the peer ID is derived
+// from it so it stays the same across restarts and can't be claimed by anyone else.
+// The X25519 static key is used by the Noise handshake to encrypt connections.
+type Identity struct {
+	SigningKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+	StaticKey  *ecdh.PrivateKey
+	peerID     string
+}
+
+// LoadOrCreate reads the identity stored in dir, generating missing keys on first run
+func LoadOrCreate(dir string) (*Identity, error) {
+	signingKey, err := loadOrCreateSigningKey(filepath.Join(dir, "identity.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	staticKey, err := secure.LoadOrCreateStaticKey(filepath.Join(dir, "static.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	return New(signingKey, staticKey), nil
+}
+
+// New builds an identity from existing keys
+func New(signingKey ed25519.PrivateKey, staticKey *ecdh.PrivateKey) *Identity {
+	publicKey := signingKey.Public().(ed25519.PublicKey)
+	return &Identity{
+		SigningKey: signingKey,
+		PublicKey:  publicKey,
+		StaticKey:  staticKey,
+		peerID:     PeerIDFromPublicKey(publicKey),
+	}
+}
+
+// Generate creates a fresh in-memory identity (useful for tests and throwaway sessions)
+func Generate() (*Identity, error) {
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	staticKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate static key: %w", err)
+	}
+	return New(signingKey, staticKey), nil
+}
+
+// PeerID returns the stable peer ID derived from our public key
+func (id *Identity) PeerID() string {
+	return id.peerID
+}
+
+// PublicKeyString returns the public key in the form carried on the wire
+func (id *Identity) PublicKeyString() string {
+	return base64.StdEncoding.EncodeToString(id.PublicKey)
+}
+
+// Sign signs data and returns the signature in the form carried on the wire
+func (id *Identity) Sign(data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(id.SigningKey, data))
+}
+
+// PeerIDFromPublicKey derives a peer ID as the truncated SHA-256 fingerprint of a public key
+func PeerIDFromPublicKey(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:])[:PeerIDLength]
+}
+
+// Verify checks that publicKey belongs to peerID and that signature covers data
+func Verify(peerID, publicKey, signature string, data []byte) error {
+	if publicKey == "" || signature == "" {
+		return fmt.Errorf("message from %s is not signed", peerID)
+	}
+
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key from %s", peerID)
+	}
+	if PeerIDFromPublicKey(pub) != peerID {
+		return fmt.Errorf("public key does not match peer ID %s", peerID)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding from %s", peerID)
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		return fmt.Errorf("signature from %s does not verify", peerID)
+	}
+	return nil
+}
+
+// loadOrCreateSigningKey reads an Ed25519 seed from path, generating it on first run
+func loadOrCreateSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid identity file %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read identity file: %w", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	// Private keys are only readable by the owner
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create identity directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write identity file: %w", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,55 @@
+package identity
+
+import (
+	"testing"
+)
+
+func TestPeerIDStableAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := LoadOrCreate(dir)
+	if err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+	second, err := LoadOrCreate(dir)
+	if err != nil {
+		t.Fatalf("Failed to reload identity: %v", err)
+	}
+
+	if first.PeerID() != second.PeerID() {
+		t.Errorf("Peer ID changed across reload: %s vs %s", first.PeerID(), second.PeerID())
+	}
+	if len(first.PeerID()) != PeerIDLength {
+		t.Errorf("Expected %d character peer ID, got %q", PeerIDLength, first.PeerID())
+	}
+	if !first.StaticKey.Equal(second.StaticKey) {
+		t.Error("Static key changed across reload")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	alice, _ := Generate()
+	mallory, _ := Generate()
+	data := []byte("alice is at 192.168.1.10:8080")
+
+	signature := alice.Sign(data)
+	if err := Verify(alice.PeerID(), alice.PublicKeyString(), signature, data); err != nil {
+		t.Errorf("Valid signature rejected: %v", err)
+	}
+
+	// Tampered data
+	if err := Verify(alice.PeerID(), alice.PublicKeyString(), signature, []byte("alice is at 10.6.6.6:8080")); err == nil {
+		t.Error("Signature over different data should be rejected")
+	}
+
+	// Mallory claiming alice's peer ID with her own key
+	forged := mallory.Sign(data)
+	if err := Verify(alice.PeerID(), mallory.PublicKeyString(), forged, data); err == nil {
+		t.Error("Key that doesn't match the peer ID should be rejected")
+	}
+
+	// Unsigned message
+	if err := Verify(alice.PeerID(), "", "", data); err == nil {
+		t.Error("Unsigned message should be rejected")
+	}
+}
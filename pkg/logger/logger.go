@@ -32,6 +32,11 @@ func Silent() {
 	SetOutput(io.Discard)
 }
 
+// Quiet drops debug logging but keeps info and errors
+func Quiet() {
+	DebugLogger.SetOutput(io.Discard)
+}
+
 // ToFile redirects logging to a file
 func ToFile(filename string) error {
 	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
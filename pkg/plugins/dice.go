@@ -0,0 +1,119 @@
+package plugins
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+
+	"p2pchat/pkg/chat"
+)
+
+// Limits that keep a roll on one line
+const (
+	maxDice  = 20
+	maxSides = 1000
+)
+
+// Dice rolls dice for everyone to see: /roll 2d6+1
+type Dice struct {
+	host *chat.PluginHost
+	roll func(sides int) int // Swapped out by tests
+}
+
+// NewDice creates the dice roller
+func NewDice() *Dice {
+	return &Dice{roll: func(sides int) int { return rand.IntN(sides) + 1 }}
+}
+
+// Name implements chat.Plugin
+func (d *Dice) Name() string {
+	return "dice"
+}
+
+// Init implements chat.Plugin
+func (d *Dice) Init(host *chat.PluginHost) error {
+	d.host = host
+	return host.RegisterCommand("roll", "/roll [NdM+K]", "Roll dice in the current room, 1d6 by default", d.command)
+}
+
+// command posts the roll as the dice bot, so nobody can claim they rolled a 6
+func (d *Dice) command(cmd chat.Command) (string, error) {
+	spec := "1d6"
+	if len(cmd.Args) > 0 {
+		spec = cmd.Args[0]
+	}
+	count, sides, modifier, err := parseDice(spec)
+	if err != nil {
+		return "", err
+	}
+	return "", d.host.Say(cmd.ConversationID, d.rollDice(d.host.Username(), count, sides, modifier))
+}
+
+// rollDice rolls and describes the result: "🎲 alice rolled 2d6: 3 + 5 = 8"
+func (d *Dice) rollDice(username string, count, sides, modifier int) string {
+	spec := formatDice(count, sides, modifier)
+	rolls := make([]string, count)
+	total := modifier
+	for i := range rolls {
+		roll := d.roll(sides)
+		rolls[i] = strconv.Itoa(roll)
+		total += roll
+	}
+
+	if count == 1 && modifier == 0 {
+		return fmt.Sprintf("🎲 %s rolled %s: %d", username, spec, total)
+	}
+	detail := strings.Join(rolls, " + ")
+	if modifier > 0 {
+		detail += fmt.Sprintf(" + %d", modifier)
+	} else if modifier < 0 {
+		detail += fmt.Sprintf(" - %d", -modifier)
+	}
+	return fmt.Sprintf("🎲 %s rolled %s: %s = %d", username, spec, detail, total)
+}
+
+// parseDice reads dice notation like d20, 2d6 or 3d8-2
+func parseDice(spec string) (count, sides, modifier int, err error) {
+	invalid := fmt.Errorf("invalid dice %q, try 2d6 or d20+3", spec)
+
+	countText, rest, ok := strings.Cut(strings.ToLower(spec), "d")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+
+	count = 1
+	if countText != "" {
+		if count, err = strconv.Atoi(countText); err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+
+	sidesText := rest
+	if i := strings.IndexAny(rest, "+-"); i >= 0 {
+		sidesText = rest[:i]
+		if modifier, err = strconv.Atoi(rest[i:]); err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+	if sides, err = strconv.Atoi(sidesText); err != nil {
+		return 0, 0, 0, invalid
+	}
+
+	if count < 1 || count > maxDice {
+		return 0, 0, 0, fmt.Errorf("roll between 1 and %d dice", maxDice)
+	}
+	if sides < 2 || sides > maxSides {
+		return 0, 0, 0, fmt.Errorf("dice need between 2 and %d sides", maxSides)
+	}
+	return count, sides, modifier, nil
+}
+
+// formatDice writes dice notation back out
+func formatDice(count, sides, modifier int) string {
+	spec := fmt.Sprintf("%dd%d", count, sides)
+	if modifier != 0 {
+		spec += fmt.Sprintf("%+d", modifier)
+	}
+	return spec
+}
@@ -0,0 +1,56 @@
+package plugins
+
+import (
+	"fmt"
+	"strings"
+
+	"p2pchat/pkg/chat"
+	"p2pchat/pkg/logger"
+)
+
+// echoTrigger starts a message the echo bot answers
+const echoTrigger = "!echo "
+
+// Echo answers "!echo <text>" from anyone with the text, handy to check a peer
+// runs plugins. Every peer with it enabled answers, so it is off by default.
+type Echo struct {
+	host *chat.PluginHost
+}
+
+// NewEcho creates the echo bot
+func NewEcho() *Echo {
+	return &Echo{}
+}
+
+// Name implements chat.Plugin
+func (e *Echo) Name() string {
+	return "echo"
+}
+
+// Init implements chat.Plugin
+func (e *Echo) Init(host *chat.PluginHost) error {
+	e.host = host
+	host.OnMessage(e.answer)
+	host.OnSend(e.answer)
+
+	return host.RegisterCommand("echo", "/echo <text>", "Post text as the echo bot", func(cmd chat.Command) (string, error) {
+		if cmd.Text == "" {
+			return "", fmt.Errorf("usage: /echo <text>")
+		}
+		return "", host.Say(cmd.ConversationID, cmd.Text)
+	})
+}
+
+// answer replies to "!echo" messages, but never to bots (including itself)
+func (e *Echo) answer(msg *chat.Message) {
+	if msg.Bot != "" || (msg.Type != chat.MessageTypeChat && msg.Type != chat.MessageTypeDirect) {
+		return
+	}
+	text, ok := strings.CutPrefix(msg.Content, echoTrigger)
+	if !ok || strings.TrimSpace(text) == "" {
+		return
+	}
+	if err := e.host.Reply(msg, text); err != nil {
+		logger.Error("❌ Echo bot failed to answer: %v", err)
+	}
+}
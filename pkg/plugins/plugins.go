@@ -0,0 +1,50 @@
+package plugins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"p2pchat/pkg/chat"
+)
+
+// Built-in plugins, mostly there to show what the plugin API can do.
+// Write your own by implementing chat.Plugin and registering it with
+// ChatService.RegisterPlugin before the UI starts.
+
+// DefaultPlugins is what -plugins enables unless told otherwise
+const DefaultPlugins = "dice,remind"
+
+// builtins creates a fresh instance of each built-in plugin by name
+var builtins = map[string]func() chat.Plugin{
+	"echo":   func() chat.Plugin { return NewEcho() },
+	"dice":   func() chat.Plugin { return NewDice() },
+	"remind": func() chat.Plugin { return NewRemind() },
+}
+
+// Names returns the names of the built-in plugins
+func Names() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load creates the built-in plugins of a comma separated list, empty means none
+func Load(list string) ([]chat.Plugin, error) {
+	var loaded []chat.Plugin
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		create, ok := builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown plugin %q (available: %s)", name, strings.Join(Names(), ", "))
+		}
+		loaded = append(loaded, create())
+	}
+	return loaded, nil
+}
@@ -0,0 +1,63 @@
+package plugins
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		spec                   string
+		count, sides, modifier int
+	}{
+		{"d20", 1, 20, 0},
+		{"2d6", 2, 6, 0},
+		{"3D8-2", 3, 8, -2},
+		{"1d100+5", 1, 100, 5},
+	}
+	for _, tt := range tests {
+		count, sides, modifier, err := parseDice(tt.spec)
+		if err != nil || count != tt.count || sides != tt.sides || modifier != tt.modifier {
+			t.Errorf("parseDice(%q) = %d, %d, %d, %v", tt.spec, count, sides, modifier, err)
+		}
+	}
+
+	for _, spec := range []string{"", "6", "2x6", "d", "0d6", "2d1", "100d6", "2d6+x", "-1d6"} {
+		if _, _, _, err := parseDice(spec); err == nil {
+			t.Errorf("Expected parseDice(%q) to fail", spec)
+		}
+	}
+}
+
+func TestRollDice(t *testing.T) {
+	rolls := []int{3, 5}
+	dice := &Dice{roll: func(sides int) int {
+		roll := rolls[0]
+		rolls = rolls[1:]
+		return roll
+	}}
+
+	if got := dice.rollDice("alice", 2, 6, 1); got != "🎲 alice rolled 2d6+1: 3 + 5 + 1 = 9" {
+		t.Errorf("Unexpected roll: %q", got)
+	}
+
+	dice.roll = func(int) int { return 17 }
+	if got := dice.rollDice("bob", 1, 20, 0); got != "🎲 bob rolled 1d20: 17" {
+		t.Errorf("Unexpected roll: %q", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	loaded, err := Load(" echo, dice ,")
+	if err != nil || len(loaded) != 2 || loaded[0].Name() != "echo" || loaded[1].Name() != "dice" {
+		t.Errorf("Expected echo and dice, got %v (%v)", loaded, err)
+	}
+
+	if loaded, err := Load(""); err != nil || len(loaded) != 0 {
+		t.Errorf("An empty list should disable plugins, got %v (%v)", loaded, err)
+	}
+
+	if _, err := Load("dice,weather"); err == nil || !strings.Contains(err.Error(), "weather") {
+		t.Errorf("Expected an unknown plugin error, got %v", err)
+	}
+}
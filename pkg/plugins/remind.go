@@ -0,0 +1,64 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"p2pchat/pkg/chat"
+)
+
+// maxReminder keeps reminders within what a running chat is likely to see
+const maxReminder = 7 * 24 * time.Hour
+
+// Remind reminds only us of something later: /remind 10m stand up
+// Reminders live in memory, they are gone if the chat restarts.
+type Remind struct {
+	host *chat.PluginHost
+}
+
+// NewRemind creates the reminder bot
+func NewRemind() *Remind {
+	return &Remind{}
+}
+
+// Name implements chat.Plugin
+func (r *Remind) Name() string {
+	return "remind"
+}
+
+// Init implements chat.Plugin
+func (r *Remind) Init(host *chat.PluginHost) error {
+	r.host = host
+	return host.RegisterCommand("remind", "/remind <duration> <text>", "Remind yourself of something, e.g. /remind 10m stand up", r.command)
+}
+
+// command schedules a reminder in the room or DM it was typed in
+func (r *Remind) command(cmd chat.Command) (string, error) {
+	if len(cmd.Args) < 2 {
+		return "", fmt.Errorf("usage: /remind <duration> <text>")
+	}
+	delay, err := time.ParseDuration(cmd.Args[0])
+	if err != nil || delay <= 0 || delay > maxReminder {
+		return "", fmt.Errorf("invalid duration %q, try 10m or 1h30m (at most %s)", cmd.Args[0], maxReminder)
+	}
+	text := strings.TrimSpace(strings.TrimPrefix(cmd.Text, cmd.Args[0]))
+
+	r.host.Go(func(ctx context.Context) {
+		r.wait(ctx, cmd.ConversationID, delay, "⏰ "+text)
+	})
+	return fmt.Sprintf("⏰ Reminding you at %s", time.Now().Add(delay).Format("15:04")), nil
+}
+
+// wait shows the reminder once it is due, unless the chat stops first
+func (r *Remind) wait(ctx context.Context, conversationID string, delay time.Duration, text string) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		r.host.Notify(conversationID, text)
+	case <-ctx.Done():
+	}
+}
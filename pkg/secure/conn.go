@@ -0,0 +1,212 @@
+package secure
+
+import (
+	"crypto/ecdh"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxFrameSize is the largest frame on the wire (Noise caps messages at 64 KiB)
+	MaxFrameSize = 65535
+
+	// maxPlaintext is how much application data fits in one sealed frame
+	maxPlaintext = MaxFrameSize - tagLen
+
+	// HandshakeTimeout bounds how long a peer may take to complete the handshake
+	HandshakeTimeout = 10 * time.Second
+)
+
+// prologue binds the handshake to this application so keys can't be replayed elsewhere
+var prologue = []byte("p2pchat/1")
+
+// Conn is an encrypted net.Conn: every Write is sealed into length-prefixed
+// AEAD frames and every frame read is authenticated before its data is returned.
+// It can be used anywhere the plain TCP connection was, including bufio readers.
+type Conn struct {
+	net.Conn
+
+	send *cipherState
+	recv *cipherState
+
+	remoteStatic  []byte
+	handshakeHash []byte
+
+	readMutex  sync.Mutex
+	readBuffer []byte // Decrypted bytes not yet returned by Read
+
+	writeMutex sync.Mutex
+}
+
+// Client performs the initiator side of the handshake over an established connection
+func Client(conn net.Conn, static *ecdh.PrivateKey) (*Conn, error) {
+	return handshake(conn, static, true)
+}
+
+// Server performs the responder side of the handshake over an accepted connection
+func Server(conn net.Conn, static *ecdh.PrivateKey) (*Conn, error) {
+	return handshake(conn, static, false)
+}
+
+// handshake runs the three XX messages and returns a ready transport connection
+func handshake(conn net.Conn, static *ecdh.PrivateKey, initiator bool) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hs := newHandshakeState(initiator, static, prologue)
+
+	if initiator {
+		msg, err := hs.writeMessage1()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFrame(conn, msg); err != nil {
+			return nil, fmt.Errorf("failed to send handshake: %w", err)
+		}
+
+		msg, err = readFrame(conn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read handshake: %w", err)
+		}
+		if err := hs.readMessage2(msg); err != nil {
+			return nil, err
+		}
+
+		msg, err = hs.writeMessage3()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFrame(conn, msg); err != nil {
+			return nil, fmt.Errorf("failed to send handshake: %w", err)
+		}
+	} else {
+		msg, err := readFrame(conn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read handshake: %w", err)
+		}
+		if err := hs.readMessage1(msg); err != nil {
+			return nil, err
+		}
+
+		msg, err = hs.writeMessage2()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFrame(conn, msg); err != nil {
+			return nil, fmt.Errorf("failed to send handshake: %w", err)
+		}
+
+		msg, err = readFrame(conn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read handshake: %w", err)
+		}
+		if err := hs.readMessage3(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	// The initiator sends with the first key, the responder with the second
+	c1, c2, err := hs.symmetric.split()
+	if err != nil {
+		return nil, err
+	}
+	secureConn := &Conn{
+		Conn:          conn,
+		remoteStatic:  hs.rs.Bytes(),
+		handshakeHash: hs.symmetric.h,
+	}
+	if initiator {
+		secureConn.send, secureConn.recv = c1, c2
+	} else {
+		secureConn.send, secureConn.recv = c2, c1
+	}
+
+	return secureConn, nil
+}
+
+// RemoteStatic returns the peer's long-term X25519 public key proven during the handshake
+func (c *Conn) RemoteStatic() []byte {
+	return c.remoteStatic
+}
+
+// HandshakeHash returns the transcript hash of the handshake, identical on both ends
+// Signing it binds an identity to this specific connection (channel binding)
+func (c *Conn) HandshakeHash() []byte {
+	return c.handshakeHash
+}
+
+// Read returns decrypted application data, reading and authenticating frames as needed
+// A frame that fails authentication returns an error and the connection should be dropped
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	for len(c.readBuffer) == 0 {
+		frame, err := readFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		plaintext, err := c.recv.decrypt(nil, frame)
+		if err != nil {
+			return 0, err
+		}
+		c.readBuffer = plaintext
+	}
+
+	n := copy(p, c.readBuffer)
+	c.readBuffer = c.readBuffer[n:]
+	return n, nil
+}
+
+// Write seals p into one or more frames
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > maxPlaintext {
+			chunk = chunk[:maxPlaintext]
+		}
+
+		ciphertext, err := c.send.encrypt(nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		if err := writeFrame(c.Conn, ciphertext); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// writeFrame sends a 2-byte big-endian length followed by the frame body
+func writeFrame(w io.Writer, body []byte) error {
+	if len(body) > MaxFrameSize {
+		return fmt.Errorf("frame too large: %d bytes (max %d)", len(body), MaxFrameSize)
+	}
+	frame := make([]byte, 2+len(body))
+	binary.BigEndian.PutUint16(frame, uint16(len(body)))
+	copy(frame[2:], body)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads one length-prefixed frame
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
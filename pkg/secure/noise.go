@@ -0,0 +1,331 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// This file implements the Noise_XX_25519_AESGCM_SHA256 handshake from the
+// Noise Protocol Framework (https://noiseprotocol.org/noise.html), using only
+// the standard library. XX means both sides transmit their static key during
+// the handshake, so neither needs to know the other's key in advance:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+//
+// After the third message both sides hold two AEAD keys, one per direction.
+
+const (
+	protocolName = "Noise_XX_25519_AESGCM_SHA256"
+	hashLen      = sha256.Size
+	dhLen        = 32 // X25519 public key size
+	tagLen       = 16 // AES-GCM authentication tag
+)
+
+// ErrNonceExhausted is returned when a cipher key has encrypted 2^64-1 messages
+var ErrNonceExhausted = errors.New("noise: nonce exhausted, connection must be rekeyed")
+
+// cipherState holds one direction's AEAD key and message counter
+type cipherState struct {
+	aead  cipher.AEAD // nil until a key has been mixed in
+	nonce uint64
+}
+
+func (c *cipherState) initializeKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("noise: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("noise: %w", err)
+	}
+	c.aead = aead
+	c.nonce = 0
+	return nil
+}
+
+// nonceBytes encodes the counter as Noise's AESGCM nonce: 4 zero bytes then a big-endian uint64
+func (c *cipherState) nonceBytes() []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], c.nonce)
+	return nonce
+}
+
+func (c *cipherState) encrypt(ad, plaintext []byte) ([]byte, error) {
+	if c.aead == nil {
+		return plaintext, nil
+	}
+	if c.nonce == math.MaxUint64 {
+		return nil, ErrNonceExhausted
+	}
+	ciphertext := c.aead.Seal(nil, c.nonceBytes(), plaintext, ad)
+	c.nonce++
+	return ciphertext, nil
+}
+
+func (c *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if c.aead == nil {
+		return ciphertext, nil
+	}
+	if c.nonce == math.MaxUint64 {
+		return nil, ErrNonceExhausted
+	}
+	plaintext, err := c.aead.Open(nil, c.nonceBytes(), ciphertext, ad)
+	if err != nil {
+		return nil, fmt.Errorf("noise: message authentication failed: %w", err)
+	}
+	c.nonce++
+	return plaintext, nil
+}
+
+// symmetricState tracks the chaining key and transcript hash during the handshake
+type symmetricState struct {
+	cipher cipherState
+	ck     []byte // Chaining key
+	h      []byte // Handshake hash, binds everything sent so far
+}
+
+func newSymmetricState() *symmetricState {
+	// The protocol name fits in HASHLEN, so it is zero-padded rather than hashed
+	h := make([]byte, hashLen)
+	copy(h, protocolName)
+	ck := make([]byte, hashLen)
+	copy(ck, h)
+	return &symmetricState{ck: ck, h: h}
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	digest := sha256.New()
+	digest.Write(s.h)
+	digest.Write(data)
+	s.h = digest.Sum(nil)
+}
+
+func (s *symmetricState) mixKey(ikm []byte) error {
+	ck, key, err := noiseHKDF(s.ck, ikm)
+	if err != nil {
+		return err
+	}
+	s.ck = ck
+	return s.cipher.initializeKey(key)
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) ([]byte, error) {
+	ciphertext, err := s.cipher.encrypt(s.h, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return ciphertext, nil
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.cipher.decrypt(s.h, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split derives the two transport keys once the handshake is complete
+func (s *symmetricState) split() (*cipherState, *cipherState, error) {
+	key1, key2, err := noiseHKDF(s.ck, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	c1, c2 := &cipherState{}, &cipherState{}
+	if err := c1.initializeKey(key1); err != nil {
+		return nil, nil, err
+	}
+	if err := c2.initializeKey(key2); err != nil {
+		return nil, nil, err
+	}
+	return c1, c2, nil
+}
+
+// noiseHKDF is Noise's HKDF with two outputs, which is RFC 5869 with an empty info
+func noiseHKDF(chainingKey, ikm []byte) ([]byte, []byte, error) {
+	out, err := hkdf.Key(sha256.New, ikm, chainingKey, "", 2*hashLen)
+	if err != nil {
+		return nil, nil, fmt.Errorf("noise: %w", err)
+	}
+	return out[:hashLen], out[hashLen:], nil
+}
+
+// handshakeState runs the XX pattern for one side of the connection
+type handshakeState struct {
+	symmetric *symmetricState
+	initiator bool
+
+	s  *ecdh.PrivateKey // Our static key
+	e  *ecdh.PrivateKey // Our ephemeral key
+	rs *ecdh.PublicKey  // Remote static key (learned during the handshake)
+	re *ecdh.PublicKey  // Remote ephemeral key
+}
+
+func newHandshakeState(initiator bool, static *ecdh.PrivateKey, prologue []byte) *handshakeState {
+	hs := &handshakeState{
+		symmetric: newSymmetricState(),
+		initiator: initiator,
+		s:         static,
+	}
+	hs.symmetric.mixHash(prologue)
+	return hs
+}
+
+func (hs *handshakeState) dh(private *ecdh.PrivateKey, public *ecdh.PublicKey) error {
+	shared, err := private.ECDH(public)
+	if err != nil {
+		return fmt.Errorf("noise: key agreement failed: %w", err)
+	}
+	return hs.symmetric.mixKey(shared)
+}
+
+func (hs *handshakeState) writeEphemeral() ([]byte, error) {
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("noise: failed to generate ephemeral key: %w", err)
+	}
+	hs.e = e
+	pub := e.PublicKey().Bytes()
+	hs.symmetric.mixHash(pub)
+	return pub, nil
+}
+
+func (hs *handshakeState) readEphemeral(msg []byte) ([]byte, error) {
+	if len(msg) < dhLen {
+		return nil, fmt.Errorf("noise: handshake message too short")
+	}
+	re, err := ecdh.X25519().NewPublicKey(msg[:dhLen])
+	if err != nil {
+		return nil, fmt.Errorf("noise: invalid ephemeral key: %w", err)
+	}
+	hs.re = re
+	hs.symmetric.mixHash(msg[:dhLen])
+	return msg[dhLen:], nil
+}
+
+func (hs *handshakeState) writeStatic() ([]byte, error) {
+	return hs.symmetric.encryptAndHash(hs.s.PublicKey().Bytes())
+}
+
+func (hs *handshakeState) readStatic(msg []byte) ([]byte, error) {
+	size := dhLen + tagLen
+	if len(msg) < size {
+		return nil, fmt.Errorf("noise: handshake message too short")
+	}
+	pub, err := hs.symmetric.decryptAndHash(msg[:size])
+	if err != nil {
+		return nil, err
+	}
+	rs, err := ecdh.X25519().NewPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("noise: invalid static key: %w", err)
+	}
+	hs.rs = rs
+	return msg[size:], nil
+}
+
+// message1 (initiator -> responder): e
+func (hs *handshakeState) writeMessage1() ([]byte, error) {
+	e, err := hs.writeEphemeral()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := hs.symmetric.encryptAndHash(nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(e, payload...), nil
+}
+
+func (hs *handshakeState) readMessage1(msg []byte) error {
+	rest, err := hs.readEphemeral(msg)
+	if err != nil {
+		return err
+	}
+	_, err = hs.symmetric.decryptAndHash(rest)
+	return err
+}
+
+// message2 (responder -> initiator): e, ee, s, es
+func (hs *handshakeState) writeMessage2() ([]byte, error) {
+	out, err := hs.writeEphemeral()
+	if err != nil {
+		return nil, err
+	}
+	if err := hs.dh(hs.e, hs.re); err != nil { // ee
+		return nil, err
+	}
+	s, err := hs.writeStatic()
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, s...)
+	if err := hs.dh(hs.s, hs.re); err != nil { // es (responder side)
+		return nil, err
+	}
+	payload, err := hs.symmetric.encryptAndHash(nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, payload...), nil
+}
+
+func (hs *handshakeState) readMessage2(msg []byte) error {
+	rest, err := hs.readEphemeral(msg)
+	if err != nil {
+		return err
+	}
+	if err := hs.dh(hs.e, hs.re); err != nil { // ee
+		return err
+	}
+	rest, err = hs.readStatic(rest)
+	if err != nil {
+		return err
+	}
+	if err := hs.dh(hs.e, hs.rs); err != nil { // es (initiator side)
+		return err
+	}
+	_, err = hs.symmetric.decryptAndHash(rest)
+	return err
+}
+
+// message3 (initiator -> responder): s, se
+func (hs *handshakeState) writeMessage3() ([]byte, error) {
+	out, err := hs.writeStatic()
+	if err != nil {
+		return nil, err
+	}
+	if err := hs.dh(hs.s, hs.re); err != nil { // se (initiator side)
+		return nil, err
+	}
+	payload, err := hs.symmetric.encryptAndHash(nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, payload...), nil
+}
+
+func (hs *handshakeState) readMessage3(msg []byte) error {
+	rest, err := hs.readStatic(msg)
+	if err != nil {
+		return err
+	}
+	if err := hs.dh(hs.e, hs.rs); err != nil { // se (responder side)
+		return err
+	}
+	_, err = hs.symmetric.decryptAndHash(rest)
+	return err
+}
@@ -0,0 +1,179 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// recordingConn captures everything written to the wire and can corrupt outgoing frames
+type recordingConn struct {
+	net.Conn
+	mutex   sync.Mutex
+	written bytes.Buffer
+	tamper  bool // Flip a bit in the last byte of the next write
+}
+
+func (r *recordingConn) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.written.Write(p)
+	if r.tamper {
+		r.tamper = false
+		corrupted := append([]byte(nil), p...)
+		corrupted[len(corrupted)-1] ^= 0x01
+		return r.Conn.Write(corrupted)
+	}
+	return r.Conn.Write(p)
+}
+
+func (r *recordingConn) wire() []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]byte(nil), r.written.Bytes()...)
+}
+
+func newKey(t *testing.T) *ecdh.PrivateKey {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return key
+}
+
+// loopbackPair connects a client and server over 127.0.0.1 and runs the handshake
+func loopbackPair(t *testing.T, clientKey, serverKey *ecdh.PrivateKey) (*Conn, *Conn, *recordingConn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	serverResult := make(chan result, 1)
+	go func() {
+		raw, err := listener.Accept()
+		if err != nil {
+			serverResult <- result{err: err}
+			return
+		}
+		conn, err := Server(raw, serverKey)
+		serverResult <- result{conn, err}
+	}()
+
+	raw, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	recorder := &recordingConn{Conn: raw}
+	client, err := Client(recorder, clientKey)
+	if err != nil {
+		t.Fatalf("Client handshake failed: %v", err)
+	}
+
+	res := <-serverResult
+	if res.err != nil {
+		t.Fatalf("Server handshake failed: %v", res.err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+		res.conn.Close()
+	})
+	return client, res.conn, recorder
+}
+
+func TestHandshakeExchangesStaticKeys(t *testing.T) {
+	clientKey, serverKey := newKey(t), newKey(t)
+	client, server, _ := loopbackPair(t, clientKey, serverKey)
+
+	if !bytes.Equal(client.RemoteStatic(), serverKey.PublicKey().Bytes()) {
+		t.Error("Client should learn the server's static key")
+	}
+	if !bytes.Equal(server.RemoteStatic(), clientKey.PublicKey().Bytes()) {
+		t.Error("Server should learn the client's static key")
+	}
+	if !bytes.Equal(client.HandshakeHash(), server.HandshakeHash()) {
+		t.Error("Both sides should agree on the handshake hash")
+	}
+}
+
+func TestCiphertextDiffersFromPlaintext(t *testing.T) {
+	client, server, recorder := loopbackPair(t, newKey(t), newKey(t))
+
+	plaintext := []byte(`{"type":"chat","content":"the deploy password is hunter2"}` + "\n")
+	if _, err := client.Write(plaintext); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	received := make([]byte, len(plaintext))
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(received, plaintext) {
+		t.Errorf("Server decrypted %q, expected %q", received, plaintext)
+	}
+
+	// Nothing readable should have crossed the wire
+	wire := recorder.wire()
+	if bytes.Contains(wire, []byte("hunter2")) || bytes.Contains(wire, []byte(`"type"`)) {
+		t.Error("Plaintext leaked onto the wire")
+	}
+}
+
+func TestTamperedFrameRejected(t *testing.T) {
+	client, server, recorder := loopbackPair(t, newKey(t), newKey(t))
+
+	recorder.mutex.Lock()
+	recorder.tamper = true
+	recorder.mutex.Unlock()
+
+	if _, err := client.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	buffer := make([]byte, 64)
+	if _, err := server.Read(buffer); err == nil {
+		t.Error("Tampered frame should fail authentication")
+	}
+}
+
+func TestLargeWriteSpansFrames(t *testing.T) {
+	client, server, _ := loopbackPair(t, newKey(t), newKey(t))
+
+	payload := bytes.Repeat([]byte("x"), 3*MaxFrameSize)
+	go client.Write(payload)
+
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Error("Large payload was corrupted across frames")
+	}
+}
+
+func TestStaticKeyPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "static.key")
+
+	first, err := LoadOrCreateStaticKey(path)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	second, err := LoadOrCreateStaticKey(path)
+	if err != nil {
+		t.Fatalf("Failed to reload key: %v", err)
+	}
+	if !first.Equal(second) {
+		t.Error("Reloaded key should match the generated one")
+	}
+}
@@ -0,0 +1,324 @@
+package settings
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"p2pchat/pkg/logger"
+)
+
+// The config file saves retyping flags. It is a small subset of TOML: top
+// level keys are defaults, [profiles.<name>] sections override them when the
+// profile is picked with -profile, P2PCHAT_PROFILE or a top level profile key.
+//
+//	username = "alice"
+//	theme = "light"
+//	plugins = ["dice", "remind"]
+//
+//	[profiles.work]
+//	multicast = "239.10.0.1:9999"
+//	port_range = "9000-9099"
+//	beacon_interval = "2s"
+//
+// Keys are the long names of the command line options, with - or _.
+// Every key can also come from the environment as P2PCHAT_<KEY>, e.g.
+// P2PCHAT_HISTORY_DAYS=7. Flags beat the environment, which beats the file.
+
+const (
+	// EnvPrefix starts the environment variables we read
+	EnvPrefix = "P2PCHAT_"
+
+	// EnvConfig and EnvProfile pick the file and profile, they are not settings themselves
+	EnvConfig  = EnvPrefix + "CONFIG"
+	EnvProfile = EnvPrefix + "PROFILE"
+
+	// profileKey picks the default profile at the top of the file
+	profileKey = "profile"
+
+	// profilesTable is the table profile sections live in: [profiles.work]
+	profilesTable = "profiles"
+)
+
+// File is a parsed config file
+type File struct {
+	Path     string
+	Defaults map[string]string            // Top level keys
+	Profiles map[string]map[string]string // Profile name -> keys
+}
+
+// Layer is one source of settings, keyed by flag name
+type Layer struct {
+	Source  string // For error messages: a file path or "environment"
+	Values  map[string]string
+	Lenient bool // Skip unknown settings instead of rejecting them
+}
+
+// Path returns where the config file lives: $XDG_CONFIG_HOME/p2pchat/config
+func Path() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "" // No home directory, no config file
+	}
+	return filepath.Join(configDir, "p2pchat", "config")
+}
+
+// Load reads a config file, a missing file is the same as an empty one unless required
+func Load(path string, required bool) (*File, error) {
+	file := &File{Path: path, Defaults: map[string]string{}, Profiles: map[string]map[string]string{}}
+	if path == "" {
+		return file, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && !required {
+		return file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+
+	parsed, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	parsed.Path = path
+	return parsed, nil
+}
+
+// Parse reads config file syntax
+func Parse(r io.Reader) (*File, error) {
+	file := &File{Defaults: map[string]string{}, Profiles: map[string]map[string]string{}}
+	section := file.Defaults
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		// [profiles.work] starts a profile
+		if strings.HasPrefix(line, "[") {
+			name, err := parseSection(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			if _, ok := file.Profiles[name]; ok {
+				return nil, fmt.Errorf("line %d: profile %s is defined twice", lineNumber, name)
+			}
+			section = map[string]string{}
+			file.Profiles[name] = section
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		key = normalizeKey(strings.TrimSpace(key))
+		if key == "" {
+			return nil, fmt.Errorf("line %d: missing key", lineNumber)
+		}
+		if key == profileKey && len(file.Profiles) > 0 {
+			return nil, fmt.Errorf("line %d: profile can only be picked at the top of the file", lineNumber)
+		}
+		if _, ok := section[key]; ok {
+			return nil, fmt.Errorf("line %d: %s is set twice", lineNumber, key)
+		}
+
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", lineNumber, key, err)
+		}
+		section[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return file, nil
+}
+
+// Profile returns the settings of a profile on top of the defaults
+// An empty name means the profile the file picks itself, if any
+func (f *File) Profile(name string) (Layer, error) {
+	if name == "" {
+		name = f.Defaults[profileKey]
+	}
+
+	values := make(map[string]string, len(f.Defaults))
+	for key, value := range f.Defaults {
+		if key != profileKey {
+			values[key] = value
+		}
+	}
+
+	source := f.Path
+	if name != "" {
+		profile, ok := f.Profiles[name]
+		if !ok {
+			return Layer{}, fmt.Errorf("unknown profile %q (defined: %s)", name, strings.Join(f.ProfileNames(), ", "))
+		}
+		for key, value := range profile {
+			values[key] = value
+		}
+		source = fmt.Sprintf("%s [%s.%s]", f.Path, profilesTable, name)
+	}
+	return Layer{Source: source, Values: values}, nil
+}
+
+// ProfileNames returns the profiles the file defines
+func (f *File) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FromEnv collects P2PCHAT_* settings from an environment like os.Environ()
+// Unknown ones are skipped, other tools and older versions may share the prefix
+func FromEnv(environ []string) Layer {
+	values := make(map[string]string)
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, EnvPrefix) || name == EnvConfig || name == EnvProfile {
+			continue
+		}
+		values[normalizeKey(strings.TrimPrefix(name, EnvPrefix))] = value
+	}
+	return Layer{Source: "environment", Values: values, Lenient: true}
+}
+
+// Apply sets the flags the command line left alone, each from the first layer that has it
+// Only flags in settable can be set, anything else is an error so typos don't go unnoticed,
+// unless the layer is lenient
+func Apply(flags *flag.FlagSet, settable map[string]bool, layers ...Layer) error {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for _, layer := range layers {
+		keys := make([]string, 0, len(layer.Values))
+		for key := range layer.Values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys) // Report the same error every time
+
+		for _, key := range keys {
+			if !settable[key] && layer.Lenient {
+				logger.Debug("⏩ Ignoring unknown setting %q in %s", key, layer.Source)
+				continue
+			}
+			if !settable[key] {
+				return fmt.Errorf("unknown setting %q in %s", key, layer.Source)
+			}
+			if explicit[key] {
+				continue
+			}
+			if err := flags.Set(key, layer.Values[key]); err != nil {
+				return fmt.Errorf("invalid %s in %s: %w", key, layer.Source, err)
+			}
+			explicit[key] = true
+		}
+	}
+	return nil
+}
+
+// normalizeKey turns history_days and HISTORY_DAYS into the flag name history-days
+func normalizeKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// parseSection reads a [profiles.name] header
+func parseSection(line string) (string, error) {
+	if !strings.HasSuffix(line, "]") {
+		return "", fmt.Errorf("unterminated section %s", line)
+	}
+	table, name, ok := strings.Cut(strings.TrimSpace(line[1:len(line)-1]), ".")
+	if !ok || strings.TrimSpace(table) != profilesTable || strings.TrimSpace(name) == "" {
+		return "", fmt.Errorf("unknown section %s, profiles look like [%s.work]", line, profilesTable)
+	}
+	return strings.Trim(strings.TrimSpace(name), `"`), nil
+}
+
+// parseValue reads a string, number, boolean or list of strings, lists become comma separated
+func parseValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil // Literal strings have no escapes
+	case strings.HasPrefix(raw, "["):
+		return parseList(raw)
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+
+	if _, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 10, 64); err != nil {
+		return "", fmt.Errorf("invalid value %s, strings need quotes", raw)
+	}
+	return strings.ReplaceAll(raw, "_", ""), nil
+}
+
+// parseList reads ["dice", "remind"]
+func parseList(raw string) (string, error) {
+	if !strings.HasSuffix(raw, "]") {
+		return "", fmt.Errorf("unterminated list %s", raw)
+	}
+	inner := strings.TrimSpace(raw[1 : len(raw)-1])
+	if inner == "" {
+		return "", nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(inner, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue // Trailing comma
+		}
+		value, err := parseValue(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, value)
+	}
+	return strings.Join(items, ","), nil
+}
+
+// stripComment cuts a # comment off a line, unless the # is inside a string
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
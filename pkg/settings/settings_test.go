@@ -0,0 +1,152 @@
+package settings
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+# Defaults for every profile
+username = "alice"   # inline comments are fine
+history_days = 7
+plugins = ["dice", "remind",]
+multicast = "224.0.0.1:9999 # not a comment"
+profile = "home"
+
+[profiles.home]
+theme = 'light'
+
+[profiles.work]
+username = "alice.smith"
+beacon-interval = "2s"
+`
+
+func TestParse(t *testing.T) {
+	file, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"username":     "alice",
+		"history-days": "7",
+		"plugins":      "dice,remind",
+		"multicast":    "224.0.0.1:9999 # not a comment",
+		"profile":      "home",
+	}
+	for key, value := range expected {
+		if file.Defaults[key] != value {
+			t.Errorf("Expected %s = %q, got %q", key, value, file.Defaults[key])
+		}
+	}
+	if names := file.ProfileNames(); len(names) != 2 || names[0] != "home" || names[1] != "work" {
+		t.Errorf("Expected profiles home and work, got %v", names)
+	}
+	if file.Profiles["work"]["beacon-interval"] != "2s" {
+		t.Errorf("Expected work's beacon interval, got %v", file.Profiles["work"])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	broken := map[string]string{
+		"no equals":         "username alice",
+		"unquoted string":   "username = alice",
+		"unknown section":   "[network]",
+		"duplicate key":     "port = 1\nport = 2",
+		"duplicate profile": "[profiles.a]\n[profiles.a]",
+		"late profile key":  "[profiles.a]\nprofile = \"a\"",
+		"unterminated":      `username = "alice`,
+	}
+	for name, text := range broken {
+		if _, err := Parse(strings.NewReader(text)); err == nil {
+			t.Errorf("%s: expected a parse error", name)
+		}
+	}
+
+	_, err := Parse(strings.NewReader("debug = true\nport = 80 80"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Errors should say which line is wrong, got %v", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	file, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// The file picks home itself
+	layer, err := file.Profile("")
+	if err != nil || layer.Values["theme"] != "light" || layer.Values["username"] != "alice" {
+		t.Errorf("Expected home on top of the defaults, got %v (%v)", layer.Values, err)
+	}
+	if _, ok := layer.Values["profile"]; ok {
+		t.Error("The profile key picks a profile, it is not a setting")
+	}
+
+	layer, err = file.Profile("work")
+	if err != nil || layer.Values["username"] != "alice.smith" || layer.Values["history-days"] != "7" {
+		t.Errorf("Expected work on top of the defaults, got %v (%v)", layer.Values, err)
+	}
+
+	if _, err := file.Profile("moon"); err == nil || !strings.Contains(err.Error(), "home, work") {
+		t.Errorf("Expected an unknown profile error listing the profiles, got %v", err)
+	}
+}
+
+func TestApplyPrecedence(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	username := flags.String("username", "", "")
+	port := flags.Int("port", 0, "")
+	beacon := flags.Duration("beacon-interval", 5*time.Second, "")
+	theme := flags.String("theme", "dark", "")
+	settable := map[string]bool{"username": true, "port": true, "beacon-interval": true, "theme": true}
+
+	if err := flags.Parse([]string{"-username", "from-flag"}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	env := FromEnv([]string{"P2PCHAT_USERNAME=from-env", "P2PCHAT_PORT=9001", "P2PCHAT_PROFILE=work", "P2PCHAT_LEGACY_MODE=1", "HOME=/root"})
+	file := Layer{Source: "config", Values: map[string]string{"username": "from-file", "port": "9002", "beacon-interval": "2s"}}
+
+	if err := Apply(flags, settable, env, file); err != nil {
+		t.Fatalf("Expected unknown environment variables to be ignored, got %v", err)
+	}
+	if *username != "from-flag" || *port != 9001 || *beacon != 2*time.Second || *theme != "dark" {
+		t.Errorf("Expected flag > env > file > default, got %s %d %s %s", *username, *port, *beacon, *theme)
+	}
+
+	err := Apply(flags, settable, Layer{Source: "config", Values: map[string]string{"colour": "red"}})
+	if err == nil || !strings.Contains(err.Error(), "colour") {
+		t.Errorf("Expected unknown settings to be rejected, got %v", err)
+	}
+	// Layers already applied count as set, so check bad values on a fresh set
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("port", 0, "")
+	err = Apply(flags, map[string]bool{"port": true}, Layer{Source: "config", Values: map[string]string{"port": "lots"}})
+	if err == nil || !strings.Contains(err.Error(), "invalid port in config") {
+		t.Errorf("Expected a bad value to name its source, got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+
+	if file, err := Load(path, false); err != nil || len(file.Defaults) != 0 {
+		t.Errorf("A missing config should be empty, got %v (%v)", file, err)
+	}
+	if _, err := Load(path, true); err == nil {
+		t.Error("A config somebody asked for has to exist")
+	}
+
+	os.WriteFile(path, []byte("port = 8080\n"), 0600)
+	if file, err := Load(path, true); err != nil || file.Defaults["port"] != "8080" {
+		t.Errorf("Expected port 8080, got %v (%v)", file, err)
+	}
+}
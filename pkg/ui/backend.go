@@ -0,0 +1,55 @@
+package ui
+
+import (
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/chat"
+)
+
+// Backend is everything the TUI needs from a chat service
+// *chat.ChatService runs one in this process, *daemon.Client attaches to a daemon
+type Backend interface {
+	GetPeerID() string
+	GetMessages() <-chan *chat.Message
+	GetConnectedPeers() []chat.PeerInfo
+	ResolvePeer(name string) (chat.PeerInfo, error)
+	ConnectTo(address string) error
+
+	// Messages
+	SendRoomMessage(roomID, content string) error
+	SendDirect(peerID, content string) error
+	SendReply(parentID, content string) error
+	GetRoomHistory(roomID string) []*chat.Message
+	GetMessage(messageID string) *chat.Message
+	GetReplies(parentID string) []*chat.Message
+	GetThread(messageID string) []*chat.Message
+	React(targetID, emoji string) error
+	EditMessage(targetID, content string) error
+	DeleteMessage(targetID string) error
+	GetDeliveryState(messageID string) chat.DeliveryState
+	MarkRead(messageIDs ...string)
+
+	// Rooms and DMs
+	GetJoinedRooms() []string
+	GetRoomMembers(roomID string) []string
+	JoinRoom(name string) (string, error)
+	PartRoom(name string) (string, error)
+	GetDirectConversations() map[string]string
+
+	// Presence and typing
+	GetTyping() map[string][]string
+	NotifyTyping(conversationID string)
+	GetPresence() (peer.Presence, string)
+	SetPresence(presence peer.Presence, reason string) error
+	ChangeUsername(newUsername string) error
+
+	// File transfers
+	GetTransfers() []chat.Transfer
+	SendFile(name, path string) (string, error)
+	AcceptFile(id string) error
+	RejectFile(id string) error
+	CancelFile(id string) error
+
+	// Plugins
+	RunCommand(conversationID, line string) (bool, string, error)
+	GetCommands() []chat.CommandInfo
+}
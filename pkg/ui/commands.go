@@ -1,7 +1,10 @@
 package ui
 
 import (
+	"fmt"
 	"p2pchat/pkg/chat"
+	"path/filepath"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -14,9 +17,20 @@ type IncomingMessageMsg struct {
 
 // NEW: Message for loading existing chat history
 type MessageHistoryMsg struct {
+	RoomID   string
 	Messages []*chat.Message
 }
 
+// TransferUpdateMsg refreshes file transfer progress
+type TransferUpdateMsg struct {
+	Transfers []chat.Transfer
+}
+
+// TypingUpdateMsg refreshes who is typing where
+type TypingUpdateMsg struct {
+	Typing map[string][]string
+}
+
 type PeerUpdateMsg struct {
 	Peers []chat.PeerInfo
 }
@@ -26,8 +40,13 @@ type StatusUpdateMsg struct {
 	IsError bool
 }
 
+// CommandOutputMsg carries what a plugin's slash command had to say
+type CommandOutputMsg struct {
+	Output string
+}
+
 // Commands that bridge your ChatService to Bubble Tea
-func ListenForMessages(chatService *chat.ChatService) tea.Cmd {
+func ListenForMessages(chatService Backend) tea.Cmd {
 	return func() tea.Msg {
 		select {
 		case msg := <-chatService.GetMessages():
@@ -39,16 +58,21 @@ func ListenForMessages(chatService *chat.ChatService) tea.Cmd {
 }
 
 // NEW: Load existing message history from ChatService
-func LoadMessageHistory(chatService *chat.ChatService) tea.Cmd {
+func LoadMessageHistory(chatService Backend) tea.Cmd {
+	return LoadRoomHistory(chatService, chat.DefaultRoom)
+}
+
+// LoadRoomHistory loads the stored messages of a single room
+func LoadRoomHistory(chatService Backend, roomID string) tea.Cmd {
 	return func() tea.Msg {
-		messages := chatService.GetMessageHistory()
-		return MessageHistoryMsg{Messages: messages}
+		messages := chatService.GetRoomHistory(roomID)
+		return MessageHistoryMsg{RoomID: roomID, Messages: messages}
 	}
 }
 
-func SendMessageCmd(chatService *chat.ChatService, content string) tea.Cmd {
+func SendMessageCmd(chatService Backend, roomID, content string) tea.Cmd {
 	return func() tea.Msg {
-		err := chatService.SendMessage(content)
+		err := chatService.SendRoomMessage(roomID, content)
 		if err != nil {
 			return StatusUpdateMsg{Status: "Error: " + err.Error(), IsError: true}
 		}
@@ -56,7 +80,18 @@ func SendMessageCmd(chatService *chat.ChatService, content string) tea.Cmd {
 	}
 }
 
-func UpdatePeers(chatService *chat.ChatService) tea.Cmd {
+// SendDirectCmd sends a private message to a single peer
+func SendDirectCmd(chatService Backend, peerID, content string) tea.Cmd {
+	return func() tea.Msg {
+		err := chatService.SendDirect(peerID, content)
+		if err != nil {
+			return StatusUpdateMsg{Status: "Error: " + err.Error(), IsError: true}
+		}
+		return StatusUpdateMsg{Status: "Direct message sent", IsError: false}
+	}
+}
+
+func UpdatePeers(chatService Backend) tea.Cmd {
 	return func() tea.Msg {
 		peers := chatService.GetConnectedPeers()
 		return PeerUpdateMsg{Peers: peers}
@@ -68,3 +103,110 @@ func PeriodicPeerUpdate() tea.Cmd {
 		return struct{}{} // This matches your update.go handler
 	})
 }
+
+// PeriodicTransferUpdate polls file transfer progress once a second
+func PeriodicTransferUpdate(chatService Backend) tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return TransferUpdateMsg{Transfers: chatService.GetTransfers()}
+	})
+}
+
+// SendReplyCmd answers an earlier message in its room or DM
+func SendReplyCmd(chatService Backend, parentID, content string) tea.Cmd {
+	return func() tea.Msg {
+		if err := chatService.SendReply(parentID, content); err != nil {
+			return StatusUpdateMsg{Status: "Error: " + err.Error(), IsError: true}
+		}
+		return StatusUpdateMsg{Status: "Reply sent", IsError: false}
+	}
+}
+
+// ReactCmd toggles an emoji reaction on a message
+func ReactCmd(chatService Backend, messageID, emoji string) tea.Cmd {
+	return func() tea.Msg {
+		if err := chatService.React(messageID, emoji); err != nil {
+			return StatusUpdateMsg{Status: "Error: " + err.Error(), IsError: true}
+		}
+		return StatusUpdateMsg{Status: "Reacted " + emoji, IsError: false}
+	}
+}
+
+// EditMessageCmd replaces the text of one of our messages
+func EditMessageCmd(chatService Backend, messageID, content string) tea.Cmd {
+	return func() tea.Msg {
+		if err := chatService.EditMessage(messageID, content); err != nil {
+			return StatusUpdateMsg{Status: "Error: " + err.Error(), IsError: true}
+		}
+		return StatusUpdateMsg{Status: "Message edited", IsError: false}
+	}
+}
+
+// DeleteMessageCmd deletes one of our messages for everyone
+func DeleteMessageCmd(chatService Backend, messageID string) tea.Cmd {
+	return func() tea.Msg {
+		if err := chatService.DeleteMessage(messageID); err != nil {
+			return StatusUpdateMsg{Status: "Error: " + err.Error(), IsError: true}
+		}
+		return StatusUpdateMsg{Status: "Message deleted", IsError: false}
+	}
+}
+
+// MarkReadCmd sends read receipts for messages that made it onto the screen
+func MarkReadCmd(chatService Backend, messageIDs []string) tea.Cmd {
+	return func() tea.Msg {
+		chatService.MarkRead(messageIDs...)
+		return nil
+	}
+}
+
+// PeriodicTypingUpdate polls typing indicators once a second so they expire on screen
+func PeriodicTypingUpdate(chatService Backend) tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return TypingUpdateMsg{Typing: chatService.GetTyping()}
+	})
+}
+
+// NotifyTypingCmd tells the current room or DM that we are typing (throttled by ChatService)
+func NotifyTypingCmd(chatService Backend, conversationID string) tea.Cmd {
+	return func() tea.Msg {
+		chatService.NotifyTyping(conversationID)
+		return nil
+	}
+}
+
+// SendFileCmd offers a file to a peer
+func SendFileCmd(chatService Backend, name, path string) tea.Cmd {
+	return func() tea.Msg {
+		id, err := chatService.SendFile(name, path)
+		if err != nil {
+			return StatusUpdateMsg{Status: "Error: " + err.Error(), IsError: true}
+		}
+		return StatusUpdateMsg{Status: fmt.Sprintf("📎 Offered %s to %s [%s], waiting for them to accept", filepath.Base(path), name, id), IsError: false}
+	}
+}
+
+// ConnectCmd dials a peer by address, for networks where discovery can't see it
+func ConnectCmd(chatService Backend, address string) tea.Cmd {
+	return func() tea.Msg {
+		if err := chatService.ConnectTo(address); err != nil {
+			return StatusUpdateMsg{Status: "Error: " + err.Error(), IsError: true}
+		}
+		return StatusUpdateMsg{Status: fmt.Sprintf("🔗 Connected to %s, it will be redialed from now on", address), IsError: false}
+	}
+}
+
+// RunPluginCommandCmd runs a slash command the built-in ones don't know, in case a plugin does
+func RunPluginCommandCmd(chatService Backend, conversationID, line string) tea.Cmd {
+	return func() tea.Msg {
+		handled, output, err := chatService.RunCommand(conversationID, line)
+		switch {
+		case !handled:
+			return StatusUpdateMsg{Status: fmt.Sprintf("Unknown command: %s. Type /help for available commands.", strings.Fields(line)[0]), IsError: true}
+		case err != nil:
+			return StatusUpdateMsg{Status: "Error: " + err.Error(), IsError: true}
+		case output != "":
+			return CommandOutputMsg{Output: output}
+		}
+		return nil
+	}
+}
@@ -1,6 +1,8 @@
 package ui
 
 import (
+	"fmt"
+	"sort"
 	"time"
 
 	"p2pchat/pkg/chat"
@@ -13,13 +15,33 @@ import (
 // This is your "single source of truth" - everything the UI needs to know
 type ChatModel struct {
 	// Core chat functionality
-	chatService *chat.ChatService
+	chatService Backend
 
 	// UI State
 	messages    []DisplayMessage // All chat messages to show
 	peers       []PeerDisplay    // Connected peers to show in sidebar
 	input       textinput.Model  // Text input component for typing
 	maxMessages int              // Maximum messages to keep in UI (performance optimization)
+	theme       Theme            // Colors (see theme.go)
+
+	// Rooms - messages only holds the current room, switching reloads from history
+	currentRoom string            // Room or DM conversation shown in the chat area and targeted by sends
+	rooms       []string          // Rooms we have joined, in switcher order
+	queries     []string          // Open DM conversation IDs, listed after the rooms
+	queryNames  map[string]string // peerID -> username for labelling DM conversations
+	unread      map[string]int    // Unseen chat messages per room or DM
+	readSent    map[string]bool   // Messages we already sent read receipts for
+
+	// File transfers, refreshed once a second
+	transfers []chat.Transfer
+
+	// Who is typing, keyed by room or DM conversation ID
+	typing map[string][]string
+
+	// Message selected in FocusMessages mode, and the one being edited or answered in the input
+	selectedID string
+	editingID  string
+	replyingID string
 
 	// Scroll state for message history
 	scrollOffset    int  // How many messages scrolled up from bottom (0 = at bottom)
@@ -47,14 +69,74 @@ type DisplayMessage struct {
 	Timestamp time.Time
 	Type      MessageType // chat, join, leave, system
 	Style     string      // Color/style info
+
+	// Set for messages from the network, so they can be selected and annotated
+	ID        string
+	SenderID  string
+	Reactions map[string][]string // emoji -> usernames
+	Edited    bool
+	Deleted   bool
+	Bot       string // Plugin that wrote this for its sender
+
+	// Threads: the message this one answers and how many answers it got
+	ReplyTo   string
+	QuoteUser string // Author of the parent, empty if we don't have it
+	QuoteText string // Text of the parent
+	Replies   int
+
+	// Our own messages: how many peers received and read them
+	Own       bool
+	Delivered int
+	Read      int
+}
+
+// displayMessage converts a chat message with its reactions, edits and thread info applied
+func (m ChatModel) displayMessage(msg *chat.Message) DisplayMessage {
+	display := DisplayMessage{
+		Content:   msg.Text(),
+		Username:  msg.Username,
+		Timestamp: msg.Timestamp,
+		Type:      convertMessageType(msg.Type),
+		ID:        msg.ID,
+		SenderID:  msg.SenderID,
+		Reactions: msg.Reactions,
+		Edited:    msg.IsEdited(),
+		Deleted:   msg.Deleted,
+		Bot:       msg.Bot,
+		ReplyTo:   msg.ReplyTo,
+		Replies:   len(m.chatService.GetReplies(msg.ID)),
+	}
+
+	if msg.ReplyTo != "" {
+		if parent := m.chatService.GetMessage(msg.ReplyTo); parent != nil {
+			display.QuoteUser = parent.Username
+			display.QuoteText = parent.Text()
+		}
+	}
+
+	if msg.SenderID == m.chatService.GetPeerID() && (msg.Type == chat.MessageTypeChat || msg.Type == chat.MessageTypeDirect) {
+		state := m.chatService.GetDeliveryState(msg.ID)
+		display.Own = true
+		display.Delivered = len(state.Delivered)
+		display.Read = len(state.Read)
+	}
+	return display
 }
 
 // PeerDisplay represents peer info formatted for the sidebar
 type PeerDisplay struct {
+	PeerID   string
 	Username string
 	Status   string // "connected", "connecting", "offline"
 	Address  string
 	LastSeen time.Time
+	Source   string // How we found them: "multicast", "pex", "static", "incoming"
+
+	Interface string // Where their beacons come in, "" if none do
+	Pending   int    // Our messages waiting in the outbox until they are back
+
+	Presence       string // "available", "away", "busy"
+	PresenceReason string
 }
 
 // FocusArea represents which part of the UI currently has focus
@@ -77,24 +159,44 @@ const (
 	MessageTypeError
 )
 
-// NewChatModel creates a new chat model with your existing ChatService
-func NewChatModel(chatService *chat.ChatService) ChatModel {
+// NewChatModel creates a new chat model on top of a local chat service or an attached daemon
+func NewChatModel(chatService Backend) ChatModel {
 	input := textinput.New()
 	input.Placeholder = "Type a message..."
 	input.Focus()
 
-	return ChatModel{
+	model := ChatModel{
 		chatService:     chatService,
 		messages:        []DisplayMessage{},
 		peers:           []PeerDisplay{},
 		input:           input,
-		maxMessages:     500,  // UI limit lower than backend (1000) for performance
+		maxMessages:     500, // UI limit lower than backend (1000) for performance
+		theme:           Themes[DefaultTheme],
+		currentRoom:     chat.DefaultRoom,
+		rooms:           chatService.GetJoinedRooms(),
+		queryNames:      make(map[string]string),
+		unread:          make(map[string]int),
+		readSent:        make(map[string]bool),
+		typing:          make(map[string][]string),
 		scrollOffset:    0,    // Start at bottom
 		maxScrollOffset: 0,    // No messages yet
 		autoScroll:      true, // Auto-scroll to new messages
 		focused:         FocusInput,
 		showHelp:        false,
 	}
+
+	// Reopen DMs that survived a restart in the persisted history
+	for peerID, username := range chatService.GetDirectConversations() {
+		model.openQuery(peerID, username)
+	}
+	sort.Strings(model.queries)
+
+	return model
+}
+
+// SetTheme changes the colors, call it before the program starts
+func (m *ChatModel) SetTheme(theme Theme) {
+	m.theme = theme
 }
 
 // Scroll Management Methods
@@ -152,10 +254,12 @@ func (m *ChatModel) updateScrollBounds() {
 // Init returns initial commands when the app starts
 func (m ChatModel) Init() tea.Cmd {
 	return tea.Batch(
-		LoadMessageHistory(m.chatService), // NEW: Load existing message history
+		LoadMessageHistory(m.chatService), // Load existing message history of the default room
 		ListenForMessages(m.chatService),  // Start listening for P2P messages
 		UpdatePeers(m.chatService),        // Get initial peer list
 		PeriodicPeerUpdate(),              // Start periodic peer updates
+		PeriodicTransferUpdate(m.chatService),
+		PeriodicTypingUpdate(m.chatService),
 	)
 }
 
@@ -180,3 +284,248 @@ func (m *ChatModel) addMessage(msg DisplayMessage) {
 
 	m.updateScrollBounds()
 }
+
+// moveSelection highlights the previous (-1) or next (1) message, skipping system lines
+func (m *ChatModel) moveSelection(direction int) {
+	index := -1
+	for i, msg := range m.messages {
+		if msg.ID != "" && msg.ID == m.selectedID {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		if direction > 0 {
+			return // Nothing below the bottom
+		}
+		index = len(m.messages) - m.scrollOffset // Start just below the newest message on screen
+	}
+
+	for i := index + direction; i >= 0 && i < len(m.messages); i += direction {
+		if m.messages[i].ID != "" {
+			m.selectedID = m.messages[i].ID
+			m.scrollToMessage(i)
+			return
+		}
+	}
+}
+
+// selectedMessage returns the highlighted message, if it is still on screen
+func (m ChatModel) selectedMessage() (DisplayMessage, bool) {
+	if m.selectedID == "" {
+		return DisplayMessage{}, false
+	}
+	for _, msg := range m.messages {
+		if msg.ID == m.selectedID {
+			return msg, true
+		}
+	}
+	return DisplayMessage{}, false
+}
+
+// scrollToMessage adjusts the scroll offset so message index is inside the viewport
+func (m *ChatModel) scrollToMessage(index int) {
+	end := len(m.messages) - m.scrollOffset // One past the newest visible message
+	start := end - m.chatAreaHeight
+
+	switch {
+	case index >= end:
+		m.scrollOffset = len(m.messages) - index - 1
+	case index < start:
+		m.scrollOffset = len(m.messages) - index - m.chatAreaHeight
+	}
+	m.scrollOffset = max(0, min(m.scrollOffset, m.maxScrollOffset))
+	m.autoScroll = m.scrollOffset == 0
+}
+
+// refreshMessage re-renders the message an incoming reaction, edit or delete changed
+func (m *ChatModel) refreshMessage(annotation *chat.Message) {
+	target := m.chatService.GetMessage(annotation.TargetID)
+	if target != nil && target.Type == chat.MessageTypeReaction {
+		target = m.chatService.GetMessage(target.TargetID) // Un-reacting changes the reacted message
+	}
+	if target == nil {
+		return
+	}
+
+	m.refreshThread(target.ID)
+}
+
+// refreshThread re-renders a message and the replies quoting it
+func (m *ChatModel) refreshThread(messageID string) {
+	for i, msg := range m.messages {
+		if msg.ID == messageID || (msg.ReplyTo != "" && msg.ReplyTo == messageID) {
+			if updated := m.chatService.GetMessage(msg.ID); updated != nil {
+				m.messages[i] = m.displayMessage(updated)
+			}
+		}
+	}
+}
+
+// refreshDelivery updates the delivery state of one of our messages after a receipt came in
+func (m *ChatModel) refreshDelivery(receipt *chat.Message) {
+	for i, msg := range m.messages {
+		if msg.ID == receipt.TargetID {
+			state := m.chatService.GetDeliveryState(msg.ID)
+			m.messages[i].Delivered = len(state.Delivered)
+			m.messages[i].Read = len(state.Read)
+			return
+		}
+	}
+}
+
+// isShown returns true if a message is already on screen
+func (m *ChatModel) isShown(messageID string) bool {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].ID == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// isLatest returns true if msg sorts last in its room's history, so appending it keeps the order
+// Messages that aren't stored (like notices) count as latest
+func (m *ChatModel) isLatest(msg *chat.Message) bool {
+	history := m.chatService.GetRoomHistory(msg.ConversationID())
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].ID == msg.ID {
+			return i == len(history)-1
+		}
+	}
+	return true
+}
+
+// visibleRange returns the messages in the viewport as indexes [start, end) into messages
+func (m ChatModel) visibleRange() (int, int) {
+	// Use the chatAreaHeight calculated in Update()
+	availableHeight := m.chatAreaHeight
+	if availableHeight <= 2 {
+		availableHeight = 5 // Minimum reasonable size
+	}
+
+	totalMessages := len(m.messages)
+	if totalMessages <= availableHeight {
+		// All messages fit on screen
+		return 0, totalMessages
+	}
+
+	// Show a window of messages based on scroll position
+	// scrollOffset = 0 means show latest (bottom)
+	// scrollOffset > 0 means show older messages
+	endIndex := totalMessages - m.scrollOffset
+	startIndex := endIndex - availableHeight
+
+	// Safety bounds
+	if startIndex < 0 {
+		startIndex = 0
+		endIndex = availableHeight
+	}
+	if endIndex > totalMessages {
+		endIndex = totalMessages
+		startIndex = totalMessages - availableHeight
+	}
+	return startIndex, endIndex
+}
+
+// markVisibleRead sends read receipts for the messages of others that are on screen now
+func (m *ChatModel) markVisibleRead() tea.Cmd {
+	start, end := m.visibleRange()
+	var ids []string
+	for _, msg := range m.messages[start:end] {
+		if msg.ID == "" || msg.Own || msg.SenderID == m.chatService.GetPeerID() || m.readSent[msg.ID] {
+			continue
+		}
+		ids = append(ids, msg.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if len(m.readSent) >= 2*m.maxMessages {
+		clear(m.readSent) // Start over, ChatService won't send a receipt twice anyway
+	}
+	for _, id := range ids {
+		m.readSent[id] = true
+	}
+	return MarkReadCmd(m.chatService, ids)
+}
+
+// switchRoom shows another joined room or open DM and reloads its history
+func (m *ChatModel) switchRoom(roomID string) tea.Cmd {
+	m.currentRoom = roomID
+	m.rooms = m.chatService.GetJoinedRooms()
+	m.selectedID = ""
+	delete(m.unread, roomID)
+
+	m.messages = []DisplayMessage{}
+	m.scrollToBottom()
+	m.updateScrollBounds()
+	m.status = fmt.Sprintf("Switched to %s", m.conversationLabel(roomID))
+
+	return LoadRoomHistory(m.chatService, roomID)
+}
+
+// cycleRoom moves the room switcher forward (1) or backward (-1)
+func (m *ChatModel) cycleRoom(direction int) tea.Cmd {
+	conversations := m.conversations()
+	if len(conversations) < 2 {
+		return nil
+	}
+
+	index := 0
+	for i, roomID := range conversations {
+		if roomID == m.currentRoom {
+			index = i
+			break
+		}
+	}
+
+	next := (index + direction + len(conversations)) % len(conversations)
+	return m.switchRoom(conversations[next])
+}
+
+// conversations returns everything the switcher cycles through: rooms, then DMs
+func (m *ChatModel) conversations() []string {
+	conversations := make([]string, 0, len(m.rooms)+len(m.queries))
+	conversations = append(conversations, m.rooms...)
+	return append(conversations, m.queries...)
+}
+
+// openQuery adds a DM conversation to the switcher and returns its ID
+func (m *ChatModel) openQuery(peerID, username string) string {
+	conversationID := chat.DirectConversationID(m.chatService.GetPeerID(), peerID)
+	m.queryNames[peerID] = username
+
+	for _, existing := range m.queries {
+		if existing == conversationID {
+			return conversationID
+		}
+	}
+	m.queries = append(m.queries, conversationID)
+	return conversationID
+}
+
+// closeQuery removes a DM conversation from the switcher
+func (m *ChatModel) closeQuery(conversationID string) {
+	for i, existing := range m.queries {
+		if existing == conversationID {
+			m.queries = append(m.queries[:i], m.queries[i+1:]...)
+			break
+		}
+	}
+	delete(m.unread, conversationID)
+}
+
+// conversationLabel renders a conversation ID as "#room" or "@user"
+func (m ChatModel) conversationLabel(conversationID string) string {
+	if !chat.IsDirectConversation(conversationID) {
+		return "#" + conversationID
+	}
+
+	peerID := chat.DirectPeer(conversationID, m.chatService.GetPeerID())
+	if username, ok := m.queryNames[peerID]; ok {
+		return "@" + username
+	}
+	return "@" + peerID
+}
@@ -0,0 +1,143 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultTheme is the palette the TUI starts with
+const DefaultTheme = "dark"
+
+// Theme is the palette of the TUI
+// Colors are anything lipgloss understands: ANSI 256 numbers like "39" or hex like "#00afff"
+type Theme struct {
+	Text       string // Message text
+	Accent     string // Banner, headings, selection marker
+	HeaderText string // Header and current room
+	HeaderBg   string
+	Faint      string // Timestamps, borders, hints
+	Dim        string // Quotes, "(edited)", presence reasons
+	Subtle     string // Room and file names
+	Separator  string // Line between different speakers
+	BadgeText  string // Reaction badges
+	BadgeBg    string
+	Success    string // Joins, connected peers, progress bars
+	Warning    string // System messages, away peers, unread counts
+	Danger     string // Leaves, offline peers
+	ErrorText  string // Error banner
+	ErrorBg    string
+
+	// Usernames get one of these, picked by a hash of the name
+	Users []string
+}
+
+// Themes are the built-in palettes, selected with -theme or theme in the config file
+var Themes = map[string]Theme{
+	// For dark terminals, the original look
+	"dark": {
+		Text:       "15",
+		Accent:     "39",
+		HeaderText: "15",
+		HeaderBg:   "57",
+		Faint:      "240",
+		Dim:        "244",
+		Subtle:     "250",
+		Separator:  "237",
+		BadgeText:  "250",
+		BadgeBg:    "236",
+		Success:    "34",
+		Warning:    "214",
+		Danger:     "160",
+		ErrorText:  "196",
+		ErrorBg:    "52",
+		Users: []string{
+			"39",  // Bright blue
+			"203", // Pink
+			"148", // Green
+			"214", // Orange
+			"177", // Purple
+			"81",  // Cyan
+			"226", // Yellow
+			"196", // Red
+			"117", // Light blue
+			"205", // Magenta
+			"51",  // Turquoise
+			"166", // Dark orange
+			"135", // Light purple
+			"82",  // Lime green
+			"220", // Gold
+		},
+	},
+
+	// For light terminals, darker shades that stay readable on white
+	"light": {
+		Text:       "232",
+		Accent:     "25",
+		HeaderText: "231",
+		HeaderBg:   "61",
+		Faint:      "245",
+		Dim:        "242",
+		Subtle:     "238",
+		Separator:  "252",
+		BadgeText:  "236",
+		BadgeBg:    "254",
+		Success:    "28",
+		Warning:    "130",
+		Danger:     "124",
+		ErrorText:  "231",
+		ErrorBg:    "124",
+		Users: []string{
+			"25",  // Blue
+			"161", // Pink
+			"28",  // Green
+			"130", // Brown
+			"91",  // Purple
+			"30",  // Teal
+			"124", // Red
+			"61",  // Slate blue
+			"127", // Magenta
+			"94",  // Dark orange
+			"22",  // Dark green
+		},
+	},
+
+	// Grays only, for people who find color distracting
+	"mono": {
+		Text:       "252",
+		Accent:     "255",
+		HeaderText: "232",
+		HeaderBg:   "250",
+		Faint:      "240",
+		Dim:        "244",
+		Subtle:     "248",
+		Separator:  "236",
+		BadgeText:  "250",
+		BadgeBg:    "236",
+		Success:    "252",
+		Warning:    "250",
+		Danger:     "244",
+		ErrorText:  "232",
+		ErrorBg:    "255",
+		Users:      []string{"255", "250", "246"},
+	},
+}
+
+// ThemeNames returns the names of the built-in themes
+func ThemeNames() []string {
+	names := make([]string, 0, len(Themes))
+	for name := range Themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupTheme finds a built-in theme by name
+func LookupTheme(name string) (Theme, error) {
+	theme, ok := Themes[strings.ToLower(name)]
+	if !ok {
+		return Theme{}, fmt.Errorf("unknown theme %q (available: %s)", name, strings.Join(ThemeNames(), ", "))
+	}
+	return theme, nil
+}
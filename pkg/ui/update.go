@@ -2,7 +2,10 @@ package ui
 
 import (
 	"fmt"
+	"os"
+	"p2pchat/internal/peer"
 	"p2pchat/pkg/chat"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -12,6 +15,19 @@ import (
 // Update handles all events and returns new state + commands
 // This is called every time something happens (key press, network event, etc.)
 func (m ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	model, cmd := m.update(msg)
+
+	// Whatever is on screen now has been seen, tell the authors
+	if updated, ok := model.(ChatModel); ok {
+		if receipts := updated.markVisibleRead(); receipts != nil {
+			return updated, tea.Batch(cmd, receipts)
+		}
+	}
+	return model, cmd
+}
+
+// update is Update without the read receipts
+func (m ChatModel) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch msg := msg.(type) {
@@ -27,9 +43,10 @@ func (m ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		// Calculate chat area height properly here (not in View!)
 		headerHeight := 1
-		inputHeight := 3 // Input field + border + padding
+		typingHeight := 1 // "alice is typing…" line, kept even when empty so the layout doesn't jump
+		inputHeight := 3  // Input field + border + padding
 		helpHeight := 1
-		usedHeight := headerHeight + inputHeight + helpHeight
+		usedHeight := headerHeight + typingHeight + inputHeight + helpHeight
 
 		m.chatAreaHeight = m.height - usedHeight
 		if m.chatAreaHeight < 3 {
@@ -44,32 +61,96 @@ func (m ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	// NEW: Handle loading message history on startup
 	case MessageHistoryMsg:
+		// Ignore history for a room we already switched away from
+		if msg.RoomID != m.currentRoom {
+			break
+		}
+
 		// Convert chat messages to display messages
+		m.messages = []DisplayMessage{}
 		for _, msg := range msg.Messages {
-			displayMsg := DisplayMessage{
-				Content:   msg.Content,
-				Username:  msg.Username,
-				Timestamp: msg.Timestamp,
-				Type:      convertMessageType(msg.Type),
-			}
-			m.addMessage(displayMsg)
+			m.addMessage(m.displayMessage(msg))
 		}
 		m.scrollToBottom()
 
 	// Handle incoming chat messages from your P2P network!
 	case IncomingMessageMsg:
-		if msg.Message != nil {
-			// Convert your chat.Message to DisplayMessage
-			displayMsg := DisplayMessage{
-				Content:   msg.Message.Content,
-				Username:  msg.Message.Username,
-				Timestamp: msg.Message.Timestamp,
-				Type:      convertMessageType(msg.Message.Type),
+		// Backfilled history arrives as one batch, merge it in by reloading
+		if msg.Message != nil && msg.Message.Type == chat.MessageTypeSyncBatch {
+			cmds = append(cmds, m.applySyncBatch(msg.Message), ListenForMessages(m.chatService))
+			break
+		}
+
+		// Someone started typing, show it right away
+		if msg.Message != nil && msg.Message.Type == chat.MessageTypeTyping {
+			m.typing = m.chatService.GetTyping()
+			cmds = append(cmds, ListenForMessages(m.chatService))
+			break
+		}
+
+		// A reaction, edit or delete changes a message that is already on screen
+		if msg.Message != nil && msg.Message.IsAnnotation() {
+			if msg.Message.ConversationID() == m.currentRoom {
+				m.refreshMessage(msg.Message)
+			}
+			cmds = append(cmds, ListenForMessages(m.chatService))
+			break
+		}
+
+		// Someone received or read one of our messages
+		if msg.Message != nil && msg.Message.IsReceipt() {
+			m.refreshDelivery(msg.Message)
+			cmds = append(cmds, ListenForMessages(m.chatService))
+			break
+		}
+
+		// A plugin has something to tell us, and only us
+		if msg.Message != nil && msg.Message.Type == chat.MessageTypeNotice {
+			m.showNotice(msg.Message)
+			cmds = append(cmds, ListenForMessages(m.chatService))
+			break
+		}
+
+		// Someone wants to send us a file
+		if msg.Message != nil && msg.Message.Type == chat.MessageTypeFileOffer {
+			m.showFileOffer(msg.Message)
+			cmds = append(cmds, ListenForMessages(m.chatService))
+			break
+		}
+
+		// Whoever just sent this is no longer typing it
+		m.typing = m.chatService.GetTyping()
+
+		// Open a query window for DMs from new people
+		if msg.Message != nil && msg.Message.Type == chat.MessageTypeDirect && msg.Message.SenderID != m.chatService.GetPeerID() {
+			m.openQuery(msg.Message.SenderID, msg.Message.Username)
+			if msg.Message.ConversationID() != m.currentRoom {
+				m.status = fmt.Sprintf("💬 Direct message from %s (/query %s)", msg.Message.Username, msg.Message.Username)
 			}
+		}
+
+		if msg.Message != nil && msg.Message.ConversationID() != m.currentRoom {
+			// Belongs to another room or DM - just count it for the switcher
+			if msg.Message.Type == chat.MessageTypeChat || msg.Message.Type == chat.MessageTypeDirect {
+				m.unread[msg.Message.ConversationID()]++
+			}
+		} else if msg.Message != nil && m.isShown(msg.Message.ID) {
+			// A reload already brought it in
+		} else if msg.Message != nil && !m.isLatest(msg.Message) {
+			// It belongs further up, history knows where, so reload like a sync batch
+			cmds = append(cmds, LoadRoomHistory(m.chatService, m.currentRoom))
+		} else if msg.Message != nil {
+			// Convert your chat.Message to DisplayMessage
+			displayMsg := m.displayMessage(msg.Message)
 
 			// Add to our message history using optimized function
 			m.addMessage(displayMsg)
 
+			// The parent's reply count just went up
+			if msg.Message.ReplyTo != "" {
+				m.refreshThread(msg.Message.ReplyTo)
+			}
+
 			// Update scroll bounds with new message
 			m.updateScrollBounds()
 
@@ -88,6 +169,17 @@ func (m ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Schedule next peer update
 		cmds = append(cmds, PeriodicPeerUpdate())
 
+	// Handle file transfer progress
+	case TransferUpdateMsg:
+		m.announceTransferChanges(msg.Transfers)
+		m.transfers = msg.Transfers
+		cmds = append(cmds, PeriodicTransferUpdate(m.chatService))
+
+	// Handle typing indicators expiring
+	case TypingUpdateMsg:
+		m.typing = msg.Typing
+		cmds = append(cmds, PeriodicTypingUpdate(m.chatService))
+
 	// Handle status updates
 	case StatusUpdateMsg:
 		if msg.IsError {
@@ -103,6 +195,18 @@ func (m ChatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.lastError = ""
 		}
 
+	// Show what a plugin command answered
+	case CommandOutputMsg:
+		m.addMessage(DisplayMessage{
+			Content:   msg.Output,
+			Username:  "System",
+			Timestamp: time.Now(),
+			Type:      MessageTypeSystem,
+		})
+		if m.autoScroll {
+			m.scrollToBottom()
+		}
+
 	// Handle periodic ticks
 	case struct{}: // Our tick message
 		// Refresh peer list periodically
@@ -145,16 +249,91 @@ func (m ChatModel) handleChatCommand(command string) (ChatModel, tea.Cmd) {
 	case "/clear":
 		return m.clearMessages()
 
+	case "/join", "/j":
+		if len(parts) < 2 {
+			m.lastError = "Usage: /join #room"
+			return m, nil
+		}
+		return m.joinRoom(parts[1])
+
+	case "/part", "/leave":
+		if len(parts) < 2 && chat.IsDirectConversation(m.currentRoom) {
+			// Parting a DM just closes the query window
+			m.closeQuery(m.currentRoom)
+			return m, m.switchRoom(chat.DefaultRoom)
+		}
+		roomName := m.currentRoom
+		if len(parts) >= 2 {
+			roomName = parts[1]
+		}
+		return m.partRoom(roomName)
+
+	case "/msg":
+		if len(parts) < 3 {
+			m.lastError = "Usage: /msg <user> <text>"
+			return m, nil
+		}
+		content := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), parts[0]))
+		content = strings.TrimSpace(strings.TrimPrefix(content, parts[1]))
+		return m.sendDirect(parts[1], content)
+
+	case "/query":
+		if len(parts) < 2 {
+			m.lastError = "Usage: /query <user>"
+			return m, nil
+		}
+		return m.openDirect(parts[1])
+
+	case "/rooms":
+		return m.showRoomsList()
+
+	case "/send":
+		if len(parts) < 3 {
+			m.lastError = "Usage: /send <user> <path>"
+			return m, nil
+		}
+		path := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), parts[0]))
+		path = strings.TrimSpace(strings.TrimPrefix(path, parts[1]))
+		return m.sendFile(parts[1], path)
+
+	case "/accept", "/reject", "/cancel":
+		id := ""
+		if len(parts) >= 2 {
+			id = parts[1]
+		}
+		return m.answerTransfer(cmd, id)
+
+	case "/transfers":
+		return m.showTransfers()
+
+	case "/thread":
+		return m.showThread()
+
+	case "/away", "/busy":
+		reason := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), parts[0]))
+		return m.setPresence(peer.Presence(strings.TrimPrefix(cmd, "/")), reason)
+
+	case "/back":
+		return m.setPresence(peer.PresenceAvailable, "")
+
+	case "/connect":
+		if len(parts) < 2 {
+			m.lastError = "Usage: /connect <host:port>"
+			return m, nil
+		}
+		m.status = fmt.Sprintf("Connecting to %s...", parts[1])
+		return m, ConnectCmd(m.chatService, parts[1])
+
 	default:
-		m.lastError = fmt.Sprintf("Unknown command: %s. Type /help for available commands.", cmd)
-		return m, nil
+		// Maybe a plugin knows it
+		return m, RunPluginCommandCmd(m.chatService, m.currentRoom, command)
 	}
 }
 
 // showHelpMessage displays available chat commands
 func (m ChatModel) showHelpMessage() (ChatModel, tea.Cmd) {
 	helpMsg := DisplayMessage{
-		Content:   "Available commands:\n/help - Show this help\n/users - List connected users\n/nick <name> - Change username\n/clear - Clear message history\n/quit - Exit chat",
+		Content:   "Available commands:\n/help - Show this help\n/users - List connected users\n/nick <name> - Change username\n/join #room - Join or switch to a room\n/part [#room] - Leave a room\n/rooms - List your rooms\n/msg <user> <text> - Send a direct message\n/query <user> - Open a direct conversation\n/send <user> <path> - Offer a file\n/accept [id] - Accept a file (newest offer if no id)\n/reject [id] - Decline a file\n/cancel <id> - Stop a transfer\n/transfers - List file transfers\n/away [reason] - Mark yourself away\n/busy [reason] - Mark yourself busy\n/back - Clear away or busy\n/connect <host:port> - Connect to a peer discovery can't find, and remember it\nTab to the messages, ↑↓ to select one, 1-6 to react, r to reply, t for the thread, e to edit, d to delete\n/thread - Show the thread of the selected message (or the latest reply)\n/clear - Clear message history\n/quit - Exit chat" + m.pluginHelp(),
 		Username:  "System",
 		Timestamp: time.Now(),
 		Type:      MessageTypeSystem,
@@ -169,6 +348,21 @@ func (m ChatModel) showHelpMessage() (ChatModel, tea.Cmd) {
 	return m, nil
 }
 
+// pluginHelp lists the slash commands plugins added, for /help
+func (m ChatModel) pluginHelp() string {
+	commands := m.chatService.GetCommands()
+	if len(commands) == 0 {
+		return ""
+	}
+
+	var help strings.Builder
+	help.WriteString("\nPlugin commands:")
+	for _, command := range commands {
+		help.WriteString(fmt.Sprintf("\n%s - %s (%s)", command.Usage, command.Help, command.Plugin))
+	}
+	return help.String()
+}
+
 // showUsersList displays connected peers
 func (m ChatModel) showUsersList() (ChatModel, tea.Cmd) {
 	var content string
@@ -182,7 +376,24 @@ func (m ChatModel) showUsersList() (ChatModel, tea.Cmd) {
 			if peer.Status != "connected" {
 				status = "◯" // offline indicator
 			}
-			userList.WriteString(fmt.Sprintf("  %s %s (%s)\n", status, peer.Username, peer.Status))
+			presence := ""
+			if peer.Presence != "" && peer.Presence != "available" {
+				presence = ", " + peer.Presence
+				if peer.PresenceReason != "" {
+					presence += ": " + peer.PresenceReason
+				}
+			}
+			source := ""
+			if label := sourceLabel(peer.Source); label != "" {
+				source = ", " + label
+			}
+			if peer.Interface != "" {
+				source += ", on " + peer.Interface
+			}
+			if peer.Pending > 0 {
+				source += fmt.Sprintf(", %d waiting to be sent", peer.Pending)
+			}
+			userList.WriteString(fmt.Sprintf("  %s %s (%s%s%s)\n", status, peer.Username, peer.Status, source, presence))
 		}
 		content = userList.String()
 	}
@@ -245,6 +456,355 @@ func (m ChatModel) changeUsername(newUsername string) (ChatModel, tea.Cmd) {
 	return m, nil
 }
 
+// joinRoom joins a room (or switches to it if already joined)
+func (m ChatModel) joinRoom(name string) (ChatModel, tea.Cmd) {
+	roomID, err := m.chatService.JoinRoom(name)
+	if err != nil {
+		m.lastError = fmt.Sprintf("Failed to join room: %v", err)
+		return m, nil
+	}
+
+	cmd := m.switchRoom(roomID)
+	m.status = fmt.Sprintf("Joined #%s", roomID)
+	return m, cmd
+}
+
+// partRoom leaves a room and falls back to the default room if it was current
+func (m ChatModel) partRoom(name string) (ChatModel, tea.Cmd) {
+	roomID, err := m.chatService.PartRoom(name)
+	if err != nil {
+		m.lastError = fmt.Sprintf("Failed to leave room: %v", err)
+		return m, nil
+	}
+
+	delete(m.unread, roomID)
+	m.rooms = m.chatService.GetJoinedRooms()
+
+	var cmd tea.Cmd
+	if roomID == m.currentRoom {
+		cmd = m.switchRoom(chat.DefaultRoom)
+	}
+	m.status = fmt.Sprintf("Left #%s", roomID)
+	return m, cmd
+}
+
+// sendDirect sends a one-off DM without switching away from the current view
+func (m ChatModel) sendDirect(name, content string) (ChatModel, tea.Cmd) {
+	target, err := m.chatService.ResolvePeer(name)
+	if err != nil {
+		m.lastError = fmt.Sprintf("Failed to send direct message: %v", err)
+		return m, nil
+	}
+	if len(content) > 1000 {
+		m.lastError = "Message too long (max 1000 characters)"
+		return m, nil
+	}
+
+	m.openQuery(target.PeerID, target.Username)
+	m.status = fmt.Sprintf("Sending direct message to %s...", target.Username)
+	return m, SendDirectCmd(m.chatService, target.PeerID, content)
+}
+
+// openDirect opens (or switches to) a DM conversation with a peer
+func (m ChatModel) openDirect(name string) (ChatModel, tea.Cmd) {
+	target, err := m.chatService.ResolvePeer(name)
+	if err != nil {
+		m.lastError = fmt.Sprintf("Failed to open conversation: %v", err)
+		return m, nil
+	}
+
+	conversationID := m.openQuery(target.PeerID, target.Username)
+	return m, m.switchRoom(conversationID)
+}
+
+// showRoomsList displays joined rooms with their known member counts
+func (m ChatModel) showRoomsList() (ChatModel, tea.Cmd) {
+	var roomList strings.Builder
+	roomList.WriteString("Your rooms:\n")
+	for _, roomID := range m.rooms {
+		marker := " "
+		if roomID == m.currentRoom {
+			marker = "▶"
+		}
+		members := len(m.chatService.GetRoomMembers(roomID))
+		roomList.WriteString(fmt.Sprintf("  %s #%s (%d peers)\n", marker, roomID, members))
+	}
+	for _, conversationID := range m.queries {
+		marker := " "
+		if conversationID == m.currentRoom {
+			marker = "▶"
+		}
+		roomList.WriteString(fmt.Sprintf("  %s %s (direct)\n", marker, m.conversationLabel(conversationID)))
+	}
+
+	roomsMsg := DisplayMessage{
+		Content:   roomList.String(),
+		Username:  "System",
+		Timestamp: time.Now(),
+		Type:      MessageTypeSystem,
+		Style:     "rooms",
+	}
+
+	m.addMessage(roomsMsg)
+	if m.autoScroll {
+		m.scrollToBottom()
+	}
+
+	return m, nil
+}
+
+// setPresence handles /away, /busy and /back
+func (m ChatModel) setPresence(presence peer.Presence, reason string) (ChatModel, tea.Cmd) {
+	if err := m.chatService.SetPresence(presence, reason); err != nil {
+		m.lastError = fmt.Sprintf("Failed to set presence: %v", err)
+		return m, nil
+	}
+
+	switch {
+	case presence == peer.PresenceAvailable:
+		m.status = "Welcome back"
+	case reason != "":
+		m.status = fmt.Sprintf("You are %s: %s", presence, reason)
+	default:
+		m.status = fmt.Sprintf("You are %s", presence)
+	}
+	return m, nil
+}
+
+// showThread lists the thread of the selected message, or of the latest reply in this conversation
+func (m ChatModel) showThread() (ChatModel, tea.Cmd) {
+	messageID := ""
+	if selected, ok := m.selectedMessage(); ok {
+		messageID = selected.ID
+	} else {
+		for i := len(m.messages) - 1; i >= 0; i-- {
+			if m.messages[i].ReplyTo != "" {
+				messageID = m.messages[i].ID
+				break
+			}
+		}
+	}
+	if messageID == "" {
+		m.lastError = "No thread here - select a message in the chat (Tab, ↑↓) or reply to one with r"
+		return m, nil
+	}
+
+	thread := m.chatService.GetThread(messageID)
+	if len(thread) == 0 {
+		m.lastError = "That message is no longer in history"
+		return m, nil
+	}
+
+	// Indent each reply under its parent
+	depth := map[string]int{thread[0].ID: 0}
+	var list strings.Builder
+	list.WriteString(fmt.Sprintf("Thread (%d messages):\n", len(thread)))
+	for _, msg := range thread {
+		level, ok := depth[msg.ReplyTo]
+		if ok {
+			level++
+		}
+		depth[msg.ID] = level
+
+		text := msg.Text()
+		if msg.Deleted {
+			text = "🗑 message deleted"
+		}
+		marker := ""
+		if level > 0 {
+			marker = strings.Repeat("  ", level-1) + "↪ "
+		}
+		list.WriteString(fmt.Sprintf("  %s[%s] %s: %s\n", marker, msg.Timestamp.Format("15:04"), msg.Username, text))
+	}
+
+	m.addMessage(DisplayMessage{
+		Content:   list.String(),
+		Username:  "System",
+		Timestamp: time.Now(),
+		Type:      MessageTypeSystem,
+		Style:     "thread",
+	})
+	m.scrollToBottom()
+
+	return m, nil
+}
+
+// sendFile offers a file to a peer
+func (m ChatModel) sendFile(name, path string) (ChatModel, tea.Cmd) {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+
+	m.status = fmt.Sprintf("Preparing %s...", filepath.Base(path))
+	return m, SendFileCmd(m.chatService, name, path)
+}
+
+// answerTransfer handles /accept, /reject and /cancel
+func (m ChatModel) answerTransfer(cmd, id string) (ChatModel, tea.Cmd) {
+	// Without an ID, answer the newest offer still waiting
+	if id == "" && cmd != "/cancel" {
+		for _, t := range m.chatService.GetTransfers() {
+			if t.Incoming && t.State == chat.TransferOffered {
+				id = t.ID
+				break
+			}
+		}
+		if id == "" {
+			m.lastError = "No file offers waiting"
+			return m, nil
+		}
+	}
+	if id == "" {
+		m.lastError = "Usage: /cancel <id>"
+		return m, nil
+	}
+
+	var err error
+	switch cmd {
+	case "/accept":
+		err = m.chatService.AcceptFile(id)
+		m.status = fmt.Sprintf("📥 Receiving [%s]...", id)
+	case "/reject":
+		err = m.chatService.RejectFile(id)
+		m.status = fmt.Sprintf("Declined [%s]", id)
+	case "/cancel":
+		err = m.chatService.CancelFile(id)
+		m.status = fmt.Sprintf("Cancelled [%s]", id)
+	}
+	if err != nil {
+		m.lastError = fmt.Sprintf("Failed to %s file: %v", strings.TrimPrefix(cmd, "/"), err)
+	}
+	return m, nil
+}
+
+// showFileOffer prompts the user about an incoming file
+func (m *ChatModel) showFileOffer(offer *chat.Message) {
+	m.addMessage(DisplayMessage{
+		Content: fmt.Sprintf("📎 %s wants to send you %s (%s) - /accept %s or /reject %s",
+			offer.Username, offer.File.Name, formatBytes(offer.File.Size), offer.File.ID, offer.File.ID),
+		Username:  "System",
+		Timestamp: time.Now(),
+		Type:      MessageTypeSystem,
+		Style:     "transfer",
+	})
+	if m.autoScroll {
+		m.scrollToBottom()
+	}
+	m.status = fmt.Sprintf("📎 File offer from %s: %s", offer.Username, offer.File.Name)
+}
+
+// showNotice shows a plugin's notice in its room or DM, or on the status line if we are elsewhere
+func (m *ChatModel) showNotice(notice *chat.Message) {
+	text := fmt.Sprintf("🤖 %s: %s", notice.Bot, notice.Content)
+	if notice.ConversationID() != m.currentRoom {
+		m.status = text
+		return
+	}
+
+	m.addMessage(DisplayMessage{
+		Content:   text,
+		Username:  "System",
+		Timestamp: notice.Timestamp,
+		Type:      MessageTypeSystem,
+	})
+	if m.autoScroll {
+		m.scrollToBottom()
+	}
+}
+
+// announceTransferChanges posts a system line when a transfer finishes
+func (m *ChatModel) announceTransferChanges(transfers []chat.Transfer) {
+	previous := make(map[string]chat.TransferState, len(m.transfers))
+	for _, t := range m.transfers {
+		previous[t.ID] = t.State
+	}
+
+	for _, t := range transfers {
+		before, known := previous[t.ID]
+		if before == t.State || (!known && t.State == chat.TransferOffered) {
+			continue
+		}
+
+		var content string
+		switch {
+		case t.State == chat.TransferCompleted && t.Incoming:
+			content = fmt.Sprintf("✅ Received %s from %s, saved to %s", t.Name, t.Username, t.Path)
+		case t.State == chat.TransferCompleted:
+			content = fmt.Sprintf("✅ %s received %s", t.Username, t.Name)
+		case t.State == chat.TransferRejected && !t.Incoming:
+			content = fmt.Sprintf("🚫 %s declined %s", t.Username, t.Name)
+		case t.State == chat.TransferFailed:
+			content = fmt.Sprintf("❌ Transfer of %s failed: %s", t.Name, t.Error)
+		case t.State == chat.TransferInterrupted:
+			content = fmt.Sprintf("⏸️ Transfer of %s interrupted at %d%%, it resumes when %s is back", t.Name, int(t.Progress()*100), t.Username)
+		default:
+			continue
+		}
+
+		m.addMessage(DisplayMessage{
+			Content:   content,
+			Username:  "System",
+			Timestamp: time.Now(),
+			Type:      MessageTypeSystem,
+			Style:     "transfer",
+		})
+		if m.autoScroll {
+			m.scrollToBottom()
+		}
+	}
+}
+
+// showTransfers lists this session's file transfers
+func (m ChatModel) showTransfers() (ChatModel, tea.Cmd) {
+	transfers := m.chatService.GetTransfers()
+
+	var list strings.Builder
+	if len(transfers) == 0 {
+		list.WriteString("No file transfers yet. Use /send <user> <path> to offer one.")
+	} else {
+		list.WriteString("File transfers:\n")
+		for _, t := range transfers {
+			direction := "⬆ to"
+			if t.Incoming {
+				direction = "⬇ from"
+			}
+			list.WriteString(fmt.Sprintf("  [%s] %s %s %s - %s %d%% of %s\n",
+				t.ID, t.Name, direction, t.Username, t.State, int(t.Progress()*100), formatBytes(t.Size)))
+		}
+	}
+
+	m.addMessage(DisplayMessage{
+		Content:   list.String(),
+		Username:  "System",
+		Timestamp: time.Now(),
+		Type:      MessageTypeSystem,
+		Style:     "transfers",
+	})
+	if m.autoScroll {
+		m.scrollToBottom()
+	}
+
+	return m, nil
+}
+
+// formatBytes renders a size as B, KB, MB or GB
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		value /= unit
+		if value < unit || suffix == "GB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
 // clearMessages clears the message history
 func (m ChatModel) clearMessages() (ChatModel, tea.Cmd) {
 	m.messages = []DisplayMessage{}
@@ -261,7 +821,43 @@ func (m ChatModel) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "ctrl+c", "q":
 		return m, tea.Quit
 
+	case "esc":
+		if m.editingID != "" {
+			m.editingID = ""
+			m.input.SetValue("")
+			m.status = "Edit cancelled"
+		} else if m.replyingID != "" {
+			m.replyingID = ""
+			m.input.SetValue("")
+			m.status = "Reply cancelled"
+		} else if m.focused == FocusMessages {
+			m.selectedID = ""
+		}
+
 	case "enter":
+		if m.focused == FocusInput && m.editingID != "" {
+			// Finish editing a message picked in FocusMessages mode
+			id, content := m.editingID, strings.TrimSpace(m.input.Value())
+			m.editingID = ""
+			m.input.SetValue("")
+			if content == "" {
+				m.status = "Edit cancelled (use d to delete a message)"
+				return m, nil
+			}
+			return m, EditMessageCmd(m.chatService, id, content)
+		}
+		if m.focused == FocusInput && m.replyingID != "" && m.input.Value() != "" && !strings.HasPrefix(m.input.Value(), "/") {
+			// Answer the message picked in FocusMessages mode
+			id, content := m.replyingID, m.input.Value()
+			if len(content) > 1000 {
+				m.lastError = "Message too long (max 1000 characters)"
+				return m, nil
+			}
+			m.replyingID = ""
+			m.input.SetValue("")
+			m.status = "Sending reply..."
+			return m, SendReplyCmd(m.chatService, id, content)
+		}
 		if m.focused == FocusInput && m.input.Value() != "" {
 			// Get the message content
 			content := m.input.Value()
@@ -287,13 +883,23 @@ func (m ChatModel) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 			m.input.SetValue("") // Clear input
 			m.status = "Sending message..."
-			return m, SendMessageCmd(m.chatService, content)
+			if chat.IsDirectConversation(m.currentRoom) {
+				peerID := chat.DirectPeer(m.currentRoom, m.chatService.GetPeerID())
+				return m, SendDirectCmd(m.chatService, peerID, content)
+			}
+			return m, SendMessageCmd(m.chatService, m.currentRoom, content)
 		} else if m.focused != FocusInput {
 			// Enter switches to input focus from other areas
 			m.focused = FocusInput
 			m.input.Focus()
 		}
 
+	// Ctrl+N / Ctrl+P: Room switcher (irssi-style)
+	case "ctrl+n":
+		return m, m.cycleRoom(1)
+	case "ctrl+p":
+		return m, m.cycleRoom(-1)
+
 	// TAB: Switch between focus areas
 	case "tab":
 		switch m.focused {
@@ -313,6 +919,11 @@ func (m ChatModel) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			var cmd tea.Cmd
 			m.input, cmd = m.input.Update(msg)
 
+			// Let the room know we're typing (commands are private)
+			if value := m.input.Value(); value != "" && !strings.HasPrefix(value, "/") {
+				cmd = tea.Batch(cmd, NotifyTypingCmd(m.chatService, m.currentRoom))
+			}
+
 			// Clear any typing-related errors when user starts typing
 			if m.input.Value() != "" && m.lastError != "" {
 				// Clear certain types of errors when user is actively typing
@@ -325,15 +936,22 @@ func (m ChatModel) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			return m, cmd
 		}
 
+		// Reactions, edits and deletes on the selected message
+		if m.focused == FocusMessages {
+			if cmd, handled := m.handleMessageAction(msg.String()); handled {
+				return m, cmd
+			}
+		}
+
 		// SCROLLING CONTROLS - Only when not actively typing
 		switch msg.String() {
 		case "k", "up":
 			if m.focused == FocusMessages {
-				m.scrollUp(1)
+				m.moveSelection(-1)
 			}
 		case "j", "down":
 			if m.focused == FocusMessages {
-				m.scrollDown(1)
+				m.moveSelection(1)
 			}
 		case "pgup":
 			m.scrollUp(5)
@@ -352,13 +970,108 @@ func (m ChatModel) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// reactionKeys maps number keys to the reactions they toggle in FocusMessages mode
+var reactionKeys = map[string]string{
+	"1": "👍",
+	"2": "❤️",
+	"3": "😂",
+	"4": "🎉",
+	"5": "😮",
+	"6": "👀",
+}
+
+// handleMessageAction reacts to, edits or deletes the selected message
+func (m *ChatModel) handleMessageAction(key string) (tea.Cmd, bool) {
+	emoji, isReaction := reactionKeys[key]
+	if !isReaction && key != "e" && key != "d" && key != "delete" && key != "r" && key != "t" {
+		return nil, false
+	}
+
+	selected, ok := m.selectedMessage()
+	if !ok {
+		m.lastError = "Select a message with ↑↓ first"
+		return nil, true
+	}
+	if selected.Deleted {
+		m.lastError = "That message was deleted"
+		return nil, true
+	}
+
+	if isReaction {
+		return ReactCmd(m.chatService, selected.ID, emoji), true
+	}
+
+	switch key {
+	case "r":
+		// Reply mode: quote the message and address its author
+		m.replyingID = selected.ID
+		m.editingID = ""
+		if selected.SenderID != m.chatService.GetPeerID() {
+			m.input.SetValue("@" + selected.Username + " ")
+		} else {
+			m.input.SetValue("")
+		}
+		m.input.CursorEnd()
+		m.focused = FocusInput
+		m.input.Focus()
+		m.status = "↪ Replying - Enter to send, Esc to cancel"
+		return nil, true
+	case "t":
+		updated, cmd := m.showThread()
+		*m = updated
+		return cmd, true
+	}
+	if selected.SenderID != m.chatService.GetPeerID() {
+		m.lastError = "You can only change your own messages"
+		return nil, true
+	}
+
+	if key == "e" {
+		m.editingID = selected.ID
+		m.replyingID = ""
+		m.input.SetValue(selected.Content)
+		m.input.CursorEnd()
+		m.focused = FocusInput
+		m.input.Focus()
+		m.status = "✏️ Editing message - Enter to save, Esc to cancel"
+		return nil, true
+	}
+	return DeleteMessageCmd(m.chatService, selected.ID), true
+}
+
 // Helper functions
+// applySyncBatch counts backfilled messages as unread and reloads the current room if it got any
+func (m *ChatModel) applySyncBatch(batch *chat.Message) tea.Cmd {
+	currentChanged := false
+	for _, synced := range batch.Messages {
+		if synced.Type == chat.MessageTypeDirect && synced.SenderID != m.chatService.GetPeerID() {
+			m.openQuery(synced.SenderID, synced.Username)
+		}
+
+		if synced.ConversationID() == m.currentRoom {
+			currentChanged = true
+		} else if !synced.IsAnnotation() {
+			m.unread[synced.ConversationID()]++
+		}
+	}
+
+	m.status = fmt.Sprintf("📥 Caught up on %d missed messages from %s", len(batch.Messages), batch.Username)
+	if !currentChanged {
+		return nil
+	}
+	return LoadRoomHistory(m.chatService, m.currentRoom)
+}
+
 func convertMessageType(chatType chat.MessageType) MessageType {
 	switch chatType {
 	case chat.MessageTypeJoin:
 		return MessageTypeJoin
 	case chat.MessageTypeLeave:
 		return MessageTypeLeave
+	case chat.MessageTypeRoomJoin:
+		return MessageTypeJoin
+	case chat.MessageTypeRoomPart:
+		return MessageTypeLeave
 	default:
 		return MessageTypeChat
 	}
@@ -373,10 +1086,18 @@ func convertPeersToDisplay(peers []chat.PeerInfo) []PeerDisplay {
 		}
 
 		display[i] = PeerDisplay{
+			PeerID:   peer.PeerID,
 			Username: peer.Username,
 			Status:   status,
 			Address:  peer.Address,
 			LastSeen: peer.LastSeen,
+			Source:   peer.Source,
+
+			Interface: peer.Interface,
+			Pending:   peer.Pending,
+
+			Presence:       peer.Presence,
+			PresenceReason: peer.PresenceReason,
 		}
 	}
 	return display
@@ -2,8 +2,12 @@ package ui
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"p2pchat/internal/peer"
+	"p2pchat/pkg/chat"
+
 	"github.com/charmbracelet/lipgloss"
 )
 
@@ -16,21 +20,21 @@ func (m ChatModel) View() string {
 
 	// Create styles
 	headerStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("15")).
-		Background(lipgloss.Color("57")).
+		Foreground(lipgloss.Color(m.theme.HeaderText)).
+		Background(lipgloss.Color(m.theme.HeaderBg)).
 		Padding(0, 1).
 		Width(m.width)
 
 	chatStyle := lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("240")).
+		BorderForeground(lipgloss.Color(m.theme.Faint)).
 		Padding(0, 1).
 		Height(m.chatAreaHeight).
 		Width(m.width * 3 / 4) // Chat takes 75% of width
 
 	peerStyle := lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("240")).
+		BorderForeground(lipgloss.Color(m.theme.Faint)).
 		Padding(0, 1).
 		Height(m.chatAreaHeight).
 		Width(m.width / 4) // Peers take 25% of width
@@ -43,7 +47,7 @@ func (m ChatModel) View() string {
 
 	// Compact ASCII art banner for visual impact
 	bannerStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("39")).
+		Foreground(lipgloss.Color(m.theme.Accent)).
 		Bold(true)
 
 	banner := bannerStyle.Render("  ╔══╗ ╔═╗ ╔══╗   ╔═══╗ ╦ ╦ ╔══╗ ╔════╗")
@@ -51,16 +55,22 @@ func (m ChatModel) View() string {
 	banner3 := bannerStyle.Render("  ╩    ╚═╝ ╩      ╚═══╝ ╩ ╩ ╩  ╩   ╩  ")
 
 	statusStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("15")).
+		Foreground(lipgloss.Color(m.theme.Text)).
 		Italic(true)
 
-	headerContent := banner + "\n" + banner2 + "\n" + banner3 + "\n" + statusStyle.Render("  🌐 Decentralized Mesh Network • "+statusText)
+	// Remind the user they set themselves away or busy
+	location := m.conversationLabel(m.currentRoom)
+	if presence, _ := m.chatService.GetPresence(); presence != peer.PresenceAvailable {
+		location += " • " + presenceBadge(presence.String())
+	}
+
+	headerContent := banner + "\n" + banner2 + "\n" + banner3 + "\n" + statusStyle.Render("  🌐 Decentralized Mesh Network • "+location+" • "+statusText)
 
 	// Add error display if there's an error
 	if m.lastError != "" {
 		errorStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("196")).
-			Background(lipgloss.Color("52")).
+			Foreground(lipgloss.Color(m.theme.ErrorText)).
+			Background(lipgloss.Color(m.theme.ErrorBg)).
 			Padding(0, 1).
 			Bold(true)
 
@@ -78,7 +88,8 @@ func (m ChatModel) View() string {
 	peerContent := m.renderPeerList()
 	peerList := peerStyle.Render(peerContent)
 
-	// Input area
+	// Typing indicator and input area
+	typingLine := m.renderTypingIndicator()
 	inputArea := m.renderInputArea()
 
 	// Help text with context-sensitive instructions
@@ -95,6 +106,7 @@ func (m ChatModel) View() string {
 		lipgloss.Left,
 		header,
 		mainArea,
+		typingLine,
 		inputArea,
 		helpText,
 	)
@@ -104,7 +116,7 @@ func (m ChatModel) View() string {
 func (m ChatModel) renderChatArea() string {
 	if len(m.messages) == 0 {
 		welcomeStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("240")).
+			Foreground(lipgloss.Color(m.theme.Faint)).
 			Italic(true).
 			Align(lipgloss.Center)
 
@@ -117,78 +129,92 @@ func (m ChatModel) renderChatArea() string {
 		return welcome
 	}
 
-	// Use the chatAreaHeight calculated in Update()
-	availableHeight := m.chatAreaHeight
-	if availableHeight <= 2 {
-		availableHeight = 5 // Minimum reasonable size
-	}
-
-	totalMessages := len(m.messages)
-
 	// Determine which messages to show based on scroll position
-	var startIndex, endIndex int
-
-	if totalMessages <= availableHeight {
-		// All messages fit on screen
-		startIndex = 0
-		endIndex = totalMessages
-	} else {
-		// Show a window of messages based on scroll position
-		// scrollOffset = 0 means show latest (bottom)
-		// scrollOffset > 0 means show older messages
-
-		endIndex = totalMessages - m.scrollOffset
-		startIndex = endIndex - availableHeight
-
-		// Safety bounds
-		if startIndex < 0 {
-			startIndex = 0
-			endIndex = availableHeight
-		}
-		if endIndex > totalMessages {
-			endIndex = totalMessages
-			startIndex = totalMessages - availableHeight
-		}
-	}
+	startIndex, endIndex := m.visibleRange()
 
 	// Build the message strings for our viewport with beautiful colors and text wrapping
 	var messageStrings []string
 	chatWidth := m.width*3/4 - 4 // Account for borders and padding
 
+	// Leave a gutter for the selection marker while browsing messages
+	selecting := m.focused == FocusMessages
+	if selecting {
+		chatWidth -= 2
+	}
+	markerStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Accent)).Bold(true)
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dim)).Italic(true)
+
 	for i := startIndex; i < endIndex; i++ {
 		msg := m.messages[i]
 		timestamp := msg.Timestamp.Format("15:04")
 
 		// Create styled timestamp
-		timestampStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+		timestampStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Faint))
 		styledTimestamp := timestampStyle.Render(fmt.Sprintf("[%s]", timestamp))
 
 		// Color-code messages by type and user
 		var wrappedLines []string
 		switch msg.Type {
 		case MessageTypeJoin:
-			joinStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("34")).Bold(true) // Green
+			joinStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Success)).Bold(true) // Green
 			messageStr := fmt.Sprintf("%s %s", styledTimestamp, joinStyle.Render(fmt.Sprintf("→ %s joined", msg.Username)))
 			wrappedLines = []string{messageStr}
 		case MessageTypeLeave:
-			leaveStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("160")).Bold(true) // Red
+			leaveStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Danger)).Bold(true) // Red
 			messageStr := fmt.Sprintf("%s %s", styledTimestamp, leaveStyle.Render(fmt.Sprintf("← %s left", msg.Username)))
 			wrappedLines = []string{messageStr}
 		case MessageTypeSystem:
-			systemStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Italic(true) // Orange
+			systemStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Warning)).Italic(true) // Orange
 			messageStr := fmt.Sprintf("%s %s", styledTimestamp, systemStyle.Render(fmt.Sprintf("* %s", msg.Content)))
 			wrappedLines = []string{messageStr}
 		default:
 			// Assign consistent colors to users based on username hash
 			userColor := m.getUserColor(msg.Username)
 			usernameStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(userColor)).Bold(true)
-			contentStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("15"))
+			contentStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Text))
 
 			styledUsername := usernameStyle.Render(msg.Username)
+			if msg.Bot != "" {
+				styledUsername = usernameStyle.Render("🤖 "+msg.Bot) + dimStyle.Render(" ("+msg.Username+")")
+			}
 			prefix := fmt.Sprintf("%s %s: ", styledTimestamp, styledUsername)
 
+			// Replies start with a snippet of what they answer
+			var quote []string
+			if msg.ReplyTo != "" {
+				quote = []string{m.renderQuote(msg, chatWidth)}
+			}
+
+			if msg.Deleted {
+				wrappedLines = append(quote, prefix+dimStyle.Render("🗑 message deleted"))
+				break
+			}
+
 			// Wrap long messages intelligently
-			wrappedLines = m.wrapMessage(prefix, msg.Content, chatWidth, contentStyle)
+			wrappedLines = append(quote, m.wrapMessage(prefix, msg.Content, chatWidth, contentStyle)...)
+			if msg.Edited {
+				wrappedLines[len(wrappedLines)-1] += " " + dimStyle.Render("(edited)")
+			}
+			if msg.Replies > 0 {
+				wrappedLines[len(wrappedLines)-1] += " " + dimStyle.Render(fmt.Sprintf("💬 %d", msg.Replies))
+			}
+			if msg.Own {
+				wrappedLines[len(wrappedLines)-1] += " " + m.renderDelivery(msg)
+			}
+			if reactions := m.renderReactions(msg.Reactions); reactions != "" {
+				wrappedLines = append(wrappedLines, strings.Repeat(" ", 8)+reactions)
+			}
+		}
+
+		// Mark the selected message while browsing
+		if selecting {
+			for j := range wrappedLines {
+				gutter := "  "
+				if j == 0 && msg.ID != "" && msg.ID == m.selectedID {
+					gutter = markerStyle.Render("▶ ")
+				}
+				wrappedLines[j] = gutter + wrappedLines[j]
+			}
 		}
 
 		// Add all wrapped lines
@@ -199,7 +225,7 @@ func (m ChatModel) renderChatArea() string {
 			nextMsg := m.messages[i+1]
 			if msg.Username != nextMsg.Username && msg.Type == MessageTypeChat && nextMsg.Type == MessageTypeChat {
 				separator := lipgloss.NewStyle().
-					Foreground(lipgloss.Color("237")).
+					Foreground(lipgloss.Color(m.theme.Separator)).
 					Render("  ┈")
 				messageStrings = append(messageStrings, separator)
 			}
@@ -211,7 +237,7 @@ func (m ChatModel) renderChatArea() string {
 	// Add beautiful scroll indicators if needed
 	if m.maxScrollOffset > 0 {
 		scrollStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("244")).
+			Foreground(lipgloss.Color(m.theme.Dim)).
 			Italic(true).
 			Align(lipgloss.Center)
 
@@ -228,20 +254,89 @@ func (m ChatModel) renderChatArea() string {
 	return result
 }
 
+// renderDelivery renders how far one of our messages got: sent, delivered to N, read by N
+func (m ChatModel) renderDelivery(msg DisplayMessage) string {
+	deliveryStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Faint))
+
+	switch {
+	case msg.Read > 0 && msg.Delivered > msg.Read:
+		return deliveryStyle.Render(fmt.Sprintf("✓✓ delivered to %d, read by %d", msg.Delivered, msg.Read))
+	case msg.Read > 0:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Success)).Render(fmt.Sprintf("👁 read by %d", msg.Read))
+	case msg.Delivered > 0:
+		return deliveryStyle.Render(fmt.Sprintf("✓✓ delivered to %d", msg.Delivered))
+	default:
+		return deliveryStyle.Render("✓ sent")
+	}
+}
+
+// renderQuote renders the "╭ alice: what they said" line above a reply
+func (m ChatModel) renderQuote(msg DisplayMessage, maxWidth int) string {
+	quoteStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dim)).Italic(true)
+
+	if msg.QuoteUser == "" {
+		return quoteStyle.Render("        ╭ ↪ an earlier message")
+	}
+	text := strings.Join(strings.Fields(msg.QuoteText), " ")
+	if text == "" {
+		text = "message deleted"
+	}
+	line := fmt.Sprintf("        ╭ %s: %s", msg.QuoteUser, text)
+	if runes := []rune(line); maxWidth > 10 && len(runes) > maxWidth {
+		line = string(runes[:maxWidth-1]) + "…"
+	}
+	return quoteStyle.Render(line)
+}
+
+// renderReactions renders reactions as "👍 2  ❤️ 1", most popular first
+func (m ChatModel) renderReactions(reactions map[string][]string) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+
+	emojis := make([]string, 0, len(reactions))
+	for emoji := range reactions {
+		emojis = append(emojis, emoji)
+	}
+	sort.Slice(emojis, func(i, j int) bool {
+		if len(reactions[emojis[i]]) != len(reactions[emojis[j]]) {
+			return len(reactions[emojis[i]]) > len(reactions[emojis[j]])
+		}
+		return emojis[i] < emojis[j]
+	})
+
+	reactionStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(m.theme.BadgeText)).
+		Background(lipgloss.Color(m.theme.BadgeBg))
+
+	parts := make([]string, len(emojis))
+	for i, emoji := range emojis {
+		parts[i] = reactionStyle.Render(fmt.Sprintf(" %s %d ", emoji, len(reactions[emoji])))
+	}
+	return strings.Join(parts, " ")
+}
+
 // renderPeerList renders the connected peers sidebar with enhanced status indicators
 func (m ChatModel) renderPeerList() string {
 	var peerStrings []string
 
 	// Enhanced header with ASCII art
 	headerStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("39")).
+		Foreground(lipgloss.Color(m.theme.Accent)).
 		Bold(true)
+	peerStrings = append(peerStrings, m.renderRoomList()...)
+	peerStrings = append(peerStrings, "")
+	if transfers := m.renderTransferList(); len(transfers) > 0 {
+		peerStrings = append(peerStrings, transfers...)
+		peerStrings = append(peerStrings, "")
+	}
+
 	peerStrings = append(peerStrings, headerStyle.Render("╭─ P2P NETWORK ─╮"))
 	peerStrings = append(peerStrings, "")
 
 	if len(m.peers) == 0 {
 		emptyStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("244")).
+			Foreground(lipgloss.Color(m.theme.Dim)).
 			Italic(true)
 
 		peerStrings = append(peerStrings, emptyStyle.Render("No peers found"))
@@ -254,7 +349,10 @@ func (m ChatModel) renderPeerList() string {
 		return strings.Join(peerStrings, "\n")
 	}
 
-	// Enhanced peer display with connection quality
+	// Enhanced peer display with connection quality and presence
+	presenceStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Warning))
+	reasonStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dim)).Italic(true)
+	sourceStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Faint))
 	for _, peer := range m.peers {
 		qualityIndicator := m.getConnectionQualityIndicator(peer.Status)
 		userColor := m.getUserColor(peer.Username)
@@ -268,19 +366,35 @@ func (m ChatModel) renderPeerList() string {
 		var statusStyle lipgloss.Style
 		switch peer.Status {
 		case "connected":
-			statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("34")) // Green
+			statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Success)) // Green
 		case "connecting":
-			statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214")) // Orange
+			statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Warning)) // Orange
 		default:
-			statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("160")) // Red
+			statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Danger)) // Red
 		}
 
 		styledIndicator := statusStyle.Render(qualityIndicator)
 		styledUsername := usernameStyle.Render(peer.Username)
 
 		peerStr := fmt.Sprintf("%s %s", styledIndicator, styledUsername)
+		if source := sourceLabel(peer.Source); source != "" {
+			peerStr += " " + sourceStyle.Render(source)
+		}
+		if badge := presenceBadge(peer.Presence); badge != "" {
+			peerStr += " " + presenceStyle.Render(badge)
+		}
+		if peer.Pending > 0 {
+			peerStr += " " + presenceStyle.Render(fmt.Sprintf("📮%d", peer.Pending))
+		}
 
 		peerStrings = append(peerStrings, peerStr)
+		if peer.PresenceReason != "" {
+			reason := peer.PresenceReason
+			if runes := []rune(reason); len(runes) > 16 {
+				reason = string(runes[:15]) + "…"
+			}
+			peerStrings = append(peerStrings, reasonStyle.Render("  "+reason))
+		}
 	}
 
 	// Enhanced connection statistics
@@ -300,11 +414,11 @@ func (m ChatModel) renderPeerList() string {
 	// Network topology info
 	if connected > 0 {
 		meshStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("34")).
+			Foreground(lipgloss.Color(m.theme.Success)).
 			Bold(true)
 		peerStrings = append(peerStrings, meshStyle.Render("🔗 Full Mesh Active"))
 
-		statsStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+		statsStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Faint))
 		peerStrings = append(peerStrings, statsStyle.Render(fmt.Sprintf("   %d connected", connected)))
 		if connecting > 0 {
 			peerStrings = append(peerStrings, statsStyle.Render(fmt.Sprintf("   %d connecting", connecting)))
@@ -314,11 +428,106 @@ func (m ChatModel) renderPeerList() string {
 	return strings.Join(peerStrings, "\n")
 }
 
+// renderRoomList renders the room and DM switcher with unread counts
+func (m ChatModel) renderRoomList() []string {
+	headerStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(m.theme.Accent)).
+		Bold(true)
+	currentStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(m.theme.HeaderText)).
+		Background(lipgloss.Color(m.theme.HeaderBg)).
+		Bold(true)
+	roomStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Subtle))
+	unreadStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Warning)).Bold(true)
+
+	lines := []string{headerStyle.Render("╭─ ROOMS ─╮")}
+	for _, roomID := range m.conversations() {
+		name := m.conversationLabel(roomID)
+		if roomID == m.currentRoom {
+			lines = append(lines, currentStyle.Render("▶ "+name))
+			continue
+		}
+
+		line := roomStyle.Render("  " + name)
+		if count := m.unread[roomID]; count > 0 {
+			line += " " + unreadStyle.Render(fmt.Sprintf("(%d)", count))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// renderTransferList renders progress bars for file transfers still running
+func (m ChatModel) renderTransferList() []string {
+	headerStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(m.theme.Accent)).
+		Bold(true)
+	nameStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Subtle))
+	barStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Success))
+	pausedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Warning))
+
+	var lines []string
+	for _, t := range m.transfers {
+		if t.State.IsFinished() {
+			continue
+		}
+
+		arrow := "⬆"
+		if t.Incoming {
+			arrow = "⬇"
+		}
+		name := t.Name
+		if runes := []rune(name); len(runes) > 14 {
+			name = string(runes[:13]) + "…"
+		}
+		lines = append(lines, nameStyle.Render(fmt.Sprintf("%s %s", arrow, name)))
+
+		const barWidth = 10
+		filled := int(t.Progress() * barWidth)
+		bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+		switch t.State {
+		case chat.TransferOffered:
+			lines = append(lines, pausedStyle.Render("  waiting ["+t.ID+"]"))
+		case chat.TransferInterrupted:
+			lines = append(lines, pausedStyle.Render(fmt.Sprintf("  %s paused", bar)))
+		default:
+			lines = append(lines, barStyle.Render(fmt.Sprintf("  %s %d%%", bar, int(t.Progress()*100))))
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+	return append([]string{headerStyle.Render("╭─ TRANSFERS ─╮")}, lines...)
+}
+
+// renderTypingIndicator renders "alice is typing…" for the current room or DM
+// It always returns a line (possibly blank) so the input box doesn't jump around
+func (m ChatModel) renderTypingIndicator() string {
+	typingStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(m.theme.Dim)).
+		Italic(true).
+		PaddingLeft(2)
+
+	var text string
+	switch names := m.typing[m.currentRoom]; len(names) {
+	case 0:
+		text = " "
+	case 1:
+		text = fmt.Sprintf("%s is typing…", names[0])
+	case 2:
+		text = fmt.Sprintf("%s and %s are typing…", names[0], names[1])
+	default:
+		text = fmt.Sprintf("%d people are typing…", len(names))
+	}
+	return typingStyle.Render(text)
+}
+
 // renderInputArea renders the text input field
 func (m ChatModel) renderInputArea() string {
 	inputStyle := lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("240")).
+		BorderForeground(lipgloss.Color(m.theme.Faint)).
 		Padding(0, 1).
 		Width(m.width - 2)
 
@@ -333,6 +542,21 @@ func (m ChatModel) renderInputArea() string {
 	if len(m.peers) == 0 {
 		placeholder = "Waiting for peers to connect..."
 	}
+	if m.editingID != "" {
+		focusIndicator = "✏️  "
+		placeholder = "Editing (Esc to cancel):"
+	}
+	if m.replyingID != "" {
+		focusIndicator = "↪  "
+		placeholder = "Replying (Esc to cancel):"
+		if parent := m.chatService.GetMessage(m.replyingID); parent != nil {
+			snippet := []rune(strings.Join(strings.Fields(parent.Text()), " "))
+			if len(snippet) > 30 {
+				snippet = append(snippet[:29], '…')
+			}
+			placeholder = fmt.Sprintf("Replying to %s \"%s\" (Esc to cancel):", parent.Username, string(snippet))
+		}
+	}
 
 	content := fmt.Sprintf("%s%s %s", focusIndicator, placeholder, m.input.View())
 	return inputStyle.Render(content)
@@ -344,9 +568,9 @@ func (m ChatModel) renderHelpText() string {
 
 	switch m.focused {
 	case FocusInput:
-		help = "Enter: send message • Tab: switch focus • ↑↓: scroll messages • Ctrl+C: quit"
+		help = "Enter: send message • Tab: switch focus • Ctrl+N/P: switch room • Ctrl+C: quit"
 	case FocusMessages:
-		help = "↑↓: scroll • PgUp/PgDn: fast scroll • Home/End: top/bottom • Tab: switch focus"
+		help = "↑↓: select • 1-6: react 👍❤️😂🎉😮👀 • r: reply • t: thread • e: edit • d: delete • Tab: switch focus"
 	case FocusPeers:
 		help = "Tab: switch focus • ↑↓: scroll messages • Enter: focus input • Ctrl+C: quit"
 	default:
@@ -354,37 +578,19 @@ func (m ChatModel) renderHelpText() string {
 	}
 
 	return lipgloss.NewStyle().
-		Foreground(lipgloss.Color("240")).
+		Foreground(lipgloss.Color(m.theme.Faint)).
 		Render(help)
 }
 
 // getUserColor returns a consistent color for each user based on their username
 func (m ChatModel) getUserColor(username string) string {
-	// Enhanced color palette for better visual distinction
-	colors := []string{
-		"39",  // Bright blue
-		"203", // Pink
-		"148", // Green
-		"214", // Orange
-		"177", // Purple
-		"81",  // Cyan
-		"226", // Yellow
-		"196", // Red
-		"117", // Light blue
-		"205", // Magenta
-		"51",  // Turquoise
-		"166", // Dark orange
-		"135", // Light purple
-		"82",  // Lime green
-		"220", // Gold
-	}
-
 	// Improved hash function for better distribution
 	hash := 0
 	for i, char := range username {
 		hash += int(char) * (i + 1)
 	}
 
+	colors := m.theme.Users
 	return colors[hash%len(colors)]
 }
 
@@ -402,6 +608,35 @@ func (m ChatModel) getConnectionQualityIndicator(status string) string {
 	}
 }
 
+// presenceBadge returns a short marker for away and busy users, nothing when available
+func presenceBadge(presence string) string {
+	switch presence {
+	case string(peer.PresenceAway):
+		return "🌙 away"
+	case string(peer.PresenceBusy):
+		return "⛔ busy"
+	default:
+		return ""
+	}
+}
+
+// sourceLabel says how we found a peer, in words that fit the sidebar
+// LAN beacons are the normal case, so those get no label
+func sourceLabel(source string) string {
+	switch peer.Source(source) {
+	case peer.SourceMDNS:
+		return "mdns"
+	case peer.SourceExchange:
+		return "via pex"
+	case peer.SourceStatic:
+		return "static"
+	case peer.SourceIncoming:
+		return "dialed in"
+	default:
+		return ""
+	}
+}
+
 // wrapMessage intelligently wraps long messages with proper indentation
 func (m ChatModel) wrapMessage(prefix, content string, maxWidth int, contentStyle lipgloss.Style) []string {
 	if maxWidth <= 0 {